@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Blueprint is a reusable project configuration: the settings a project
+// changed, its build toggles, and a prompt template to create apps from.
+type Blueprint struct {
+	Version  int                        `json:"version"`
+	Settings map[string]json.RawMessage `json:"settings,omitempty"`
+	PWA      bool                       `json:"pwa"`
+	SRI      bool                       `json:"sri"`
+	// Prompt is a Go text/template; variables passed to CreateFromBlueprint
+	// are available as .Payload.
+	Prompt string `json:"prompt"`
+}
+
+// ExportBlueprint returns the project's configuration and creation prompt
+// as a blueprint.
+func (c *Client) ExportBlueprint(ctx context.Context, projectID string) (*Blueprint, error) {
+	var out Blueprint
+	if _, err := c.do(ctx, http.MethodGet, c.projectPath(projectID, "/blueprint"), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateFromBlueprint configures a new project from bp and creates its app
+// from the prompt template rendered with variables.
+func (c *Client) CreateFromBlueprint(ctx context.Context, projectID string, bp *Blueprint, variables map[string]any) (*CreateResponse, error) {
+	body := struct {
+		Blueprint *Blueprint     `json:"blueprint"`
+		Variables map[string]any `json:"variables,omitempty"`
+	}{bp, variables}
+	var out CreateResponse
+	resp, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/blueprint"), body, &out)
+	if err != nil {
+		return nil, err
+	}
+	out.RateLimit = parseRateLimit(resp.Header)
+	return &out, nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// BuildRecord describes one compile of a project's source.
+type BuildRecord struct {
+	ID           string              `json:"id"`
+	Trigger      string              `json:"trigger"`
+	Attempt      int                 `json:"attempt,omitempty"`
+	InputHash    string              `json:"input_hash"`
+	Inputs       map[string]string   `json:"inputs,omitempty"`
+	StartedAt    time.Time           `json:"started_at"`
+	DurationMS   int64               `json:"duration_ms,omitempty"`
+	ToolVersions map[string]string   `json:"tool_versions,omitempty"`
+	Warnings     []string            `json:"warnings,omitempty"`
+	Artifacts    map[string]int      `json:"artifacts,omitempty"`
+	Sources      map[string][]string `json:"sources,omitempty"`
+	// MissingAssets are references in the output to files it doesn't contain.
+	MissingAssets []MissingAsset `json:"missing_assets,omitempty"`
+	Status        string         `json:"status"` // "succeeded" or "failed"
+	Error         string         `json:"error,omitempty"`
+}
+
+// MissingAsset is a reference in a compiled file to a file the build didn't produce.
+// Path is the compiled file it resolves to; Width and Height are the size the
+// markup gives it, if any.
+type MissingAsset struct {
+	File      string `json:"file"`
+	Reference string `json:"reference"`
+	Path      string `json:"path"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// SizeChange is an artifact present in both builds with different sizes.
+type SizeChange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// VersionChange is a build tool whose version differs between builds.
+type VersionChange struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// BuildDiff compares a build against an earlier one.
+type BuildDiff struct {
+	From *BuildRecord `json:"from"`
+	To   *BuildRecord `json:"to"`
+
+	InputsAdded      []string                 `json:"inputs_added"`
+	InputsRemoved    []string                 `json:"inputs_removed"`
+	InputsChanged    []string                 `json:"inputs_changed"`
+	ArtifactsAdded   []string                 `json:"artifacts_added"`
+	ArtifactsRemoved []string                 `json:"artifacts_removed"`
+	ArtifactsResized map[string]SizeChange    `json:"artifacts_resized"`
+	ToolVersions     map[string]VersionChange `json:"tool_versions"`
+}
+
+// BuildStatus is the state of a project's latest queued build: "queued",
+// "building", "succeeded" or "failed", with the error output if it failed.
+type BuildStatus struct {
+	State      string     `json:"state"`
+	Trigger    string     `json:"trigger"`
+	Channel    string     `json:"channel"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// BuildEvent reports a chat stream's build: "repairing" for each failed
+// compile sent back to the agent, then "succeeded" or "failed". Attempt is
+// the number of repairs made so far.
+type BuildEvent struct {
+	State   string `json:"state"`
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error,omitempty"`
+}
+
+// GetBuildStatus returns the state of the project's latest build, for
+// polling after an edit.
+func (c *Client) GetBuildStatus(ctx context.Context, projectID string) (*BuildStatus, error) {
+	var out BuildStatus
+	if _, err := c.do(ctx, http.MethodGet, c.projectPath(projectID, "/build"), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Rebuild rebuilds the app from its stored source files and returns the
+// resulting build status.
+func (c *Client) Rebuild(ctx context.Context, projectID string) (*BuildStatus, error) {
+	var out BuildStatus
+	if _, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/rebuild"), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StartRebuild starts a rebuild in the background, and returns the
+// operation to follow it by.
+func (c *Client) StartRebuild(ctx context.Context, projectID string) (*Operation, error) {
+	var out Operation
+	if _, err := c.do(preferAsync(ctx), http.MethodPost, c.projectPath(projectID, "/rebuild"), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListBuilds returns up to limit of the project's most recent builds,
+// newest first. A limit of zero uses the server's maximum.
+func (c *Client) ListBuilds(ctx context.Context, projectID string, limit int) ([]BuildRecord, error) {
+	endpoint := c.projectPath(projectID, "/builds")
+	if limit > 0 {
+		endpoint += "?" + url.Values{"limit": {strconv.Itoa(limit)}}.Encode()
+	}
+	var out []BuildRecord
+	if _, err := c.do(ctx, http.MethodGet, endpoint, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DiffBuilds compares build b against an earlier build a.
+func (c *Client) DiffBuilds(ctx context.Context, projectID, a, b string) (*BuildDiff, error) {
+	var out BuildDiff
+	endpoint := c.projectPath(projectID, "/builds/"+url.PathEscape(a)+"/diff/"+url.PathEscape(b))
+	if _, err := c.do(ctx, http.MethodGet, endpoint, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
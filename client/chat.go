@@ -0,0 +1,278 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Chat event types of the AI SDK UI message stream.
+const (
+	EventStart               = "start"
+	EventStartStep           = "start-step"
+	EventTextStart           = "text-start"
+	EventTextDelta           = "text-delta"
+	EventTextEnd             = "text-end"
+	EventReasoningStart      = "reasoning-start"
+	EventReasoningDelta      = "reasoning-delta"
+	EventReasoningEnd        = "reasoning-end"
+	EventToolInputStart      = "tool-input-start"
+	EventToolInputDelta      = "tool-input-delta"
+	EventToolInputAvailable  = "tool-input-available"
+	EventToolOutputAvailable = "tool-output-available"
+	EventFinishStep          = "finish-step"
+	EventFinish              = "finish"
+	EventError               = "error"
+	// EventAbort ends a stream stopped by AbortChat.
+	EventAbort = "abort"
+	// EventOperation carries an Operation in Data as a create stream moves
+	// through its stages.
+	EventOperation = "data-operation"
+	// EventBuild carries a BuildEvent in Data when the server reports chat
+	// builds (BUILD_ERROR_EVENTS).
+	EventBuild = "data-build"
+)
+
+// chatStreamHeader carries the ID a dropped stream is resumed with.
+const chatStreamHeader = "X-Chat-Stream-ID"
+
+// ChatEvent is one event of a chat stream. Fields not used by an event's
+// type are empty; Raw holds the full event.
+type ChatEvent struct {
+	// ID is the server's event ID, sent as Last-Event-ID on reconnect.
+	ID string `json:"-"`
+
+	Type           string          `json:"type"`
+	MessageID      string          `json:"messageId,omitempty"`
+	PartID         string          `json:"id,omitempty"`
+	Delta          string          `json:"delta,omitempty"`
+	ToolCallID     string          `json:"toolCallId,omitempty"`
+	ToolName       string          `json:"toolName,omitempty"`
+	InputTextDelta string          `json:"inputTextDelta,omitempty"`
+	Input          json.RawMessage `json:"input,omitempty"`
+	Output         json.RawMessage `json:"output,omitempty"`
+	FinishReason   string          `json:"finishReason,omitempty"`
+	ErrorText      string          `json:"errorText,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// ChatStream reads events from a chat response, reconnecting with
+// Last-Event-ID if the connection drops before the stream finishes.
+type ChatStream struct {
+	client    *Client
+	ctx       context.Context
+	projectID string
+
+	body   io.ReadCloser
+	reader *bufio.Reader
+
+	// ID identifies the stream on the server, for resuming it.
+	ID string
+	// OperationID is the ID a create stream's progress is recorded under.
+	OperationID string
+	// RateLimit is the token quota as it stood when the stream began.
+	RateLimit *RateLimit
+
+	lastEventID string
+	finished    bool
+	failures    int
+}
+
+// Chat sends AI SDK UI messages to the project's agent and returns the
+// streamed reply. File changes the agent makes are applied and rebuilt by
+// the server as it streams. The caller must Close the stream.
+func (c *Client) Chat(ctx context.Context, projectID string, messages []json.RawMessage) (*ChatStream, error) {
+	body := struct {
+		Messages []json.RawMessage `json:"messages"`
+	}{messages}
+	return c.openChatStream(ctx, projectID, "/chat", body)
+}
+
+// CreateStream creates the project's app from prompt like Create, but
+// returns the agent's progress as a chat stream. The app has been built
+// when the stream finishes. The caller must Close the stream.
+func (c *Client) CreateStream(ctx context.Context, projectID, prompt string) (*ChatStream, error) {
+	return c.openChatStream(ctx, projectID, "/create/stream", promptRequest{Prompt: prompt})
+}
+
+// openChatStream posts body to a streaming endpoint.
+func (c *Client) openChatStream(ctx context.Context, projectID, path string, body any) (*ChatStream, error) {
+	resp, err := c.send(ctx, http.MethodPost, c.projectPath(projectID, path), body)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatStream{
+		client:      c,
+		ctx:         ctx,
+		projectID:   projectID,
+		body:        resp.Body,
+		reader:      bufio.NewReader(resp.Body),
+		ID:          resp.Header.Get(chatStreamHeader),
+		OperationID: resp.Header.Get(operationHeader),
+		RateLimit:   parseRateLimit(resp.Header),
+	}, nil
+}
+
+// ResumeChat reattaches to a chat stream by ID, continuing after the event
+// lastEventID, or from the start if it is empty.
+func (c *Client) ResumeChat(ctx context.Context, projectID, streamID, lastEventID string) (*ChatStream, error) {
+	s := &ChatStream{client: c, ctx: ctx, projectID: projectID, ID: streamID, lastEventID: lastEventID}
+	if err := s.resume(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AbortChat stops the project's running chat or create stream, returning
+// its ID. Files the agent had already written are kept and built, and the
+// stream ends with an EventAbort.
+func (c *Client) AbortChat(ctx context.Context, projectID string) (string, error) {
+	var out struct {
+		StreamID string `json:"stream_id"`
+	}
+	if _, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/chat/abort"), nil, &out); err != nil {
+		return "", err
+	}
+	return out.StreamID, nil
+}
+
+// resume opens the stream after the last event read.
+func (s *ChatStream) resume() error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.client.projectPath(s.projectID, "/chat/"+s.ID), nil)
+	if err != nil {
+		return fmt.Errorf("forgettable: failed to create request: %w", err)
+	}
+	for name, values := range s.client.header {
+		req.Header[name] = values
+	}
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+
+	resp, err := s.client.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("forgettable: request failed: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+		return responseError(resp)
+	}
+	// Nothing left to send
+	if resp.StatusCode == http.StatusNoContent {
+		s.finished = true
+	}
+	s.body = resp.Body
+	s.reader = bufio.NewReader(resp.Body)
+	return nil
+}
+
+// reconnect resumes the stream after a dropped connection, backing off
+// between attempts. It gives up after the client's reconnect limit, or at
+// once if the server doesn't support resuming.
+func (s *ChatStream) reconnect(cause error) error {
+	for {
+		if s.ID == "" || s.failures >= s.client.maxReconnects {
+			return cause
+		}
+		_ = s.body.Close()
+
+		delay := time.Duration(1<<s.failures) * 250 * time.Millisecond
+		s.failures++
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+
+		err := s.resume()
+		if err == nil {
+			return nil
+		}
+		// The stream is gone, so retrying won't help
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode < 500 {
+			return err
+		}
+		cause = err
+	}
+}
+
+// Next returns the next event, or io.EOF once the stream has finished.
+func (s *ChatStream) Next() (*ChatEvent, error) {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if s.finished {
+				return nil, io.EOF
+			}
+			if s.ctx.Err() != nil {
+				return nil, s.ctx.Err()
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err := s.reconnect(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		field, value, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case field == "id":
+			s.lastEventID = value
+			continue
+		case field != "data" || value == "":
+			continue
+		case value == "[DONE]":
+			s.finished = true
+			return nil, io.EOF
+		}
+
+		event := &ChatEvent{ID: s.lastEventID, Raw: json.RawMessage(value)}
+		if err := json.Unmarshal(event.Raw, event); err != nil {
+			return nil, fmt.Errorf("forgettable: invalid chat event: %w", err)
+		}
+		if event.Type == EventFinish || event.Type == EventAbort {
+			s.finished = true
+		}
+		s.failures = 0
+		return event, nil
+	}
+}
+
+// Events iterates over the remaining events, stopping after the first
+// error.
+func (s *ChatStream) Events() iter.Seq2[*ChatEvent, error] {
+	return func(yield func(*ChatEvent, error) bool) {
+		for {
+			event, err := s.Next()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if !yield(event, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// LastEventID returns the ID of the last event read, for ResumeChat.
+func (s *ChatStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Close ends the stream.
+func (s *ChatStream) Close() error {
+	return s.body.Close()
+}
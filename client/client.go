@@ -0,0 +1,181 @@
+// Package client is a Go client for the Forgettable API, implementing the
+// project, file, chat and build operations described in openapi.yaml.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the Go Main API.
+type Client struct {
+	baseURL       string
+	httpClient    *http.Client
+	header        http.Header
+	maxReconnects int
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests. Chat streams run
+// for as long as the agent does, so its Timeout should be zero or generous.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithHeader adds a header to every request, e.g. an API key read by
+// IDENTITY_KEY_HEADER.
+func WithHeader(name, value string) Option {
+	return func(c *Client) { c.header.Add(name, value) }
+}
+
+// WithAPIKey authenticates every request with an API key, required when
+// the server has AUTH_ENABLED set.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.header.Set("Authorization", "Bearer "+key) }
+}
+
+// WithMaxReconnects sets how many times in a row a dropped chat stream is
+// resumed before giving up. The default is 3; zero disables reconnection.
+func WithMaxReconnects(n int) Option {
+	return func(c *Client) { c.maxReconnects = n }
+}
+
+// New returns a client for the API at baseURL, e.g.
+// "http://localhost:3000/api/v1".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		httpClient:    http.DefaultClient,
+		header:        http.Header{},
+		maxReconnects: 3,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// RateLimit is the monthly token quota reported in X-RateLimit-* headers.
+type RateLimit struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+}
+
+// parseRateLimit reads the quota headers, returning nil when the server
+// didn't send them.
+func parseRateLimit(header http.Header) *RateLimit {
+	limit, err := strconv.ParseInt(header.Get("X-RateLimit-Limit"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	remaining, _ := strconv.ParseInt(header.Get("X-RateLimit-Remaining"), 10, 64)
+	reset, _ := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	return &RateLimit{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0).UTC()}
+}
+
+// Error is a non-2xx response from the API.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is set when the server asked the client to back off.
+	RetryAfter time.Duration
+	RateLimit  *RateLimit
+	// Body is the raw response, which for validation and partial store
+	// failures lists the files affected.
+	Body json.RawMessage
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("forgettable: %d %s", e.StatusCode, e.Message)
+}
+
+// responseError builds an Error from a failed response.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &Error{StatusCode: resp.StatusCode, RateLimit: parseRateLimit(resp.Header)}
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		apiErr.Message = payload.Error
+		apiErr.Body = body
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// projectPath returns the URL of path within a project.
+func (c *Client) projectPath(projectID, path string) string {
+	return c.baseURL + "/" + url.PathEscape(projectID) + path
+}
+
+// send makes a request with an optional JSON body and returns the response
+// if it succeeded. The caller closes the body.
+func (c *Client) send(ctx context.Context, method, endpoint string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("forgettable: failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, fmt.Errorf("forgettable: failed to create request: %w", err)
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if id, ok := ctx.Value(operationIDKey{}).(string); ok {
+		req.Header.Set(operationHeader, id)
+	}
+	if async, _ := ctx.Value(preferAsyncKey{}).(bool); async {
+		req.Header.Set("Prefer", "respond-async")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("forgettable: request failed: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// do makes a request and decodes a JSON response into out, if not nil.
+func (c *Client) do(ctx context.Context, method, endpoint string, body, out any) (*http.Response, error) {
+	resp, err := c.send(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("forgettable: failed to decode response: %w", err)
+		}
+	}
+	return resp, nil
+}
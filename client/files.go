@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ReplaceRequest is a search/replace across a project's source files.
+type ReplaceRequest struct {
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+	// Regex treats Search as a Go regular expression; Replace may then use
+	// $1-style group references.
+	Regex bool `json:"regex"`
+	// Paths optionally restricts the replacement to files matching any of
+	// these globs, e.g. "src/components/*.tsx".
+	Paths  []string `json:"paths,omitempty"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// LineChange previews one changed line.
+type LineChange struct {
+	Line   int    `json:"line"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// FileReplaceResult reports the replacements made in one file.
+type FileReplaceResult struct {
+	Path    string       `json:"path"`
+	Matches int          `json:"matches"`
+	Preview []LineChange `json:"preview"`
+}
+
+// ReplaceResponse is the result, or with DryRun the preview, of a replace.
+type ReplaceResponse struct {
+	Files   []FileReplaceResult `json:"files"`
+	Matches int                 `json:"matches"`
+	DryRun  bool                `json:"dry_run"`
+	// Revision is the change event revision of the applied replacement.
+	Revision   int64  `json:"revision,omitempty"`
+	BuildError string `json:"build_error,omitempty"`
+}
+
+// Replace applies req to the project's source files and rebuilds the app.
+func (c *Client) Replace(ctx context.Context, projectID string, req ReplaceRequest) (*ReplaceResponse, error) {
+	var out ReplaceResponse
+	if _, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/files/replace"), req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Comment is an annotation on a range of lines of a source file.
+type Comment struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	// Context asks for the comment, with the lines it covers, to be given
+	// to the agent on the next chat or edit turn; IncludedAt is when that
+	// happened.
+	Context    bool       `json:"context"`
+	IncludedAt *time.Time `json:"included_at,omitempty"`
+}
+
+// CommentRequest adds a comment to lines StartLine to EndLine (default
+// StartLine) of a file.
+type CommentRequest struct {
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line,omitempty"`
+	Body      string `json:"body"`
+	Context   bool   `json:"context"`
+}
+
+type commentsResponse struct {
+	Comments []Comment `json:"comments"`
+}
+
+// commentsPath is the comments endpoint of the source file at path.
+func commentsPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/files/" + strings.Join(segments, "/") + "/comments"
+}
+
+// AddComment comments on lines of the source file at path.
+func (c *Client) AddComment(ctx context.Context, projectID, path string, req CommentRequest) (*Comment, error) {
+	var out Comment
+	if _, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, commentsPath(path)), req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListComments returns the comments on the source file at path, or on all
+// files when path is empty, oldest first.
+func (c *Client) ListComments(ctx context.Context, projectID, path string) ([]Comment, error) {
+	endpoint := "/comments"
+	if path != "" {
+		endpoint = commentsPath(path)
+	}
+	var out commentsResponse
+	if _, err := c.do(ctx, http.MethodGet, c.projectPath(projectID, endpoint), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Comments, nil
+}
+
+// DeleteComment removes a comment.
+func (c *Client) DeleteComment(ctx context.Context, projectID, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, c.projectPath(projectID, "/comments/"+url.PathEscape(id)), nil, nil)
+	return err
+}
@@ -0,0 +1,3 @@
+module forgettable/client
+
+go 1.24.0
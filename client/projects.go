@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// QuotaUsage is a project's usage this month against its quotas. Limits
+// are zero when unlimited.
+type QuotaUsage struct {
+	Period            string    `json:"period"`
+	Tokens            int64     `json:"tokens"`
+	TokenLimit        int64     `json:"token_limit,omitempty"`
+	BuildMinutes      int64     `json:"build_minutes"`
+	BuildMinutesLimit int       `json:"build_minutes_limit,omitempty"`
+	ResetsAt          time.Time `json:"resets_at"`
+}
+
+// CreateResponse is the result of creating an app.
+type CreateResponse struct {
+	Summary string      `json:"summary"`
+	Files   []string    `json:"files"`
+	ViewURL string      `json:"view_url"`
+	Quota   *QuotaUsage `json:"quota,omitempty"`
+	// OperationID is the ID the create's progress was recorded under.
+	OperationID string `json:"operation_id"`
+	// RateLimit is read from the response headers.
+	RateLimit *RateLimit `json:"-"`
+}
+
+// operationHeader carries the ID a create's progress is recorded under.
+const operationHeader = "X-Operation-ID"
+
+type operationIDKey struct{}
+
+// WithOperationID has creates made with ctx record their progress under
+// id, a UUID, so it can be polled with GetOperation while they run.
+func WithOperationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+type preferAsyncKey struct{}
+
+// preferAsync has the request made with ctx run in the background as an
+// operation, answered with 202 and the operation.
+func preferAsync(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preferAsyncKey{}, true)
+}
+
+// Operation is the progress of a long-running request: its state
+// ("running", "succeeded" or "failed"), its stage ("generating",
+// "processing", "storing", "building", "promoting" or "exporting") and, for
+// creates and edits, how many files have been stored.
+type Operation struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	State       string     `json:"state"`
+	Stage       string     `json:"stage"`
+	FilesTotal  int        `json:"files_total,omitempty"`
+	FilesStored int        `json:"files_stored"`
+	StartedAt   time.Time  `json:"started_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	// Status is the HTTP status a request started with a Start method
+	// finished with; its response is read with OperationResult.
+	Status int `json:"status,omitempty"`
+}
+
+// GetOperation returns the progress of one of the project's recent
+// long-running requests.
+func (c *Client) GetOperation(ctx context.Context, projectID, id string) (*Operation, error) {
+	var out Operation
+	if _, err := c.do(ctx, http.MethodGet, c.projectPath(projectID, "/operations/"+url.PathEscape(id)), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// WaitOperation polls an operation every interval until it finishes, and
+// returns it.
+func (c *Client) WaitOperation(ctx context.Context, projectID, id string, interval time.Duration) (*Operation, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		op, err := c.GetOperation(ctx, projectID, id)
+		if err != nil {
+			return nil, err
+		}
+		if op.State != "running" {
+			return op, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// OperationResult decodes the response a finished operation started with a
+// Start method returned into out, e.g. a *CreateResponse for StartCreate.
+// A failed request's response is returned as an *Error.
+func (c *Client) OperationResult(ctx context.Context, projectID, id string, out any) error {
+	_, err := c.do(ctx, http.MethodGet, c.projectPath(projectID, "/operations/"+url.PathEscape(id)+"/result"), nil, out)
+	return err
+}
+
+// HunkResult is the outcome of one search/replace hunk of an edit.
+type HunkResult struct {
+	Search string `json:"search"`
+	Status string `json:"status"` // "applied", "fuzzy" or "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+// FileEditResult describes how a single file changed during an edit.
+type FileEditResult struct {
+	Path   string       `json:"path"`
+	Status string       `json:"status"` // "created", "modified", "deleted" or "unchanged"
+	Hunks  []HunkResult `json:"hunks"`
+}
+
+// EditResponse is the result of editing an app.
+type EditResponse struct {
+	Summary string           `json:"summary"`
+	Files   []string         `json:"files"`
+	Changes []FileEditResult `json:"changes"`
+	ViewURL string           `json:"view_url"`
+	Quota   *QuotaUsage      `json:"quota,omitempty"`
+	// RateLimit is read from the response headers.
+	RateLimit *RateLimit `json:"-"`
+}
+
+// QuotaWarning reports that a resource has reached a warning threshold.
+type QuotaWarning struct {
+	Resource  string `json:"resource"`
+	Threshold int    `json:"threshold"`
+	Used      int64  `json:"used"`
+	Limit     int64  `json:"limit"`
+}
+
+// AppMetadata describes a project's generated app.
+type AppMetadata struct {
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	Summary       string            `json:"summary"`
+	Summaries     map[string]string `json:"summaries,omitempty"`
+	SourceFiles   []string          `json:"source_files"`
+	CompiledFiles []string          `json:"compiled_files"`
+	GitCommit     string            `json:"git_commit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Description   string            `json:"description,omitempty"`
+	// ForkedFrom is the project this one was forked from.
+	ForkedFrom string `json:"forked_from,omitempty"`
+}
+
+// State is a project's current app, conversation and quota warnings.
+type State struct {
+	HasApp       bool            `json:"hasApp"`
+	Conversation json.RawMessage `json:"conversation,omitempty"`
+	Metadata     *AppMetadata    `json:"metadata,omitempty"`
+	Warnings     []QuotaWarning  `json:"warnings,omitempty"`
+}
+
+// PendingDeletion records a scheduled project deletion.
+type PendingDeletion struct {
+	RequestedAt time.Time `json:"requested_at"`
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+// DeletedProject reports the keys removed by Purge.
+type DeletedProject struct {
+	// Deleted counts the keys removed per top-level prefix ("source/",
+	// "compiled/", "_meta/", ...).
+	Deleted map[string]int `json:"deleted"`
+	Total   int            `json:"total"`
+}
+
+type promptRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+// Create generates a new app in the project from prompt.
+func (c *Client) Create(ctx context.Context, projectID, prompt string) (*CreateResponse, error) {
+	var out CreateResponse
+	resp, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/create"), promptRequest{Prompt: prompt}, &out)
+	if err != nil {
+		return nil, err
+	}
+	out.RateLimit = parseRateLimit(resp.Header)
+	return &out, nil
+}
+
+// Edit changes the project's existing app as described by prompt.
+func (c *Client) Edit(ctx context.Context, projectID, prompt string) (*EditResponse, error) {
+	var out EditResponse
+	resp, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/edit"), promptRequest{Prompt: prompt}, &out)
+	if err != nil {
+		return nil, err
+	}
+	out.RateLimit = parseRateLimit(resp.Header)
+	return &out, nil
+}
+
+// StartCreate starts generating an app from prompt in the background, and
+// returns the operation to follow it by.
+func (c *Client) StartCreate(ctx context.Context, projectID, prompt string) (*Operation, error) {
+	var out Operation
+	if _, err := c.do(preferAsync(ctx), http.MethodPost, c.projectPath(projectID, "/create"), promptRequest{Prompt: prompt}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StartEdit starts editing the app with prompt in the background, and
+// returns the operation to follow it by.
+func (c *Client) StartEdit(ctx context.Context, projectID, prompt string) (*Operation, error) {
+	var out Operation
+	if _, err := c.do(preferAsync(ctx), http.MethodPost, c.projectPath(projectID, "/edit"), promptRequest{Prompt: prompt}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// State returns the project's current state.
+func (c *Client) State(ctx context.Context, projectID string) (*State, error) {
+	var out State
+	if _, err := c.do(ctx, http.MethodGet, c.projectPath(projectID, "/state"), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SaveConversation stores the project's chat messages.
+func (c *Client) SaveConversation(ctx context.Context, projectID string, messages []json.RawMessage) error {
+	body := struct {
+		Messages []json.RawMessage `json:"messages"`
+	}{messages}
+	_, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/conversation"), body, nil)
+	return err
+}
+
+// Fork is a project copied from another by Fork.
+type Fork struct {
+	ProjectID  string `json:"project_id"`
+	ForkedFrom string `json:"forked_from"`
+	URL        string `json:"url"`
+	ViewURL    string `json:"view_url"`
+	StateURL   string `json:"state_url"`
+}
+
+// Fork copies the project's app, settings and, with conversation, its chat
+// history into a new project.
+func (c *Client) Fork(ctx context.Context, projectID string, conversation bool) (*Fork, error) {
+	body := struct {
+		Conversation bool `json:"conversation"`
+	}{conversation}
+	var out Fork
+	if _, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/fork"), body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Delete schedules the project for deletion after the server's grace
+// period. Deleting again keeps the original deadline.
+func (c *Client) Delete(ctx context.Context, projectID string) (*PendingDeletion, error) {
+	var out PendingDeletion
+	if _, err := c.do(ctx, http.MethodDelete, c.projectPath(projectID, "/"), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Purge deletes every key of the project now, skipping the grace period.
+// Purging a project that is already gone reports zero keys.
+func (c *Client) Purge(ctx context.Context, projectID string) (*DeletedProject, error) {
+	var out DeletedProject
+	if _, err := c.do(ctx, http.MethodDelete, c.projectPath(projectID, "/?immediate=true"), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CancelDeletion cancels a scheduled deletion.
+func (c *Client) CancelDeletion(ctx context.Context, projectID string) error {
+	_, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/delete/cancel"), nil, nil)
+	return err
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Version describes a stored snapshot of a project's source and compiled
+// files, taken after each create, edit, chat build and rollback.
+type Version struct {
+	N             int       `json:"n"`
+	CreatedAt     time.Time `json:"created_at"`
+	Trigger       string    `json:"trigger"` // "create", "edit", "chat" or "rollback"
+	Summary       string    `json:"summary,omitempty"`
+	SourceFiles   int       `json:"source_files"`
+	CompiledFiles int       `json:"compiled_files"`
+	RestoredFrom  int       `json:"restored_from,omitempty"`
+}
+
+// ListVersions returns the project's stored versions, newest first.
+func (c *Client) ListVersions(ctx context.Context, projectID string) ([]Version, error) {
+	var out struct {
+		Versions []Version `json:"versions"`
+	}
+	if _, err := c.do(ctx, http.MethodGet, c.projectPath(projectID, "/versions"), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Versions, nil
+}
+
+// Rollback restores the project's app to version n. It returns the new
+// version recording the rollback, or nil if the server has versioning
+// disabled.
+func (c *Client) Rollback(ctx context.Context, projectID string, n int) (*Version, error) {
+	var out struct {
+		Version *Version `json:"version"`
+	}
+	if _, err := c.do(ctx, http.MethodPost, c.projectPath(projectID, "/rollback/"+strconv.Itoa(n)), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Version, nil
+}
@@ -45,7 +45,7 @@ function App() {
 
     async function fetchState() {
       try {
-        const response = await fetch(`/api/${projectId}/state`)
+        const response = await fetch(`/api/v1/${projectId}/state`)
         if (response.ok) {
           const data = await response.json()
           setInitialState(data)
@@ -34,9 +34,23 @@ function hasCompletedFileOp(message: UIMessage): boolean {
   return false
 }
 
+// The chat stream holds the project lock until it has fully closed, so a
+// save made as it finishes can briefly get 409 and is retried
+async function saveConversation(projectId: string, messages: UIMessage[], attempts = 3): Promise<void> {
+  for (let attempt = 1; ; attempt++) {
+    const response = await fetch(`/api/v1/${projectId}/conversation`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ messages }),
+    })
+    if (response.status !== 409 || attempt >= attempts) return
+    await new Promise((resolve) => setTimeout(resolve, 1000 * attempt))
+  }
+}
+
 export function Chat({ projectId, onFileChange, initialMessages }: ChatProps) {
   const [input, setInput] = useState('')
-  const transport = useMemo(() => new DefaultChatTransport({ api: `/api/${projectId}/chat` }), [projectId])
+  const transport = useMemo(() => new DefaultChatTransport({ api: `/api/v1/${projectId}/chat` }), [projectId])
   const { messages, sendMessage, status, error } = useChat({ transport, messages: initialMessages })
   const textareaRef = useRef<HTMLTextAreaElement>(null)
   const lastRefreshedMsgRef = useRef<string>('')
@@ -52,11 +66,7 @@ export function Chat({ projectId, onFileChange, initialMessages }: ChatProps) {
     lastSavedMsgRef.current = lastMsg.id
 
     // Save conversation to backend
-    fetch(`/api/${projectId}/conversation`, {
-      method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({ messages }),
-    }).catch((err: unknown) => {
+    saveConversation(projectId, messages).catch((err: unknown) => {
       console.error('Failed to save conversation:', err)
     })
   }, [status, messages, projectId])
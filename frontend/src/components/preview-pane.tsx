@@ -24,7 +24,7 @@ export const PreviewPane = forwardRef<PreviewPaneRef, PreviewPaneProps>(
       },
     }))
 
-    const previewUrl = `/api/${projectId}/view?t=${refreshKey}`
+    const previewUrl = `/api/v1/${projectId}/view?t=${refreshKey}`
 
     return (
       <div className={cn('relative h-full w-full bg-muted/30', className)}>
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminMiddleware restricts operator endpoints to requests bearing
+// ADMIN_TOKEN. Without a configured token the endpoints are disabled.
+func (h *Handlers) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.cfg.AdminToken == "" {
+			writeError(w, ErrNotFound)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) != 1 {
+			writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// replicaHeader names the replica that owns a project. It is set on
+// responses to chat and build requests so a load balancer (or a client
+// sending it back) can route the project's later requests to the same
+// instance.
+const replicaHeader = "X-Replica"
+
+// Affinity modes.
+const (
+	AffinityHeader   = "header"
+	AffinityRedirect = "redirect"
+)
+
+// Replica is one instance of a multi-replica deployment.
+type Replica struct {
+	ID  string
+	URL string
+}
+
+// parseReplicas parses REPLICAS entries of the form "id=url", where url is
+// the base address clients can reach the replica on.
+func parseReplicas(entries []string) ([]Replica, error) {
+	replicas := make([]Replica, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id, rawURL, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid replica %q, expected id=url", entry)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL for replica %q", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate replica %q", id)
+		}
+		seen[id] = true
+		replicas = append(replicas, Replica{ID: id, URL: strings.TrimRight(rawURL, "/")})
+	}
+	return replicas, nil
+}
+
+// ReplicaRing assigns each project to one replica by rendezvous hashing, so
+// every instance configured with the same replicas agrees on the owner and
+// adding or removing a replica only moves the projects it owned. Chat and
+// build requests for a project then land where its in-memory lock, chat
+// streams and change hub live.
+type ReplicaRing struct {
+	self     string
+	replicas []Replica
+	redirect bool
+}
+
+// NewReplicaRing creates a ring for the replicas, self being this
+// instance's ID. With mode AffinityRedirect, requests for projects owned
+// elsewhere are redirected there; with AffinityHeader they are served and
+// only the owner is reported. It returns nil, disabling affinity, when no
+// replicas are configured.
+func NewReplicaRing(self string, replicas []Replica, mode string) (*ReplicaRing, error) {
+	if len(replicas) == 0 {
+		return nil, nil
+	}
+	if mode != AffinityHeader && mode != AffinityRedirect {
+		return nil, fmt.Errorf("unknown affinity mode %q", mode)
+	}
+	found := false
+	for _, replica := range replicas {
+		found = found || replica.ID == self
+	}
+	if !found {
+		return nil, fmt.Errorf("REPLICA_ID %q is not one of the configured replicas", self)
+	}
+	return &ReplicaRing{self: self, replicas: replicas, redirect: mode == AffinityRedirect}, nil
+}
+
+// Owner returns the replica a project is assigned to.
+func (rr *ReplicaRing) Owner(projectID string) Replica {
+	var owner Replica
+	var best uint64
+	for i, replica := range rr.replicas {
+		sum := sha256.Sum256([]byte(replica.ID + "\x00" + projectID))
+		if score := binary.BigEndian.Uint64(sum[:8]); i == 0 || score > best {
+			owner, best = replica, score
+		}
+	}
+	return owner
+}
+
+// AffinityMiddleware reports the replica owning the project in X-Replica
+// and, in redirect mode, sends requests that reached another replica to
+// the owner with a 307 so the method and body are kept. Locks are also
+// leased in storage, so requests served off their owner stay correct;
+// they just can't share its in-memory state.
+func (h *Handlers) AffinityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "uuid")
+		if h.replicas == nil || validateUUID(projectID) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		owner := h.replicas.Owner(projectID)
+		w.Header().Set(replicaHeader, owner.ID)
+		if h.replicas.redirect && owner.ID != h.replicas.self {
+			http.Redirect(w, r, owner.URL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
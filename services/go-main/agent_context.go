@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// providerAPIKeyHeader carries a caller's own LLM provider key. go-main
+// forwards it to the Python agent under the same name.
+const providerAPIKeyHeader = "X-Provider-Api-Key"
+
+type agentHeadersContextKey struct{}
+
+// withAgentHeader attaches a header to send on agent requests made with ctx.
+func withAgentHeader(ctx context.Context, name, value string) context.Context {
+	if value == "" {
+		return ctx
+	}
+	headers := http.Header{}
+	if existing, ok := ctx.Value(agentHeadersContextKey{}).(http.Header); ok {
+		headers = existing.Clone()
+	}
+	headers.Set(name, value)
+	return context.WithValue(ctx, agentHeadersContextKey{}, headers)
+}
+
+// setAgentHeaders copies headers attached to the request's context onto it.
+func setAgentHeaders(req *http.Request) {
+	if headers, ok := req.Context().Value(agentHeadersContextKey{}).(http.Header); ok {
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+	}
+}
+
+// agentContext returns the request context carrying what the agent needs to
+// know about the caller: their Accept-Language, the project's model, tool,
+// build profile and image optimization settings, the source of its
+// workspace peers, the brand kit to follow, and the provider key to bill
+// generation to (the request header if present, otherwise the project
+// secret).
+func (h *Handlers) agentContext(r *http.Request, projectID string) (context.Context, error) {
+	return h.projectAgentContext(r.Context(), projectID, r.Header)
+}
+
+// projectAgentContext is agentContext for work not made by a request, such
+// as hooks and schedules, which pass no header.
+func (h *Handlers) projectAgentContext(parent context.Context, projectID string, header http.Header) (context.Context, error) {
+	ctx := withAgentHeader(parent, "Accept-Language", header.Get("Accept-Language"))
+
+	settings, err := h.projectSettings(parent, projectID)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withAgentHeader(ctx, agentModelHeader, settings.Model)
+	ctx = withAgentHeader(ctx, buildProfileHeader, settings.BuildProfile)
+	if settings.OptimizeImages {
+		ctx = withAgentHeader(ctx, optimizeImagesHeader, "true")
+	}
+	if !slices.Equal(settings.Tools, agentTools) {
+		ctx = withAgentHeader(ctx, agentToolsHeader, strings.Join(settings.Tools, ","))
+	}
+	ctx = withWorkspaceFiles(ctx, h.workspaceContext(parent, projectID))
+	ctx = withBrandKit(ctx, h.brandContext(parent, projectID))
+
+	if key := header.Get(providerAPIKeyHeader); key != "" {
+		return withAgentHeader(ctx, providerAPIKeyHeader, key), nil
+	}
+
+	key, err := h.getSecret(parent, projectID, providerAPIKeySecret)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrSecretsDisabled) {
+			return ctx, nil
+		}
+		return nil, err
+	}
+	return withAgentHeader(ctx, providerAPIKeyHeader, key), nil
+}
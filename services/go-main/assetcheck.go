@@ -0,0 +1,157 @@
+package main
+
+import (
+	"cmp"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// htmlRefPattern matches local references in src and href attributes.
+	htmlRefPattern = regexp.MustCompile(`(?i)\b(?:src|href|poster)\s*=\s*["']([^"']+)["']`)
+	// cssRefPattern matches url() references in stylesheets.
+	cssRefPattern = regexp.MustCompile(`url\(\s*["']?([^"')\s]+)`)
+	// jsAssetPattern matches string literals in scripts that look like paths
+	// to static files, such as image sources set from components.
+	jsAssetPattern = regexp.MustCompile("[\"'`]((?:\\.{0,2}/)?[\\w@~-][\\w./@~-]*\\.(?:png|jpe?g|gif|svg|webp|avif|ico|bmp|woff2?|ttf|otf|mp3|wav|ogg|mp4|webm|pdf))[\"'`]")
+)
+
+// MissingAsset is a reference in the compiled output to a file the build
+// didn't produce, most often an image the agent never created. Path is the
+// compiled file it should have been. Width and Height are the size the
+// markup gives it, if any, for placeholders.
+type MissingAsset struct {
+	File      string `json:"file"`
+	Reference string `json:"reference"`
+	Path      string `json:"path"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// findMissingAssets checks the local files referenced by compiled HTML, CSS
+// and JS against the compiled output. External URLs, data URIs, anchors and
+// extensionless links, which are client-side routes, are skipped.
+func findMissingAssets(compiled map[string]string) []MissingAsset {
+	var missing []MissingAsset
+	seen := make(map[string]bool)
+	check := func(file, content string, m []int, base string, open, close byte) {
+		ref := content[m[2]:m[3]]
+		target, ok := resolveAssetRef(ref, base)
+		if !ok {
+			return
+		}
+		if _, exists := compiled[target]; exists {
+			return
+		}
+		key := file + "\x00" + ref
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		asset := MissingAsset{File: file, Reference: ref, Path: target}
+		asset.Width, asset.Height = referenceDimensions(content, m[0], m[1], open, close)
+		if asset.Width == 0 || asset.Height == 0 {
+			asset.Width, asset.Height = nameDimensions(target)
+		}
+		missing = append(missing, asset)
+	}
+
+	for file, content := range compiled {
+		// References in HTML and CSS resolve against the file itself; strings
+		// in scripts end up in the page, so resolve against the root
+		dir := path.Dir(file)
+		switch path.Ext(file) {
+		case ".html", ".htm":
+			for _, m := range htmlRefPattern.FindAllStringSubmatchIndex(content, -1) {
+				check(file, content, m, dir, '<', '>')
+			}
+			for _, m := range cssRefPattern.FindAllStringSubmatchIndex(content, -1) {
+				check(file, content, m, dir, 0, 0)
+			}
+		case ".css":
+			for _, m := range cssRefPattern.FindAllStringSubmatchIndex(content, -1) {
+				check(file, content, m, dir, 0, 0)
+			}
+		case ".js", ".mjs":
+			// Component props like {src:"...",width:800} sit in the same object
+			for _, m := range jsAssetPattern.FindAllStringSubmatchIndex(content, -1) {
+				check(file, content, m, ".", '{', '}')
+			}
+		}
+	}
+
+	slices.SortFunc(missing, func(a, b MissingAsset) int {
+		return cmp.Or(cmp.Compare(a.File, b.File), cmp.Compare(a.Reference, b.Reference))
+	})
+	return missing
+}
+
+// resolveAssetRef returns the compiled file ref points to from a file in
+// base, or false if ref isn't a local file reference.
+func resolveAssetRef(ref, base string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref = ref[:i]
+	}
+	if ref == "" || strings.HasPrefix(ref, "//") || strings.Contains(ref, ":") ||
+		strings.ContainsAny(ref, "{}$") || path.Ext(ref) == "" {
+		return "", false
+	}
+	var target string
+	if strings.HasPrefix(ref, "/") {
+		target = path.Clean(ref)[1:]
+	} else {
+		target = path.Join(base, ref)
+	}
+	if target == "" || strings.HasPrefix(target, "../") {
+		return "", false
+	}
+	return target, true
+}
+
+var (
+	widthAttrPattern  = regexp.MustCompile(`\bwidth\s*[=:]\s*["']?(\d+)`)
+	heightAttrPattern = regexp.MustCompile(`\bheight\s*[=:]\s*["']?(\d+)`)
+	// nameSizePattern matches sizes in file names like hero-1200x600.jpg.
+	nameSizePattern = regexp.MustCompile(`(\d{2,4})x(\d{2,4})`)
+)
+
+// referenceDimensions reads width and height from the element or object
+// around content[start:end], bounded by open and close, looking no further
+// than a few hundred bytes either way.
+func referenceDimensions(content string, start, end int, open, close byte) (int, int) {
+	if open == 0 {
+		return 0, 0
+	}
+	const window = 300
+	from := strings.LastIndexByte(content[max(0, start-window):start], open)
+	to := strings.IndexByte(content[end:min(len(content), end+window)], close)
+	if from < 0 || to < 0 {
+		return 0, 0
+	}
+	scope := content[max(0, start-window)+from : end+to]
+	return matchedInt(widthAttrPattern, scope), matchedInt(heightAttrPattern, scope)
+}
+
+// nameDimensions reads a size from a file name like hero-1200x600.jpg.
+func nameDimensions(name string) (int, int) {
+	m := nameSizePattern.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return 0, 0
+	}
+	width, _ := strconv.Atoi(m[1])
+	height, _ := strconv.Atoi(m[2])
+	return width, height
+}
+
+func matchedInt(pattern *regexp.Regexp, s string) int {
+	m := pattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxAuditEntries caps how many entries GET /{uuid}/audit returns.
+const maxAuditEntries = 100
+
+// AuditEntry records a state-changing request against a project.
+type AuditEntry struct {
+	Time      time.Time      `json:"time"`
+	Method    string         `json:"method"`
+	Route     string         `json:"route"`
+	Status    int            `json:"status"`
+	RequestID string         `json:"request_id,omitempty"`
+	Identity  ClientIdentity `json:"identity"`
+}
+
+// AuditMiddleware records an audit entry for every non-GET request to a
+// project route, including who made it and how it ended. Form submissions
+// from the served app's visitors aren't changes to the project, so are
+// left out, as are immediate deletions, which would otherwise write the
+// entry back into the project they just removed.
+func (h *Handlers) AuditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions || isPublicViewRoute(r, chi.URLParam(r, "uuid")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		projectID := chi.URLParam(r, "uuid")
+		if validateUUID(projectID) != nil || isProjectDeleted(r, projectID, ww.Status()) {
+			return
+		}
+		entry := AuditEntry{
+			Time:      time.Now().UTC(),
+			Method:    r.Method,
+			Route:     chi.RouteContext(r.Context()).RoutePattern(),
+			Status:    ww.Status(),
+			RequestID: middleware.GetReqID(r.Context()),
+			Identity:  requestIdentity(r.Context()),
+		}
+		if err := h.storage.StoreAuditEntry(r.Context(), projectID, &entry); err != nil {
+			log.Printf("Error storing audit entry for project %s: %v", projectID, err)
+		}
+	})
+}
+
+// isProjectDeleted reports whether the request deleted the project
+// immediately, rather than scheduling its deletion.
+func isProjectDeleted(r *http.Request, projectID string, status int) bool {
+	_, rest, _ := strings.Cut(r.URL.Path, "/"+projectID)
+	return r.Method == http.MethodDelete && strings.Trim(rest, "/") == "" && status == http.StatusOK
+}
+
+// HandleGetAudit returns the project's most recent audit entries, newest first.
+func (h *Handlers) HandleGetAudit(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	limit := maxAuditEntries
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxAuditEntries {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", maxAuditEntries)})
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.storage.ListAuditEntries(r.Context(), projectID, limit)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to load audit log: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// API key scopes. Read keys can only make GET and HEAD requests.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// minAPIKeyLength is the shortest secret accepted in API_KEYS.
+const minAPIKeyLength = 16
+
+var apiKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// APIKey is a credential for the project API, configured in API_KEYS or
+// created through /admin/keys.
+type APIKey struct {
+	ID    string `json:"id"`
+	Scope string `json:"scope"`
+	// Projects, when set, are the only projects the key can access.
+	Projects  []string  `json:"projects,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+// allows reports whether the key grants scope on projectID. No key
+// grants access to a reserved namespace through the project API.
+func (k *APIKey) allows(projectID, scope string) bool {
+	if validateUUID(projectID) != nil {
+		return false
+	}
+	if len(k.Projects) > 0 && !slices.Contains(k.Projects, projectID) {
+		return false
+	}
+	return k.Scope == ScopeWrite || scope == ScopeRead
+}
+
+// hashAPIKey returns the hash API keys are looked up by.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKeys parses API_KEYS entries into keys by secret hash. Entries
+// with the same secret are merged, so a key can be listed once per project
+// it may access.
+func parseAPIKeys(entries []string) (map[string]APIKey, error) {
+	keys := make(map[string]APIKey)
+	for i, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, fmt.Errorf("entry %d must be id:scope:secret or id:scope:secret:project", i+1)
+		}
+		key := APIKey{ID: parts[0], Scope: parts[1]}
+		if err := key.validate(); err != nil {
+			return nil, err
+		}
+		secret := parts[2]
+		if len(secret) < minAPIKeyLength {
+			return nil, fmt.Errorf("key %q: secret must be at least %d characters", key.ID, minAPIKeyLength)
+		}
+		if len(parts) == 4 {
+			if err := validateUUID(parts[3]); err != nil {
+				return nil, fmt.Errorf("key %q: invalid project %q", key.ID, parts[3])
+			}
+			key.Projects = []string{parts[3]}
+		}
+
+		hash := hashAPIKey(secret)
+		if existing, ok := keys[hash]; ok {
+			if existing.ID != key.ID || existing.Scope != key.Scope || len(existing.Projects) == 0 || len(key.Projects) == 0 {
+				return nil, fmt.Errorf("key %q: secret is listed twice with different access", key.ID)
+			}
+			key.Projects = append(existing.Projects, key.Projects...)
+		}
+		keys[hash] = key
+	}
+	return keys, nil
+}
+
+// validate checks a key's ID, scope and projects.
+func (k *APIKey) validate() error {
+	if !apiKeyIDPattern.MatchString(k.ID) {
+		return fmt.Errorf("key ID %q must be 1-64 letters, digits, '_', '.' or '-'", k.ID)
+	}
+	if k.Scope != ScopeRead && k.Scope != ScopeWrite {
+		return fmt.Errorf("key %q: scope must be %q or %q", k.ID, ScopeRead, ScopeWrite)
+	}
+	for _, projectID := range k.Projects {
+		if err := validateUUID(projectID); err != nil {
+			return fmt.Errorf("key %q: invalid project %q", k.ID, projectID)
+		}
+	}
+	return nil
+}
+
+// bearerToken returns the request's bearer token, or "".
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// authenticate returns the key with the given secret: the admin token, a
+// configured key or a stored one.
+func (h *Handlers) authenticate(ctx context.Context, secret string) (*APIKey, error) {
+	if h.cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(h.cfg.AdminToken)) == 1 {
+		return &APIKey{ID: "admin", Scope: ScopeWrite}, nil
+	}
+	hash := hashAPIKey(secret)
+	if key, ok := h.apiKeys[hash]; ok {
+		return &key, nil
+	}
+	return h.storage.GetAPIKey(ctx, hash)
+}
+
+// isHookTrigger reports whether the request triggers a webhook or is a
+// forge push, which are authenticated by their signature instead of an
+// API key.
+func isHookTrigger(r *http.Request, projectID string) bool {
+	_, rest, _ := strings.Cut(r.URL.Path, "/"+projectID)
+	return r.Method == http.MethodPost && (strings.HasPrefix(rest, "/hooks/") || rest == "/git/push")
+}
+
+// isPublicViewRoute reports whether the request is for the served app
+// rather than the project API: the view, its assets, the files the view
+// page links to, and the app's form submissions and data.
+func isPublicViewRoute(r *http.Request, projectID string) bool {
+	_, rest, _ := strings.Cut(r.URL.Path, "/"+projectID)
+	if r.Method == http.MethodPost {
+		return strings.HasPrefix(rest, "/view/"+formsPath+"/")
+	}
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		return strings.HasPrefix(rest, "/view/"+dataPath+"/")
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	switch rest {
+	case "/view", "/embed.js", "/favicon.svg", "/manifest.webmanifest", "/" + serviceWorkerPath:
+		return true
+	}
+	return strings.HasPrefix(rest, "/view/") || strings.HasPrefix(rest, "/assets/")
+}
+
+// AuthMiddleware requires an API key bearing the right scope for the
+// project when AUTH_ENABLED is set. Reads need a read or write key and
+// anything else a write key. The view routes of public projects and
+// webhook triggers need none. The key's ID is recorded as the request's API key identity.
+// Invalid project IDs and reserved namespaces are refused whether or not
+// auth is enabled.
+func (h *Handlers) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "uuid")
+		if err := validateUUID(projectID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if !h.cfg.AuthEnabled || isHookTrigger(r, projectID) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := bearerToken(r)
+		if secret == "" && isPublicViewRoute(r, projectID) {
+			settings, err := h.projectSettings(r.Context(), projectID)
+			if err != nil {
+				writeError(w, upstreamError("Failed to load settings", err))
+				return
+			}
+			if settings.Public {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if secret == "" {
+			writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+			return
+		}
+
+		key, err := h.authenticate(r.Context(), secret)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+			return
+		}
+		if err != nil {
+			writeError(w, upstreamError("Failed to check API key", err))
+			return
+		}
+		scope := ScopeWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = ScopeRead
+		}
+		if !key.allows(projectID, scope) {
+			writeError(w, AppError{Code: http.StatusForbidden, Message: fmt.Sprintf("API key lacks %s access to this project", scope)})
+			return
+		}
+
+		identity := requestIdentity(r.Context())
+		identity.APIKeyID = key.ID
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	})
+}
+
+// CreateAPIKeyResponse is a created key with its secret, which is only
+// ever returned here.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// HandleCreateAPIKey creates a stored API key with a generated secret.
+func (h *Handlers) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var key APIKey
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON body"})
+		return
+	}
+	if err := key.validate(); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	keys, err := h.storage.ListAPIKeys(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to list API keys", err))
+		return
+	}
+	for _, existing := range keys {
+		if existing.ID == key.ID {
+			writeError(w, AppError{Code: http.StatusConflict, Message: fmt.Sprintf("API key %q already exists", key.ID)})
+			return
+		}
+	}
+	for _, existing := range h.apiKeys {
+		if existing.ID == key.ID {
+			writeError(w, AppError{Code: http.StatusConflict, Message: fmt.Sprintf("API key %q is configured in API_KEYS", key.ID)})
+			return
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		writeError(w, err)
+		return
+	}
+	key.CreatedAt = time.Now().UTC()
+	resp := CreateAPIKeyResponse{APIKey: key, Key: hex.EncodeToString(secret)}
+	if err := h.storage.StoreAPIKey(r.Context(), hashAPIKey(resp.Key), &key); err != nil {
+		writeError(w, upstreamError("Failed to store API key", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// HandleListAPIKeys returns the stored and configured API keys, without
+// their secrets.
+func (h *Handlers) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	stored, err := h.storage.ListAPIKeys(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to list API keys", err))
+		return
+	}
+	keys := make([]APIKey, 0, len(stored)+len(h.apiKeys))
+	for _, key := range h.apiKeys {
+		keys = append(keys, key)
+	}
+	for _, key := range stored {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b APIKey) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// HandleDeleteAPIKey revokes a stored API key. Keys in API_KEYS can only
+// be revoked by removing them from the configuration.
+func (h *Handlers) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "keyID")
+	keys, err := h.storage.ListAPIKeys(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to list API keys", err))
+		return
+	}
+	for hash, key := range keys {
+		if key.ID != id {
+			continue
+		}
+		if err := h.storage.DeleteAPIKey(r.Context(), hash); err != nil {
+			writeError(w, upstreamError("Failed to delete API key", err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, AppError{Code: http.StatusNotFound, Message: "API key not found"})
+}
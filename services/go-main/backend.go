@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Storage backends selectable with STORAGE_BACKEND.
+const (
+	BackendRustDB     = "rustdb"
+	BackendMemory     = "memory"
+	BackendFilesystem = "filesystem"
+)
+
+// StorageBackend is the key-value store Storage keeps projects in. Keys are
+// namespaced by project and ETags are the MD5 of the content, as in Rust DB.
+// RustDBClient is the production backend; MemoryBackend and FileBackend run
+// without Rust DB for local development and tests.
+type StorageBackend interface {
+	// Store saves content under key.
+	Store(ctx context.Context, project, key, mimeType string, content []byte) error
+	// StoreIf saves content only if the key's current ETag is etag or, when
+	// etag is "*", only if the key doesn't exist yet. Otherwise it returns
+	// ErrPreconditionFailed.
+	StoreIf(ctx context.Context, project, key, mimeType string, content []byte, etag string) error
+	// Get returns a key's content and mime type, or ErrNotFound.
+	Get(ctx context.Context, project, key string) ([]byte, string, error)
+	// GetStream is like Get but returns the content as a stream, so large
+	// files can be copied out without holding them in memory. The caller
+	// must close it.
+	GetStream(ctx context.Context, project, key string) (*StoredStream, error)
+	// GetMany returns several keys, leaving out those that don't exist.
+	GetMany(ctx context.Context, project string, keys []string) (map[string]StoredValue, error)
+	// List returns the keys starting with prefix, sorted.
+	List(ctx context.Context, project, prefix string) ([]KeyInfo, error)
+	// ListDetailed is like List but also returns each key's size and ETag.
+	ListDetailed(ctx context.Context, project, prefix string) ([]KeyInfo, error)
+	// Delete removes a key; deleting a missing key is not an error.
+	Delete(ctx context.Context, project, key string) error
+	// DeleteIf removes a key only if its current ETag is etag.
+	DeleteIf(ctx context.Context, project, key, etag string) error
+}
+
+// NewStorageBackend creates the backend selected by cfg. transport is used
+// for calls to Rust DB.
+func NewStorageBackend(cfg Config, transport http.RoundTripper) (StorageBackend, error) {
+	switch cfg.StorageBackend {
+	case BackendRustDB:
+		return NewRustDBClient(cfg.RustDBURL, cfg.StorageTimeout, transport), nil
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	case BackendFilesystem:
+		return NewFileBackend(cfg.StorageDir)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (expected %s, %s or %s)",
+			cfg.StorageBackend, BackendRustDB, BackendMemory, BackendFilesystem)
+	}
+}
+
+// checkPrecondition applies StoreIf and DeleteIf semantics to a key's
+// current content.
+func checkPrecondition(current []byte, exists bool, etag string) error {
+	switch {
+	case etag == "":
+		return nil
+	case etag == "*" && exists:
+		return ErrPreconditionFailed
+	case etag != "*" && (!exists || contentETag(current) != etag):
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// keyInfo describes a stored entry as List and ListDetailed return it.
+func keyInfo(key, mimeType string, content []byte, details bool) KeyInfo {
+	info := KeyInfo{Key: key, MimeType: mimeType}
+	if details {
+		info.Size = int64(len(content))
+		info.ETag = contentETag(content)
+	}
+	return info
+}
+
+func sortKeyInfos(infos []KeyInfo) []KeyInfo {
+	slices.SortFunc(infos, func(a, b KeyInfo) int { return cmp.Compare(a.Key, b.Key) })
+	return infos
+}
+
+// MemoryBackend keeps everything in memory, so projects are lost on
+// restart and can't be shared between instances.
+type MemoryBackend struct {
+	mu       sync.RWMutex
+	projects map[string]map[string]StoredValue
+}
+
+// NewMemoryBackend creates an empty in-memory backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{projects: make(map[string]map[string]StoredValue)}
+}
+
+func (m *MemoryBackend) Store(ctx context.Context, project, key, mimeType string, content []byte) error {
+	return m.StoreIf(ctx, project, key, mimeType, content, "")
+}
+
+func (m *MemoryBackend) StoreIf(_ context.Context, project, key, mimeType string, content []byte, etag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.projects[project]
+	current, exists := entries[key]
+	if err := checkPrecondition(current.Content, exists, etag); err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = make(map[string]StoredValue)
+		m.projects[project] = entries
+	}
+	entries[key] = StoredValue{Content: slices.Clone(content), MimeType: mimeType}
+	return nil
+}
+
+func (m *MemoryBackend) Get(_ context.Context, project, key string) ([]byte, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.projects[project][key]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	return slices.Clone(value.Content), value.MimeType, nil
+}
+
+// GetStream reads straight from the stored value, which writes replace
+// rather than modify.
+func (m *MemoryBackend) GetStream(_ context.Context, project, key string) (*StoredStream, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.projects[project][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &StoredStream{
+		ReadCloser: io.NopCloser(bytes.NewReader(value.Content)),
+		Size:       int64(len(value.Content)),
+		MimeType:   value.MimeType,
+	}, nil
+}
+
+func (m *MemoryBackend) GetMany(_ context.Context, project string, keys []string) (map[string]StoredValue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[string]StoredValue, len(keys))
+	for _, key := range keys {
+		if value, ok := m.projects[project][key]; ok {
+			result[key] = StoredValue{Content: slices.Clone(value.Content), MimeType: value.MimeType}
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryBackend) List(_ context.Context, project, prefix string) ([]KeyInfo, error) {
+	return m.list(project, prefix, false), nil
+}
+
+func (m *MemoryBackend) ListDetailed(_ context.Context, project, prefix string) ([]KeyInfo, error) {
+	return m.list(project, prefix, true), nil
+}
+
+func (m *MemoryBackend) list(project, prefix string, details bool) []KeyInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	infos := []KeyInfo{}
+	for key, value := range m.projects[project] {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, keyInfo(key, value.MimeType, value.Content, details))
+		}
+	}
+	return sortKeyInfos(infos)
+}
+
+func (m *MemoryBackend) Delete(ctx context.Context, project, key string) error {
+	return m.DeleteIf(ctx, project, key, "")
+}
+
+func (m *MemoryBackend) DeleteIf(_ context.Context, project, key, etag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, exists := m.projects[project][key]
+	if err := checkPrecondition(current.Content, exists, etag); err != nil {
+		return err
+	}
+	delete(m.projects[project], key)
+	return nil
+}
+
+// FileBackend stores each project in a directory under root, with one file
+// per key (the key path-escaped, so keys can't leave the directory) and its
+// mime type alongside it. Conditional writes are only atomic within one
+// process, so a directory must not be shared between instances.
+type FileBackend struct {
+	root string
+	mu   sync.RWMutex
+}
+
+// fileMimeSuffix marks the file holding an entry's mime type. An escaped key
+// could only end with it if the key contained a NUL byte, which Rust DB
+// doesn't allow either.
+const fileMimeSuffix = "%00mime"
+
+// NewFileBackend creates a backend storing projects under root, creating
+// the directory if needed.
+func NewFileBackend(root string) (*FileBackend, error) {
+	if root == "" {
+		return nil, errors.New("STORAGE_DIR is required for the filesystem backend")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FileBackend{root: root}, nil
+}
+
+// projectDir returns the directory holding project's entries.
+func (f *FileBackend) projectDir(project string) (string, error) {
+	if project == "" || project != filepath.Base(project) || project == "." || project == ".." {
+		return "", fmt.Errorf("invalid project %q", project)
+	}
+	return filepath.Join(f.root, project), nil
+}
+
+// entryPath returns the files holding key's content and mime type.
+func (f *FileBackend) entryPath(project, key string) (string, string, error) {
+	dir, err := f.projectDir(project)
+	if err != nil {
+		return "", "", err
+	}
+	name := url.PathEscape(key)
+	if key == "" || key == "." || key == ".." || len(name)+len(fileMimeSuffix) > 255 {
+		return "", "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(dir, name), filepath.Join(dir, name+fileMimeSuffix), nil
+}
+
+// read returns an entry's content and mime type, and whether it exists.
+func (f *FileBackend) read(project, key string) ([]byte, string, bool, error) {
+	contentPath, mimePath, err := f.entryPath(project, key)
+	if err != nil {
+		return nil, "", false, err
+	}
+	content, err := os.ReadFile(contentPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", false, nil
+	}
+	if err != nil {
+		return nil, "", false, err
+	}
+	mimeType, err := os.ReadFile(mimePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, "", false, err
+	}
+	return content, string(mimeType), true, nil
+}
+
+// fileTempPrefix starts the names of files being written. Escaped keys
+// can't start with it since url.PathEscape escapes "%".
+const fileTempPrefix = "%tmp-"
+
+// writeFileAtomic replaces name with data so readers never see a partial
+// write.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), fileTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (f *FileBackend) Store(ctx context.Context, project, key, mimeType string, content []byte) error {
+	return f.StoreIf(ctx, project, key, mimeType, content, "")
+}
+
+func (f *FileBackend) StoreIf(_ context.Context, project, key, mimeType string, content []byte, etag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, _, exists, err := f.read(project, key)
+	if err != nil {
+		return err
+	}
+	if err := checkPrecondition(current, exists, etag); err != nil {
+		return err
+	}
+	contentPath, mimePath, _ := f.entryPath(project, key)
+	if err := os.MkdirAll(filepath.Dir(contentPath), 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(mimePath, []byte(mimeType)); err != nil {
+		return err
+	}
+	return writeFileAtomic(contentPath, content)
+}
+
+func (f *FileBackend) Get(_ context.Context, project, key string) ([]byte, string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	content, mimeType, exists, err := f.read(project, key)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		return nil, "", ErrNotFound
+	}
+	return content, mimeType, nil
+}
+
+// GetStream opens the content file. Writes replace files by rename, so an
+// open stream keeps reading the content it started with.
+func (f *FileBackend) GetStream(_ context.Context, project, key string) (*StoredStream, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	contentPath, mimePath, err := f.entryPath(project, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(contentPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	mimeType, err := os.ReadFile(mimePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = file.Close()
+		return nil, err
+	}
+	return &StoredStream{ReadCloser: file, Size: info.Size(), MimeType: string(mimeType)}, nil
+}
+
+func (f *FileBackend) GetMany(_ context.Context, project string, keys []string) (map[string]StoredValue, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	result := make(map[string]StoredValue, len(keys))
+	for _, key := range keys {
+		content, mimeType, exists, err := f.read(project, key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			result[key] = StoredValue{Content: content, MimeType: mimeType}
+		}
+	}
+	return result, nil
+}
+
+func (f *FileBackend) List(_ context.Context, project, prefix string) ([]KeyInfo, error) {
+	return f.list(project, prefix, false)
+}
+
+func (f *FileBackend) ListDetailed(_ context.Context, project, prefix string) ([]KeyInfo, error) {
+	return f.list(project, prefix, true)
+}
+
+func (f *FileBackend) list(project, prefix string, details bool) ([]KeyInfo, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	dir, err := f.projectDir(project)
+	if err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []KeyInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []KeyInfo{}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if strings.HasSuffix(name, fileMimeSuffix) || strings.HasPrefix(name, fileTempPrefix) {
+			continue
+		}
+		key, err := url.PathUnescape(name)
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if !details {
+			mimeType, _ := os.ReadFile(filepath.Join(dir, name+fileMimeSuffix))
+			infos = append(infos, KeyInfo{Key: key, MimeType: string(mimeType)})
+			continue
+		}
+		content, mimeType, exists, err := f.read(project, key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			infos = append(infos, keyInfo(key, mimeType, content, true))
+		}
+	}
+	return sortKeyInfos(infos), nil
+}
+
+func (f *FileBackend) Delete(ctx context.Context, project, key string) error {
+	return f.DeleteIf(ctx, project, key, "")
+}
+
+func (f *FileBackend) DeleteIf(_ context.Context, project, key, etag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, _, exists, err := f.read(project, key)
+	if err != nil {
+		return err
+	}
+	if err := checkPrecondition(current, exists, etag); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	contentPath, mimePath, _ := f.entryPath(project, key)
+	if err := os.Remove(contentPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(mimePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
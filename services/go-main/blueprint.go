@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// blueprintVersion is the blueprint format written by exports.
+const blueprintVersion = 1
+
+// Blueprint is a reusable project configuration: the settings a project
+// changed, its build toggles, and a prompt template to create apps from.
+type Blueprint struct {
+	Version  int                        `json:"version"`
+	Settings map[string]json.RawMessage `json:"settings,omitempty"`
+	PWA      bool                       `json:"pwa"`
+	SRI      bool                       `json:"sri"`
+	// Prompt is a template rendered with PromptTemplateData, with the
+	// variables given at creation as Payload. Exports contain the prompt
+	// the project was created from.
+	Prompt string `json:"prompt"`
+}
+
+// CreateFromBlueprintRequest is the request body for creating a project
+// from a blueprint.
+type CreateFromBlueprintRequest struct {
+	Blueprint Blueprint      `json:"blueprint"`
+	Variables map[string]any `json:"variables"`
+}
+
+// HandleExportBlueprint returns the project's configuration as a blueprint.
+// Settings locked by the organization defaults are left out.
+func (h *Handlers) HandleExportBlueprint(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	org, err := h.orgDefaults(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	overrides, err := h.storage.GetSettings(r.Context(), projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	maps.DeleteFunc(overrides, func(name string, _ json.RawMessage) bool {
+		return slices.Contains(org.Locked, name)
+	})
+
+	bp := Blueprint{Version: blueprintVersion, Settings: overrides}
+	if pwa, err := h.storage.GetPWA(r.Context(), projectID); err == nil {
+		bp.PWA = pwa.Enabled
+	}
+	if sri, err := h.storage.GetSRI(r.Context(), projectID); err == nil {
+		bp.SRI = sri.Enabled
+	}
+	if prompt, err := h.storage.GetPrompt(r.Context(), projectID); err == nil {
+		bp.Prompt = prompt
+	}
+
+	writeJSON(w, http.StatusOK, bp)
+}
+
+// HandleCreateFromBlueprint applies a blueprint's settings and toggles to a
+// new project, then creates its app from the rendered prompt template.
+func (h *Handlers) HandleCreateFromBlueprint(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req CreateFromBlueprintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	bp := req.Blueprint
+	if bp.Version != blueprintVersion {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Unsupported blueprint version %d", bp.Version)})
+		return
+	}
+	if bp.Prompt == "" {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Blueprint prompt is required"})
+		return
+	}
+	if _, err := template.New("blueprint").Parse(bp.Prompt); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid prompt template: %v", err)})
+		return
+	}
+
+	if _, err := h.storage.GetMetadata(r.Context(), projectID); err == nil {
+		writeError(w, AppError{Code: http.StatusConflict, Message: "Project already exists"})
+		return
+	} else if !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to check project", err))
+		return
+	}
+
+	org, err := h.orgDefaults(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	overrides := map[string]json.RawMessage{}
+	for name := range bp.Settings {
+		if slices.Contains(org.Locked, name) {
+			writeError(w, AppError{Code: http.StatusForbidden, Message: fmt.Sprintf("Setting %q is locked by the organization defaults", name)})
+			return
+		}
+	}
+	if err := mergeSettingsPatch(overrides, bp.Settings); err != nil {
+		writeError(w, err)
+		return
+	}
+	settings, err := resolveSettings(h.cfg, org, overrides)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	if err := settings.validate(h.cfg); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	prompt, err := renderPrompt("blueprint", bp.Prompt, PromptTemplateData{Now: time.Now().UTC(), Payload: req.Variables})
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Failed to render prompt template: %v", err)})
+		return
+	}
+
+	if len(overrides) > 0 {
+		if err := h.storage.StoreSettings(r.Context(), projectID, overrides); err != nil {
+			writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store settings: %v", err)})
+			return
+		}
+	}
+	if bp.PWA {
+		if err := h.storage.StorePWA(r.Context(), projectID, &PWASettings{Enabled: true}); err != nil {
+			writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store PWA settings: %v", err)})
+			return
+		}
+	}
+	if bp.SRI {
+		if err := h.storage.StoreSRI(r.Context(), projectID, &SRISettings{Enabled: true}); err != nil {
+			writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store SRI settings: %v", err)})
+			return
+		}
+	}
+
+	h.createApp(w, r, projectID, prompt)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxBrandColors and maxBrandFonts cap the entries of a brand kit.
+const (
+	maxBrandColors = 32
+	maxBrandFonts  = 8
+)
+
+// brandNamePattern matches a brand color or font name, which becomes part
+// of a CSS variable name.
+var brandNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// brandValuePattern matches a brand color or font value. It can't end the
+// declaration or the style element it is injected into.
+var brandValuePattern = regexp.MustCompile(`^[^;{}<>\\\r\n]{1,100}$`)
+
+type brandKitKey struct{}
+
+// withBrandKit attaches the brand kit to agent requests made with ctx.
+func withBrandKit(ctx context.Context, kit *BrandKit) context.Context {
+	if kit == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, brandKitKey{}, kit)
+}
+
+// brandKit returns the brand kit attached to ctx, if any.
+func brandKit(ctx context.Context) *BrandKit {
+	kit, _ := ctx.Value(brandKitKey{}).(*BrandKit)
+	return kit
+}
+
+// validateBrandKit checks a brand kit's names and values.
+func validateBrandKit(kit *BrandKit) error {
+	if len(kit.Colors) > maxBrandColors {
+		return AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("A brand kit has at most %d colors", maxBrandColors)}
+	}
+	if len(kit.Fonts) > maxBrandFonts {
+		return AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("A brand kit has at most %d fonts", maxBrandFonts)}
+	}
+	for kind, entries := range map[string]map[string]string{"color": kit.Colors, "font": kit.Fonts} {
+		for name, value := range entries {
+			if !brandNamePattern.MatchString(name) {
+				return AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid %s name %q: use lowercase letters, digits and dashes", kind, name)}
+			}
+			if !brandValuePattern.MatchString(strings.TrimSpace(value)) {
+				return AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid %s value for %s", kind, name)}
+			}
+		}
+	}
+	if kit.Logo != "" && (validatePath(kit.Logo) != "" || !libraryRefPattern.MatchString(libraryRefPrefix+kit.Logo)) {
+		return AppError{Code: http.StatusBadRequest, Message: "Invalid logo asset name"}
+	}
+	return nil
+}
+
+// brandStyle renders a brand kit as CSS variables on :root:
+// --brand-color-{name}, --brand-font-{name} and --brand-logo.
+func brandStyle(kit *BrandKit) string {
+	var b strings.Builder
+	b.WriteString(`<style id="brand-kit">:root{`)
+	for _, name := range slices.Sorted(maps.Keys(kit.Colors)) {
+		fmt.Fprintf(&b, "--brand-color-%s:%s;", name, strings.TrimSpace(kit.Colors[name]))
+	}
+	for _, name := range slices.Sorted(maps.Keys(kit.Fonts)) {
+		fmt.Fprintf(&b, "--brand-font-%s:%s;", name, strings.TrimSpace(kit.Fonts[name]))
+	}
+	if kit.Logo != "" {
+		fmt.Fprintf(&b, `--brand-logo:url("./%s%s");`, libraryAssetsDir, kit.Logo)
+	}
+	b.WriteString("}</style>")
+	return b.String()
+}
+
+// projectBrandKit returns the brand kit of the library projectID is linked
+// to, or nil if it isn't linked or the library has none.
+func (h *Handlers) projectBrandKit(ctx context.Context, projectID string) (*BrandKit, error) {
+	lib, err := h.storage.GetProjectLibrary(ctx, projectID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	kit, err := h.storage.GetBrandKit(ctx, lib.Namespace)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return kit, err
+}
+
+// brandContext returns the brand kit the agent follows for projectID: its
+// library's, or for a project not linked to a library, the caller's. Such
+// a project is linked to the caller's library, so its builds get the kit's
+// variables and logo too. Failures are only logged, so the agent just
+// goes unbranded.
+func (h *Handlers) brandContext(ctx context.Context, projectID string) *BrandKit {
+	lib, err := h.storage.GetProjectLibrary(ctx, projectID)
+	if err == nil {
+		kit, err := h.storage.GetBrandKit(ctx, lib.Namespace)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Error loading brand kit of project %s: %v", projectID, err)
+		}
+		return kit
+	}
+	if !errors.Is(err, ErrNotFound) {
+		log.Printf("Error loading library link of project %s: %v", projectID, err)
+		return nil
+	}
+
+	owner := identityOwner(requestIdentity(ctx))
+	kit, err := h.storage.GetBrandKit(ctx, libraryID(owner))
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf("Error loading brand kit of %s: %v", owner, err)
+		}
+		return nil
+	}
+	if err := h.storage.StoreProjectLibrary(ctx, projectID, &ProjectLibrary{Owner: owner, Namespace: libraryID(owner)}); err != nil {
+		log.Printf("Error linking project %s to the library of %s: %v", projectID, owner, err)
+	}
+	return kit
+}
+
+// addBrandKit injects the project's brand kit into the page as CSS
+// variables.
+func (h *Handlers) addBrandKit(ctx context.Context, projectID string, compiledFiles map[string]string) (map[string]string, error) {
+	kit, err := h.projectBrandKit(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load brand kit: %w", err)
+	}
+	page, ok := compiledFiles["index.html"]
+	if kit == nil || !ok {
+		return compiledFiles, nil
+	}
+
+	out := maps.Clone(compiledFiles)
+	style := brandStyle(kit)
+	if i := strings.Index(page, "</head>"); i >= 0 {
+		out["index.html"] = page[:i] + style + page[i:]
+	} else {
+		out["index.html"] = style + page
+	}
+	return out, nil
+}
+
+// HandleGetBrandKit returns the caller's brand kit.
+func (h *Handlers) HandleGetBrandKit(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.libraryOwner(w, r, ScopeRead)
+	if !ok {
+		return
+	}
+	kit, err := h.storage.GetBrandKit(r.Context(), libraryID(owner))
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "No brand kit defined"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to load brand kit", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, kit)
+}
+
+// HandlePutBrandKit sets the caller's brand kit. The agent follows it from
+// the next generation, and linked projects' builds use it from their next
+// build.
+func (h *Handlers) HandlePutBrandKit(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.libraryOwner(w, r, ScopeWrite)
+	if !ok {
+		return
+	}
+	var kit BrandKit
+	if err := json.NewDecoder(r.Body).Decode(&kit); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if err := validateBrandKit(&kit); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	library := libraryID(owner)
+	if kit.Logo != "" {
+		if _, _, err := h.storage.GetLibraryAsset(r.Context(), library, kit.Logo); errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Logo %s is not in the library", kit.Logo)})
+			return
+		} else if err != nil {
+			writeError(w, upstreamError("Failed to check logo", err))
+			return
+		}
+	}
+	kit.UpdatedAt = time.Now().UTC()
+	if err := h.storage.StoreBrandKit(r.Context(), library, &kit); err != nil {
+		writeError(w, upstreamError("Failed to store brand kit", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, kit)
+}
+
+// HandleDeleteBrandKit removes the caller's brand kit.
+func (h *Handlers) HandleDeleteBrandKit(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.libraryOwner(w, r, ScopeWrite)
+	if !ok {
+		return
+	}
+	if err := h.storage.DeleteBrandKit(r.Context(), libraryID(owner)); err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to delete brand kit", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
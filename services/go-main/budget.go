@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// budgetReserve is held back from each downstream call so a request that
+// exhausts its budget still has time to store results and respond.
+const budgetReserve = 2 * time.Second
+
+type (
+	budgetKey    struct{}
+	operationKey struct{}
+)
+
+// requestBudget records when a request started and when its budget runs out.
+type requestBudget struct {
+	start    time.Time
+	deadline time.Time
+}
+
+// BudgetMiddleware gives each request a total latency budget. Downstream
+// calls derive their deadlines from what remains via budgetStep. A zero
+// total leaves requests unbounded.
+func BudgetMiddleware(total time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if total <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx, cancel := context.WithDeadline(r.Context(), start.Add(total))
+			defer cancel()
+			ctx = context.WithValue(ctx, budgetKey{}, requestBudget{start: start, deadline: start.Add(total)})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// budgetStep derives a context for one downstream operation. Its deadline
+// is the smaller of limit (when positive) and the request's remaining
+// budget less budgetReserve. Contexts without a budget only get limit.
+func budgetStep(ctx context.Context, operation string, limit time.Duration) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, operationKey{}, operation)
+
+	if b, ok := ctx.Value(budgetKey{}).(requestBudget); ok {
+		remaining := time.Until(b.deadline)
+		if remaining > 2*budgetReserve {
+			remaining -= budgetReserve
+		}
+		if limit <= 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	if limit <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, limit)
+}
+
+// BudgetExceededError reports a downstream operation that ran out of time.
+// Budget and Elapsed are zero for calls made outside a request.
+type BudgetExceededError struct {
+	Operation string
+	Budget    time.Duration
+	Elapsed   time.Duration
+	Err       error
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s exceeded the request time budget (budget %s, elapsed %s): %v",
+		e.Operation, e.Budget, e.Elapsed.Round(time.Millisecond), e.Err)
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return e.Err
+}
+
+// budgetError converts err into a BudgetExceededError when the step's
+// deadline caused it, and returns it unchanged otherwise.
+func budgetError(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	budgetErr := &BudgetExceededError{Err: err}
+	budgetErr.Operation, _ = ctx.Value(operationKey{}).(string)
+	if b, ok := ctx.Value(budgetKey{}).(requestBudget); ok {
+		budgetErr.Budget = b.deadline.Sub(b.start)
+		budgetErr.Elapsed = time.Since(b.start)
+	}
+	return budgetErr
+}
+
+// BudgetErrorResponse is the JSON body for a request that ran out of time.
+type BudgetErrorResponse struct {
+	Message   string `json:"error"`
+	Operation string `json:"operation"`
+	BudgetMS  int64  `json:"budget_ms"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+}
+
+// upstreamError wraps a downstream failure as a 500 with the given message,
+// unless it ran out of time, partially stored files or read corrupted
+// content, in which case the details are kept.
+func upstreamError(message string, err error) error {
+	var budgetErr *BudgetExceededError
+	var partialErr *PartialStoreError
+	var integrityErr *IntegrityError
+	var circuitErr *CircuitOpenError
+	if errors.As(err, &budgetErr) || errors.As(err, &partialErr) || errors.As(err, &integrityErr) || errors.As(err, &circuitErr) {
+		return err
+	}
+	return AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("%s: %v", message, err)}
+}
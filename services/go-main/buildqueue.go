@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// BuildStatus is the state of the project's latest build through the
+// queue, stored in _meta/build.json for clients to poll.
+type BuildStatus struct {
+	State      string     `json:"state"`
+	Trigger    string     `json:"trigger"`
+	Channel    string     `json:"channel"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Error is the build's error output when it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// buildJob is a build waiting in the queue and everyone waiting on it.
+type buildJob struct {
+	ctx      context.Context
+	channel  string
+	run      func(context.Context) error
+	queuedAt time.Time
+	waiters  []chan error
+}
+
+// BuildQueue runs builds on a bounded number of workers, one at a time
+// per project. A build submitted while another for the same project and
+// channel is still queued replaces it, since only the latest source
+// matters; both callers get its result.
+type BuildQueue struct {
+	storage *Storage
+	slots   chan struct{}
+
+	mu      sync.Mutex
+	running map[string]bool
+	pending map[string][]*buildJob
+}
+
+// NewBuildQueue creates a queue running at most workers builds at once.
+func NewBuildQueue(storage *Storage, workers int) *BuildQueue {
+	return &BuildQueue{
+		storage: storage,
+		slots:   make(chan struct{}, max(workers, 1)),
+		running: make(map[string]bool),
+		pending: make(map[string][]*buildJob),
+	}
+}
+
+// Submit queues run as a build of projectID's channel and returns a
+// channel that receives its error once it has run.
+func (q *BuildQueue) Submit(ctx context.Context, projectID, channel string, run func(context.Context) error) <-chan error {
+	done := make(chan error, 1)
+
+	q.mu.Lock()
+	var job *buildJob
+	for _, queued := range q.pending[projectID] {
+		if queued.channel == channel {
+			job = queued
+			break
+		}
+	}
+	if job == nil {
+		job = &buildJob{channel: channel, queuedAt: time.Now().UTC()}
+		q.pending[projectID] = append(q.pending[projectID], job)
+	}
+	job.ctx, job.run = ctx, run
+	job.waiters = append(job.waiters, done)
+	start := !q.running[projectID]
+	q.running[projectID] = true
+	q.mu.Unlock()
+
+	// While another build of the project runs, its status stands until
+	// this one starts
+	if start {
+		q.setStatus(ctx, projectID, &BuildStatus{State: BuildQueued, Trigger: buildTrigger(ctx), Channel: channel, QueuedAt: job.queuedAt})
+		go q.process(projectID)
+	}
+	return done
+}
+
+// process runs projectID's queued builds in order until none are left.
+func (q *BuildQueue) process(projectID string) {
+	for {
+		q.slots <- struct{}{}
+		q.mu.Lock()
+		job := q.pending[projectID][0]
+		q.pending[projectID] = q.pending[projectID][1:]
+		q.mu.Unlock()
+
+		status := &BuildStatus{State: BuildBuilding, Trigger: buildTrigger(job.ctx), Channel: job.channel, QueuedAt: job.queuedAt}
+		started := time.Now().UTC()
+		status.StartedAt = &started
+		q.setStatus(job.ctx, projectID, status)
+
+		err := job.run(job.ctx)
+		<-q.slots
+
+		finished := time.Now().UTC()
+		status.FinishedAt = &finished
+		status.State = BuildSucceeded
+		if err != nil {
+			status.State = BuildFailed
+			status.Error = err.Error()
+		}
+		q.setStatus(job.ctx, projectID, status)
+		for _, waiter := range job.waiters {
+			waiter <- err
+		}
+
+		q.mu.Lock()
+		if len(q.pending[projectID]) == 0 {
+			delete(q.pending, projectID)
+			delete(q.running, projectID)
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (q *BuildQueue) setStatus(ctx context.Context, projectID string, status *BuildStatus) {
+	if err := q.storage.StoreBuildStatus(context.WithoutCancel(ctx), projectID, status); err != nil {
+		log.Printf("Error storing build status for project %s: %v", projectID, err)
+	}
+}
+
+// HandleGetBuildStatus returns the state of the project's latest build, for
+// clients to poll after an edit.
+func (h *Handlers) HandleGetBuildStatus(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	status, err := h.storage.GetBuildStatus(r.Context(), projectID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "No builds for this project"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to get build status", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// HandleRebuild rebuilds the app from its stored source files and returns
+// the resulting build status.
+func (h *Handlers) HandleRebuild(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "rebuild")
+	if !ok {
+		return
+	}
+	defer release()
+
+	err := h.rebuild(withBuildTrigger(r.Context(), "api"), projectID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to rebuild app", err))
+		return
+	}
+
+	status, err := h.storage.GetBuildStatus(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to get build status", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
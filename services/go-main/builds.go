@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBuildRecords caps how many records GET /{uuid}/builds returns.
+const maxBuildRecords = 100
+
+// Build statuses. Build records are only ever succeeded or failed; the
+// project's build status is also queued or building while in the queue,
+// and chat build events are repairing while the agent fixes a failure.
+const (
+	BuildQueued    = "queued"
+	BuildBuilding  = "building"
+	BuildRepairing = "repairing"
+	BuildSucceeded = "succeeded"
+	BuildFailed    = "failed"
+)
+
+// BuildRecord describes one compile of a project's source, kept so builds
+// can be compared when one starts failing. Builds run by the agent during
+// create and edit have no duration or tool versions, which it doesn't
+// report.
+type BuildRecord struct {
+	ID           string            `json:"id"`
+	Trigger      string            `json:"trigger"`
+	Attempt      int               `json:"attempt,omitempty"`
+	InputHash    string            `json:"input_hash"`
+	Inputs       map[string]string `json:"inputs,omitempty"`
+	StartedAt    time.Time         `json:"started_at"`
+	DurationMS   int64             `json:"duration_ms,omitempty"`
+	ToolVersions map[string]string `json:"tool_versions,omitempty"`
+	Warnings     []string          `json:"warnings,omitempty"`
+	Artifacts    map[string]int    `json:"artifacts,omitempty"`
+	// Sources maps compiled JS files to the source files bundled into
+	// them, so runtime errors can be traced back for the agent to fix.
+	Sources map[string][]string `json:"sources,omitempty"`
+	// MissingAssets are references in the output to files it doesn't
+	// contain.
+	MissingAssets []MissingAsset `json:"missing_assets,omitempty"`
+	Status        string         `json:"status"`
+	Error         string         `json:"error,omitempty"`
+}
+
+type buildTriggerKey struct{}
+
+// withBuildTrigger records what caused any builds run with ctx.
+func withBuildTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, buildTriggerKey{}, trigger)
+}
+
+// buildTrigger returns what caused a build, or "unknown".
+func buildTrigger(ctx context.Context) string {
+	if trigger, ok := ctx.Value(buildTriggerKey{}).(string); ok {
+		return trigger
+	}
+	return "unknown"
+}
+
+// inputHash identifies a set of source files independent of map order.
+func inputHash(files map[string]string) string {
+	h := sha256.New()
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		fmt.Fprintf(h, "%s\x00%d\x00%s", path, len(files[path]), files[path])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// inputHashes returns the SHA-256 of each source file.
+func inputHashes(files map[string]string) map[string]string {
+	hashes := make(map[string]string, len(files))
+	for path, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		hashes[path] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
+
+// artifactSizes returns the size in bytes of each compiled file.
+func artifactSizes(compiled map[string]string) map[string]int {
+	sizes := make(map[string]int, len(compiled))
+	for path, content := range compiled {
+		sizes[path] = len(content)
+	}
+	return sizes
+}
+
+// newBuildRecord starts a record for a compile of files beginning now.
+func newBuildRecord(ctx context.Context, files map[string]string) *BuildRecord {
+	start := time.Now().UTC()
+	return &BuildRecord{
+		ID:        fmt.Sprintf("%020d", start.UnixNano()),
+		Trigger:   buildTrigger(ctx),
+		InputHash: inputHash(files),
+		Inputs:    inputHashes(files),
+		StartedAt: start,
+	}
+}
+
+// finish fills in the outcome of a build.
+func (b *BuildRecord) finish(result *BuildResponse, err error) {
+	b.DurationMS = time.Since(b.StartedAt).Milliseconds()
+	if err != nil {
+		b.Status = BuildFailed
+		b.Error = err.Error()
+		return
+	}
+	b.Status = BuildSucceeded
+	b.ToolVersions = result.Versions
+	b.Warnings = result.Warnings
+	b.Artifacts = artifactSizes(result.Compiled)
+	b.Sources = result.Sources
+	b.MissingAssets = findMissingAssets(result.Compiled)
+}
+
+// saveBuildRecord stores a build record, logging rather than failing the
+// build if it can't be written.
+func (h *Handlers) saveBuildRecord(ctx context.Context, projectID string, record *BuildRecord) {
+	ctx = context.WithoutCancel(ctx)
+	if err := h.storage.StoreBuildRecord(ctx, projectID, record); err != nil {
+		log.Printf("Error storing build record for project %s: %v", projectID, err)
+	}
+
+	settings, err := h.projectSettings(ctx, projectID)
+	if err != nil || settings.BuildRetentionDays == 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -settings.BuildRetentionDays)
+	if err := h.storage.PruneBuildRecords(ctx, projectID, cutoff); err != nil {
+		log.Printf("Error pruning build records for project %s: %v", projectID, err)
+	}
+}
+
+// recordAgentBuild stores a record for output the agent compiled itself.
+func (h *Handlers) recordAgentBuild(ctx context.Context, projectID, trigger string, files, compiled map[string]string) {
+	record := newBuildRecord(ctx, files)
+	record.Trigger = trigger
+	record.Status = BuildSucceeded
+	record.Artifacts = artifactSizes(compiled)
+	record.MissingAssets = findMissingAssets(compiled)
+	h.saveBuildRecord(ctx, projectID, record)
+}
+
+// HandleListBuilds returns the project's most recent build records, newest first.
+func (h *Handlers) HandleListBuilds(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	limit := maxBuildRecords
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxBuildRecords {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", maxBuildRecords)})
+			return
+		}
+		limit = n
+	}
+
+	records, err := h.storage.ListBuildRecords(r.Context(), projectID, limit)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load build records", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
+
+var buildIDPattern = regexp.MustCompile(`^[0-9]{20}$`)
+
+// SizeChange is an artifact present in both builds with different sizes.
+type SizeChange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// VersionChange is a build tool whose version differs between builds.
+type VersionChange struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// BuildDiff compares build b against an earlier build a.
+type BuildDiff struct {
+	From *BuildRecord `json:"from"`
+	To   *BuildRecord `json:"to"`
+
+	InputsAdded      []string                 `json:"inputs_added"`
+	InputsRemoved    []string                 `json:"inputs_removed"`
+	InputsChanged    []string                 `json:"inputs_changed"`
+	ArtifactsAdded   []string                 `json:"artifacts_added"`
+	ArtifactsRemoved []string                 `json:"artifacts_removed"`
+	ArtifactsResized map[string]SizeChange    `json:"artifacts_resized"`
+	ToolVersions     map[string]VersionChange `json:"tool_versions"`
+}
+
+// diffKeys returns the keys only in b, only in a, and in both, each sorted.
+func diffKeys[V any](a, b map[string]V) (added, removed, common []string) {
+	added, removed, common = []string{}, []string{}, []string{}
+	for _, key := range slices.Sorted(maps.Keys(b)) {
+		if _, ok := a[key]; ok {
+			common = append(common, key)
+		} else {
+			added = append(added, key)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(a)) {
+		if _, ok := b[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	return added, removed, common
+}
+
+// compareBuilds reports what changed in inputs, artifacts and tools from a to b.
+func compareBuilds(a, b *BuildRecord) BuildDiff {
+	diff := BuildDiff{
+		From:             a,
+		To:               b,
+		InputsChanged:    []string{},
+		ArtifactsResized: map[string]SizeChange{},
+		ToolVersions:     map[string]VersionChange{},
+	}
+
+	var common []string
+	diff.InputsAdded, diff.InputsRemoved, common = diffKeys(a.Inputs, b.Inputs)
+	for _, path := range common {
+		if a.Inputs[path] != b.Inputs[path] {
+			diff.InputsChanged = append(diff.InputsChanged, path)
+		}
+	}
+
+	diff.ArtifactsAdded, diff.ArtifactsRemoved, common = diffKeys(a.Artifacts, b.Artifacts)
+	for _, path := range common {
+		if a.Artifacts[path] != b.Artifacts[path] {
+			diff.ArtifactsResized[path] = SizeChange{From: a.Artifacts[path], To: b.Artifacts[path]}
+		}
+	}
+
+	for tool := range maps.Keys(a.ToolVersions) {
+		if a.ToolVersions[tool] != b.ToolVersions[tool] {
+			diff.ToolVersions[tool] = VersionChange{From: a.ToolVersions[tool], To: b.ToolVersions[tool]}
+		}
+	}
+	for tool := range maps.Keys(b.ToolVersions) {
+		if _, ok := a.ToolVersions[tool]; !ok {
+			diff.ToolVersions[tool] = VersionChange{To: b.ToolVersions[tool]}
+		}
+	}
+	return diff
+}
+
+// HandleDiffBuilds compares two build records of the project.
+func (h *Handlers) HandleDiffBuilds(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	records := make([]*BuildRecord, 0, 2)
+	for _, id := range []string{chi.URLParam(r, "a"), chi.URLParam(r, "b")} {
+		if !buildIDPattern.MatchString(id) {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid build ID"})
+			return
+		}
+		record, err := h.storage.GetBuildRecord(r.Context(), projectID, id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeError(w, AppError{Code: http.StatusNotFound, Message: fmt.Sprintf("Build %s not found", id)})
+				return
+			}
+			writeError(w, upstreamError("Failed to load build record", err))
+			return
+		}
+		records = append(records, record)
+	}
+
+	writeJSON(w, http.StatusOK, compareBuilds(records[0], records[1]))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// maxCapturedResponse caps how much of a failed response body is kept.
+const maxCapturedResponse = 64 << 10
+
+// capturedHeaders are the only request headers kept in a capture; provider
+// keys and service credentials are never recorded.
+var capturedHeaders = []string{"Content-Type", "Accept", "Accept-Language"}
+
+var captureIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,128}$`)
+
+// RequestCapture is a failed agent request and the response it got, kept
+// so it can be replayed against another agent.
+type RequestCapture struct {
+	ID        string      `json:"id"`
+	RequestID string      `json:"request_id,omitempty"`
+	ProjectID string      `json:"project_id,omitempty"`
+	Time      time.Time   `json:"time"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body"`
+	Status    int         `json:"status,omitempty"`
+	Response  string      `json:"response,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// captureID returns a storage-safe ID for the request behind ctx, derived
+// from its request ID when it has one.
+func captureID(ctx context.Context) (string, string) {
+	requestID := middleware.GetReqID(ctx)
+	if requestID == "" {
+		return uuid.NewString(), ""
+	}
+	return strings.NewReplacer("/", "-", " ", "-").Replace(requestID), requestID
+}
+
+// captureTransport records agent requests that fail, either in transport
+// or with an error status.
+type captureTransport struct {
+	base  http.RoundTripper
+	store func(context.Context, *RequestCapture) error
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(rc)
+			_ = rc.Close()
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode < 400 {
+		return resp, nil
+	}
+	// Requests the caller abandoned aren't agent failures
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		return resp, err
+	}
+
+	capture := &RequestCapture{
+		Time:   time.Now().UTC(),
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Header: http.Header{},
+		Body:   string(body),
+	}
+	capture.ID, capture.RequestID = captureID(req.Context())
+	if rctx := chi.RouteContext(req.Context()); rctx != nil {
+		capture.ProjectID = rctx.URLParam("uuid")
+	}
+	for _, name := range capturedHeaders {
+		if value := req.Header.Get(name); value != "" {
+			capture.Header.Set(name, value)
+		}
+	}
+	if err != nil {
+		capture.Error = err.Error()
+	} else {
+		// Keep the body readable for the caller after capturing it
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxCapturedResponse))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(respBody), resp.Body), resp.Body}
+		capture.Status = resp.StatusCode
+		capture.Response = string(respBody)
+	}
+
+	if storeErr := t.store(context.WithoutCancel(req.Context()), capture); storeErr != nil {
+		log.Printf("Failed to store capture %s: %v", capture.ID, storeErr)
+	}
+	return resp, err
+}
+
+// withCapture wraps base so failed requests are passed to store.
+func withCapture(base http.RoundTripper, store func(context.Context, *RequestCapture) error) http.RoundTripper {
+	return &captureTransport{base: base, store: store}
+}
+
+// ReplayResult is the outcome of replaying a capture.
+type ReplayResult struct {
+	Capture    *RequestCapture `json:"capture"`
+	Status     int             `json:"status,omitempty"`
+	Response   string          `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+// Replay sends a captured request to this client's agent.
+func (c *PythonAgentClient) Replay(ctx context.Context, capture *RequestCapture) *ReplayResult {
+	result := &ReplayResult{Capture: capture}
+	start := time.Now()
+	defer func() { result.DurationMS = time.Since(start).Milliseconds() }()
+
+	req, err := http.NewRequestWithContext(ctx, capture.Method, c.baseURL+capture.Path, strings.NewReader(capture.Body))
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create request: %v", err)
+		return result
+	}
+	for name, values := range capture.Header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		result.Error = fmt.Sprintf("replay request failed: %v", err)
+		return result
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxCapturedResponse))
+	result.Status = resp.StatusCode
+	result.Response = string(respBody)
+	return result
+}
+
+// HandleListCaptures returns the IDs of stored captures.
+func (h *Handlers) HandleListCaptures(w http.ResponseWriter, r *http.Request) {
+	ids, err := h.storage.ListCaptures(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to list captures", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ids)
+}
+
+// loadCapture reads the capture named in the URL, writing an error response
+// if it can't.
+func (h *Handlers) loadCapture(w http.ResponseWriter, r *http.Request) (*RequestCapture, bool) {
+	id := chi.URLParam(r, "captureID")
+	if !captureIDPattern.MatchString(id) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid capture ID"})
+		return nil, false
+	}
+	capture, err := h.storage.GetCapture(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, ErrNotFound)
+			return nil, false
+		}
+		writeError(w, upstreamError("Failed to load capture", err))
+		return nil, false
+	}
+	return capture, true
+}
+
+// HandleGetCapture returns one capture.
+func (h *Handlers) HandleGetCapture(w http.ResponseWriter, r *http.Request) {
+	if capture, ok := h.loadCapture(w, r); ok {
+		writeJSON(w, http.StatusOK, capture)
+	}
+}
+
+// HandleReplayCapture replays a capture against REPLAY_AGENT_URL and
+// returns the new response alongside the original.
+func (h *Handlers) HandleReplayCapture(w http.ResponseWriter, r *http.Request) {
+	if h.replayClient == nil {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Replay is not configured"})
+		return
+	}
+	capture, ok := h.loadCapture(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.replayClient.Replay(r.Context(), capture))
+}
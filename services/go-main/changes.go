@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/websocket"
+)
+
+// Change event types.
+const (
+	ChangeFiles    = "files"
+	ChangeCompiled = "compiled"
+	ChangeQuota    = "quota"
+)
+
+// changeBuffer is how many events a subscriber may fall behind before it is
+// disconnected and has to resync from GetState.
+const changeBuffer = 64
+
+// FileChange describes one written or deleted source file.
+type FileChange struct {
+	Path    string `json:"path"`
+	Hash    string `json:"hash,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// ChangeEvent is broadcast to a project's subscribers after a write.
+// Revisions increase per project for the life of the process; clients that
+// see a gap, or reconnect, should reload state.
+type ChangeEvent struct {
+	Type     string        `json:"type"`
+	Revision int64         `json:"revision"`
+	Source   string        `json:"source"`
+	Files    []FileChange  `json:"files,omitempty"`
+	Warning  *QuotaWarning `json:"warning,omitempty"`
+	Time     time.Time     `json:"time"`
+}
+
+// ChangeHub fans file-change notifications out to subscribers per project.
+type ChangeHub struct {
+	mu        sync.Mutex
+	subs      map[string]map[chan ChangeEvent]struct{}
+	revisions map[string]int64
+}
+
+// NewChangeHub creates an empty ChangeHub.
+func NewChangeHub() *ChangeHub {
+	return &ChangeHub{
+		subs:      make(map[string]map[chan ChangeEvent]struct{}),
+		revisions: make(map[string]int64),
+	}
+}
+
+// contentHash returns the hex SHA-256 of a file's content.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// diffFiles returns the files in after that are new or changed since
+// before, and the paths that were removed.
+func diffFiles(before, after map[string]string) (map[string]string, []string) {
+	changed := make(map[string]string)
+	for path, content := range after {
+		if old, ok := before[path]; !ok || old != content {
+			changed[path] = content
+		}
+	}
+	var removed []string
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	return changed, removed
+}
+
+// PublishFiles announces written and removed source files and returns the
+// event's revision, or zero if there was nothing to announce.
+func (c *ChangeHub) PublishFiles(projectID, source string, written map[string]string, removed []string) int64 {
+	if len(written) == 0 && len(removed) == 0 {
+		return 0
+	}
+	fileOperations.Add(float64(len(written)), source, "write")
+	fileOperations.Add(float64(len(removed)), source, "delete")
+	files := make([]FileChange, 0, len(written)+len(removed))
+	for _, path := range slices.Sorted(maps.Keys(written)) {
+		files = append(files, FileChange{Path: path, Hash: contentHash(written[path])})
+	}
+	for _, path := range removed {
+		files = append(files, FileChange{Path: path, Deleted: true})
+	}
+	return c.publish(projectID, ChangeEvent{Type: ChangeFiles, Source: source, Files: files})
+}
+
+// PublishCompiled announces new compiled output so previews can reload.
+func (c *ChangeHub) PublishCompiled(projectID, source string) {
+	c.publish(projectID, ChangeEvent{Type: ChangeCompiled, Source: source})
+}
+
+// PublishQuotaWarning announces that a quota threshold has been crossed.
+func (c *ChangeHub) PublishQuotaWarning(projectID string, warning QuotaWarning) {
+	c.publish(projectID, ChangeEvent{Type: ChangeQuota, Source: warning.Resource, Warning: &warning})
+}
+
+// publish stamps and delivers an event, returning its revision. Subscribers
+// that have fallen too far behind are dropped rather than blocking writers.
+func (c *ChangeHub) publish(projectID string, event ChangeEvent) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.revisions[projectID]++
+	event.Revision = c.revisions[projectID]
+	event.Time = time.Now().UTC()
+
+	for ch := range c.subs[projectID] {
+		select {
+		case ch <- event:
+		default:
+			delete(c.subs[projectID], ch)
+			close(ch)
+		}
+	}
+	return event.Revision
+}
+
+// Subscribe returns a channel of the project's change events and a function
+// to unsubscribe. The channel is closed when the subscriber is dropped.
+func (c *ChangeHub) Subscribe(projectID string) (<-chan ChangeEvent, func()) {
+	ch := make(chan ChangeEvent, changeBuffer)
+
+	c.mu.Lock()
+	if c.subs[projectID] == nil {
+		c.subs[projectID] = make(map[chan ChangeEvent]struct{})
+	}
+	c.subs[projectID][ch] = struct{}{}
+	c.mu.Unlock()
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.subs[projectID][ch]; ok {
+			delete(c.subs[projectID], ch)
+			close(ch)
+		}
+		if len(c.subs[projectID]) == 0 {
+			delete(c.subs, projectID)
+		}
+	}
+}
+
+// HandleChanges streams the project's change events over a WebSocket.
+func (h *Handlers) HandleChanges(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, done := h.streams.Track(r.Context(), StreamViewer, projectID)
+	defer done()
+
+	websocket.Handler(func(conn *websocket.Conn) {
+		events, unsubscribe := h.changes.Subscribe(projectID)
+		defer unsubscribe()
+
+		// The client only listens; a read returning means it went away
+		closed := make(chan struct{})
+		go func() {
+			var discard []byte
+			for websocket.Message.Receive(conn, &discard) == nil {
+			}
+			close(closed)
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-closed:
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := websocket.JSON.Send(conn, event); err != nil {
+					log.Printf("Error sending change event for project %s: %v", projectID, err)
+					return
+				}
+			}
+		}
+	}).ServeHTTP(w, r.WithContext(ctx))
+}
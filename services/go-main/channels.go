@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Compiled output channels. Production is what viewers see by default;
+// staging holds chat results waiting to be promoted.
+const (
+	ChannelProduction = "production"
+	ChannelStaging    = "staging"
+)
+
+// stagingAssetPattern matches rewritten asset references in staged HTML.
+var stagingAssetPattern = regexp.MustCompile(`((?:src|href)="\./assets/[^"?]*)"`)
+
+// parseChannel reads the ?channel= query parameter, defaulting to production.
+func parseChannel(r *http.Request) (string, error) {
+	switch channel := r.URL.Query().Get("channel"); channel {
+	case "", ChannelProduction:
+		return ChannelProduction, nil
+	case ChannelStaging:
+		return ChannelStaging, nil
+	default:
+		return "", AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Unknown channel %q", channel)}
+	}
+}
+
+// rewriteStagingAssetPaths makes asset references in staged HTML request the
+// staging channel. Must run after rewriteAssetPaths.
+func rewriteStagingAssetPaths(html string) string {
+	return stagingAssetPattern.ReplaceAllString(html, `$1?channel=staging"`)
+}
+
+// PromoteResponse is the response for promoting staging to production.
+type PromoteResponse struct {
+	CompiledFiles []string `json:"compiled_files"`
+}
+
+// HandlePromote copies the staging compiled set over production.
+func (h *Handlers) HandlePromote(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "promote")
+	if !ok {
+		return
+	}
+	defer release()
+
+	files, err := h.storage.PromoteStaging(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "Nothing staged for this project"})
+			return
+		}
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to promote staging: %v", err)})
+		return
+	}
+
+	h.queueLighthouse(projectID, "promote")
+
+	writeJSON(w, http.StatusOK, PromoteResponse{CompiledFiles: files})
+}
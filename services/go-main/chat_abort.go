@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AbortChatResponse is the response for aborting a chat stream.
+type AbortChatResponse struct {
+	StreamID string `json:"stream_id"`
+}
+
+// HandleAbortChat stops the project's running chat or create stream. The
+// agent request is cancelled; the stream then builds the files the agent
+// had already written, sends its clients an abort event and ends.
+func (h *Handlers) HandleAbortChat(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	streamID, replay, ok := h.chatReplays.Active(projectID)
+	if !ok || !replay.abort() {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "No chat stream is running"})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, AbortChatResponse{StreamID: streamID})
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// chatReplayRetention is how long a finished chat stream can still be
+// resumed, so a client that dropped just before the end can fetch the rest.
+const chatReplayRetention = time.Minute
+
+// chatStreamHeader carries the ID a dropped chat stream is resumed with.
+const chatStreamHeader = "X-Chat-Stream-ID"
+
+// chatReplay buffers the data lines of one chat stream so clients can
+// reconnect with Last-Event-ID. Event IDs are 1-based line numbers.
+type chatReplay struct {
+	projectID string
+
+	mu      sync.Mutex
+	lines   []string
+	done    bool
+	readers int
+	// changed is closed and replaced whenever lines or done change.
+	changed chan struct{}
+	// timer cancels the stream once it has had no readers for the
+	// resume window.
+	timer  *time.Timer
+	window time.Duration
+	cancel context.CancelFunc
+	// aborted is set when the stream was stopped by an abort request.
+	aborted bool
+}
+
+// append buffers a data line and returns its event ID.
+func (c *chatReplay) append(line string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return len(c.lines)
+}
+
+// finish marks the stream complete.
+func (c *chatReplay) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// abort stops an unfinished stream on request, returning false if it
+// had already finished.
+func (c *chatReplay) abort() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return false
+	}
+	c.aborted = true
+	c.cancel()
+	return true
+}
+
+// wasAborted reports whether abort stopped the stream.
+func (c *chatReplay) wasAborted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.aborted
+}
+
+// since returns the lines after event ID after, whether the stream is
+// done, and a channel closed on the next change.
+func (c *chatReplay) since(after int) ([]string, bool, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	after = min(max(after, 0), len(c.lines))
+	return c.lines[after:], c.done, c.changed
+}
+
+// length returns the number of lines buffered so far, the ID of the
+// latest event.
+func (c *chatReplay) length() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.lines)
+}
+
+// attach registers a reader, stopping any pending cancellation.
+func (c *chatReplay) attach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readers++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// detach unregisters a reader. When the last one leaves an unfinished
+// stream, it is cancelled unless someone resumes within the window.
+func (c *chatReplay) detach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readers--
+	if c.readers > 0 || c.done {
+		return
+	}
+	c.timer = time.AfterFunc(c.window, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.readers == 0 && !c.done {
+			c.cancel()
+		}
+	})
+}
+
+// ChatReplays holds the buffers of running and recently finished chat
+// streams.
+type ChatReplays struct {
+	mu      sync.Mutex
+	replays map[string]*chatReplay
+	// active maps a project to the ID of its running stream; the project
+	// lock allows only one at a time.
+	active map[string]string
+}
+
+// NewChatReplays creates an empty ChatReplays.
+func NewChatReplays() *ChatReplays {
+	return &ChatReplays{replays: make(map[string]*chatReplay), active: make(map[string]string)}
+}
+
+// Start registers a stream for projectID that cancel stops once it has
+// had no readers for window, and returns its ID.
+func (r *ChatReplays) Start(projectID string, window time.Duration, cancel context.CancelFunc) (string, *chatReplay) {
+	id := uuid.NewString()
+	replay := &chatReplay{
+		projectID: projectID,
+		changed:   make(chan struct{}),
+		window:    window,
+		cancel:    cancel,
+	}
+	r.mu.Lock()
+	r.replays[id] = replay
+	r.active[projectID] = id
+	r.mu.Unlock()
+	return id, replay
+}
+
+// Finish marks a stream complete and forgets it after the retention period.
+func (r *ChatReplays) Finish(id string, replay *chatReplay) {
+	replay.finish()
+	r.mu.Lock()
+	if r.active[replay.projectID] == id {
+		delete(r.active, replay.projectID)
+	}
+	r.mu.Unlock()
+	time.AfterFunc(chatReplayRetention, func() {
+		r.mu.Lock()
+		delete(r.replays, id)
+		r.mu.Unlock()
+	})
+}
+
+// Get returns the stream with id in projectID.
+func (r *ChatReplays) Get(projectID, id string) (*chatReplay, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	replay, ok := r.replays[id]
+	if !ok || replay.projectID != projectID {
+		return nil, false
+	}
+	return replay, true
+}
+
+// Active returns the running stream of projectID and its ID.
+func (r *ChatReplays) Active(projectID string) (string, *chatReplay, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.active[projectID]
+	if !ok {
+		return "", nil, false
+	}
+	return id, r.replays[id], true
+}
+
+// eventEnd is how an SSE event ends.
+var eventEnd = [2]byte{'\n', '\n'}
+
+// heartbeatWriter serializes writes to an SSE response and, while the
+// stream is idle, sends ": ping" comments between events so proxies don't
+// close it as dead.
+type heartbeatWriter struct {
+	http.ResponseWriter
+	flusher http.Flusher
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	// tail is the last two bytes written; "\n\n" ends an event, so a
+	// comment can be sent without splitting one.
+	tail [2]byte
+	done chan struct{}
+}
+
+// startHeartbeat wraps an SSE response whose headers have been written,
+// pinging after each interval without writes until stop is called. A zero
+// interval sends no pings.
+func startHeartbeat(w http.ResponseWriter, flusher http.Flusher, interval time.Duration) *heartbeatWriter {
+	hw := &heartbeatWriter{ResponseWriter: w, flusher: flusher, lastWrite: time.Now(), tail: eventEnd, done: make(chan struct{})}
+	if interval > 0 {
+		go hw.run(interval)
+	}
+	return hw
+}
+
+func (hw *heartbeatWriter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hw.done:
+			return
+		case <-ticker.C:
+		}
+		hw.mu.Lock()
+		if hw.tail == eventEnd && time.Since(hw.lastWrite) >= interval {
+			if _, err := io.WriteString(hw.ResponseWriter, ": ping\n\n"); err != nil {
+				hw.mu.Unlock()
+				return
+			}
+			hw.flusher.Flush()
+			hw.lastWrite = time.Now()
+		}
+		hw.mu.Unlock()
+	}
+}
+
+func (hw *heartbeatWriter) Write(p []byte) (int, error) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	n, err := hw.ResponseWriter.Write(p)
+	if n >= 2 {
+		hw.tail = [2]byte{p[n-2], p[n-1]}
+	} else if n == 1 {
+		hw.tail = [2]byte{hw.tail[1], p[0]}
+	}
+	if n > 0 {
+		hw.lastWrite = time.Now()
+	}
+	return n, err
+}
+
+func (hw *heartbeatWriter) Flush() {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.flusher.Flush()
+}
+
+func (hw *heartbeatWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// stop ends the heartbeats.
+func (hw *heartbeatWriter) stop() {
+	close(hw.done)
+}
+
+// writeChatEvent writes a buffered data line as an SSE event with its ID.
+func writeChatEvent(w http.ResponseWriter, id int, line string) error {
+	_, err := fmt.Fprintf(w, "id: %d\n%s\n", id, strings.TrimRight(line, "\r\n")+"\n")
+	return err
+}
+
+// HandleResumeChat continues a chat stream after the event in the
+// Last-Event-ID header, then follows it live until it finishes. Streams
+// that finished with nothing further to send get 204.
+func (h *Handlers) HandleResumeChat(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h.resumeChat(w, r, projectID, chi.URLParam(r, "streamID"))
+}
+
+// resumeChat serves a chat stream from after the request's Last-Event-ID.
+func (h *Handlers) resumeChat(w http.ResponseWriter, r *http.Request, projectID, streamID string) {
+	replay, ok := h.chatReplays.Get(projectID, streamID)
+	if !ok {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Chat stream not found"})
+		return
+	}
+
+	after := 0
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid Last-Event-ID"})
+			return
+		}
+		// An ID past the end was never sent, and following from it would
+		// skip and misnumber the events still to come
+		if n > replay.length() {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "Last-Event-ID is past the end of the stream"})
+			return
+		}
+		after = n
+	}
+
+	lines, done, changed := replay.since(after)
+	if done && len(lines) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: "Streaming not supported"})
+		return
+	}
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Could not lift write deadline for chat stream: %v", err)
+	}
+
+	replay.attach()
+	defer replay.detach()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	hw := startHeartbeat(w, flusher, h.cfg.ChatHeartbeatInterval)
+	defer hw.stop()
+
+	for {
+		for _, line := range lines {
+			after++
+			if err := writeChatEvent(hw, after, line); err != nil {
+				return
+			}
+		}
+		hw.Flush()
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		lines, done, changed = replay.since(after)
+	}
+}
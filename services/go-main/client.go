@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +15,8 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// httpClient is used for calls to external services, such as git hosts.
+// Internal services get their own clients carrying service credentials.
 var httpClient = &http.Client{
 	Timeout: 120 * time.Second,
 	Transport: otelhttp.NewTransport(&http.Transport{
@@ -24,17 +28,35 @@ var httpClient = &http.Client{
 
 // PythonAgentClient handles communication with the Python Agent service.
 type PythonAgentClient struct {
-	baseURL string
+	baseURL      string
+	timeout      time.Duration
+	httpClient   *http.Client
+	streamClient *http.Client
+	limiter      *PriorityLimiter
 }
 
-// NewPythonAgentClient creates a new Python Agent client.
-func NewPythonAgentClient(baseURL string) *PythonAgentClient {
-	return &PythonAgentClient{baseURL: baseURL}
+// NewPythonAgentClient creates a new Python Agent client. Calls are limited
+// to timeout (zero for no limit) and to the request's remaining budget.
+// Concurrent runs are bounded by limiter.
+func NewPythonAgentClient(baseURL string, timeout time.Duration, transport http.RoundTripper, limiter *PriorityLimiter) *PythonAgentClient {
+	return &PythonAgentClient{
+		baseURL:    baseURL,
+		timeout:    timeout,
+		httpClient: &http.Client{Timeout: 120 * time.Second, Transport: transport},
+		// Chat streams are bounded by the request context instead
+		streamClient: &http.Client{Transport: transport},
+		limiter:      limiter,
+	}
 }
 
 // CreateAppRequest is the request body for creating an app.
 type CreateAppRequest struct {
 	Prompt string `json:"prompt"`
+	// WorkspaceFiles are the source files of the other projects in the
+	// project's workspace, by project ID, which the agent can read.
+	WorkspaceFiles map[string]map[string]string `json:"workspace_files,omitempty"`
+	// Brand is the brand kit the app should follow.
+	Brand *BrandKit `json:"brand,omitempty"`
 }
 
 // CreateAppResponse is the response from creating an app.
@@ -42,12 +64,15 @@ type CreateAppResponse struct {
 	Files         map[string]string `json:"files"`
 	CompiledFiles map[string]string `json:"compiled_files"`
 	Summary       string            `json:"summary"`
+	Tokens        int64             `json:"tokens"`
 }
 
 // EditAppRequest is the request body for editing an app.
 type EditAppRequest struct {
-	Prompt string            `json:"prompt"`
-	Files  map[string]string `json:"files"`
+	Prompt         string                       `json:"prompt"`
+	Files          map[string]string            `json:"files"`
+	WorkspaceFiles map[string]map[string]string `json:"workspace_files,omitempty"`
+	Brand          *BrandKit                    `json:"brand,omitempty"`
 }
 
 // EditAppResponse is the response from editing an app.
@@ -55,11 +80,22 @@ type EditAppResponse struct {
 	Files         map[string]string `json:"files"`
 	CompiledFiles map[string]string `json:"compiled_files"`
 	Summary       string            `json:"summary"`
+	Edits         []AgentEdit       `json:"edits"`
+	Tokens        int64             `json:"tokens"`
 }
 
 // CreateApp sends a create request to the Python Agent.
 func (c *PythonAgentClient) CreateApp(ctx context.Context, prompt string) (*CreateAppResponse, error) {
-	reqBody := CreateAppRequest{Prompt: prompt}
+	ctx, cancel := budgetStep(ctx, "python agent", c.timeout)
+	defer cancel()
+
+	release, err := c.limiter.Acquire(ctx, requestTier(ctx))
+	if err != nil {
+		return nil, budgetError(ctx, err)
+	}
+	defer release()
+
+	reqBody := CreateAppRequest{Prompt: prompt, WorkspaceFiles: workspaceFiles(ctx), Brand: brandKit(ctx)}
 	body, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -70,10 +106,11 @@ func (c *PythonAgentClient) CreateApp(ctx context.Context, prompt string) (*Crea
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setAgentHeaders(req)
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("python agent request failed: %w", err)
+		return nil, budgetError(ctx, fmt.Errorf("python agent request failed: %w", err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -91,7 +128,16 @@ func (c *PythonAgentClient) CreateApp(ctx context.Context, prompt string) (*Crea
 
 // EditApp sends an edit request to the Python Agent.
 func (c *PythonAgentClient) EditApp(ctx context.Context, prompt string, files map[string]string) (*EditAppResponse, error) {
-	reqBody := EditAppRequest{Prompt: prompt, Files: files}
+	ctx, cancel := budgetStep(ctx, "python agent", c.timeout)
+	defer cancel()
+
+	release, err := c.limiter.Acquire(ctx, requestTier(ctx))
+	if err != nil {
+		return nil, budgetError(ctx, err)
+	}
+	defer release()
+
+	reqBody := EditAppRequest{Prompt: prompt, Files: files, WorkspaceFiles: workspaceFiles(ctx), Brand: brandKit(ctx)}
 	body, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -102,10 +148,11 @@ func (c *PythonAgentClient) EditApp(ctx context.Context, prompt string, files ma
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setAgentHeaders(req)
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("python agent request failed: %w", err)
+		return nil, budgetError(ctx, fmt.Errorf("python agent request failed: %w", err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -121,37 +168,145 @@ func (c *PythonAgentClient) EditApp(ctx context.Context, prompt string, files ma
 	return &result, nil
 }
 
-// RustDBClient handles communication with the Rust DB service.
+// CompactRequest is the request body for summarizing conversation turns.
+type CompactRequest struct {
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// CompactResponse is the response from summarizing conversation turns.
+type CompactResponse struct {
+	Summary string `json:"summary"`
+	Tokens  int64  `json:"tokens"`
+}
+
+// Compact asks the Python Agent to summarize chat messages, oldest first.
+func (c *PythonAgentClient) Compact(ctx context.Context, messages []json.RawMessage) (*CompactResponse, error) {
+	ctx, cancel := budgetStep(ctx, "python agent", c.timeout)
+	defer cancel()
+
+	release, err := c.limiter.Acquire(ctx, requestTier(ctx))
+	if err != nil {
+		return nil, budgetError(ctx, err)
+	}
+	defer release()
+
+	body, err := json.Marshal(CompactRequest{Messages: messages})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/compact", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAgentHeaders(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, budgetError(ctx, fmt.Errorf("python agent request failed: %w", err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("python agent error (%d): %s", resp.StatusCode, respBody)
+	}
+
+	var result CompactResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
+
+// RustDBClient handles communication with the Rust DB service. Rust DB
+// writes to a single Postgres primary and commits before responding, so a
+// read issued after Store returns always sees the write; no barrier or
+// revision wait is needed for read-your-writes.
 type RustDBClient struct {
-	baseURL string
+	baseURL    string
+	timeout    time.Duration
+	httpClient *http.Client
 }
 
-// NewRustDBClient creates a new Rust DB client.
-func NewRustDBClient(baseURL string) *RustDBClient {
-	return &RustDBClient{baseURL: baseURL}
+// NewRustDBClient creates a new Rust DB client. Calls are limited to
+// timeout (zero for no limit) and to the request's remaining budget.
+func NewRustDBClient(baseURL string, timeout time.Duration, transport http.RoundTripper) *RustDBClient {
+	return &RustDBClient{
+		baseURL:    baseURL,
+		timeout:    timeout,
+		httpClient: &http.Client{Timeout: 120 * time.Second, Transport: transport},
+	}
 }
 
-// KeyInfo represents an entry in the list response.
+// KeyInfo represents an entry in the list response. Size and ETag are only
+// populated by ListDetailed.
 type KeyInfo struct {
 	Key      string `json:"key"`
 	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size,omitempty"`
+	ETag     string `json:"etag,omitempty"`
+}
+
+// StoredValue is a key's content and mime type as returned by GetMany.
+type StoredValue struct {
+	Content  []byte
+	MimeType string
+}
+
+// StoredStream is a stored value being read. Size is the content length,
+// or -1 when the backend didn't report one.
+type StoredStream struct {
+	io.ReadCloser
+	Size     int64
+	MimeType string
 }
 
+// ErrPreconditionFailed is returned by StoreIf and DeleteIf when the key's
+// current content doesn't match.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
 // Store saves content to the Rust DB.
 func (c *RustDBClient) Store(ctx context.Context, project, key, mimeType string, content []byte) error {
+	return c.store(ctx, project, key, mimeType, content, "")
+}
+
+// StoreIf saves content only if the key's current ETag is etag or, when
+// etag is "*", only if the key doesn't exist yet.
+func (c *RustDBClient) StoreIf(ctx context.Context, project, key, mimeType string, content []byte, etag string) error {
+	return c.store(ctx, project, key, mimeType, content, etag)
+}
+
+func (c *RustDBClient) store(ctx context.Context, project, key, mimeType string, content []byte, etag string) error {
+	ctx, cancel := budgetStep(ctx, "rust db", c.timeout)
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/project/%s/%s", c.baseURL, project, url.PathEscape(key))
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(content))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", mimeType)
+	switch etag {
+	case "":
+		// An unconditional upsert can be retried (see isIdempotent)
+		req.Header["Idempotency-Key"] = nil
+	case "*":
+		req.Header.Set("If-None-Match", "*")
+	default:
+		req.Header.Set("If-Match", `"`+etag+`"`)
+	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("rust db request failed: %w", err)
+		return budgetError(ctx, fmt.Errorf("rust db request failed: %w", err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return ErrPreconditionFailed
+	}
 	if resp.StatusCode != http.StatusCreated {
 		respBody, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("store failed (%d): %s", resp.StatusCode, respBody)
@@ -161,15 +316,18 @@ func (c *RustDBClient) Store(ctx context.Context, project, key, mimeType string,
 
 // Get retrieves content from the Rust DB.
 func (c *RustDBClient) Get(ctx context.Context, project, key string) ([]byte, string, error) {
+	ctx, cancel := budgetStep(ctx, "rust db", c.timeout)
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/project/%s/get/%s", c.baseURL, project, url.PathEscape(key))
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("rust db request failed: %w", err)
+		return nil, "", budgetError(ctx, fmt.Errorf("rust db request failed: %w", err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -190,17 +348,78 @@ func (c *RustDBClient) Get(ctx context.Context, project, key string) ([]byte, st
 	return content, mimeType, nil
 }
 
+// GetStream returns the response body of a get unread. The storage
+// deadline covers reading it, and is released when it is closed.
+func (c *RustDBClient) GetStream(ctx context.Context, project, key string) (*StoredStream, error) {
+	ctx, cancel := budgetStep(ctx, "rust db", c.timeout)
+
+	reqURL := fmt.Sprintf("%s/project/%s/get/%s", c.baseURL, project, url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, budgetError(ctx, fmt.Errorf("rust db request failed: %w", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer cancel()
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get failed (%d): %s", resp.StatusCode, respBody)
+	}
+
+	return &StoredStream{
+		ReadCloser: &cancelOnClose{ReadCloser: resp.Body, cancel: cancel},
+		Size:       resp.ContentLength,
+		MimeType:   resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// cancelOnClose releases a request's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
 // List retrieves all keys with a given prefix from the Rust DB.
 func (c *RustDBClient) List(ctx context.Context, project, prefix string) ([]KeyInfo, error) {
+	return c.list(ctx, project, prefix, false)
+}
+
+// ListDetailed is like List but also returns each key's size and ETag.
+func (c *RustDBClient) ListDetailed(ctx context.Context, project, prefix string) ([]KeyInfo, error) {
+	return c.list(ctx, project, prefix, true)
+}
+
+func (c *RustDBClient) list(ctx context.Context, project, prefix string, details bool) ([]KeyInfo, error) {
+	ctx, cancel := budgetStep(ctx, "rust db", c.timeout)
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/project/%s/list/%s", c.baseURL, project, url.PathEscape(prefix))
+	if details {
+		reqURL += "?details=true"
+	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("rust db request failed: %w", err)
+		return nil, budgetError(ctx, fmt.Errorf("rust db request failed: %w", err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -216,20 +435,93 @@ func (c *RustDBClient) List(ctx context.Context, project, prefix string) ([]KeyI
 	return result, nil
 }
 
+// GetMany retrieves several keys in one request. Keys that don't exist are
+// missing from the result rather than an error.
+func (c *RustDBClient) GetMany(ctx context.Context, project string, keys []string) (map[string]StoredValue, error) {
+	if len(keys) == 0 {
+		return map[string]StoredValue{}, nil
+	}
+
+	ctx, cancel := budgetStep(ctx, "rust db", c.timeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string][]string{"keys": keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	reqURL := fmt.Sprintf("%s/get-many/%s", c.baseURL, project)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header["Idempotency-Key"] = nil
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, budgetError(ctx, fmt.Errorf("rust db request failed: %w", err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get many failed (%d): %s", resp.StatusCode, respBody)
+	}
+
+	var entries []struct {
+		Key      string `json:"key"`
+		MimeType string `json:"mime_type"`
+		Content  string `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	result := make(map[string]StoredValue, len(entries))
+	for _, entry := range entries {
+		content, err := base64.StdEncoding.DecodeString(entry.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode content of %s: %w", entry.Key, err)
+		}
+		result[entry.Key] = StoredValue{Content: content, MimeType: entry.MimeType}
+	}
+	return result, nil
+}
+
 // Delete removes a key from the Rust DB.
 func (c *RustDBClient) Delete(ctx context.Context, project, key string) error {
+	return c.delete(ctx, project, key, "")
+}
+
+// DeleteIf removes a key only if its current ETag is etag.
+func (c *RustDBClient) DeleteIf(ctx context.Context, project, key, etag string) error {
+	return c.delete(ctx, project, key, etag)
+}
+
+func (c *RustDBClient) delete(ctx context.Context, project, key, etag string) error {
+	ctx, cancel := budgetStep(ctx, "rust db", c.timeout)
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/project/%s/%s", c.baseURL, project, url.PathEscape(key))
 	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, reqURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	if etag != "" {
+		req.Header.Set("If-Match", `"`+etag+`"`)
+	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("rust db request failed: %w", err)
+		return budgetError(ctx, fmt.Errorf("rust db request failed: %w", err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return ErrPreconditionFailed
+	}
+
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
 		respBody, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("delete failed (%d): %s", resp.StatusCode, respBody)
@@ -239,40 +531,153 @@ func (c *RustDBClient) Delete(ctx context.Context, project, key string) error {
 
 // NodeBuildClient handles communication with the Node Build service.
 type NodeBuildClient struct {
-	baseURL string
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	limiter    *PriorityLimiter
 }
 
-// NewNodeBuildClient creates a new Node Build client.
-func NewNodeBuildClient(baseURL string) *NodeBuildClient {
-	return &NodeBuildClient{baseURL: baseURL}
+// NewNodeBuildClient creates a new Node Build client with its own timeout
+// and number of retries for failed builds. Concurrent builds are bounded by
+// limiter.
+func NewNodeBuildClient(baseURL string, timeout time.Duration, retries int, transport http.RoundTripper, limiter *PriorityLimiter) *NodeBuildClient {
+	return &NodeBuildClient{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		retries: retries,
+		limiter: limiter,
+	}
 }
 
 // BuildRequest is the request body for building an app.
 type BuildRequest struct {
 	Files map[string]string `json:"files"`
+	// Profile is "production" or "development"; empty uses the service default.
+	Profile string `json:"profile,omitempty"`
+	// OptimizeImages adds WebP, AVIF and resized variants of images.
+	OptimizeImages bool `json:"optimize_images,omitempty"`
 }
 
 // BuildResponse is the response from the build service.
 type BuildResponse struct {
 	Compiled map[string]string `json:"compiled"`
 	Source   map[string]string `json:"source"`
+	Warnings []string          `json:"warnings"`
+	Versions map[string]string `json:"versions"`
+	// Sources maps each compiled JS file to the source files bundled into it.
+	Sources map[string][]string `json:"sources"`
+	// Dependencies are the npm packages bundled into the output.
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// Dependency is an npm package bundled into a build.
+type Dependency struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// License is the package's SPDX expression, or "UNKNOWN".
+	License string `json:"license"`
 }
 
-// Build compiles the source files and returns compiled assets.
-func (c *NodeBuildClient) Build(ctx context.Context, files map[string]string) (map[string]string, error) {
-	reqBody := BuildRequest{Files: files}
+// Build compiles the source files and returns compiled assets, along with
+// build warnings and toolchain versions.
+// Connection failures and 5xx responses are retried; build errors (4xx) are not.
+func (c *NodeBuildClient) Build(ctx context.Context, files map[string]string, profile string, optimizeImages bool) (*BuildResponse, error) {
+	// Binary files would be mangled as JSON strings, so they travel base64 encoded
+	encoded := make(map[string]string, len(files))
+	for path, content := range files {
+		if isBinaryPath(path) {
+			content = base64.StdEncoding.EncodeToString([]byte(content))
+		}
+		encoded[path] = content
+	}
+	reqBody := BuildRequest{Files: encoded, Profile: profile, OptimizeImages: optimizeImages}
 	body, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	// Retries share one budget step; each attempt is still capped by httpClient.Timeout
+	ctx, cancel := budgetStep(ctx, "node build", 0)
+	defer cancel()
+
+	release, err := c.limiter.Acquire(ctx, requestTier(ctx))
+	if err != nil {
+		return nil, budgetError(ctx, err)
+	}
+	defer release()
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, budgetError(ctx, lastErr)
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			}
+		}
+
+		result, retry, err := c.build(ctx, body)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return nil, budgetError(ctx, lastErr)
+}
+
+// build performs a single build request, reporting whether a failure is retryable.
+func (c *NodeBuildClient) build(ctx context.Context, body []byte) (*BuildResponse, bool, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/build", bytes.NewReader(body))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("node build request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, resp.StatusCode >= 500, fmt.Errorf("node build error (%d): %s", resp.StatusCode, respBody)
+	}
+
+	var result BuildResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, false, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, false, nil
+}
+
+// ScaffoldRequest is the request body for generating standalone project files.
+type ScaffoldRequest struct {
+	Name string `json:"name"`
+}
+
+// Scaffold returns the files the build service supplies implicitly
+// (package.json, Vite config, entry point, shadcn components) so exported
+// source can be built outside the service.
+func (c *NodeBuildClient) Scaffold(ctx context.Context, name string) (map[string]string, error) {
+	body, err := json.Marshal(ScaffoldRequest{Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/scaffold", bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("node build request failed: %w", err)
 	}
@@ -283,9 +688,103 @@ func (c *NodeBuildClient) Build(ctx context.Context, files map[string]string) (m
 		return nil, fmt.Errorf("node build error (%d): %s", resp.StatusCode, respBody)
 	}
 
-	var result BuildResponse
+	var files map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return files, nil
+}
+
+// LighthouseRequest is the request body for auditing a page.
+type LighthouseRequest struct {
+	URL string `json:"url"`
+}
+
+// LighthouseResult is a Lighthouse audit of a page.
+type LighthouseResult struct {
+	// Scores out of 100 by category: performance, accessibility,
+	// best_practices and seo.
+	Scores map[string]int `json:"scores"`
+	// Metrics are paint and blocking times in milliseconds, cumulative
+	// layout shift and total byte weight.
+	Metrics map[string]float64 `json:"metrics"`
+	Version string             `json:"lighthouse_version"`
+}
+
+// Lighthouse audits the page at pageURL. Runs take longer than builds, so
+// they are bounded by ctx rather than the build timeout, but share the
+// build slots as batch work.
+func (c *NodeBuildClient) Lighthouse(ctx context.Context, pageURL string) (*LighthouseResult, error) {
+	body, err := json.Marshal(LighthouseRequest{URL: pageURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	release, err := c.limiter.Acquire(ctx, TierBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/lighthouse", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("node build request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("lighthouse error (%d): %s", resp.StatusCode, respBody)
+	}
+
+	var result LighthouseResult
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-	return result.Compiled, nil
+	return &result, nil
+}
+
+// Health checks that the Python Agent is reachable and healthy.
+func (c *PythonAgentClient) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("python agent request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("python agent unhealthy (%d)", resp.StatusCode)
+	}
+	return nil
+}
+
+// Health checks that the Node Build service is reachable and healthy.
+func (c *NodeBuildClient) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("node build request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node build unhealthy (%d)", resp.StatusCode)
+	}
+	return nil
 }
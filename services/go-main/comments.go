@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxCommentLength caps the body of a file comment, in bytes.
+const maxCommentLength = 4000
+
+// AddCommentRequest is the request body for commenting on a file. EndLine
+// defaults to StartLine.
+type AddCommentRequest struct {
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Body      string `json:"body"`
+	Context   bool   `json:"context"`
+}
+
+// CommentsResponse is the response for listing comments.
+type CommentsResponse struct {
+	Comments []FileComment `json:"comments"`
+}
+
+// HandleFileComments lists (GET) or adds (POST) comments on a source file,
+// at /files/{path}/comments.
+func (h *Handlers) HandleFileComments(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	path, ok := strings.CutSuffix(chi.URLParam(r, "*"), "/comments")
+	if !ok || validatePath(path) != "" {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		comments, err := h.storage.GetComments(r.Context(), projectID)
+		if err != nil {
+			writeError(w, upstreamError("Failed to load comments", err))
+			return
+		}
+		comments = slices.DeleteFunc(comments, func(c FileComment) bool { return c.Path != path })
+		writeJSON(w, http.StatusOK, CommentsResponse{Comments: comments})
+		return
+	}
+
+	var req AddCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if req.EndLine == 0 {
+		req.EndLine = req.StartLine
+	}
+	if strings.TrimSpace(req.Body) == "" || len(req.Body) > maxCommentLength {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("body must be between 1 and %d bytes", maxCommentLength)})
+		return
+	}
+
+	content, err := h.storage.GetSourceFile(r.Context(), projectID, path)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: fmt.Sprintf("File %s not found", path)})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to get file", err))
+		return
+	}
+	if lines := strings.Count(content, "\n") + 1; req.StartLine < 1 || req.EndLine < req.StartLine || req.EndLine > lines {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Lines must be within 1-%d", lines)})
+		return
+	}
+
+	comment := FileComment{
+		ID:        uuid.NewString(),
+		Path:      path,
+		StartLine: req.StartLine,
+		EndLine:   req.EndLine,
+		Body:      req.Body,
+		CreatedAt: time.Now().UTC(),
+		Context:   req.Context,
+	}
+	err = h.storage.UpdateComments(r.Context(), projectID, func(comments []FileComment) ([]FileComment, error) {
+		return append(comments, comment), nil
+	})
+	if err != nil {
+		writeError(w, upstreamError("Failed to store comment", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+// HandleListComments returns the comments on all of the project's files.
+func (h *Handlers) HandleListComments(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	comments, err := h.storage.GetComments(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load comments", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, CommentsResponse{Comments: comments})
+}
+
+// HandleDeleteComment removes a comment.
+func (h *Handlers) HandleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	commentID := chi.URLParam(r, "commentID")
+
+	err := h.storage.UpdateComments(r.Context(), projectID, func(comments []FileComment) ([]FileComment, error) {
+		i := slices.IndexFunc(comments, func(c FileComment) bool { return c.ID == commentID })
+		if i < 0 {
+			return nil, AppError{Code: http.StatusNotFound, Message: "Comment not found"}
+		}
+		return slices.Delete(comments, i, i+1), nil
+	})
+	var appErr AppError
+	if errors.As(err, &appErr) {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to delete comment", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commentContext returns the comments waiting to be given to the agent and
+// the text presenting them, quoting the lines they refer to from files. It
+// is empty when there are none; failing to load comments is only logged,
+// so they never hold up a turn.
+func (h *Handlers) commentContext(ctx context.Context, projectID string, files map[string]string) ([]FileComment, string) {
+	comments, err := h.storage.GetComments(ctx, projectID)
+	if err != nil {
+		log.Printf("Error loading comments for project %s: %v", projectID, err)
+		return nil, ""
+	}
+	comments = slices.DeleteFunc(comments, func(c FileComment) bool { return !c.Context || c.IncludedAt != nil })
+	if len(comments) == 0 {
+		return nil, ""
+	}
+
+	var b strings.Builder
+	b.WriteString("The user left these comments on the code:\n")
+	for _, c := range comments {
+		if c.StartLine == c.EndLine {
+			fmt.Fprintf(&b, "\n%s, line %d:\n", c.Path, c.StartLine)
+		} else {
+			fmt.Fprintf(&b, "\n%s, lines %d-%d:\n", c.Path, c.StartLine, c.EndLine)
+		}
+		// Lines may have moved since; the excerpt shows what is there now
+		if lines := strings.Split(files[c.Path], "\n"); c.EndLine <= len(lines) {
+			fmt.Fprintf(&b, "```\n%s\n```\n", strings.Join(lines[c.StartLine-1:c.EndLine], "\n"))
+		}
+		b.WriteString(c.Body + "\n")
+	}
+	return comments, b.String()
+}
+
+// markCommentsIncluded records that comments were given to the agent, so
+// the next turn doesn't repeat them.
+func (h *Handlers) markCommentsIncluded(ctx context.Context, projectID string, included []FileComment) {
+	if len(included) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	err := h.storage.UpdateComments(context.WithoutCancel(ctx), projectID, func(comments []FileComment) ([]FileComment, error) {
+		for i := range comments {
+			if slices.ContainsFunc(included, func(c FileComment) bool { return c.ID == comments[i].ID }) {
+				comments[i].IncludedAt = &now
+			}
+		}
+		return comments, nil
+	})
+	if err != nil {
+		log.Printf("Error marking comments included for project %s: %v", projectID, err)
+	}
+}
+
+// appendUserText adds a text part to the last message of an AI SDK chat
+// request body if it is the user's, reporting whether it was added.
+func appendUserText(bodyData map[string]any, text string) bool {
+	raw, err := json.Marshal(bodyData["messages"])
+	if err != nil {
+		return false
+	}
+	var messages []map[string]any
+	if json.Unmarshal(raw, &messages) != nil || len(messages) == 0 {
+		return false
+	}
+	last := messages[len(messages)-1]
+	if last["role"] != "user" {
+		return false
+	}
+	parts, _ := last["parts"].([]any)
+	last["parts"] = append(parts, map[string]any{"type": "text", "text": text})
+	bodyData["messages"] = messages
+	return true
+}
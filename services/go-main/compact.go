@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// compactSummaryID is the message ID given to the summary that stands in
+// for compacted chat messages.
+const compactSummaryID = "compacted-summary"
+
+// ConversationSummary caches the summary of a conversation's older
+// messages so each chat request only summarizes what is new. Count is the
+// number of messages summarized and Through the ID of the last of them.
+type ConversationSummary struct {
+	Count   int    `json:"count"`
+	Through string `json:"through"`
+	Summary string `json:"summary"`
+}
+
+// messageID returns the ID of a chat UI message, or "" if it has none.
+func messageID(message json.RawMessage) string {
+	var m struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(message, &m)
+	return m.ID
+}
+
+// summaryMessage returns a system message carrying a conversation summary.
+func summaryMessage(summary string) (json.RawMessage, error) {
+	return json.Marshal(map[string]any{
+		"id":   compactSummaryID,
+		"role": "system",
+		"parts": []map[string]string{
+			{"type": "text", "text": "Summary of the earlier conversation:\n\n" + summary},
+		},
+	})
+}
+
+// compactMessages keeps chat history under the configured size by
+// replacing all but the most recent messages with a summary. The previous
+// summary is reused and extended when the older messages are unchanged.
+func (h *Handlers) compactMessages(ctx context.Context, projectID string, messages []json.RawMessage) ([]json.RawMessage, error) {
+	if h.cfg.CompactConversationSize <= 0 || len(messages) <= h.cfg.CompactKeepMessages {
+		return messages, nil
+	}
+	size := 0
+	for _, m := range messages {
+		size += len(m)
+	}
+	if size <= h.cfg.CompactConversationSize {
+		return messages, nil
+	}
+
+	older := messages[:len(messages)-h.cfg.CompactKeepMessages]
+	recent := messages[len(older):]
+
+	summary, err := h.storage.GetConversationSummary(ctx, projectID)
+	if err != nil || summary.Count > len(older) || summary.Count == 0 || messageID(older[summary.Count-1]) != summary.Through {
+		summary = &ConversationSummary{}
+	}
+
+	if summary.Count < len(older) {
+		pending := older[summary.Count:]
+		if summary.Summary != "" {
+			previous, err := summaryMessage(summary.Summary)
+			if err != nil {
+				return nil, err
+			}
+			pending = append([]json.RawMessage{previous}, pending...)
+		}
+
+		result, err := h.pythonClient.Compact(ctx, pending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to summarize conversation: %w", err)
+		}
+		h.recordUsage(ctx, projectID, result.Tokens, 0)
+
+		summary = &ConversationSummary{
+			Count:   len(older),
+			Through: messageID(older[len(older)-1]),
+			Summary: result.Summary,
+		}
+		if err := h.storage.StoreConversationSummary(ctx, projectID, summary); err != nil {
+			log.Printf("Failed to store conversation summary for project %s: %v", projectID, err)
+		}
+	}
+
+	compacted, err := summaryMessage(summary.Summary)
+	if err != nil {
+		return nil, err
+	}
+	return append([]json.RawMessage{compacted}, recent...), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// gzipSuffix names the gzip copy of a compiled file, stored alongside it.
+const gzipSuffix = ".gz"
+
+// minCompressSize is the smallest file worth compressing; below it the
+// gzip framing eats most of the saving.
+const minCompressSize = 1024
+
+// compressibleExtensions are the text types served gzip compressed.
+var compressibleExtensions = map[string]bool{
+	".html": true, ".js": true, ".mjs": true, ".css": true, ".json": true, ".map": true,
+	".svg": true, ".webmanifest": true, ".txt": true, ".xml": true,
+}
+
+// isCompressible reports whether the file at p is served gzip compressed
+// to clients that accept it.
+func isCompressible(p string) bool {
+	return compressibleExtensions[strings.ToLower(path.Ext(p))]
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+// Brotli isn't offered: there is no encoder in the standard library.
+func acceptsGzip(r *http.Request) bool {
+	return acceptsType(r.Header.Get("Accept-Encoding"), "gzip")
+}
+
+// gzipBytes compresses data at the given level.
+func gzipBytes(data []byte, level int) []byte {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, level)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+// addGzipVariants adds a gzip copy of each compressible compiled file,
+// as {path}.gz, so assets are compressed once at build time rather than
+// on every request. Like optimized images, the copies are compiled files,
+// so they are promoted, snapshotted and cleaned up with the rest of the
+// build. index.html is skipped: it is rewritten per view.
+func addGzipVariants(compiledFiles map[string]string) map[string]string {
+	out := make(map[string]string, len(compiledFiles)*2)
+	for p, content := range compiledFiles {
+		out[p] = content
+		if p == "index.html" || !isCompressible(p) || len(content) < minCompressSize {
+			continue
+		}
+		if _, ok := compiledFiles[p+gzipSuffix]; ok {
+			continue
+		}
+		compressed := gzipBytes([]byte(content), gzip.BestCompression)
+		if len(compressed) < len(content) {
+			out[p+gzipSuffix] = base64.StdEncoding.EncodeToString(compressed)
+		}
+	}
+	return out
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -10,14 +12,438 @@ type Config struct {
 	PythonAgentURL string
 	RustDBURL      string
 	NodeBuildURL   string
+
+	// StorageBackend is where projects are kept: "rustdb" (the default),
+	// "memory", which is lost on restart, or "filesystem" under StorageDir.
+	// The latter two need no Rust DB and suit a single local instance.
+	StorageBackend string
+	StorageDir     string
+
+	// TLS for the public listener. TLSClientCA enables mutual TLS.
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+
+	// HTTP/2 is served over TLS unless HTTP2Enabled is false. H2C allows
+	// prior-knowledge HTTP/2 over plain TCP, for use behind TrustedProxies
+	// that terminate TLS. Streams per connection and the largest frame
+	// accepted are tunable.
+	HTTP2Enabled              bool
+	H2C                       bool
+	HTTP2MaxConcurrentStreams int
+	HTTP2MaxReadFrameSize     int
+
+	// Listener hardening: how long clients get to send headers and whole
+	// requests, how long idle connections (and HTTP/2 connections with no
+	// open streams) are kept, the largest request header block, and the
+	// most connections accepted at once (zero for no limit).
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxConnections    int
+
+	// Client IPs or CIDR ranges allowed or denied on the public listener.
+	IPAllowlist []string
+	IPDenylist  []string
+
+	// TrustedProxies are the CIDRs whose X-Forwarded-For, X-Real-IP and
+	// identity headers are believed. Requests from anywhere else are
+	// identified by their peer address alone.
+	TrustedProxies     []string
+	IdentityUserHeader string
+	IdentityKeyHeader  string
+	IdentityTierHeader string
+
+	// External hosts whose resources may be fetched through the view proxy,
+	// and the largest resource it will fetch, in bytes.
+	ProxyAllowedHosts []string
+	ProxyMaxSize      int
+
+	// GitAllowedHosts are the hosts linked repositories' raw_url_template
+	// may fetch files from.
+	GitAllowedHosts []string
+
+	// AdminToken is the bearer token for /admin endpoints, which are
+	// disabled when it is empty.
+	AdminToken string
+
+	// AuthEnabled requires an API key on project routes. Keys come from
+	// APIKeys ("id:scope:secret" or "id:scope:secret:project") and from
+	// keys created through /admin/keys. PublicProjects is the default of
+	// the public setting, which leaves a project's view routes open.
+	AuthEnabled    bool
+	APIKeys        []string
+	PublicProjects bool
+
+	// MetricsToken, if set, is required as a bearer token to scrape
+	// /metrics.
+	MetricsToken string
+
+	// CaptureFailedRequests records failed agent requests for replay
+	// against ReplayAgentURL (authenticated with ReplayAgentToken).
+	CaptureFailedRequests bool
+	ReplayAgentURL        string
+	ReplayAgentToken      string
+
+	// Low-priority requests are shed while, over ShedWindow and at least
+	// ShedMinRequests calls, storage calls fail more than
+	// ShedErrorPercent of the time or average more than ShedLatency.
+	ShedWindow       time.Duration
+	ShedMinRequests  int
+	ShedErrorPercent int
+	ShedLatency      time.Duration
+
+	// EmbedSecret signs the view tokens baked into embed scripts, which are
+	// disabled when it is empty. Tokens expire after EmbedTokenTTL.
+	EmbedSecret   string
+	EmbedTokenTTL time.Duration
+
+	// Static bearer tokens sent to each internal service, if set.
+	RustDBToken      string
+	PythonAgentToken string
+	NodeBuildToken   string
+
+	// Client certificate, key and CA bundle for mTLS to internal services.
+	ServiceTLSCert string
+	ServiceTLSKey  string
+	ServiceTLSCA   string
+
+	// Per-route-class request budgets: RouteTimeout for views, assets, state
+	// and settings, GenerationTimeout for agent generation. Streaming routes
+	// have none. Downstream calls get whatever is left of the budget,
+	// further capped by their own timeouts.
+	RouteTimeout      time.Duration
+	GenerationTimeout time.Duration
+	AgentTimeout      time.Duration
+	StorageTimeout    time.Duration
+
+	// Node Build gets its own timeout and retry budget since compiles are
+	// slower than the other downstream calls and safe to repeat.
+	NodeBuildTimeout time.Duration
+	NodeBuildRetries int
+
+	// BuildWorkers is how many queued builds (compile, repair and store)
+	// run at once; see buildqueue.go.
+	BuildWorkers int
+
+	// Calls to Rust DB and Python Agent that are safe to repeat are retried
+	// DownstreamRetries times on connection errors and 502/503/504, waiting
+	// from DownstreamRetryBackoff and doubling with jitter. After
+	// CircuitBreakerFailures consecutive failures (zero to disable) calls to
+	// that service fail fast with 503 until a probe succeeds, one per
+	// CircuitBreakerCooldown.
+	DownstreamRetries      int
+	DownstreamRetryBackoff time.Duration
+	CircuitBreakerFailures int
+	CircuitBreakerCooldown time.Duration
+
+	// /readyz probes each downstream with ReadyCheckTimeout and reuses the
+	// result for ReadyCacheTTL.
+	ReadyCheckTimeout time.Duration
+	ReadyCacheTTL     time.Duration
+
+	// With LighthouseBaseURL set to an address Node Build can reach this
+	// service on (e.g. http://go-main:8080), the preview is audited with
+	// Lighthouse after every production build, each run getting
+	// LighthouseTimeout.
+	LighthouseBaseURL string
+	LighthouseTimeout time.Duration
+
+	// Concurrent agent runs and builds, zero for no limit. Batch-tier
+	// clients can't use the last ReservedInteractiveSlots of either.
+	AgentConcurrency         int
+	BuildConcurrency         int
+	ReservedInteractiveSlots int
+
+	// BuildRepairAttempts is how many times a failed build is sent back to the
+	// agent to fix before giving up. Zero disables automatic repair.
+	BuildRepairAttempts int
+	// BuildErrorEvents reports chat builds to the stream as data-build
+	// events: each failure sent for repair, then the outcome.
+	BuildErrorEvents bool
+
+	// SchedulerEnabled runs project cron schedules on this instance. Only one
+	// instance in a deployment should have it enabled.
+	SchedulerEnabled bool
+
+	// ReadOnly runs the instance as a serving replica: views, assets and
+	// state are served, but nothing is generated, built or changed, and the
+	// scheduler doesn't run.
+	ReadOnly bool
+
+	// With Replicas set ("id=url" per instance, the same on every replica),
+	// chat and build requests report the replica that owns their project,
+	// and with ReplicaAffinity "redirect" are sent there, so per-project
+	// in-memory locks, chat streams and change events stay on one instance.
+	// ReplicaID is this instance's entry.
+	ReplicaID       string
+	Replicas        []string
+	ReplicaAffinity string
+
+	// DeletionGracePeriod is how long a deleted project can still be restored.
+	DeletionGracePeriod time.Duration
+
+	// Only one mutating operation (create, edit, chat, replace, rollback)
+	// runs per project at a time, across instances. ProjectLockTTL is how
+	// long a lease survives without renewal if its instance dies, and
+	// ProjectLockWait how long a request waits for a busy project before
+	// getting 409. Zero wait rejects immediately.
+	ProjectLockTTL  time.Duration
+	ProjectLockWait time.Duration
+
+	// VerifyContentHashes checks source and compiled files read from storage
+	// against the hashes in the project manifest.
+	VerifyContentHashes bool
+	// StorageFetchConcurrency bounds the parallel rust-db reads made when
+	// loading a project's files.
+	StorageFetchConcurrency int
+	// EventLog records every source and compiled file write, build and
+	// settings change in an append-only per-project log (see events.go).
+	EventLog bool
+
+	// Limits on files returned by the agent, in bytes.
+	MaxFileSize    int
+	MaxProjectSize int
+
+	// ChatResumeWindow is how long a chat stream keeps running after its
+	// last client disconnects, waiting for one to resume it with
+	// Last-Event-ID. Zero cancels it on disconnect.
+	ChatResumeWindow time.Duration
+	// ChatHeartbeatInterval is how long a chat stream can be idle before a
+	// ": ping" comment is sent to keep proxies from closing it. Zero
+	// disables heartbeats.
+	ChatHeartbeatInterval time.Duration
+
+	// Chat history larger than CompactConversationSize bytes has all but the
+	// last CompactKeepMessages messages replaced by an agent-written summary
+	// before it is sent to the agent. Zero disables compaction.
+	CompactConversationSize int
+	CompactKeepMessages     int
+
+	// Monthly per-project quotas, zero for unlimited. Warnings are raised at
+	// 80, 90 and 100% of these and of MaxProjectSize.
+	TokenQuota        int64
+	BuildMinutesQuota int
+
+	// Defaults for per-project settings (see settings.go). AgentModels are
+	// the models a project may choose instead of the agent's default.
+	DefaultCSP          string
+	DefaultBuildProfile string
+	AgentModels         []string
+	BuildRetentionDays  int
+	PlaceholderImages   bool
+	OptimizeImages      bool
+	SelfHostFonts       bool
+
+	// Source file path policy. An empty allowlist allows anything.
+	AllowedPathPrefixes []string
+	AllowedExtensions   []string
+	MaxFilesPerProject  int
+
+	// MaxVersions is how many app snapshots each project keeps for
+	// rollback; zero disables versioning.
+	MaxVersions int
+	// MaxFileHistory is how many previous contents of each source file are
+	// kept for single-file reverts; zero disables file history.
+	MaxFileHistory int
+
+	// FormRateLimit is how many form submissions each client can make to a
+	// project per minute; zero disables the limit. MaxFormSubmissions is
+	// how many submissions each form keeps.
+	FormRateLimit      int
+	MaxFormSubmissions int
+
+	// DataRateLimit is how many app data requests each client can make to
+	// a project per minute; zero disables the limit. MaxDataValueBytes
+	// caps one value and MaxDataBytes all of a project's values.
+	DataRateLimit     int
+	MaxDataValueBytes int
+	MaxDataBytes      int
+
+	// MaxBodyBytes caps API request bodies, and MaxChatBodyBytes those of
+	// chat requests and conversation saves, which carry the whole
+	// conversation; zero disables a limit. MaxPromptLength caps a prompt or
+	// user chat message in characters, and MaxChatMessages the messages of
+	// a chat request.
+	MaxBodyBytes     int
+	MaxChatBodyBytes int
+	MaxPromptLength  int
+	MaxChatMessages  int
+
+	// SecretsKey is the hex-encoded AES-256 key used to encrypt project secrets.
+	SecretsKey string
+
+	// RedactionMode is how payloads that may carry prompts, file contents or
+	// conversations are recorded in logs: "off", "truncate" to
+	// RedactionMaxLength characters, or "hash".
+	RedactionMode      string
+	RedactionMaxLength int
+
+	// ViewStatsSamplePercent is the share of view and asset requests recorded
+	// in access stats (0-100). Sampled counts are scaled back up when stored.
+	ViewStatsSamplePercent int
 }
 
 func LoadConfig() Config {
 	return Config{
 		Port:           getEnvInt("PORT", 3000),
-		PythonAgentURL: getEnv("PYTHON_AGENT_URL", "http://localhost:3003"),
-		RustDBURL:      getEnv("RUST_DB_URL", "http://localhost:3001"),
-		NodeBuildURL:   getEnv("NODE_BUILD_URL", "http://localhost:3000"),
+		PythonAgentURL: getEnv("PYTHON_AGENT_URL", "http://localhost:3001"),
+		RustDBURL:      getEnv("RUST_DB_URL", "http://localhost:3003"),
+		NodeBuildURL:   getEnv("NODE_BUILD_URL", "http://localhost:3002"),
+
+		StorageBackend: getEnv("STORAGE_BACKEND", BackendRustDB),
+		StorageDir:     getEnv("STORAGE_DIR", "data"),
+
+		TLSCert:     getEnv("TLS_CERT", ""),
+		TLSKey:      getEnv("TLS_KEY", ""),
+		TLSClientCA: getEnv("TLS_CLIENT_CA", ""),
+
+		HTTP2Enabled:              getEnvBool("HTTP2_ENABLED", true),
+		H2C:                       getEnvBool("H2C", false),
+		HTTP2MaxConcurrentStreams: getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 250),
+		HTTP2MaxReadFrameSize:     getEnvInt("HTTP2_MAX_READ_FRAME_SIZE", 1<<20),
+
+		ReadHeaderTimeout: getEnvDuration("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("READ_TIMEOUT", 10*time.Second),
+		IdleTimeout:       getEnvDuration("IDLE_TIMEOUT", 60*time.Second),
+		MaxHeaderBytes:    getEnvInt("MAX_HEADER_BYTES", 64<<10),
+		MaxConnections:    getEnvInt("MAX_CONNECTIONS", 10000),
+
+		IPAllowlist: getEnvList("IP_ALLOWLIST", nil),
+		IPDenylist:  getEnvList("IP_DENYLIST", nil),
+
+		TrustedProxies:     getEnvList("TRUSTED_PROXIES", nil),
+		IdentityUserHeader: getEnv("IDENTITY_USER_HEADER", "X-Forwarded-User"),
+		IdentityKeyHeader:  getEnv("IDENTITY_KEY_HEADER", "X-Api-Key-Id"),
+		IdentityTierHeader: getEnv("IDENTITY_TIER_HEADER", "X-Api-Key-Tier"),
+
+		ProxyAllowedHosts: getEnvList("PROXY_ALLOWED_HOSTS", []string{"fonts.googleapis.com", "fonts.gstatic.com"}),
+		ProxyMaxSize:      getEnvInt("PROXY_MAX_SIZE", 5<<20),
+
+		GitAllowedHosts: getEnvList("GIT_ALLOWED_HOSTS", []string{"raw.githubusercontent.com", "gitlab.com", "codeberg.org", "gitea.com", "bitbucket.org"}),
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+
+		AuthEnabled:    getEnvBool("AUTH_ENABLED", false),
+		APIKeys:        getEnvList("API_KEYS", nil),
+		PublicProjects: getEnvBool("PUBLIC_PROJECTS", false),
+
+		MetricsToken: getEnv("METRICS_TOKEN", ""),
+
+		CaptureFailedRequests: getEnvBool("CAPTURE_FAILED_REQUESTS", false),
+		ReplayAgentURL:        getEnv("REPLAY_AGENT_URL", ""),
+		ReplayAgentToken:      getEnv("REPLAY_AGENT_TOKEN", ""),
+
+		ShedWindow:       getEnvDuration("SHED_WINDOW", 30*time.Second),
+		ShedMinRequests:  getEnvInt("SHED_MIN_REQUESTS", 20),
+		ShedErrorPercent: getEnvInt("SHED_ERROR_PERCENT", 50),
+		ShedLatency:      getEnvDuration("SHED_LATENCY", 5*time.Second),
+
+		EmbedSecret:   getEnv("EMBED_SECRET", ""),
+		EmbedTokenTTL: getEnvDuration("EMBED_TOKEN_TTL", 30*24*time.Hour),
+
+		RustDBToken:      getEnv("RUST_DB_TOKEN", ""),
+		PythonAgentToken: getEnv("PYTHON_AGENT_TOKEN", ""),
+		NodeBuildToken:   getEnv("NODE_BUILD_TOKEN", ""),
+
+		ServiceTLSCert: getEnv("SERVICE_TLS_CERT", ""),
+		ServiceTLSKey:  getEnv("SERVICE_TLS_KEY", ""),
+		ServiceTLSCA:   getEnv("SERVICE_TLS_CA", ""),
+
+		RouteTimeout:      getEnvDuration("ROUTE_TIMEOUT", 15*time.Second),
+		GenerationTimeout: getEnvDuration("GENERATION_TIMEOUT", 120*time.Second),
+		AgentTimeout:      getEnvDuration("AGENT_TIMEOUT", 0),
+		StorageTimeout:    getEnvDuration("STORAGE_TIMEOUT", 10*time.Second),
+
+		NodeBuildTimeout: getEnvDuration("NODE_BUILD_TIMEOUT", 60*time.Second),
+		NodeBuildRetries: getEnvInt("NODE_BUILD_RETRIES", 2),
+
+		BuildWorkers: getEnvInt("BUILD_WORKERS", 4),
+
+		DownstreamRetries:      getEnvInt("DOWNSTREAM_RETRIES", 2),
+		DownstreamRetryBackoff: getEnvDuration("DOWNSTREAM_RETRY_BACKOFF", 100*time.Millisecond),
+		CircuitBreakerFailures: getEnvInt("CIRCUIT_BREAKER_FAILURES", 5),
+		CircuitBreakerCooldown: getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 10*time.Second),
+
+		ReadyCheckTimeout: getEnvDuration("READY_CHECK_TIMEOUT", 2*time.Second),
+		ReadyCacheTTL:     getEnvDuration("READY_CACHE_TTL", 5*time.Second),
+
+		LighthouseBaseURL: getEnv("LIGHTHOUSE_BASE_URL", ""),
+		LighthouseTimeout: getEnvDuration("LIGHTHOUSE_TIMEOUT", 2*time.Minute),
+
+		AgentConcurrency:         getEnvInt("AGENT_CONCURRENCY", 0),
+		BuildConcurrency:         getEnvInt("BUILD_CONCURRENCY", 0),
+		ReservedInteractiveSlots: getEnvInt("RESERVED_INTERACTIVE_SLOTS", 1),
+
+		BuildRepairAttempts: getEnvInt("BUILD_REPAIR_ATTEMPTS", 0),
+		BuildErrorEvents:    getEnvBool("BUILD_ERROR_EVENTS", false),
+
+		SchedulerEnabled: getEnvBool("SCHEDULER_ENABLED", true),
+
+		ReadOnly: getEnvBool("READ_ONLY", false),
+
+		ReplicaID:       getEnv("REPLICA_ID", ""),
+		Replicas:        getEnvList("REPLICAS", nil),
+		ReplicaAffinity: getEnv("REPLICA_AFFINITY", AffinityHeader),
+
+		DeletionGracePeriod: getEnvDuration("DELETION_GRACE_PERIOD", 24*time.Hour),
+
+		ProjectLockTTL:  getEnvDuration("PROJECT_LOCK_TTL", 2*time.Minute),
+		ProjectLockWait: getEnvDuration("PROJECT_LOCK_WAIT", 0),
+
+		VerifyContentHashes:     getEnvBool("VERIFY_CONTENT_HASHES", false),
+		StorageFetchConcurrency: getEnvInt("STORAGE_FETCH_CONCURRENCY", 4),
+		EventLog:                getEnvBool("EVENT_LOG", false),
+
+		MaxFileSize:    getEnvInt("MAX_FILE_SIZE", 1<<20),
+		MaxProjectSize: getEnvInt("MAX_PROJECT_SIZE", 20<<20),
+
+		ChatResumeWindow:      getEnvDuration("CHAT_RESUME_WINDOW", 30*time.Second),
+		ChatHeartbeatInterval: getEnvDuration("CHAT_HEARTBEAT_INTERVAL", 15*time.Second),
+
+		CompactConversationSize: getEnvInt("COMPACT_CONVERSATION_SIZE", 200<<10),
+		CompactKeepMessages:     getEnvInt("COMPACT_KEEP_MESSAGES", 6),
+
+		TokenQuota:        int64(getEnvInt("TOKEN_QUOTA", 0)),
+		BuildMinutesQuota: getEnvInt("BUILD_MINUTES_QUOTA", 0),
+
+		DefaultCSP:          getEnv("DEFAULT_CSP", ""),
+		DefaultBuildProfile: getEnv("DEFAULT_BUILD_PROFILE", BuildProduction),
+		AgentModels:         getEnvList("AGENT_MODELS", nil),
+		BuildRetentionDays:  getEnvInt("BUILD_RETENTION_DAYS", 0),
+		PlaceholderImages:   getEnvBool("PLACEHOLDER_IMAGES", false),
+		OptimizeImages:      getEnvBool("OPTIMIZE_IMAGES", false),
+		SelfHostFonts:       getEnvBool("SELF_HOST_FONTS", false),
+
+		AllowedPathPrefixes: getEnvList("ALLOWED_PATH_PREFIXES", nil),
+		AllowedExtensions: getEnvList("ALLOWED_EXTENSIONS", []string{
+			".ts", ".tsx", ".js", ".mjs", ".jsx", ".css", ".json", ".md", ".html",
+			".svg", ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".ico",
+		}),
+		MaxFilesPerProject: getEnvInt("MAX_FILES_PER_PROJECT", 200),
+
+		MaxVersions:    getEnvInt("MAX_VERSIONS", 20),
+		MaxFileHistory: getEnvInt("MAX_FILE_HISTORY", 20),
+
+		FormRateLimit:      getEnvInt("FORM_RATE_LIMIT", 10),
+		MaxFormSubmissions: getEnvInt("MAX_FORM_SUBMISSIONS", 1000),
+
+		DataRateLimit:     getEnvInt("DATA_RATE_LIMIT", 120),
+		MaxDataValueBytes: getEnvInt("MAX_DATA_VALUE_BYTES", 64<<10),
+		MaxDataBytes:      getEnvInt("MAX_DATA_BYTES", 5<<20),
+
+		MaxBodyBytes:     getEnvInt("MAX_BODY_BYTES", 1<<20),
+		MaxChatBodyBytes: getEnvInt("MAX_CHAT_BODY_BYTES", 8<<20),
+		MaxPromptLength:  getEnvInt("MAX_PROMPT_LENGTH", 50000),
+		MaxChatMessages:  getEnvInt("MAX_CHAT_MESSAGES", 1000),
+
+		SecretsKey: getEnv("SECRETS_KEY", ""),
+
+		RedactionMode:      getEnv("REDACTION_MODE", RedactOff),
+		RedactionMaxLength: getEnvInt("REDACTION_MAX_LENGTH", 64),
+
+		ViewStatsSamplePercent: getEnvInt("VIEW_STATS_SAMPLE_PERCENT", 100),
 	}
 }
 
@@ -36,3 +462,35 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dataPath is the path under /view that generated apps keep data at.
+const dataPath = "_data"
+
+// dataKeyPattern matches an app data key: up to eight slash-separated
+// segments, none starting with a dot.
+var dataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}(/[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}){0,7}$`)
+
+// allowDataRequest applies DATA_RATE_LIMIT to the client, writing the
+// error response and returning false if it is over.
+func (h *Handlers) allowDataRequest(w http.ResponseWriter, r *http.Request, projectID string) bool {
+	client := projectID + "/" + requestIdentity(r.Context()).IP
+	if allowed, retryAfter := h.dataLimiter.Allow(client, h.cfg.DataRateLimit); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(retryAfter.Seconds(), 1))))
+		writeError(w, AppError{Code: http.StatusTooManyRequests, Message: "Too many data requests, try again later"})
+		return false
+	}
+	return true
+}
+
+// ErrNoApp is returned by the served app's endpoints for projects without
+// an app, so their data and forms can't be used as free storage.
+var ErrNoApp = AppError{Code: http.StatusNotFound, Message: "No app exists for this project"}
+
+// dataProject checks the project and rate limit of an app data request,
+// writing the error response and returning false if one fails.
+func (h *Handlers) dataProject(w http.ResponseWriter, r *http.Request) (string, bool) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return "", false
+	}
+	if !h.allowDataRequest(w, r, projectID) {
+		return "", false
+	}
+	if !h.storage.HasApp(r.Context(), projectID) {
+		writeError(w, ErrNoApp)
+		return "", false
+	}
+	return projectID, true
+}
+
+// dataRequest checks the project, rate limit and key of a request for an
+// app data value, writing the error response and returning false if one
+// fails.
+func (h *Handlers) dataRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	projectID, ok := h.dataProject(w, r)
+	if !ok {
+		return "", "", false
+	}
+	key := chi.URLParam(r, "*")
+	if !dataKeyPattern.MatchString(key) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid data key"})
+		return "", "", false
+	}
+	return projectID, key, true
+}
+
+// dataPrecondition returns the StoreIf/DeleteIf ETag for the request's
+// If-Match, or "*" for If-None-Match: *.
+func dataPrecondition(r *http.Request) string {
+	if strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" {
+		return "*"
+	}
+	tag := strings.TrimPrefix(strings.TrimSpace(r.Header.Get("If-Match")), "W/")
+	if tag == "*" {
+		return ""
+	}
+	return strings.Trim(tag, `"`)
+}
+
+// AppDataKey is one key in an app data listing.
+type AppDataKey struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+// AppDataListResponse is the response for listing app data.
+type AppDataListResponse struct {
+	Keys []AppDataKey `json:"keys"`
+	// Bytes is the size of all the project's values, against MaxBytes.
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int   `json:"max_bytes"`
+}
+
+// HandleListAppData lists the served app's data keys, optionally only
+// those starting with ?prefix=.
+func (h *Handlers) HandleListAppData(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := h.dataProject(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := h.storage.ListAppData(r.Context(), projectID, "")
+	if err != nil {
+		writeError(w, upstreamError("Failed to list data", err))
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	resp := AppDataListResponse{Keys: []AppDataKey{}, MaxBytes: h.cfg.MaxDataBytes}
+	for _, entry := range entries {
+		resp.Bytes += entry.Size
+		if strings.HasPrefix(entry.Key, prefix) {
+			resp.Keys = append(resp.Keys, AppDataKey{Key: entry.Key, Size: entry.Size, ETag: entry.ETag})
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleGetAppData returns an app data value, tagged with its ETag for
+// conditional updates.
+func (h *Handlers) HandleGetAppData(w http.ResponseWriter, r *http.Request) {
+	projectID, key, ok := h.dataRequest(w, r)
+	if !ok {
+		return
+	}
+
+	value, err := h.storage.GetAppData(r.Context(), projectID, key)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "No data stored at " + key})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to get data", err))
+		return
+	}
+
+	etag := quoteETag(contentETag(value))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(value)
+}
+
+// currentAppData returns the stored value at key, nil if there is none,
+// and the ETag to update it with: its own, or "*" for none. A request
+// precondition that doesn't match it fails with ErrPreconditionFailed.
+func (h *Handlers) currentAppData(ctx context.Context, projectID, key, precondition string) ([]byte, string, error) {
+	current, err := h.storage.GetAppData(ctx, projectID, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, "", err
+	}
+	etag := "*"
+	if err == nil {
+		etag = contentETag(current)
+	}
+	if precondition != "" && precondition != etag {
+		return nil, "", ErrPreconditionFailed
+	}
+	return current, etag, nil
+}
+
+// HandlePutAppData stores a JSON value for the served app, so generated
+// apps can keep state (todo lists, counters) without a backend of their
+// own. It is public along with the view. Values are capped by
+// MAX_DATA_VALUE_BYTES and the project's values together by
+// MAX_DATA_BYTES, against a running total updated with each write.
+// If-Match with a value's ETag, or If-None-Match: *, makes the write
+// conditional, so concurrent read-modify-writes can retry.
+func (h *Handlers) HandlePutAppData(w http.ResponseWriter, r *http.Request) {
+	projectID, key, ok := h.dataRequest(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.cfg.MaxDataValueBytes))
+	value, err := io.ReadAll(r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, AppError{Code: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Values are at most %d bytes", h.cfg.MaxDataValueBytes)})
+		return
+	}
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Failed to read value"})
+		return
+	}
+	if !json.Valid(value) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Values must be JSON"})
+		return
+	}
+
+	// The value replaced is read first, to count the change in size, and
+	// is only overwritten if it is still the one read. Unconditional
+	// writes that lose that race try again.
+	precondition := dataPrecondition(r)
+	for attempt := 1; ; attempt++ {
+		current, etag, err := h.currentAppData(r.Context(), projectID, key, precondition)
+		if errors.Is(err, ErrPreconditionFailed) {
+			writeError(w, AppError{Code: http.StatusPreconditionFailed, Message: "The value has changed"})
+			return
+		}
+		if err != nil {
+			writeError(w, upstreamError("Failed to get data", err))
+			return
+		}
+
+		delta := int64(len(value) - len(current))
+		fits, err := h.storage.AddAppDataUsage(r.Context(), projectID, delta, int64(h.cfg.MaxDataBytes))
+		if err != nil {
+			writeError(w, upstreamError("Failed to check data quota", err))
+			return
+		}
+		if !fits {
+			writeError(w, AppError{Code: http.StatusInsufficientStorage, Message: fmt.Sprintf("The app's data would exceed %d bytes", h.cfg.MaxDataBytes)})
+			return
+		}
+
+		err = h.storage.StoreAppData(r.Context(), projectID, key, value, etag)
+		if err != nil {
+			if _, undoErr := h.storage.AddAppDataUsage(context.WithoutCancel(r.Context()), projectID, -delta, 0); undoErr != nil {
+				log.Printf("Error restoring data usage of project %s: %v", projectID, undoErr)
+			}
+		}
+		if errors.Is(err, ErrPreconditionFailed) && precondition == "" && attempt < maxAppDataRetries {
+			continue
+		}
+		if errors.Is(err, ErrPreconditionFailed) {
+			writeError(w, AppError{Code: http.StatusPreconditionFailed, Message: "The value has changed"})
+			return
+		}
+		if err != nil {
+			writeError(w, upstreamError("Failed to store data", err))
+			return
+		}
+		break
+	}
+	w.Header().Set("ETag", quoteETag(contentETag(value)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteAppData removes an app data value, conditionally with
+// If-Match.
+func (h *Handlers) HandleDeleteAppData(w http.ResponseWriter, r *http.Request) {
+	projectID, key, ok := h.dataRequest(w, r)
+	if !ok {
+		return
+	}
+
+	precondition := dataPrecondition(r)
+	for attempt := 1; ; attempt++ {
+		current, etag, err := h.currentAppData(r.Context(), projectID, key, precondition)
+		if errors.Is(err, ErrPreconditionFailed) {
+			writeError(w, AppError{Code: http.StatusPreconditionFailed, Message: "The value has changed"})
+			return
+		}
+		if err != nil {
+			writeError(w, upstreamError("Failed to get data", err))
+			return
+		}
+		if current == nil {
+			break
+		}
+
+		err = h.storage.DeleteAppData(r.Context(), projectID, key, etag)
+		if errors.Is(err, ErrPreconditionFailed) && precondition == "" && attempt < maxAppDataRetries {
+			continue
+		}
+		if errors.Is(err, ErrPreconditionFailed) {
+			writeError(w, AppError{Code: http.StatusPreconditionFailed, Message: "The value has changed"})
+			return
+		}
+		if err != nil {
+			writeError(w, upstreamError("Failed to delete data", err))
+			return
+		}
+		if _, err := h.storage.AddAppDataUsage(context.WithoutCancel(r.Context()), projectID, -int64(len(current)), 0); err != nil {
+			log.Printf("Error updating data usage of project %s: %v", projectID, err)
+		}
+		break
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PendingDeletion records a scheduled project deletion.
+type PendingDeletion struct {
+	RequestedAt time.Time `json:"requested_at"`
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+// DeletedProject reports the keys removed by an immediate deletion.
+type DeletedProject struct {
+	// Deleted counts the keys removed under each top-level prefix, such as
+	// "source/", "compiled/" and "_meta/" (which holds the conversation).
+	Deleted map[string]int `json:"deleted"`
+	Total   int            `json:"total"`
+}
+
+// HandleDeleteProject schedules the project for deletion after the grace
+// period. With ?immediate=true, or no grace period, every key is removed at
+// once; repeating that reports zero keys deleted.
+func (h *Handlers) HandleDeleteProject(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("immediate") == "true" || h.cfg.DeletionGracePeriod == 0 {
+		deleted, err := h.deleteProject(r.Context(), projectID)
+		if errors.Is(err, ErrProjectBusy) {
+			w.Header().Set("Retry-After", strconv.Itoa(projectLockRetryAfter))
+			writeError(w, err)
+			return
+		}
+		if err != nil {
+			writeError(w, upstreamError("Failed to delete project", err))
+			return
+		}
+		resp := DeletedProject{Deleted: deleted}
+		for _, n := range deleted {
+			resp.Total += n
+		}
+		log.Printf("Deleted project %s (%d keys)", projectID, resp.Total)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	// Deleting twice keeps the original deadline
+	if pending, err := h.storage.GetPendingDeletion(r.Context(), projectID); err == nil {
+		writeJSON(w, http.StatusAccepted, pending)
+		return
+	}
+
+	now := time.Now().UTC()
+	pending := PendingDeletion{
+		RequestedAt: now,
+		DeleteAfter: now.Add(h.cfg.DeletionGracePeriod),
+	}
+	if err := h.storage.StorePendingDeletion(r.Context(), projectID, &pending); err != nil {
+		writeError(w, upstreamError("Failed to schedule deletion", err))
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, pending)
+}
+
+// HandleCancelDeletion cancels a scheduled project deletion.
+func (h *Handlers) HandleCancelDeletion(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if _, err := h.storage.GetPendingDeletion(r.Context(), projectID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "No deletion scheduled for this project"})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	if err := h.storage.DeletePendingDeletion(r.Context(), projectID); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to cancel deletion: %v", err)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteProject removes every key of the project while holding its lock,
+// so a change in progress can't write into it afterwards, then the lock
+// itself. The project is first taken out of its workspace, and then out
+// of the API keys limited to it.
+func (h *Handlers) deleteProject(ctx context.Context, projectID string) (map[string]int, error) {
+	release, err := h.locks.Acquire(ctx, projectID, "delete")
+	if err != nil {
+		return nil, err
+	}
+	if err := h.removeFromWorkspace(ctx, projectID); err != nil {
+		release()
+		return nil, err
+	}
+	deleted, err := h.storage.DeleteProject(ctx, projectID)
+	release()
+	if err != nil {
+		return deleted, err
+	}
+	if err := h.storage.RemoveAPIKeyProject(ctx, projectID); err != nil {
+		return deleted, err
+	}
+	return deleted, h.locks.Remove(ctx, projectID)
+}
+
+// purgeDueDeletions deletes every project whose grace period has expired.
+func (s *Scheduler) purgeDueDeletions(ctx context.Context, now time.Time) {
+	projectIDs, err := s.h.storage.ListPendingDeletions(ctx)
+	if err != nil {
+		log.Printf("Error listing pending deletions: %v", err)
+		return
+	}
+
+	for _, projectID := range projectIDs {
+		pending, err := s.h.storage.GetPendingDeletion(ctx, projectID)
+		if err != nil || now.Before(pending.DeleteAfter) {
+			continue
+		}
+		if _, err := s.h.deleteProject(ctx, projectID); err != nil {
+			log.Printf("Error deleting project %s: %v", projectID, err)
+			continue
+		}
+		log.Printf("Deleted project %s", projectID)
+	}
+}
+
+// injectDeletionBanner adds a fixed warning banner to served HTML.
+func injectDeletionBanner(page string, deleteAfter time.Time) string {
+	banner := `<div style="position:fixed;top:0;left:0;right:0;z-index:2147483647;padding:8px;` +
+		`background:#b91c1c;color:#fff;font:14px sans-serif;text-align:center">` +
+		html.EscapeString("This app is scheduled for deletion on "+deleteAfter.Format(time.RFC1123)) +
+		`</div>`
+
+	if i := strings.LastIndex(page, "</body>"); i >= 0 {
+		return page[:i] + banner + page[i:]
+	}
+	return page + banner
+}
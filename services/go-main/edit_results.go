@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxHunkSearchLen caps how much of each hunk's search text is echoed back.
+const maxHunkSearchLen = 120
+
+// AgentEdit is the agent's report of a single edit_file call.
+type AgentEdit struct {
+	Path   string `json:"path"`
+	OldStr string `json:"old_str"`
+	Status string `json:"status"` // "applied", "fuzzy" or "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+// HunkResult is the outcome of one search/replace hunk.
+type HunkResult struct {
+	Search string `json:"search"`
+	Status string `json:"status"` // "applied", "fuzzy" or "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+// FileEditResult describes how a single file changed during an edit.
+type FileEditResult struct {
+	Path   string       `json:"path"`
+	Status string       `json:"status"` // "created", "modified", "deleted" or "unchanged"
+	Hunks  []HunkResult `json:"hunks"`
+}
+
+// buildEditResults combines the before/after file sets with the agent's edit
+// reports into per-file results, sorted by path. Files that were only touched
+// by failed hunks are reported as unchanged.
+func buildEditResults(before, after map[string]string, edits []AgentEdit) []FileEditResult {
+	hunks := make(map[string][]HunkResult)
+	for _, edit := range edits {
+		search := edit.OldStr
+		if len(search) > maxHunkSearchLen {
+			search = search[:maxHunkSearchLen] + "…"
+		}
+		hunks[edit.Path] = append(hunks[edit.Path], HunkResult{
+			Search: search,
+			Status: edit.Status,
+			Error:  edit.Error,
+		})
+	}
+
+	paths := make(map[string]bool, len(before)+len(after))
+	for path := range before {
+		paths[path] = true
+	}
+	for path := range after {
+		paths[path] = true
+	}
+	for path := range hunks {
+		paths[path] = true
+	}
+
+	results := make([]FileEditResult, 0, len(paths))
+	for path := range paths {
+		oldContent, existed := before[path]
+		newContent, exists := after[path]
+
+		var status string
+		switch {
+		case !existed && exists:
+			status = "created"
+		case existed && !exists:
+			status = "deleted"
+		case existed && oldContent != newContent:
+			status = "modified"
+		default:
+			status = "unchanged"
+		}
+		if status == "unchanged" && len(hunks[path]) == 0 {
+			continue
+		}
+
+		fileHunks := hunks[path]
+		if fileHunks == nil {
+			fileHunks = []HunkResult{}
+		}
+		results = append(results, FileEditResult{Path: path, Status: status, Hunks: fileHunks})
+	}
+
+	slices.SortFunc(results, func(a, b FileEditResult) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return results
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// embedTokenParam carries the signed token on embedded view requests.
+const embedTokenParam = "embed_token"
+
+// embedSandbox is the iframe sandbox for embedded previews. Scripts run,
+// but without allow-same-origin the app can't reach the host page or our
+// own origin's cookies.
+const embedSandbox = "allow-scripts allow-forms allow-popups allow-modals"
+
+var embedSizePattern = regexp.MustCompile(`^\d{1,5}(px|%|vh|vw|em|rem)?$`)
+
+// embedScript injects the preview iframe after the script tag, or into the
+// element matched by the tag's data-target selector. The view URL is
+// resolved relative to the script's own URL.
+const embedScript = `(function () {
+  var script = document.currentScript;
+  var iframe = document.createElement('iframe');
+  iframe.src = new URL('view?%s=' + encodeURIComponent(%s), script.src).href;
+  iframe.title = %s;
+  iframe.setAttribute('sandbox', %s);
+  iframe.setAttribute('loading', 'lazy');
+  iframe.style.width = %s;
+  iframe.style.height = %s;
+  iframe.style.border = '0';
+  var target = script.dataset.target && document.querySelector(script.dataset.target);
+  if (target) {
+    target.appendChild(iframe);
+  } else {
+    script.parentNode.insertBefore(iframe, script.nextSibling);
+  }
+})();
+`
+
+// signEmbedToken returns a token for viewing projectID until expires.
+func signEmbedToken(secret, projectID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + embedSignature(secret, projectID, exp)
+}
+
+// verifyEmbedToken checks a token's signature and expiry for projectID.
+func verifyEmbedToken(secret, projectID, token string) bool {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(embedSignature(secret, projectID, exp)))
+}
+
+func embedSignature(secret, projectID, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(projectID + "." + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkEmbedToken rejects embedded view requests whose token is invalid or
+// expired. Requests without a token are unaffected.
+func (h *Handlers) checkEmbedToken(r *http.Request, projectID string) error {
+	token := r.URL.Query().Get(embedTokenParam)
+	if token == "" {
+		return nil
+	}
+	if h.cfg.EmbedSecret == "" || !verifyEmbedToken(h.cfg.EmbedSecret, projectID, token) {
+		return AppError{Code: http.StatusForbidden, Message: "Invalid or expired embed token"}
+	}
+	settings, err := h.projectSettings(r.Context(), projectID)
+	if err != nil {
+		return upstreamError("Failed to load settings", err)
+	}
+	if !settings.Embed.Enabled {
+		return AppError{Code: http.StatusForbidden, Message: "Embedding is disabled for this project"}
+	}
+	return nil
+}
+
+// jsString encodes s as a JavaScript string literal.
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// HandleEmbedScript returns a script that embeds the project's preview in a
+// host page as a sized, sandboxed iframe, with a signed view token baked in.
+// ?width= and ?height= set the iframe size (default 100% by 600px).
+// Embedding is disabled unless EMBED_SECRET is configured and the project's
+// embed setting is enabled.
+func (h *Handlers) HandleEmbedScript(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if h.cfg.EmbedSecret == "" {
+		writeError(w, ErrNotFound)
+		return
+	}
+	settings, err := h.projectSettings(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	if !settings.Embed.Enabled {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	width, height := r.URL.Query().Get("width"), r.URL.Query().Get("height")
+	if width == "" {
+		width = "100%"
+	}
+	if height == "" {
+		height = "600px"
+	}
+	for _, size := range []string{width, height} {
+		if !embedSizePattern.MatchString(size) {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid size %q", size)})
+			return
+		}
+	}
+	// Bare numbers are pixels
+	if _, err := strconv.Atoi(width); err == nil {
+		width += "px"
+	}
+	if _, err := strconv.Atoi(height); err == nil {
+		height += "px"
+	}
+
+	meta, err := h.storage.GetMetadata(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
+			return
+		}
+		writeError(w, upstreamError("Failed to get metadata", err))
+		return
+	}
+	title := meta.Title
+	if title == "" {
+		title = "App preview"
+	}
+
+	token := signEmbedToken(h.cfg.EmbedSecret, projectID, time.Now().Add(h.cfg.EmbedTokenTTL))
+	script := fmt.Sprintf(embedScript, embedTokenParam, jsString(token), jsString(title),
+		jsString(embedSandbox), jsString(width), jsString(height))
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	// Cached copies must not outlive the token they carry
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(min(h.cfg.EmbedTokenTTL/2, time.Hour).Seconds())))
+	_, _ = w.Write([]byte(script))
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Project event types.
+const (
+	EventFileWritten     = "file_written"
+	EventFileDeleted     = "file_deleted"
+	EventBuildCompleted  = "build_completed"
+	EventSettingsChanged = "settings_changed"
+)
+
+// Event log keys: events are stored under eventsPrefix by zero-padded
+// sequence number so they list in order, and written file contents under
+// eventBlobsPrefix by MD5, so the log can reproduce every file without
+// storing repeated content twice.
+const (
+	eventsPrefix     = "_events/log/"
+	eventBlobsPrefix = "_events/blobs/"
+)
+
+// maxEvents caps how many events GET /{uuid}/events returns.
+const maxEvents = 1000
+
+// ProjectEvent is one entry of a project's append-only mutation log. Fields
+// not used by an event's type are empty.
+type ProjectEvent struct {
+	Seq  int64     `json:"seq"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Source is what caused the mutation, when known (chat, create, hook...).
+	Source string `json:"source,omitempty"`
+
+	// Key, Hash (the content MD5, as in the metadata manifest), MimeType
+	// and Size describe a written or deleted source/ or compiled/ key.
+	Key      string `json:"key,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int    `json:"size,omitempty"`
+
+	// Channel and Files are the compiled set a build stored.
+	Channel string   `json:"channel,omitempty"`
+	Files   []string `json:"files,omitempty"`
+
+	// Settings are the project's setting overrides after a change.
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+// eventSeq is one project's log as this instance has read it: the
+// manifest of every event up to manifest.Seq, the last one.
+type eventSeq struct {
+	mu       sync.Mutex
+	loaded   bool
+	manifest *EventManifest
+}
+
+// EventLog appends project mutations to an append-only log in storage.
+// Events get consecutive sequence numbers per project, claimed with
+// If-None-Match so instances sharing storage never reuse one. An event is
+// appended after the write it describes reached storage, and the write
+// fails if it can't be recorded. The manifest each project's log produces
+// is kept up to date as events are appended, and is what the project's
+// file list is read from.
+type EventLog struct {
+	backend StorageBackend
+
+	mu   sync.Mutex
+	seqs map[string]*eventSeq
+}
+
+// NewEventLog creates an event log kept in backend, which must not itself
+// record events.
+func NewEventLog(backend StorageBackend) *EventLog {
+	return &EventLog{backend: backend, seqs: make(map[string]*eventSeq)}
+}
+
+type skipEventsKey struct{}
+
+// withoutEvents stops writes made with ctx from being recorded, for
+// deleting a project along with its log.
+func withoutEvents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipEventsKey{}, true)
+}
+
+// eventSource returns the build trigger of ctx, if any, as the source of
+// the events it records.
+func eventSource(ctx context.Context) string {
+	trigger, _ := ctx.Value(buildTriggerKey{}).(string)
+	return trigger
+}
+
+func (l *EventLog) seq(projectID string) *eventSeq {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq, ok := l.seqs[projectID]
+	if !ok {
+		seq = &eventSeq{}
+		l.seqs[projectID] = seq
+	}
+	return seq
+}
+
+// Append records events in order, stamping their sequence numbers and time.
+// It stops at the first event that can't be recorded and returns the error.
+// The first events of a project whose files predate its log are baseline
+// file_written events for those files, so the log accounts for all of them.
+func (l *EventLog) Append(ctx context.Context, projectID string, events ...ProjectEvent) error {
+	if l == nil || len(events) == 0 || ctx.Value(skipEventsKey{}) != nil {
+		return nil
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	seq := l.seq(projectID)
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	if !seq.loaded {
+		if err := l.catchUp(ctx, projectID, seq); err != nil {
+			return fmt.Errorf("reading the event log: %w", err)
+		}
+	}
+	if seq.manifest.Seq == 0 {
+		if err := l.appendBaseline(ctx, projectID, seq); err != nil {
+			return fmt.Errorf("recording existing files: %w", err)
+		}
+	}
+	for _, event := range events {
+		event.Time = time.Now().UTC()
+		if event.Source == "" {
+			event.Source = eventSource(ctx)
+		}
+		if err := l.appendOne(ctx, projectID, seq, event); err != nil {
+			return fmt.Errorf("recording %s event: %w", event.Type, err)
+		}
+	}
+	return nil
+}
+
+// appendOne claims the next sequence number for event, catching up with
+// the log when another instance got there first.
+func (l *EventLog) appendOne(ctx context.Context, projectID string, seq *eventSeq, event ProjectEvent) error {
+	for {
+		event.Seq = seq.manifest.Seq + 1
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		err = l.backend.StoreIf(ctx, projectID, eventKey(event.Seq), "application/json", data, "*")
+		if errors.Is(err, ErrPreconditionFailed) {
+			if err := l.catchUp(ctx, projectID, seq); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		seq.manifest.apply(event)
+		return nil
+	}
+}
+
+// appendBaseline records a file_written event for each source and compiled
+// file already in storage, with its content as the blob.
+func (l *EventLog) appendBaseline(ctx context.Context, projectID string, seq *eventSeq) error {
+	for _, prefix := range []string{"source/", "compiled/"} {
+		entries, err := l.backend.List(ctx, projectID, prefix)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			content, mimeType, err := l.backend.Get(ctx, projectID, entry.Key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			hash := contentETag(content)
+			if err := l.backend.Store(ctx, projectID, eventBlobsPrefix+hash, mimeType, content); err != nil {
+				return err
+			}
+			event := ProjectEvent{Type: EventFileWritten, Time: time.Now().UTC(), Source: "baseline", Key: entry.Key, Hash: hash, MimeType: mimeType, Size: len(content)}
+			if err := l.appendOne(ctx, projectID, seq, event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// catchUp brings seq's manifest up to the newest stored event: from the
+// whole log the first time, and afterwards by reading the events following
+// the last one applied, which other instances may have appended.
+func (l *EventLog) catchUp(ctx context.Context, projectID string, seq *eventSeq) error {
+	if !seq.loaded {
+		events, err := l.load(ctx, projectID)
+		if err != nil {
+			return err
+		}
+		seq.manifest = newEventManifest()
+		for _, event := range events {
+			seq.manifest.apply(event)
+		}
+		seq.loaded = true
+		return nil
+	}
+	for {
+		data, _, err := l.backend.Get(ctx, projectID, eventKey(seq.manifest.Seq+1))
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var event ProjectEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("invalid event %d: %w", seq.manifest.Seq+1, err)
+		}
+		seq.manifest.apply(event)
+	}
+}
+
+// load reads the project's whole log, oldest first.
+func (l *EventLog) load(ctx context.Context, projectID string) ([]ProjectEvent, error) {
+	entries, err := l.backend.List(ctx, projectID, eventsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	slices.Sort(keys)
+
+	events := make([]ProjectEvent, 0, len(keys))
+	for chunk := range slices.Chunk(keys, fetchChunkSize) {
+		values, err := l.backend.GetMany(ctx, projectID, chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range chunk {
+			value, ok := values[key]
+			if !ok {
+				continue
+			}
+			var event ProjectEvent
+			if err := json.Unmarshal(value.Content, &event); err != nil {
+				return nil, fmt.Errorf("invalid event %s: %w", key, err)
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Manifest returns the files and settings the project's log produces, up
+// to its newest event.
+func (l *EventLog) Manifest(ctx context.Context, projectID string) (*EventManifest, error) {
+	seq := l.seq(projectID)
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	if err := l.catchUp(ctx, projectID, seq); err != nil {
+		return nil, err
+	}
+	return seq.manifest.snapshot(), nil
+}
+
+// Forget drops the cached log of a deleted project.
+func (l *EventLog) Forget(projectID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.seqs, projectID)
+}
+
+// FileWritten stores content as a blob and records the write of key.
+func (l *EventLog) FileWritten(ctx context.Context, projectID, key, mimeType string, content []byte) error {
+	if l == nil || ctx.Value(skipEventsKey{}) != nil {
+		return nil
+	}
+	hash := contentETag(content)
+	if err := l.backend.Store(context.WithoutCancel(ctx), projectID, eventBlobsPrefix+hash, mimeType, content); err != nil {
+		return fmt.Errorf("recording content of %s: %w", key, err)
+	}
+	return l.Append(ctx, projectID, ProjectEvent{Type: EventFileWritten, Key: key, Hash: hash, MimeType: mimeType, Size: len(content)})
+}
+
+func eventKey(seq int64) string {
+	return fmt.Sprintf("%s%020d", eventsPrefix, seq)
+}
+
+// isAppKey reports whether key is a source or production compiled file,
+// the keys whose writes the event log records.
+func isAppKey(key string) bool {
+	return strings.HasPrefix(key, "source/") || strings.HasPrefix(key, "compiled/")
+}
+
+// eventBackend records writes and deletes of source and compiled files in
+// the event log as they reach storage, whichever Storage method made them.
+// A write that reached storage but couldn't be recorded returns the error,
+// so the caller retries it or reports it rather than leaving the log behind.
+type eventBackend struct {
+	StorageBackend
+	events *EventLog
+}
+
+// withEventLog wraps backend so app file writes are recorded in events.
+func withEventLog(backend StorageBackend, events *EventLog) StorageBackend {
+	return &eventBackend{StorageBackend: backend, events: events}
+}
+
+func (b *eventBackend) Store(ctx context.Context, project, key, mimeType string, content []byte) error {
+	if err := b.StorageBackend.Store(ctx, project, key, mimeType, content); err != nil {
+		return err
+	}
+	if !isAppKey(key) {
+		return nil
+	}
+	return b.events.FileWritten(ctx, project, key, mimeType, content)
+}
+
+func (b *eventBackend) StoreIf(ctx context.Context, project, key, mimeType string, content []byte, etag string) error {
+	if err := b.StorageBackend.StoreIf(ctx, project, key, mimeType, content, etag); err != nil {
+		return err
+	}
+	if !isAppKey(key) {
+		return nil
+	}
+	return b.events.FileWritten(ctx, project, key, mimeType, content)
+}
+
+func (b *eventBackend) Delete(ctx context.Context, project, key string) error {
+	if err := b.StorageBackend.Delete(ctx, project, key); err != nil {
+		return err
+	}
+	if !isAppKey(key) {
+		return nil
+	}
+	return b.events.Append(ctx, project, ProjectEvent{Type: EventFileDeleted, Key: key})
+}
+
+func (b *eventBackend) DeleteIf(ctx context.Context, project, key, etag string) error {
+	if err := b.StorageBackend.DeleteIf(ctx, project, key, etag); err != nil {
+		return err
+	}
+	if !isAppKey(key) {
+		return nil
+	}
+	return b.events.Append(ctx, project, ProjectEvent{Type: EventFileDeleted, Key: key})
+}
+
+// EventManifest is a project's state derived from its event log alone.
+type EventManifest struct {
+	// Seq is the last event applied.
+	Seq           int64             `json:"seq"`
+	SourceFiles   []string          `json:"source_files"`
+	CompiledFiles []string          `json:"compiled_files"`
+	Hashes        map[string]string `json:"hashes"`
+	// MimeTypes are the content types the files were written with.
+	MimeTypes map[string]string `json:"-"`
+	Settings  json.RawMessage   `json:"settings,omitempty"`
+	// LastBuild is the latest build_completed event.
+	LastBuild *ProjectEvent `json:"last_build,omitempty"`
+}
+
+func newEventManifest() *EventManifest {
+	return &EventManifest{Hashes: make(map[string]string), MimeTypes: make(map[string]string)}
+}
+
+// apply folds the next event into the manifest.
+func (m *EventManifest) apply(event ProjectEvent) {
+	m.Seq = event.Seq
+	switch event.Type {
+	case EventFileWritten:
+		m.Hashes[event.Key] = event.Hash
+		m.MimeTypes[event.Key] = event.MimeType
+	case EventFileDeleted:
+		delete(m.Hashes, event.Key)
+		delete(m.MimeTypes, event.Key)
+	case EventBuildCompleted:
+		m.LastBuild = &event
+	case EventSettingsChanged:
+		m.Settings = event.Settings
+	}
+}
+
+// snapshot copies the manifest, listing its source and compiled files.
+func (m *EventManifest) snapshot() *EventManifest {
+	c := *m
+	c.Hashes, c.MimeTypes = maps.Clone(m.Hashes), maps.Clone(m.MimeTypes)
+	c.SourceFiles, c.CompiledFiles = []string{}, []string{}
+	for _, key := range slices.Sorted(maps.Keys(c.Hashes)) {
+		if path, ok := strings.CutPrefix(key, "source/"); ok {
+			c.SourceFiles = append(c.SourceFiles, path)
+		} else {
+			c.CompiledFiles = append(c.CompiledFiles, strings.TrimPrefix(key, "compiled/"))
+		}
+	}
+	return &c
+}
+
+// ReplayReport is the outcome of replaying a project's event log over its
+// storage.
+type ReplayReport struct {
+	// Seq is the last event replayed.
+	Seq int64 `json:"seq"`
+	// Restored are the keys rewritten from the log, Removed those deleted
+	// because the log has deleted them and Unrecoverable those whose
+	// content the log no longer holds.
+	Restored      []string `json:"restored"`
+	Removed       []string `json:"removed"`
+	Unrecoverable []string `json:"unrecoverable"`
+	// SettingsRestored is set when the stored settings differed from the
+	// log's.
+	SettingsRestored bool `json:"settings_restored"`
+	DryRun           bool `json:"dry_run"`
+}
+
+// errEventLogDisabled is returned by the event routes without EVENT_LOG.
+var errEventLogDisabled = AppError{Code: http.StatusNotFound, Message: "The event log is not enabled"}
+
+// HandleListEvents returns the project's events after ?after= (a sequence
+// number, default 0), oldest first, up to ?limit=.
+func (h *Handlers) HandleListEvents(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if h.storage.events == nil {
+		writeError(w, errEventLogDisabled)
+		return
+	}
+
+	var after int64
+	if raw := r.URL.Query().Get("after"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "after must be a sequence number"})
+			return
+		}
+		after = n
+	}
+	limit := maxEvents
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxEvents {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", maxEvents)})
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.storage.ListEvents(r.Context(), projectID, after, limit)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load events", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// HandleGetEventManifest returns the project's files and settings as
+// derived from its event log.
+func (h *Handlers) HandleGetEventManifest(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if h.storage.events == nil {
+		writeError(w, errEventLogDisabled)
+		return
+	}
+
+	manifest, err := h.storage.EventManifest(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to replay events", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, manifest)
+}
+
+// HandleReplayEvents reconstructs the project's files, manifest and
+// settings from its event log, for recovering from corrupted storage. With
+// ?dry_run=true it only reports where storage has drifted from the log.
+func (h *Handlers) HandleReplayEvents(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if h.storage.events == nil {
+		writeError(w, errEventLogDisabled)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	if !dryRun {
+		release, ok := h.lockProject(w, r, projectID, "replay")
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	report, err := h.storage.ReplayEvents(r.Context(), projectID, dryRun)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "The project has no events"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to replay events", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
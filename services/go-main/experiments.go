@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// exposureFlushInterval is how often buffered exposure counts are persisted.
+const exposureFlushInterval = 30 * time.Second
+
+// Experiment splits view traffic between the production and staging channels.
+type Experiment struct {
+	// StagingPercent is the share of new viewers assigned to staging (0-100).
+	StagingPercent int            `json:"staging_percent"`
+	StartedAt      time.Time      `json:"started_at"`
+	Exposures      map[string]int `json:"exposures"`
+}
+
+// SaveExperimentRequest is the request body for starting or adjusting an experiment.
+type SaveExperimentRequest struct {
+	StagingPercent int `json:"staging_percent"`
+}
+
+// variantCookie returns the cookie that pins a viewer to a variant.
+func variantCookie(projectID string) string {
+	return "variant-" + projectID
+}
+
+// chooseVariant returns the viewer's sticky variant, assigning one if needed.
+func chooseVariant(w http.ResponseWriter, r *http.Request, projectID string, exp *Experiment) string {
+	name := variantCookie(projectID)
+	if cookie, err := r.Cookie(name); err == nil {
+		if cookie.Value == ChannelProduction || cookie.Value == ChannelStaging {
+			return cookie.Value
+		}
+	}
+
+	variant := ChannelProduction
+	if rand.IntN(100) < exp.StagingPercent { //nolint:gosec // traffic split, not security sensitive
+		variant = ChannelStaging
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    variant,
+		Path:     "/",
+		MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return variant
+}
+
+// HandleGetExperiment returns the project's experiment with exposure counts.
+func (h *Handlers) HandleGetExperiment(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	exp, err := h.storage.GetExperiment(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "No experiment for this project"})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	// Include counts that haven't been flushed yet
+	for variant, n := range h.exposures.Pending(projectID) {
+		exp.Exposures[variant] += n
+	}
+
+	writeJSON(w, http.StatusOK, exp)
+}
+
+// HandleSaveExperiment starts an experiment or changes its traffic split.
+func (h *Handlers) HandleSaveExperiment(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req SaveExperimentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if req.StagingPercent < 0 || req.StagingPercent > 100 {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "staging_percent must be between 0 and 100"})
+		return
+	}
+
+	if _, _, err := h.storage.GetChannelFile(r.Context(), projectID, ChannelStaging, "index.html"); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusConflict, Message: "Nothing staged to compare against"})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	exp, err := h.storage.GetExperiment(r.Context(), projectID)
+	if err != nil {
+		exp = &Experiment{StartedAt: time.Now().UTC(), Exposures: map[string]int{}}
+	}
+	exp.StagingPercent = req.StagingPercent
+
+	if err := h.storage.StoreExperiment(r.Context(), projectID, exp); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store experiment: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, exp)
+}
+
+// HandleDeleteExperiment ends the project's experiment.
+func (h *Handlers) HandleDeleteExperiment(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.storage.DeleteExperiment(r.Context(), projectID); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to delete experiment: %v", err)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExposureRecorder buffers experiment exposure counts in memory and
+// periodically adds them to the stored experiment.
+type ExposureRecorder struct {
+	storage *Storage
+	mu      sync.Mutex
+	pending map[string]map[string]int // project -> variant -> count
+}
+
+// NewExposureRecorder creates a new ExposureRecorder.
+func NewExposureRecorder(storage *Storage) *ExposureRecorder {
+	return &ExposureRecorder{
+		storage: storage,
+		pending: make(map[string]map[string]int),
+	}
+}
+
+// Record counts one exposure of a variant.
+func (e *ExposureRecorder) Record(projectID, variant string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending[projectID] == nil {
+		e.pending[projectID] = make(map[string]int)
+	}
+	e.pending[projectID][variant]++
+}
+
+// Pending returns the unflushed counts for a project.
+func (e *ExposureRecorder) Pending(projectID string) map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return maps.Clone(e.pending[projectID])
+}
+
+// Run flushes buffered counts until ctx is cancelled.
+func (e *ExposureRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(exposureFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Final flush so shutdown doesn't drop counts
+			e.flush(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			e.flush(ctx)
+		}
+	}
+}
+
+// flush persists and clears the buffered counts.
+func (e *ExposureRecorder) flush(ctx context.Context) {
+	e.mu.Lock()
+	pending := e.pending
+	e.pending = make(map[string]map[string]int)
+	e.mu.Unlock()
+
+	for projectID, counts := range pending {
+		exp, err := e.storage.GetExperiment(ctx, projectID)
+		if err != nil {
+			// Experiment was ended; drop its counts
+			continue
+		}
+		for variant, n := range counts {
+			exp.Exposures[variant] += n
+		}
+		if err := e.storage.StoreExperiment(ctx, projectID, exp); err != nil {
+			log.Printf("Error storing exposures for project %s: %v", projectID, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// exportDockerfile builds an exported project and serves the compiled
+// output with nginx.
+const exportDockerfile = `FROM node:22-alpine AS build
+WORKDIR /app
+COPY package.json ./
+RUN npm install
+COPY . .
+RUN npm run build
+
+FROM nginx:alpine
+COPY nginx.conf /etc/nginx/conf.d/default.conf
+COPY --from=build /app/dist /usr/share/nginx/html
+EXPOSE 80
+`
+
+// exportNginxConf serves the compiled app, caching hashed assets and
+// falling back to index.html for client-side routes.
+const exportNginxConf = `server {
+    listen 80;
+    root /usr/share/nginx/html;
+    index index.html;
+
+    gzip on;
+    gzip_types text/css application/javascript application/json image/svg+xml;
+
+    location /assets/ {
+        expires 1y;
+        add_header Cache-Control "public, immutable";
+        try_files $uri =404;
+    }
+
+    location / {
+        add_header Cache-Control "no-cache";
+        try_files $uri $uri/ /index.html;
+    }
+}
+`
+
+// exportName returns the folder name used for an exported project.
+func exportName(projectID, title string) string {
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		return "app-" + projectID[:8]
+	}
+	return slug
+}
+
+// exportReadme describes how to run an exported project.
+func exportReadme(title, description string, docker bool) string {
+	if title == "" {
+		title = "App"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	if description != "" {
+		fmt.Fprintf(&b, "%s\n\n", description)
+	}
+	b.WriteString("## Development\n\n")
+	b.WriteString("```bash\nnpm install\nnpm run dev\n```\n\n")
+	b.WriteString("`npm run build` writes a static build to `dist/`.\n")
+	if docker {
+		b.WriteString("\n## Docker\n\n")
+		b.WriteString("```bash\ndocker build -t app .\ndocker run -p 8080:80 app\n```\n\n")
+		b.WriteString("The image serves the compiled app with nginx on port 80.\n")
+	}
+	return b.String()
+}
+
+// HandleExportRepo downloads the project's source as a zip archive, along
+// with the package.json, Vite config and components the build service
+// normally supplies, so it builds locally with `npm install && npm run dev`.
+// With ?docker=true it also includes a Dockerfile and nginx config that
+// build and serve the compiled output.
+func (h *Handlers) HandleExportRepo(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	meta, err := h.storage.GetMetadata(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
+			return
+		}
+		writeError(w, upstreamError("Failed to get metadata", err))
+		return
+	}
+
+	source, err := h.storage.GetSourceFiles(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to get source files", err))
+		return
+	}
+
+	name := exportName(projectID, meta.Title)
+	files, err := h.nodeBuildClient.Scaffold(r.Context(), name)
+	if err != nil {
+		writeError(w, upstreamError("Failed to generate project files", err))
+		return
+	}
+	docker := r.URL.Query().Get("docker") == "true"
+	files["README.md"] = exportReadme(meta.Title, meta.Description, docker)
+	if docker {
+		files["Dockerfile"] = exportDockerfile
+		files["nginx.conf"] = exportNginxConf
+		files[".dockerignore"] = "node_modules\ndist\n"
+	}
+	maps.Copy(files, source)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	zw := zip.NewWriter(w)
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		f, err := zw.Create(name + "/" + path)
+		if err == nil {
+			_, err = f.Write([]byte(files[path]))
+		}
+		if err != nil {
+			// Headers are already sent, so the client gets a truncated archive
+			log.Printf("Failed to write export for project %s: %v", projectID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Failed to finish export for project %s: %v", projectID, err)
+	}
+}
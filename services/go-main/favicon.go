@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	titlePattern        = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	iconLinkPattern     = regexp.MustCompile(`(?i)<link[^>]+rel=["'][^"']*\bicon\b`)
+	manifestLinkPattern = regexp.MustCompile(`(?i)<link[^>]+rel=["']manifest["']`)
+)
+
+// defaultAppTitle is used when the generated page has no <title>.
+const defaultAppTitle = "App"
+
+// WebManifest is a minimal web app manifest.
+type WebManifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	StartURL        string         `json:"start_url"`
+	Display         string         `json:"display"`
+	BackgroundColor string         `json:"background_color"`
+	ThemeColor      string         `json:"theme_color"`
+	Icons           []ManifestIcon `json:"icons"`
+}
+
+// ManifestIcon is an icon entry in a web app manifest.
+type ManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// pageTitle extracts the <title> text from a page.
+func pageTitle(page string) string {
+	if m := titlePattern.FindStringSubmatch(page); m != nil {
+		if title := strings.TrimSpace(html.UnescapeString(m[1])); title != "" {
+			return title
+		}
+	}
+	return defaultAppTitle
+}
+
+// projectColor derives a stable brand colour from the project ID.
+func projectColor(projectID string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(projectID))
+	return fmt.Sprintf("hsl(%d, 55%%, 45%%)", h.Sum32()%360)
+}
+
+// generateFavicon draws an SVG icon showing the title's first letter.
+func generateFavicon(projectID, title string) string {
+	letter, _ := utf8.DecodeRuneInString(title)
+	if !unicode.IsLetter(letter) && !unicode.IsDigit(letter) {
+		letter = 'A'
+	}
+	return `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 64 64">` +
+		`<rect width="64" height="64" rx="14" fill="` + projectColor(projectID) + `"/>` +
+		`<text x="32" y="44" font-family="sans-serif" font-size="36" font-weight="bold" ` +
+		`fill="#fff" text-anchor="middle">` + html.EscapeString(string(unicode.ToUpper(letter))) + `</text></svg>`
+}
+
+// newWebManifest builds a default manifest for the project's app.
+func newWebManifest(projectID, title string) WebManifest {
+	shortName := title
+	if utf8.RuneCountInString(shortName) > 12 {
+		shortName = string([]rune(shortName)[:12])
+	}
+	return WebManifest{
+		Name:            title,
+		ShortName:       shortName,
+		StartURL:        "./view",
+		Display:         "standalone",
+		BackgroundColor: "#ffffff",
+		ThemeColor:      projectColor(projectID),
+		Icons: []ManifestIcon{
+			{Src: "./favicon.svg", Sizes: "any", Type: "image/svg+xml"},
+		},
+	}
+}
+
+// injectIconLinks adds favicon and manifest links for any the page lacks.
+// Links are relative to the view URL, so they resolve next to /assets/.
+func injectIconLinks(page string) string {
+	var links string
+	if !iconLinkPattern.MatchString(page) {
+		links += `<link rel="icon" type="image/svg+xml" href="./favicon.svg">`
+	}
+	if !manifestLinkPattern.MatchString(page) {
+		links += `<link rel="manifest" href="./manifest.webmanifest">`
+	}
+	if links == "" {
+		return page
+	}
+	if i := strings.Index(page, "</head>"); i >= 0 {
+		return page[:i] + links + page[i:]
+	}
+	return links + page
+}
+
+// appTitle returns the title of the project's production page.
+func (h *Handlers) appTitle(r *http.Request, projectID string) (string, error) {
+	content, _, err := h.storage.GetChannelFile(r.Context(), projectID, ChannelProduction, "index.html")
+	if err != nil {
+		return "", err
+	}
+	return pageTitle(string(content)), nil
+}
+
+// HandleFavicon serves the app's own favicon.svg, or a generated one.
+func (h *Handlers) HandleFavicon(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	content, mimeType, err := h.storage.GetChannelFile(r.Context(), projectID, ChannelProduction, "favicon.svg")
+	if errors.Is(err, ErrNotFound) {
+		title, titleErr := h.appTitle(r, projectID)
+		if titleErr != nil && !errors.Is(titleErr, ErrNotFound) {
+			writeError(w, titleErr)
+			return
+		}
+		content, mimeType, err = []byte(generateFavicon(projectID, title)), "image/svg+xml", nil
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Type", mimeType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
+
+// HandleManifest serves the app's own manifest.webmanifest, or a generated one.
+func (h *Handlers) HandleManifest(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	content, mimeType, err := h.storage.GetChannelFile(r.Context(), projectID, ChannelProduction, "manifest.webmanifest")
+	if err == nil {
+		w.Header().Set("Content-Type", mimeType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content)
+		return
+	}
+	if !errors.Is(err, ErrNotFound) {
+		writeError(w, err)
+		return
+	}
+
+	title, err := h.appTitle(r, projectID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	manifest, err := json.Marshal(newWebManifest(projectID, title))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(manifest)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// fileActionPattern splits the wildcard of a /files/* route into the file
+// path and the action on it.
+var fileActionPattern = regexp.MustCompile(`^(.+)/(comments|history|revert/(\d+))$`)
+
+// HandleFileGet routes GET /files/{path}/comments and /files/{path}/history.
+func (h *Handlers) HandleFileGet(w http.ResponseWriter, r *http.Request) {
+	m := fileActionPattern.FindStringSubmatch(chi.URLParam(r, "*"))
+	switch {
+	case m != nil && m[2] == "comments":
+		h.HandleFileComments(w, r)
+	case m != nil && m[2] == "history":
+		h.HandleFileHistory(w, r)
+	default:
+		writeError(w, ErrNotFound)
+	}
+}
+
+// HandleFilePost routes POST /files/{path}/comments and
+// /files/{path}/revert/{n}.
+func (h *Handlers) HandleFilePost(w http.ResponseWriter, r *http.Request) {
+	m := fileActionPattern.FindStringSubmatch(chi.URLParam(r, "*"))
+	switch {
+	case m != nil && m[2] == "comments":
+		h.HandleFileComments(w, r)
+	case m != nil && m[3] != "":
+		h.HandleRevertFile(w, r)
+	default:
+		writeError(w, ErrNotFound)
+	}
+}
+
+// FileHistoryResponse is the response for a file's history.
+type FileHistoryResponse struct {
+	Path      string         `json:"path"`
+	Revisions []FileRevision `json:"revisions"`
+}
+
+// HandleFileHistory returns the stored previous contents of a source file,
+// newest first.
+func (h *Handlers) HandleFileHistory(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	path := fileActionPattern.FindStringSubmatch(chi.URLParam(r, "*"))[1]
+	if validatePath(path) != "" {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	revisions, err := h.storage.ListFileHistory(r.Context(), projectID, path)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load file history", err))
+		return
+	}
+	slices.Reverse(revisions)
+	writeJSON(w, http.StatusOK, FileHistoryResponse{Path: path, Revisions: revisions})
+}
+
+// RevertFileResponse is the response for reverting a file.
+type RevertFileResponse struct {
+	Path         string `json:"path"`
+	RestoredFrom int    `json:"restored_from"`
+	// Revision is the change event revision of the revert.
+	Revision   int64  `json:"revision"`
+	BuildError string `json:"build_error,omitempty"`
+}
+
+// HandleRevertFile restores one source file to a previous content, leaving
+// the rest of the app as it is, and rebuilds. The content it replaces goes
+// into the file's history, so the revert can itself be undone.
+func (h *Handlers) HandleRevertFile(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	m := fileActionPattern.FindStringSubmatch(chi.URLParam(r, "*"))
+	path := m[1]
+	if validatePath(path) != "" {
+		writeError(w, ErrNotFound)
+		return
+	}
+	n, err := strconv.Atoi(m[3])
+	if err != nil || n < 1 {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid revision"})
+		return
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "revert")
+	if !ok {
+		return
+	}
+	defer release()
+
+	content, err := h.storage.GetFileRevision(r.Context(), projectID, path, n)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: fmt.Sprintf("Revision %d of %s not found", n, path)})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to get file revision", err))
+		return
+	}
+
+	// The file may have been deleted since, so the revert is checked like
+	// any other write against the project's limits
+	files, err := h.storage.GetSourceFiles(r.Context(), projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to get existing files", err))
+		return
+	}
+	next := maps.Clone(files)
+	if next == nil {
+		next = make(map[string]string)
+	}
+	next[path] = content
+	if err := h.validateSourceFiles(next); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.storage.StoreSourceFile(r.Context(), projectID, path, content); err != nil {
+		writeError(w, upstreamError("Failed to store file", err))
+		return
+	}
+	resp := RevertFileResponse{Path: path, RestoredFrom: n}
+	resp.Revision = h.changes.PublishFiles(projectID, "revert", map[string]string{path: content}, nil)
+
+	if err := h.rebuild(withBuildTrigger(r.Context(), "revert"), projectID); err != nil {
+		resp.BuildError = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fontsDir is where self-hosted fonts and their stylesheets are stored
+// among the compiled files.
+const fontsDir = "assets/fonts/"
+
+// maxSelfHostedFonts caps the font files downloaded for one build.
+const maxSelfHostedFonts = 64
+
+var (
+	// googleFontsPattern matches Google Fonts stylesheet URLs in HTML
+	// attributes and CSS imports.
+	googleFontsPattern = regexp.MustCompile(`https://fonts\.googleapis\.com/css2?\?[^"'()\s]+`)
+	// fontPreconnectPattern matches preconnect and dns-prefetch hints for
+	// the Google Fonts hosts, which self-hosting makes pointless.
+	fontPreconnectPattern = regexp.MustCompile(`<link[^>]+href=["']https://fonts\.(?:googleapis|gstatic)\.com/?["'][^>]*>\s*`)
+	fontFacePattern       = regexp.MustCompile(`@font-face\s*\{[^}]*\}`)
+	unicodeRangePattern   = regexp.MustCompile(`unicode-range:\s*([^;}]+)`)
+	fontSrcPattern        = regexp.MustCompile(`url\(\s*["']?(https://fonts\.gstatic\.com/[^"')\s]+)["']?\s*\)`)
+)
+
+// selfHostFonts downloads the Google Fonts an app links to into its
+// compiled files when the project has self_host_fonts enabled, so viewers
+// never contact Google. Only the font-face subsets covering characters in
+// the app are kept. Stylesheets that can't be fetched stay remote.
+func (h *Handlers) selfHostFonts(ctx context.Context, projectID string, compiledFiles map[string]string) (map[string]string, error) {
+	settings, err := h.projectSettings(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	if !settings.SelfHostFonts {
+		return compiledFiles, nil
+	}
+
+	var stylesheets []string
+	for name, content := range compiledFiles {
+		if fontReferrer(name) {
+			stylesheets = append(stylesheets, googleFontsPattern.FindAllString(content, -1)...)
+		}
+	}
+	if len(stylesheets) == 0 {
+		return compiledFiles, nil
+	}
+
+	out := maps.Clone(compiledFiles)
+	used := usedRunes(compiledFiles)
+	downloaded := 0
+	local := make(map[string]string)
+	for _, ref := range stylesheets {
+		if _, ok := local[ref]; ok {
+			continue
+		}
+		files, err := h.selfHostStylesheet(ctx, html.UnescapeString(ref), used, maxSelfHostedFonts-downloaded)
+		if err != nil {
+			log.Printf("Error self-hosting fonts %s for project %s: %v", ref, projectID, err)
+			continue
+		}
+		for name, content := range files {
+			if strings.HasSuffix(name, ".css") {
+				local[ref] = name
+			} else {
+				downloaded++
+			}
+			out[name] = content
+		}
+	}
+	if len(local) == 0 {
+		return compiledFiles, nil
+	}
+
+	for name, content := range out {
+		if !fontReferrer(name) {
+			continue
+		}
+		content = googleFontsPattern.ReplaceAllStringFunc(content, func(ref string) string {
+			stylesheet, ok := local[ref]
+			if !ok {
+				return ref
+			}
+			if name == "index.html" {
+				return "/" + stylesheet
+			}
+			return strings.TrimPrefix(stylesheet, path.Dir(name)+"/")
+		})
+		if name == "index.html" && !googleFontsPattern.MatchString(content) {
+			content = fontPreconnectPattern.ReplaceAllString(content, "")
+		}
+		out[name] = content
+	}
+	return out, nil
+}
+
+// fontReferrer reports whether a compiled file may link Google Fonts
+// stylesheets that self-hosting rewrites.
+func fontReferrer(name string) bool {
+	return name == "index.html" || (strings.HasSuffix(name, ".css") && !strings.HasPrefix(name, fontsDir))
+}
+
+// selfHostStylesheet downloads a Google Fonts stylesheet and up to limit
+// fonts of its font-faces covering used, returning them as compiled files:
+// the fonts and a stylesheet pointing at them.
+func (h *Handlers) selfHostStylesheet(ctx context.Context, target string, used []rune, limit int) (map[string]string, error) {
+	content, mimeType, err := h.fetchCached(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mimeType, "text/css") {
+		return nil, fmt.Errorf("content type %q is not a stylesheet", mimeType)
+	}
+
+	faces := fontFacePattern.FindAllString(string(content), -1)
+	var kept []string
+	for _, face := range faces {
+		if m := unicodeRangePattern.FindStringSubmatch(face); m == nil || rangesCover(m[1], used) {
+			kept = append(kept, face)
+		}
+	}
+	// Keep everything rather than nothing if the ranges can't be matched
+	if len(kept) == 0 {
+		kept = faces
+	}
+
+	files := make(map[string]string)
+	fonts := make(map[string]string)
+	for i, face := range kept {
+		for _, m := range fontSrcPattern.FindAllStringSubmatch(face, -1) {
+			fontURL := m[1]
+			if _, ok := fonts[fontURL]; !ok {
+				u, err := url.Parse(fontURL)
+				if err != nil {
+					return nil, err
+				}
+				name := fontsDir + shortHash(fontURL) + path.Ext(u.Path)
+				if !isBinaryPath(name) {
+					return nil, fmt.Errorf("unsupported font format %q", path.Ext(u.Path))
+				}
+				if len(fonts) >= limit {
+					return nil, fmt.Errorf("more than %d font files", maxSelfHostedFonts)
+				}
+				data, _, err := h.fetchCached(ctx, fontURL)
+				if err != nil {
+					return nil, fmt.Errorf("failed to fetch %s: %w", fontURL, err)
+				}
+				files[name] = base64.StdEncoding.EncodeToString(data)
+				fonts[fontURL] = name
+			}
+			face = strings.ReplaceAll(face, fontURL, path.Base(fonts[fontURL]))
+		}
+		kept[i] = face
+	}
+
+	files[fontsDir+shortHash(target)+".css"] = strings.Join(kept, "\n") + "\n"
+	return files, nil
+}
+
+// shortHash names a downloaded file after its URL.
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// usedRunes returns the distinct characters in the app's compiled text
+// files, which the self-hosted fonts need to cover.
+func usedRunes(compiledFiles map[string]string) []rune {
+	seen := make(map[rune]bool)
+	var runes []rune
+	for name, content := range compiledFiles {
+		if isBinaryPath(name) || strings.HasSuffix(name, ".map") {
+			continue
+		}
+		for _, r := range content {
+			if !seen[r] {
+				seen[r] = true
+				runes = append(runes, r)
+			}
+		}
+	}
+	return runes
+}
+
+// rangesCover reports whether a CSS unicode-range list (U+0000-00FF,
+// U+0131, U+4??) includes any of runes.
+func rangesCover(spec string, runes []rune) bool {
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		hexRange, ok := strings.CutPrefix(strings.ToUpper(part), "U+")
+		if !ok {
+			continue
+		}
+		lo, hi, found := strings.Cut(hexRange, "-")
+		if !found {
+			lo, hi = strings.ReplaceAll(hexRange, "?", "0"), strings.ReplaceAll(hexRange, "?", "F")
+		}
+		start, err1 := strconv.ParseUint(lo, 16, 32)
+		end, err2 := strconv.ParseUint(hi, 16, 32)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		for _, r := range runes {
+			if uint64(r) >= start && uint64(r) <= end {
+				return true
+			}
+		}
+	}
+	return false
+}
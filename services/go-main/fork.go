@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ForkRequest is the optional request body for forking a project.
+type ForkRequest struct {
+	// Conversation also copies the chat history.
+	Conversation bool `json:"conversation"`
+}
+
+// ForkResponse is the response for forking a project.
+type ForkResponse struct {
+	ProjectID  string `json:"project_id"`
+	ForkedFrom string `json:"forked_from"`
+	URL        string `json:"url"`
+	ViewURL    string `json:"view_url"`
+	StateURL   string `json:"state_url"`
+}
+
+// HandleFork copies the project's app into a new project, so it can be
+// remixed without regenerating it. The fork starts with the same source,
+// compiled files and settings, and optionally the conversation, but none
+// of the original's history, integrations or secrets.
+func (h *Handlers) HandleFork(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req ForkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	// Held so the copy doesn't interleave with a generation's writes
+	release, ok := h.lockProject(w, r, projectID, "fork")
+	if !ok {
+		return
+	}
+	defer release()
+
+	forkID := uuid.NewString()
+	meta, err := h.storage.ForkProject(r.Context(), projectID, forkID, req.Conversation)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to fork project", err))
+		return
+	}
+	h.snapshotVersion(r.Context(), forkID, "fork", meta.Summary)
+
+	writeJSON(w, http.StatusCreated, ForkResponse{
+		ProjectID:  forkID,
+		ForkedFrom: projectID,
+		URL:        "/" + forkID,
+		ViewURL:    "/" + forkID + "/view",
+		StateURL:   "/api/" + APIVersion + "/" + forkID + "/state",
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// formsPath is the path under /view that generated apps post forms to.
+const formsPath = "_forms"
+
+// maxFormSubmissionBytes caps the body of a form submission.
+const maxFormSubmissionBytes = 64 << 10
+
+// maxFormFields caps the fields of a form submission.
+const maxFormFields = 50
+
+// formNamePattern matches a form name.
+var formNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// formName reads and checks the form name from the URL, writing the error
+// response and returning false if it is invalid.
+func formName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "name")
+	if !formNamePattern.MatchString(name) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid form name"})
+		return "", false
+	}
+	return name, true
+}
+
+// parseFormFields reads a submission's fields from a JSON object or an
+// HTML form body. Repeated form fields become lists; file uploads are
+// ignored.
+func parseFormFields(r *http.Request, mediaType string) (map[string]any, error) {
+	fields := make(map[string]any)
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			return nil, err
+		}
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseMultipartForm(maxFormSubmissionBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return nil, err
+		}
+		for name, values := range r.PostForm {
+			if len(values) == 1 {
+				fields[name] = values[0]
+			} else {
+				fields[name] = values
+			}
+		}
+	default:
+		return nil, AppError{Code: http.StatusUnsupportedMediaType, Message: "Submit forms as JSON or form data"}
+	}
+	return fields, nil
+}
+
+// sameOriginReferer returns the request's Referer if it is on the
+// requested host, for sending plain HTML form posts back to their page.
+func sameOriginReferer(r *http.Request) string {
+	ref, err := url.Parse(r.Referer())
+	if err != nil || ref.Host != r.Host || (ref.Scheme != "http" && ref.Scheme != "https") {
+		return ""
+	}
+	return ref.String()
+}
+
+// FormSubmittedResponse is the response for a form submission.
+type FormSubmittedResponse struct {
+	ID string `json:"id"`
+}
+
+// HandleSubmitForm stores a submission to one of the served app's forms,
+// so generated contact and signup forms have somewhere to send data. It is
+// public along with the view, limited per client by FORM_RATE_LIMIT and
+// per form by MAX_FORM_SUBMISSIONS. Plain HTML form posts are redirected
+// back to their page; everything else gets the submission's ID.
+func (h *Handlers) HandleSubmitForm(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	name, ok := formName(w, r)
+	if !ok {
+		return
+	}
+
+	client := projectID + "/" + requestIdentity(r.Context()).IP
+	if allowed, retryAfter := h.formLimiter.Allow(client, h.cfg.FormRateLimit); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(retryAfter.Seconds(), 1))))
+		writeError(w, AppError{Code: http.StatusTooManyRequests, Message: "Too many form submissions, try again later"})
+		return
+	}
+
+	if !h.storage.HasApp(r.Context(), projectID) {
+		writeError(w, ErrNoApp)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSubmissionBytes)
+	fields, err := parseFormFields(r, mediaType)
+	var maxBytesErr *http.MaxBytesError
+	var appErr AppError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeError(w, AppError{Code: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Submission exceeds %d bytes", maxFormSubmissionBytes)})
+		return
+	case errors.As(err, &appErr):
+		writeError(w, err)
+		return
+	case err != nil:
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid form submission"})
+		return
+	}
+	if len(fields) == 0 || len(fields) > maxFormFields {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("A submission has between 1 and %d fields", maxFormFields)})
+		return
+	}
+
+	if h.cfg.MaxFormSubmissions > 0 {
+		count, err := h.storage.CountFormSubmissions(r.Context(), projectID, name)
+		if err != nil {
+			writeError(w, upstreamError("Failed to count submissions", err))
+			return
+		}
+		if count >= h.cfg.MaxFormSubmissions {
+			writeError(w, AppError{Code: http.StatusInsufficientStorage, Message: "This form is not accepting submissions"})
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	sub := &FormSubmission{
+		ID:          fmt.Sprintf("%016x-%s", now.UnixNano(), uuid.NewString()[:8]),
+		Form:        name,
+		Fields:      fields,
+		SubmittedAt: now,
+	}
+	if err := h.storage.StoreFormSubmission(r.Context(), projectID, sub); err != nil {
+		writeError(w, upstreamError("Failed to store submission", err))
+		return
+	}
+
+	if ref := sameOriginReferer(r); ref != "" && mediaType != "application/json" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, ref, http.StatusSeeOther)
+		return
+	}
+	writeJSON(w, http.StatusCreated, FormSubmittedResponse{ID: sub.ID})
+}
+
+// FormSubmissionsResponse is the response for listing form submissions.
+type FormSubmissionsResponse struct {
+	Form        string           `json:"form"`
+	Submissions []FormSubmission `json:"submissions"`
+}
+
+// HandleListFormSubmissions returns a form's submissions, newest first,
+// up to ?limit= (default 100).
+func (h *Handlers) HandleListFormSubmissions(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	name, ok := formName(w, r)
+	if !ok {
+		return
+	}
+	limit := 100
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 1000 {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+
+	subs, err := h.storage.ListFormSubmissions(r.Context(), projectID, name, limit)
+	if err != nil {
+		writeError(w, upstreamError("Failed to list submissions", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, FormSubmissionsResponse{Form: name, Submissions: subs})
+}
+
+// HandleDeleteFormSubmission removes a form submission.
+func (h *Handlers) HandleDeleteFormSubmission(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	name, ok := formName(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "submissionID")
+	if !formNamePattern.MatchString(id) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid submission ID"})
+		return
+	}
+
+	if err := h.storage.DeleteFormSubmission(r.Context(), projectID, name, id); err != nil {
+		writeError(w, upstreamError("Failed to delete submission", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Consistency problems reported by fsck.
+const (
+	FsckMissing      = "missing"
+	FsckOrphan       = "orphan"
+	FsckHashMismatch = "hash_mismatch"
+	// FsckEventMismatch is a key whose stored content differs from what the
+	// event log says was last written to it, or that only one of them has.
+	FsckEventMismatch = "event_mismatch"
+)
+
+// FsckFinding is one disagreement between the metadata manifest and storage.
+type FsckFinding struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+}
+
+// FsckReport is the response for the fsck endpoint.
+type FsckReport struct {
+	Findings     []FsckFinding  `json:"findings"`
+	Inconsistent *Inconsistency `json:"inconsistent,omitempty"`
+	// EventFindings are disagreements between storage and the event log.
+	EventFindings []FsckFinding `json:"event_findings,omitempty"`
+	// Set when repair was requested: Relisted means the manifest was rebuilt
+	// from storage, Rebuilt that compiled output was rebuilt from source.
+	Relisted     bool   `json:"relisted,omitempty"`
+	Rebuilt      bool   `json:"rebuilt,omitempty"`
+	RebuildError string `json:"rebuild_error,omitempty"`
+}
+
+// checkManifest compares the keys listed in meta against what storage holds.
+func checkManifest(meta *AppMetadata, stored []KeyInfo) []FsckFinding {
+	expected := make(map[string]bool, len(meta.SourceFiles)+len(meta.CompiledFiles))
+	for _, path := range meta.SourceFiles {
+		expected["source/"+path] = true
+	}
+	for _, path := range meta.CompiledFiles {
+		expected["compiled/"+path] = true
+	}
+
+	findings := []FsckFinding{}
+	present := make(map[string]bool, len(stored))
+	for _, entry := range stored {
+		present[entry.Key] = true
+		if !expected[entry.Key] {
+			findings = append(findings, FsckFinding{Key: entry.Key, Problem: FsckOrphan})
+			continue
+		}
+		if hash, ok := meta.Hashes[entry.Key]; ok && hash != entry.ETag {
+			findings = append(findings, FsckFinding{Key: entry.Key, Problem: FsckHashMismatch})
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(expected)) {
+		if !present[key] {
+			findings = append(findings, FsckFinding{Key: key, Problem: FsckMissing})
+		}
+	}
+	return findings
+}
+
+// checkEventManifest compares the files derived from the event log against
+// what storage holds.
+func checkEventManifest(manifest *EventManifest, stored []KeyInfo) []FsckFinding {
+	findings := []FsckFinding{}
+	present := make(map[string]bool, len(stored))
+	for _, entry := range stored {
+		present[entry.Key] = true
+		if manifest.Hashes[entry.Key] != entry.ETag {
+			findings = append(findings, FsckFinding{Key: entry.Key, Problem: FsckEventMismatch})
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(manifest.Hashes)) {
+		if !present[key] {
+			findings = append(findings, FsckFinding{Key: key, Problem: FsckEventMismatch})
+		}
+	}
+	return findings
+}
+
+// HandleFsck cross-checks the project's metadata manifest against the keys
+// in storage. With ?repair=true, the manifest is re-listed from storage and,
+// if any compiled output was affected, the project is rebuilt from source.
+// With ?events=true and the event log enabled, storage is also checked
+// against the files the log derives; repair doesn't act on those findings.
+func (h *Handlers) HandleFsck(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	meta, err := h.storage.GetMetadata(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
+			return
+		}
+		writeError(w, upstreamError("Failed to get metadata", err))
+		return
+	}
+
+	stored, err := h.storage.ListAppKeys(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to list files", err))
+		return
+	}
+
+	report := FsckReport{
+		Findings:     checkManifest(meta, stored),
+		Inconsistent: meta.Inconsistent,
+	}
+	if r.URL.Query().Get("events") == "true" && h.storage.events != nil {
+		manifest, err := h.storage.EventManifest(r.Context(), projectID)
+		if err != nil {
+			writeError(w, upstreamError("Failed to replay events", err))
+			return
+		}
+		report.EventFindings = checkEventManifest(manifest, stored)
+	}
+	if r.URL.Query().Get("repair") != "true" || (len(report.Findings) == 0 && meta.Inconsistent == nil) {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "fsck")
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := h.storage.RelistManifest(r.Context(), projectID); err != nil {
+		writeError(w, upstreamError("Failed to repair manifest", err))
+		return
+	}
+	report.Relisted = true
+
+	compiledAffected := meta.Inconsistent != nil
+	for _, finding := range report.Findings {
+		if strings.HasPrefix(finding.Key, "compiled/") {
+			compiledAffected = true
+		}
+	}
+	if compiledAffected {
+		if err := h.rebuild(withBuildTrigger(r.Context(), "fsck"), projectID); err != nil {
+			report.RebuildError = err.Error()
+		} else {
+			report.Rebuilt = true
+		}
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GitLink connects a project to a Git repository so pushes sync into source/.
+type GitLink struct {
+	RepoURL string `json:"repo_url"`
+	Branch  string `json:"branch"`
+	// Dir is the repository subdirectory that maps onto the project root.
+	Dir string `json:"dir,omitempty"`
+	// RawURLTemplate builds file download URLs, with {sha} and {path} placeholders,
+	// e.g. https://raw.githubusercontent.com/owner/repo/{sha}/{path}.
+	RawURLTemplate string `json:"raw_url_template"`
+	// SealedSecret is the webhook secret encrypted with SECRETS_KEY; it is
+	// never returned.
+	SealedSecret []byte `json:"sealed_secret,omitempty"`
+	// Commit is the last synced commit, which the next push must start
+	// from, so an earlier push sent again can't revert files.
+	Commit   string    `json:"commit,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// LinkGitRequest is the request body for linking a Git repository.
+type LinkGitRequest struct {
+	RepoURL        string `json:"repo_url"`
+	Branch         string `json:"branch"`
+	Dir            string `json:"dir"`
+	RawURLTemplate string `json:"raw_url_template"`
+	Secret         string `json:"secret"`
+}
+
+// GitPushEvent is the subset of a forge push webhook payload we use.
+// GitHub, Gitea and Forgejo all send this shape.
+type GitPushEvent struct {
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Commits []struct {
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+}
+
+// GitSyncResult is the outcome of a synced push.
+type GitSyncResult struct {
+	Commit  string
+	Updated []string
+	Removed []string
+}
+
+// HandleLinkGit links a Git repository to the project. The webhook secret
+// is stored encrypted, so linking needs SECRETS_KEY. Linking again starts
+// over from whatever commit is pushed next.
+func (h *Handlers) HandleLinkGit(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req LinkGitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	if req.RepoURL == "" || req.RawURLTemplate == "" || req.Secret == "" {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "repo_url, raw_url_template and secret are required"})
+		return
+	}
+	if !strings.Contains(req.RawURLTemplate, "{path}") {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "raw_url_template must contain {path}"})
+		return
+	}
+	if !h.gitTemplateAllowed(req.RawURLTemplate) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "raw_url_template must be an https URL on an allowed git host"})
+		return
+	}
+	sealed, err := h.secrets.Seal([]byte(req.Secret))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	link := GitLink{
+		RepoURL:        req.RepoURL,
+		Branch:         req.Branch,
+		Dir:            strings.Trim(req.Dir, "/"),
+		RawURLTemplate: req.RawURLTemplate,
+		SealedSecret:   sealed,
+		LinkedAt:       time.Now().UTC(),
+	}
+	if link.Branch == "" {
+		link.Branch = "main"
+	}
+
+	if err := h.storage.StoreGitLink(r.Context(), projectID, &link); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store git link: %v", err)})
+		return
+	}
+
+	link.SealedSecret = nil
+	writeJSON(w, http.StatusOK, link)
+}
+
+// HandleUnlinkGit removes the project's Git link.
+func (h *Handlers) HandleUnlinkGit(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.storage.DeleteGitLink(r.Context(), projectID); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to delete git link: %v", err)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGitPush receives a forge push webhook and, once its signature is
+// verified, syncs it in the background under the project's lock, like a
+// hook trigger: fetching, building and snapshotting can outlast both the
+// route timeout and the forge's wait for a response. A push must start
+// from the last synced commit, so one sent again later is refused rather
+// than reverting the files it changed.
+func (h *Handlers) HandleGitPush(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	link, err := h.storage.GetGitLink(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "No git repository linked"})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Failed to read request body"})
+		return
+	}
+
+	secret, err := h.secrets.Open(link.SealedSecret)
+	if err != nil || !verifySignature(string(secret), body, r.Header.Get(signatureHeader)) {
+		writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Invalid signature"})
+		return
+	}
+
+	// Forges send a ping on webhook creation; acknowledge anything that isn't a push
+	if event := r.Header.Get("X-GitHub-Event"); event != "" && event != "push" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var push GitPushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	if push.Ref != "refs/heads/"+link.Branch {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := checkGitPush(link, &push); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The lock is taken here, so a busy project gets 409 and the forge can
+	// redeliver, and handed to the sync
+	release, ok := h.lockProject(w, r, projectID, "git")
+	if !ok {
+		return
+	}
+	go h.runGitPush(projectID, &push, release)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// checkGitPush refuses a push that doesn't start from the link's last
+// synced commit.
+func checkGitPush(link *GitLink, push *GitPushEvent) error {
+	if link.Commit != "" && push.Before != link.Commit {
+		return AppError{Code: http.StatusConflict, Message: fmt.Sprintf("Push starts from %s, not the last synced commit %s", push.Before, link.Commit)}
+	}
+	return nil
+}
+
+// runGitPush syncs a push detached from its request, within
+// GENERATION_TIMEOUT, then releases the project's lock.
+func (h *Handlers) runGitPush(projectID string, push *GitPushEvent, release func()) {
+	defer release()
+	ctx, cancel := context.WithTimeout(withBuildTrigger(context.Background(), "git"), h.cfg.GenerationTimeout)
+	defer cancel()
+
+	result, err := h.syncGitPush(ctx, projectID, push)
+	if err != nil {
+		log.Printf("Git push %s failed for project %s: %v", push.After, projectID, redactPayload(err))
+		return
+	}
+	log.Printf("Synced git push %s into project %s: %d updated, %d removed", result.Commit, projectID, len(result.Updated), len(result.Removed))
+}
+
+// syncGitPush applies a push's changed files to source/ and rebuilds. The
+// caller holds the project's lock, under which the link is read again so
+// pushes delivered together apply in order or not at all. Like an edit,
+// the changed files are checked against the file limits and path policy
+// before any is stored, and the result is recorded as a version.
+func (h *Handlers) syncGitPush(ctx context.Context, projectID string, push *GitPushEvent) (*GitSyncResult, error) {
+	link, err := h.storage.GetGitLink(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGitPush(link, push); err != nil {
+		return nil, err
+	}
+
+	// Collapse the commit list so the last change to each path wins
+	changes := make(map[string]bool) // path -> removed
+	for _, commit := range push.Commits {
+		for _, p := range commit.Added {
+			changes[p] = false
+		}
+		for _, p := range commit.Modified {
+			changes[p] = false
+		}
+		for _, p := range commit.Removed {
+			changes[p] = true
+		}
+	}
+
+	existingFiles, err := h.storage.GetSourceFiles(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	result := &GitSyncResult{Commit: push.After}
+	written := make(map[string]string)
+	files := maps.Clone(existingFiles)
+	if files == nil {
+		files = make(map[string]string)
+	}
+	for repoPath, removed := range changes {
+		filePath, ok := gitProjectPath(link, repoPath)
+		if !ok {
+			continue
+		}
+		if removed {
+			if _, ok := files[filePath]; ok {
+				delete(files, filePath)
+				result.Removed = append(result.Removed, filePath)
+			}
+			continue
+		}
+
+		content, err := h.fetchGitFile(ctx, link, push.After, repoPath)
+		if err != nil {
+			return nil, err
+		}
+		files[filePath] = content
+		written[filePath] = content
+		result.Updated = append(result.Updated, filePath)
+	}
+
+	problems := h.validateFileSet("", written)
+	for p := range written {
+		if problem := h.pathPolicyProblem(p); problem != "" {
+			problems = append(problems, FileProblem{Path: p, Problem: problem})
+		}
+	}
+	if len(files) > h.cfg.MaxFilesPerProject {
+		problems = append(problems, FileProblem{Problem: fmt.Sprintf("%d files exceeds the limit of %d", len(files), h.cfg.MaxFilesPerProject)})
+	}
+	if err := problemsError(problems); err != nil {
+		validationErr := err.(ValidationError)
+		validationErr.Message = "Push contains invalid files"
+		return nil, validationErr
+	}
+
+	if err := h.checkLock(ctx, projectID); err != nil {
+		return nil, err
+	}
+	for _, filePath := range result.Removed {
+		if err := h.storage.DeleteSourceFile(ctx, projectID, filePath); err != nil {
+			return nil, err
+		}
+	}
+	for filePath, content := range written {
+		if err := h.storage.StoreSourceFile(ctx, projectID, filePath, content); err != nil {
+			return nil, err
+		}
+	}
+
+	// Recorded before the rebuild, which a failure doesn't undo: the source
+	// is synced either way
+	link.Commit = push.After
+	if err := h.storage.StoreGitLink(ctx, projectID, link); err != nil {
+		return nil, err
+	}
+	if err := h.storage.SetGitCommit(ctx, projectID, push.After); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	if len(result.Updated) > 0 || len(result.Removed) > 0 {
+		h.changes.PublishFiles(projectID, "git", written, result.Removed)
+		if err := h.rebuild(ctx, projectID); err != nil {
+			log.Printf("Error rebuilding project %s after git push: %v", projectID, redactPayload(err))
+		}
+		h.snapshotVersion(ctx, projectID, "git", "Synced commit "+push.After)
+	}
+	return result, nil
+}
+
+// gitProjectPath maps a repository path onto a project path, reporting
+// false for files outside the linked directory.
+func gitProjectPath(link *GitLink, repoPath string) (string, bool) {
+	if link.Dir == "" {
+		return repoPath, true
+	}
+	rel, ok := strings.CutPrefix(repoPath, link.Dir+"/")
+	return rel, ok && rel != ""
+}
+
+// gitURLAllowed reports whether u may be fetched for a linked repository.
+func (h *Handlers) gitURLAllowed(u *url.URL) bool {
+	return u.Scheme == "https" && u.User == nil && u.Port() == "" && slices.Contains(h.cfg.GitAllowedHosts, strings.ToLower(u.Hostname()))
+}
+
+// gitTemplateAllowed reports whether a raw URL template points at an
+// allowed git host, with its placeholders only in the path.
+func (h *Handlers) gitTemplateAllowed(template string) bool {
+	u, err := url.Parse(strings.NewReplacer("{sha}", "sha", "{path}", "path").Replace(template))
+	if err != nil || !h.gitURLAllowed(u) {
+		return false
+	}
+	return strings.HasPrefix(template, "https://"+u.Host+"/")
+}
+
+// gitClient fetches repository files, refusing redirects off the allowlist.
+func (h *Handlers) gitClient() *http.Client {
+	return &http.Client{
+		Timeout:   httpClient.Timeout,
+		Transport: httpClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !h.gitURLAllowed(req.URL) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// escapeGitPath escapes each segment of a repository path for a URL.
+func escapeGitPath(p string) string {
+	segments := strings.Split(path.Clean(p), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// fetchGitFile downloads a file at a given commit using the link's raw URL
+// template, which is checked against GIT_ALLOWED_HOSTS again in case the
+// link predates the allowlist. Files over MAX_FILE_SIZE are refused.
+func (h *Handlers) fetchGitFile(ctx context.Context, link *GitLink, sha, repoPath string) (string, error) {
+	fileURL := strings.NewReplacer("{sha}", url.PathEscape(sha), "{path}", escapeGitPath(repoPath)).Replace(link.RawURLTemplate)
+	u, err := url.Parse(fileURL)
+	if err != nil || !h.gitTemplateAllowed(link.RawURLTemplate) {
+		return "", fmt.Errorf("raw URL template is not on an allowed git host")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := h.gitClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("git fetch failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("git fetch of %s failed (%d)", repoPath, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, int64(h.cfg.MaxFileSize)+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(content) > h.cfg.MaxFileSize {
+		return "", fmt.Errorf("%s exceeds %d bytes", repoPath, h.cfg.MaxFileSize)
+	}
+	return string(content), nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,6 +11,9 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -34,33 +38,127 @@ var (
 
 // Handlers contains HTTP handlers and their dependencies.
 type Handlers struct {
+	cfg             Config
 	pythonClient    *PythonAgentClient
 	nodeBuildClient *NodeBuildClient
 	storage         *Storage
+	exposures       *ExposureRecorder
+	viewStats       *ViewStatsRecorder
+	streams         *StreamRegistry
+	changes         *ChangeHub
+	secrets         *Secrets
+	health          *DownstreamHealth
+	replayClient    *PythonAgentClient
+	chatReplays     *ChatReplays
+	locks           *ProjectLocks
+	lighthouse      *LighthouseRunner
+	builds          *BuildQueue
+	replicas        *ReplicaRing
+	readiness       *Readiness
+	formLimiter     *WindowLimiter
+	dataLimiter     *WindowLimiter
+	// apiKeys are the keys configured in API_KEYS, by secret hash.
+	apiKeys map[string]APIKey
 }
 
 // NewHandlers creates a new Handlers instance.
-func NewHandlers(pythonClient *PythonAgentClient, nodeBuildClient *NodeBuildClient, storage *Storage) *Handlers {
+func NewHandlers(cfg Config, pythonClient *PythonAgentClient, nodeBuildClient *NodeBuildClient, storage *Storage, secrets *Secrets, health *DownstreamHealth, replayClient *PythonAgentClient, apiKeys map[string]APIKey, replicas *ReplicaRing) *Handlers {
 	return &Handlers{
+		cfg:             cfg,
 		pythonClient:    pythonClient,
 		nodeBuildClient: nodeBuildClient,
 		storage:         storage,
+		exposures:       NewExposureRecorder(storage),
+		viewStats:       NewViewStatsRecorder(storage, cfg.ViewStatsSamplePercent),
+		streams:         NewStreamRegistry(),
+		changes:         NewChangeHub(),
+		secrets:         secrets,
+		health:          health,
+		replayClient:    replayClient,
+		chatReplays:     NewChatReplays(),
+		locks:           NewProjectLocks(storage.client, cfg.ProjectLockTTL, cfg.ProjectLockWait),
+		lighthouse:      NewLighthouseRunner(),
+		builds:          NewBuildQueue(storage, cfg.BuildWorkers),
+		replicas:        replicas,
+		readiness:       NewReadiness(cfg, storage, pythonClient, nodeBuildClient),
+		formLimiter:     NewWindowLimiter(time.Minute),
+		dataLimiter:     NewWindowLimiter(time.Minute),
+		apiKeys:         apiKeys,
 	}
 }
 
+// PartialStoreErrorResponse is the JSON body when only some of an app's
+// files were stored. Unless RolledBack, the project is left marked
+// inconsistent in its state until the next successful generation.
+type PartialStoreErrorResponse struct {
+	Message    string         `json:"error"`
+	Failed     []StoreFailure `json:"failed"`
+	RolledBack bool           `json:"rolled_back"`
+}
+
 // writeError writes an error response as JSON.
 func writeError(w http.ResponseWriter, err error) {
+	lang := responseLanguage(w)
+	var budgetErr *BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		log.Printf("budget exceeded: %v", err)
+		writeJSON(w, http.StatusGatewayTimeout, BudgetErrorResponse{
+			Message:   localize(lang, "Request timed out"),
+			Operation: budgetErr.Operation,
+			BudgetMS:  budgetErr.Budget.Milliseconds(),
+			ElapsedMS: budgetErr.Elapsed.Milliseconds(),
+		})
+		return
+	}
+	var integrityErr *IntegrityError
+	if errors.As(err, &integrityErr) {
+		writeJSON(w, http.StatusBadGateway, IntegrityErrorResponse{
+			Message: localize(lang, "Stored content failed an integrity check"),
+			Code:    IntegrityErrorCode,
+			Key:     integrityErr.Key,
+		})
+		return
+	}
+	var circuitErr *CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(circuitErr.RetryAfter.Seconds(), 1))))
+		writeJSON(w, http.StatusServiceUnavailable, AppError{Message: localize(lang, "Temporarily unavailable, please retry later")})
+		return
+	}
+	var partialErr *PartialStoreError
+	if errors.As(err, &partialErr) {
+		log.Printf("partial store: %v", redactPayload(err))
+		writeJSON(w, http.StatusInternalServerError, PartialStoreErrorResponse{
+			Message:    localize(lang, "Some files could not be stored"),
+			Failed:     partialErr.Failed,
+			RolledBack: partialErr.RolledBack,
+		})
+		return
+	}
+	var validationErr ValidationError
+	if errors.As(err, &validationErr) {
+		validationErr.Message = localize(lang, validationErr.Message)
+		writeJSON(w, http.StatusUnprocessableEntity, validationErr)
+		return
+	}
+	var requestErr RequestError
+	if errors.As(err, &requestErr) {
+		requestErr.Message = localize(lang, requestErr.Message)
+		writeJSON(w, requestErr.Code, requestErr)
+		return
+	}
 	var appErr AppError
 	if errors.As(err, &appErr) {
+		appErr.Message = localize(lang, appErr.Message)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(appErr.Code)
 		_ = json.NewEncoder(w).Encode(appErr)
 		return
 	}
-	log.Printf("unexpected error: %v", err)
+	log.Printf("unexpected error: %v", redactPayload(err))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusInternalServerError)
-	_ = json.NewEncoder(w).Encode(AppError{Message: "Internal server error"})
+	_ = json.NewEncoder(w).Encode(AppError{Message: localize(lang, "Internal server error")})
 }
 
 // writeJSON writes a JSON response.
@@ -70,18 +168,51 @@ func writeJSON(w http.ResponseWriter, status int, data any) {
 	_ = json.NewEncoder(w).Encode(data)
 }
 
-// validateUUID validates that the given string is a valid UUID.
+// validateUUID validates a project ID: a UUID that isn't the system
+// namespace or another non-project namespace, which are reported as not
+// found.
 func validateUUID(id string) error {
-	if _, err := uuid.Parse(id); err != nil {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
 		return ErrInvalidUUID
 	}
+	if isReservedNamespace(parsed) {
+		return ErrNotFound
+	}
 	return nil
 }
 
+// HealthResponse is the response for a deep health check.
+type HealthResponse struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]string `json:"dependencies"`
+	ReadOnly     bool              `json:"read_only,omitempty"`
+}
+
 // HandleHealth returns a health check response.
+// With ?deep=true it also checks downstream services and reports each one.
+// Read-only instances don't build, so they don't depend on node-build.
 func (h *Handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("OK"))
+	if r.URL.Query().Get("deep") != "true" {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK"))
+		return
+	}
+
+	resp := HealthResponse{Status: "ok", Dependencies: map[string]string{}, ReadOnly: h.cfg.ReadOnly}
+	status := http.StatusOK
+
+	if !h.cfg.ReadOnly {
+		if err := h.nodeBuildClient.Health(r.Context()); err != nil {
+			resp.Dependencies["node_build"] = err.Error()
+			resp.Status = "degraded"
+			status = http.StatusServiceUnavailable
+		} else {
+			resp.Dependencies["node_build"] = "ok"
+		}
+	}
+
+	writeJSON(w, status, resp)
 }
 
 // CreateRequest is the request body for creating an app.
@@ -91,9 +222,12 @@ type CreateRequest struct {
 
 // CreateResponse is the response for creating an app.
 type CreateResponse struct {
-	Summary string   `json:"summary"`
-	Files   []string `json:"files"`
-	ViewURL string   `json:"view_url"`
+	Summary string      `json:"summary"`
+	Files   []string    `json:"files"`
+	ViewURL string      `json:"view_url"`
+	Quota   *QuotaUsage `json:"quota,omitempty"`
+	// OperationID is the ID the create's progress was recorded under.
+	OperationID string `json:"operation_id"`
 }
 
 // HandleCreate creates a new app.
@@ -105,28 +239,103 @@ func (h *Handlers) HandleCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeError(w, err)
 		return
 	}
+	if err := h.validatePrompt(req.Prompt); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h.createApp(w, r, projectID, req.Prompt)
+}
 
-	if req.Prompt == "" {
-		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Prompt is required"})
+// createApp generates and stores an app from prompt and writes the
+// CreateResponse. Its progress is recorded as an operation, under the ID in
+// the X-Operation-ID request header if the client chose one, unless the
+// request already runs as an async operation.
+func (h *Handlers) createApp(w http.ResponseWriter, r *http.Request, projectID, prompt string) {
+	op := operationFrom(r.Context())
+	var opID string
+	if op == nil {
+		var err error
+		if opID, err = operationID(r); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "create")
+	if !ok {
 		return
 	}
+	defer release()
 
-	// Call Python Agent
-	result, err := h.pythonClient.CreateApp(r.Context(), req.Prompt)
+	if op == nil {
+		op = h.startOperation(r.Context(), w, projectID, opID, "create", StageGenerating)
+		r = r.WithContext(withOperation(r.Context(), op))
+	}
+	resp, err := h.generateApp(w, r, projectID, prompt)
+	// Async operations are finished by AsyncMiddleware
+	if opID != "" {
+		op.Finish(r.Context(), err)
+	}
 	if err != nil {
-		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to create app: %v", err)})
+		writeError(w, err)
 		return
 	}
+	resp.OperationID = op.ID()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// generateApp is the work of createApp, reporting progress to the request
+// context's operation.
+func (h *Handlers) generateApp(w http.ResponseWriter, r *http.Request, projectID, prompt string) (*CreateResponse, error) {
+	op := operationFrom(r.Context())
+	agentCtx, err := h.agentContext(r, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Call Python Agent
+	result, err := h.pythonClient.CreateApp(agentCtx, prompt)
+	if err != nil {
+		return nil, upstreamError("Failed to create app", err)
+	}
+	quota := h.recordUsage(r.Context(), projectID, result.Tokens, 0)
+	h.setRateLimitHeaders(w, quota)
+
+	if err := h.validateAgentOutput(result.Files, result.CompiledFiles); err != nil {
+		return nil, err
+	}
+	h.recordAgentBuild(r.Context(), projectID, "create", result.Files, result.CompiledFiles)
+
+	op.SetStage(r.Context(), StageProcessing)
+	compiledFiles, err := h.postProcessBuild(r.Context(), projectID, result.CompiledFiles)
+	if err != nil {
+		return nil, err
+	}
 
 	// Store in Rust DB
-	if err := h.storage.StoreApp(r.Context(), projectID, result.Files, result.CompiledFiles, result.Summary); err != nil {
-		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store app: %v", err)})
-		return
+	op.SetStage(r.Context(), StageStoring)
+	if err := h.checkLock(r.Context(), projectID); err != nil {
+		return nil, err
+	}
+	if err := h.storage.StoreApp(r.Context(), projectID, result.Files, compiledFiles, result.Summary, requestLanguage(r)); err != nil {
+		return nil, upstreamError("Failed to store app", err)
+	}
+
+	// Kept so the project can be exported as a blueprint
+	if err := h.storage.StorePrompt(r.Context(), projectID, prompt); err != nil {
+		log.Printf("Error storing prompt for project %s: %v", projectID, err)
 	}
+	h.ensureTitle(r.Context(), projectID, prompt, result.Summary)
+	h.snapshotVersion(r.Context(), projectID, "create", result.Summary)
+	h.trackTasks(r.Context(), projectID, "create", prompt, result.Summary, true)
+	h.changes.PublishFiles(projectID, "create", result.Files, nil)
+	h.changes.PublishCompiled(projectID, "create")
+	h.queueLighthouse(projectID, "create")
 
 	// Build response
 	fileList := make([]string, 0, len(result.Files))
@@ -134,13 +343,12 @@ func (h *Handlers) HandleCreate(w http.ResponseWriter, r *http.Request) {
 		fileList = append(fileList, path)
 	}
 
-	resp := CreateResponse{
+	return &CreateResponse{
 		Summary: result.Summary,
 		Files:   fileList,
 		ViewURL: "/" + projectID + "/view",
-	}
-
-	writeJSON(w, http.StatusOK, resp)
+		Quota:   quota,
+	}, nil
 }
 
 // EditRequest is the request body for editing an app.
@@ -150,9 +358,11 @@ type EditRequest struct {
 
 // EditResponse is the response for editing an app.
 type EditResponse struct {
-	Summary string   `json:"summary"`
-	Files   []string `json:"files"`
-	ViewURL string   `json:"view_url"`
+	Summary string           `json:"summary"`
+	Files   []string         `json:"files"`
+	Changes []FileEditResult `json:"changes"`
+	ViewURL string           `json:"view_url"`
+	Quota   *QuotaUsage      `json:"quota,omitempty"`
 }
 
 // HandleEdit edits an existing app.
@@ -164,44 +374,36 @@ func (h *Handlers) HandleEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req EditRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
-		return
-	}
-
-	if req.Prompt == "" {
-		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Prompt is required"})
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeError(w, err)
 		return
 	}
-
-	// Get existing source files
-	existingFiles, err := h.storage.GetSourceFiles(r.Context(), projectID)
-	if err != nil {
-		if errors.Is(err, ErrNotFound) {
-			writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
-			return
-		}
-		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to get existing files: %v", err)})
+	if err := h.validatePrompt(req.Prompt); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	if len(existingFiles) == 0 {
-		writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
+	release, ok := h.lockProject(w, r, projectID, "edit")
+	if !ok {
 		return
 	}
+	defer release()
 
-	// Call Python Agent
-	result, err := h.pythonClient.EditApp(r.Context(), req.Prompt, existingFiles)
+	agentCtx, err := h.agentContext(r, projectID)
 	if err != nil {
-		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to edit app: %v", err)})
+		writeError(w, err)
 		return
 	}
 
-	// Update in Rust DB
-	if err := h.storage.UpdateApp(r.Context(), projectID, result.Files, result.CompiledFiles, result.Summary); err != nil {
-		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to update app: %v", err)})
+	edit, err := h.editApp(r.Context(), agentCtx, projectID, req.Prompt, "edit", requestLanguage(r))
+	if edit != nil {
+		h.setRateLimitHeaders(w, edit.quota)
+	}
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+	result := edit.result
 
 	// Build response
 	fileList := make([]string, 0, len(result.Files))
@@ -212,12 +414,81 @@ func (h *Handlers) HandleEdit(w http.ResponseWriter, r *http.Request) {
 	resp := EditResponse{
 		Summary: result.Summary,
 		Files:   fileList,
+		Changes: buildEditResults(edit.existingFiles, result.Files, result.Edits),
 		ViewURL: "/" + projectID + "/view",
+		Quota:   edit.quota,
 	}
 
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// appEdit is an agent edit stored by editApp.
+type appEdit struct {
+	existingFiles map[string]string
+	result        *EditAppResponse
+	quota         *QuotaUsage
+}
+
+// editApp runs an agent edit of the project and stores it: the one edit
+// path for edit requests, hooks, schedules and security remediation. The
+// caller holds the project's lock, and agentCtx carries the agent context
+// (see agentContext). trigger names what asked for the edit in its build
+// record, version, tasks and change events. Once the agent has run, the
+// edit is returned with its quota even if storing it fails.
+func (h *Handlers) editApp(ctx, agentCtx context.Context, projectID, prompt, trigger, language string) (*appEdit, error) {
+	existingFiles, err := h.storage.GetSourceFiles(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, upstreamError("Failed to get existing files", err)
+	}
+	if len(existingFiles) == 0 {
+		return nil, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"}
+	}
+
+	// Comments left for the agent go along with the prompt
+	agentPrompt := prompt
+	comments, commentText := h.commentContext(ctx, projectID, existingFiles)
+	if commentText != "" {
+		agentPrompt += "\n\n" + commentText
+	}
+
+	// Call Python Agent
+	result, err := h.pythonClient.EditApp(agentCtx, agentPrompt, existingFiles)
+	if err != nil {
+		return nil, upstreamError("Failed to edit app", err)
+	}
+	edit := &appEdit{existingFiles: existingFiles, result: result, quota: h.recordUsage(ctx, projectID, result.Tokens, 0)}
+
+	if err := h.validateAgentOutput(result.Files, result.CompiledFiles); err != nil {
+		return edit, err
+	}
+	h.recordAgentBuild(ctx, projectID, trigger, result.Files, result.CompiledFiles)
+
+	op := operationFrom(ctx)
+	op.SetStage(ctx, StageProcessing)
+	compiledFiles, err := h.postProcessBuild(ctx, projectID, result.CompiledFiles)
+	if err != nil {
+		return edit, err
+	}
+
+	// Update in Rust DB
+	op.SetStage(ctx, StageStoring)
+	if err := h.checkLock(ctx, projectID); err != nil {
+		return edit, err
+	}
+	if err := h.storage.UpdateApp(ctx, projectID, result.Files, compiledFiles, result.Summary, language); err != nil {
+		return edit, upstreamError("Failed to update app", err)
+	}
+
+	h.snapshotVersion(ctx, projectID, trigger, result.Summary)
+	h.markCommentsIncluded(ctx, projectID, comments)
+	h.trackTasks(ctx, projectID, trigger, prompt, result.Summary, true)
+	written, removed := diffFiles(existingFiles, result.Files)
+	h.changes.PublishFiles(projectID, trigger, written, removed)
+	h.changes.PublishCompiled(projectID, trigger)
+	h.queueLighthouse(projectID, trigger)
+	return edit, nil
+}
+
 // HandleView serves the generated app's index.html.
 func (h *Handlers) HandleView(w http.ResponseWriter, r *http.Request) {
 	projectID := chi.URLParam(r, "uuid")
@@ -226,11 +497,53 @@ func (h *Handlers) HandleView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	content, mimeType, err := h.storage.GetCompiledFile(r.Context(), projectID, "index.html")
+	if err := h.checkEmbedToken(r, projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, done := h.streams.Track(r.Context(), StreamViewer, projectID)
+	defer done()
+	r = r.WithContext(ctx)
+
+	channel, err := parseChannel(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// ?at= views the version that was current at that time instead
+	var version int
+	if r.URL.Query().Has("at") {
+		n, ok := h.viewVersion(w, r, projectID)
+		if !ok {
+			return
+		}
+		version = n
+		w.Header().Set(versionHeader, strconv.Itoa(version))
+	}
+
+	// Split viewers between channels while an experiment is running,
+	// unless a channel or time was asked for explicitly
+	if !r.URL.Query().Has("channel") && version == 0 {
+		if exp, expErr := h.storage.GetExperiment(r.Context(), projectID); expErr == nil {
+			channel = chooseVariant(w, r, projectID, exp)
+			h.exposures.Record(projectID, channel)
+			w.Header().Set("Cache-Control", "private, no-cache")
+		}
+	}
+
+	var content []byte
+	var mimeType string
+	if version > 0 {
+		content, mimeType, err = h.storage.GetVersionFile(r.Context(), projectID, version, "index.html")
+	} else {
+		content, mimeType, err = h.storage.GetChannelFile(r.Context(), projectID, channel, "index.html")
+	}
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
 			w.WriteHeader(http.StatusNotFound)
-			_, _ = w.Write([]byte("No app generated yet"))
+			_, _ = w.Write([]byte(localize(requestLanguage(r), "No app generated yet")))
 			return
 		}
 		writeError(w, err)
@@ -240,10 +553,65 @@ func (h *Handlers) HandleView(w http.ResponseWriter, r *http.Request) {
 	// Rewrite asset paths to go through our service
 	html := string(content)
 	html = rewriteAssetPaths(html, projectID)
+	html = injectIconLinks(html)
+	html = h.rewriteProxiedURLs(html, proxyAttrPattern, "./view/")
+	if channel == ChannelStaging {
+		html = rewriteStagingAssetPaths(html)
+	}
+	if version > 0 {
+		html = rewriteVersionAssetPaths(html, version)
+	}
+
+	// Warn viewers while the project is pending deletion
+	if pending, pendingErr := h.storage.GetPendingDeletion(r.Context(), projectID); pendingErr == nil {
+		html = injectDeletionBanner(html, pending.DeleteAfter)
+	}
 
+	robots, _ := h.storage.GetRobots(r.Context(), projectID)
+	if robots != nil && robots.InjectMeta {
+		html = injectRobotsMeta(html, robots.Tag)
+	}
+	setRobotsHeader(w, robots)
+
+	if settings, settingsErr := h.projectSettings(r.Context(), projectID); settingsErr == nil {
+		if csp := settings.contentSecurityPolicy(); csp != "" {
+			w.Header().Set("Content-Security-Policy", csp)
+		}
+	} else {
+		log.Printf("Error loading settings for project %s: %v", projectID, settingsErr)
+	}
+
+	if !isLighthouse(r) {
+		h.viewStats.RecordView(r, projectID)
+	}
+
+	// The page is rewritten per request, so its ETag is of what is served;
+	// revalidating each view keeps it current after a rebuild. It is
+	// compressed per request too, tagged apart from the uncompressed page.
+	body := []byte(html)
+	etag := contentETag(body)
+	w.Header().Add("Vary", "Accept-Encoding")
+	gzipped := len(body) >= minCompressSize && acceptsGzip(r)
+	if gzipped {
+		etag += "-gzip"
+	}
+	etag = quoteETag(etag)
+	w.Header().Set("ETag", etag)
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if gzipped {
+		body = gzipBytes(body, gzip.DefaultCompression)
+		w.Header().Set("Content-Encoding", "gzip")
+	}
 	w.Header().Set("Content-Type", mimeType)
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(html))
+	_, _ = w.Write(body)
 }
 
 // HandleAsset serves compiled assets.
@@ -254,6 +622,10 @@ func (h *Handlers) HandleAsset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ctx, done := h.streams.Track(r.Context(), StreamViewer, projectID)
+	defer done()
+	r = r.WithContext(ctx)
+
 	// Get the asset path from the wildcard
 	assetPath := chi.URLParam(r, "*")
 	if assetPath == "" {
@@ -263,23 +635,180 @@ func (h *Handlers) HandleAsset(w http.ResponseWriter, r *http.Request) {
 
 	// Prepend "assets/" to match the storage key structure
 	fullPath := "assets/" + assetPath
+	if name, ok := strings.CutPrefix(fullPath, libraryAssetsDir); ok {
+		h.serveProjectLibraryAsset(w, r, projectID, name)
+		return
+	}
 
-	content, mimeType, err := h.storage.GetCompiledFile(r.Context(), projectID, fullPath)
+	channel, err := parseChannel(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Assets of a time-travel view come from the version it resolved to
+	version, err := parseVersion(r)
 	if err != nil {
-		if errors.Is(err, ErrNotFound) {
-			w.WriteHeader(http.StatusNotFound)
-			_, _ = w.Write([]byte("Asset not found"))
-			return
-		}
 		writeError(w, err)
 		return
 	}
 
+	// Production images may be served as an optimized variant picked by the
+	// Accept header and ?w=, so caches must key on Accept
+	if isOptimizedImage(fullPath) {
+		w.Header().Add("Vary", "Accept")
+		if channel == ChannelProduction && version == 0 {
+			fullPath = h.negotiateImage(r, projectID, fullPath)
+		}
+	}
+
+	// Text assets are served from their gzip copy to clients that accept
+	// it. Range requests get the original, whose offsets they refer to.
+	servedPath := fullPath
+	if isCompressible(fullPath) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Header.Get("Range") == "" && acceptsGzip(r) {
+			servedPath = fullPath + gzipSuffix
+		}
+	}
+
+	// Production assets are tagged with the hash in the metadata, so
+	// revalidations are answered without reading the file. Content is
+	// streamed, so assets without a recorded hash (staging) go untagged;
+	// their names are content hashed and cached as immutable anyway.
+	var etag string
+	if channel == ChannelProduction && version == 0 {
+		hash := h.storage.CompiledFileETag(r.Context(), projectID, servedPath)
+		if hash == "" && servedPath != fullPath {
+			// Built before gzip copies were stored
+			servedPath = fullPath
+			hash = h.storage.CompiledFileETag(r.Context(), projectID, fullPath)
+		}
+		if hash != "" {
+			etag = quoteETag(hash)
+		}
+	}
+
+	var stream *StoredStream
+	if etag == "" || !etagMatches(r, etag) {
+		fetch := func(assetPath string) (*StoredStream, error) {
+			if version > 0 {
+				return h.storage.GetVersionFileStream(r.Context(), projectID, version, assetPath)
+			}
+			stream, err := h.storage.GetChannelFileStream(r.Context(), projectID, channel, assetPath)
+			if errors.Is(err, ErrNotFound) && channel == ChannelProduction {
+				// Chunks imported from staged bundles don't carry the channel parameter;
+				// asset names are content hashed so falling back can't serve the wrong file.
+				etag = ""
+				stream, err = h.storage.GetChannelFileStream(r.Context(), projectID, ChannelStaging, assetPath)
+			}
+			return stream, err
+		}
+		stream, err = fetch(servedPath)
+		if errors.Is(err, ErrNotFound) && servedPath != fullPath {
+			etag = ""
+			servedPath = fullPath
+			stream, err = fetch(fullPath)
+		}
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				if h.servePlaceholder(w, r, projectID, fullPath) {
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(localize(requestLanguage(r), "Asset not found")))
+				return
+			}
+			writeError(w, err)
+			return
+		}
+		defer func() { _ = stream.Close() }()
+	}
+
+	robots, _ := h.storage.GetRobots(r.Context(), projectID)
+	setRobotsHeader(w, robots)
+
+	h.viewStats.RecordAsset(projectID)
+
 	// Set caching headers for hashed assets
 	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+		if stream == nil {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Entries stored before a type was known come back as octet-stream
+	mimeType := stream.MimeType
+	if mimeType == "" || mimeType == "application/octet-stream" || servedPath != fullPath {
+		mimeType = getMimeType(fullPath)
+	}
+
 	w.Header().Set("Content-Type", mimeType)
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(content)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	status, body := http.StatusOK, io.Reader(stream)
+	if servedPath != fullPath {
+		w.Header().Set("Content-Encoding", "gzip")
+		if stream.Size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(stream.Size, 10))
+		}
+	} else if stream.Size >= 0 {
+		// Media elements seek with Range requests; the backends can't read
+		// part of a value, so the skipped bytes are still fetched, just not
+		// sent on
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.FormatInt(stream.Size, 10))
+		if header := r.Header.Get("Range"); header != "" && ifRangeMatches(r, etag) {
+			rng, ok, err := parseByteRange(header, stream.Size)
+			if errors.Is(err, errRangeUnsatisfiable) {
+				w.Header().Del("Content-Length")
+				w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(stream.Size, 10))
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if ok {
+				if err := skipBytes(stream.ReadCloser, rng.start); err != nil {
+					writeError(w, upstreamError("Failed to read asset", err))
+					return
+				}
+				w.Header().Set("Content-Range", rng.contentRange(stream.Size))
+				w.Header().Set("Content-Length", strconv.FormatInt(rng.length, 10))
+				status, body = http.StatusPartialContent, io.LimitReader(stream, rng.length)
+			}
+		}
+	}
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, body); err != nil {
+		// The status has gone out, so a broken read, or a file failing its
+		// integrity check (already reported), can only abort the response
+		// short of its Content-Length
+		var integrityErr *IntegrityError
+		if !errors.As(err, &integrityErr) {
+			log.Printf("Error streaming asset %s for project %s: %v", fullPath, projectID, err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// quoteETag formats a content hash as an entity tag.
+func quoteETag(hash string) string {
+	return `"` + hash + `"`
+}
+
+// etagMatches reports whether the request's If-None-Match lists etag,
+// comparing weakly as RFC 9110 requires for GET.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for tag := range strings.SplitSeq(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // HandleChat proxies chat requests to the Python Agent using Server-Sent Events.
@@ -291,14 +820,119 @@ func (h *Handlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get existing source files to provide context
-	existingFiles, err := h.storage.GetSourceFiles(r.Context(), projectID)
-	if err != nil && !errors.Is(err, ErrNotFound) {
-		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to get existing files: %v", err)})
+	// Clients that reconnect by posting again pick up the stream they lost
+	if streamID := r.Header.Get(chatStreamHeader); streamID != "" && r.Header.Get("Last-Event-ID") != "" {
+		h.resumeChat(w, r, projectID, streamID)
+		return
+	}
+
+	// Checked before the stream takes the project lock
+	body, err := readJSONBody(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.validateChatRequest(body); err != nil {
+		writeError(w, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	h.streamChat(w, r, projectID, "")
+}
+
+// HandleCreateStream creates an app like HandleCreate, but streams the
+// agent's progress (files being written, text deltas) as the chat SSE
+// stream does, including resuming. The app is built from scratch: source
+// files it doesn't write are removed when the stream finishes.
+func (h *Handlers) HandleCreateStream(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req CreateRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.validatePrompt(req.Prompt); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The agent's chat endpoint takes an AI SDK submit-message request
+	message := map[string]any{
+		"id":    uuid.NewString(),
+		"role":  "user",
+		"parts": []map[string]string{{"type": "text", "text": req.Prompt}},
+	}
+	body, err := json.Marshal(map[string]any{
+		"id":       uuid.NewString(),
+		"trigger":  "submit-message",
+		"messages": []any{message},
+	})
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: "Failed to serialize request body"})
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	h.streamChat(w, r, projectID, req.Prompt)
+}
+
+// streamChat proxies a chat request body to the Python Agent's streaming
+// endpoint, applying file operations as they arrive. With a createPrompt,
+// the agent starts from no files and the stream creates the app.
+func (h *Handlers) streamChat(w http.ResponseWriter, r *http.Request, projectID, createPrompt string) {
+	trigger := "chat"
+	if createPrompt != "" {
+		trigger = "create"
+	}
+
+	// The stream outlives its client for ChatResumeWindow so it can be
+	// resumed; until it starts, a disconnect cancels it as usual
+	clientCtx := r.Context()
+	ctx, cancel := context.WithCancel(context.WithoutCancel(clientCtx))
+	defer cancel()
+	stopCancel := context.AfterFunc(clientCtx, cancel)
+	defer stopCancel()
+	ctx, done := h.streams.Track(ctx, StreamChat, projectID)
+	defer done()
+	r = r.WithContext(ctx)
+
+	channel, err := parseChannel(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	if existingFiles == nil {
-		existingFiles = make(map[string]string)
+	var opID string
+	if createPrompt != "" {
+		if opID, err = operationID(r); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	// Held until the stream ends, even if its client has gone
+	unlock, ok := h.lockProject(w, r, projectID, trigger)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Get existing source files to provide context
+	existingFiles := make(map[string]string)
+	if createPrompt == "" {
+		existingFiles, err = h.storage.GetSourceFiles(r.Context(), projectID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			writeError(w, upstreamError("Failed to get existing files", err))
+			return
+		}
+		if existingFiles == nil {
+			existingFiles = make(map[string]string)
+		}
 	}
 
 	// Read the original request body
@@ -315,8 +949,44 @@ func (h *Handlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	agentCtx, err := h.agentContext(r, projectID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
 	// Add existing files to the request
 	bodyData["files"] = existingFiles
+	if files := workspaceFiles(agentCtx); len(files) > 0 {
+		bodyData["workspace_files"] = files
+	}
+	if kit := brandKit(agentCtx); kit != nil {
+		bodyData["brand"] = kit
+	}
+
+	// Summarize older turns so long conversations stay within the model's
+	// context; on failure the full history is sent as before
+	var chatBody struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if json.Unmarshal(originalBody, &chatBody) == nil && len(chatBody.Messages) > 0 {
+		messages, compactErr := h.compactMessages(agentCtx, projectID, chatBody.Messages)
+		if compactErr != nil {
+			log.Printf("Failed to compact conversation for project %s: %v", projectID, redactPayload(compactErr))
+		} else {
+			bodyData["messages"] = messages
+		}
+	}
+
+	// Comments left for the agent are added to the user's message
+	var comments []FileComment
+	if createPrompt == "" {
+		var commentText string
+		comments, commentText = h.commentContext(r.Context(), projectID, existingFiles)
+		if commentText != "" && !appendUserText(bodyData, commentText) {
+			comments = nil
+		}
+	}
 
 	// Marshal the modified body
 	modifiedBody, err := json.Marshal(bodyData)
@@ -325,9 +995,17 @@ func (h *Handlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Chat streams hold an agent slot until they finish
+	release, err := h.pythonClient.limiter.Acquire(agentCtx, requestTier(agentCtx))
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusServiceUnavailable, Message: "Failed to connect to chat service"})
+		return
+	}
+	defer release()
+
 	// Create request to Python Agent
 	chatURL := h.pythonClient.baseURL + "/chat"
-	proxyReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, chatURL, bytes.NewReader(modifiedBody))
+	proxyReq, err := http.NewRequestWithContext(agentCtx, http.MethodPost, chatURL, bytes.NewReader(modifiedBody))
 	if err != nil {
 		writeError(w, AppError{Code: http.StatusInternalServerError, Message: "Failed to create proxy request"})
 		return
@@ -338,22 +1016,27 @@ func (h *Handlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 	if accept := r.Header.Get("Accept"); accept != "" {
 		proxyReq.Header.Set("Accept", accept)
 	}
+	setAgentHeaders(proxyReq)
 
-	// Make the request with a longer timeout for streaming
-	client := &http.Client{Timeout: 0} // No timeout for streaming
-	resp, err := client.Do(proxyReq)
+	resp, err := h.pythonClient.streamClient.Do(proxyReq)
 	if err != nil {
 		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to connect to chat service: %v", err)})
 		return
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	// Set SSE headers
+	// Set SSE headers, with the quota as it stands before this stream
+	h.setRateLimitHeaders(w, h.currentQuota(r.Context(), projectID))
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
 
+	// Streams outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Could not lift write deadline for chat stream: %v", err)
+	}
+
 	// Get the flusher for streaming
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -361,71 +1044,265 @@ func (h *Handlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	streamID, replay := h.chatReplays.Start(projectID, h.cfg.ChatResumeWindow, cancel)
+	defer h.chatReplays.Finish(streamID, replay)
+	if stopCancel() {
+		replay.attach()
+		context.AfterFunc(clientCtx, replay.detach)
+	}
+	w.Header().Set(chatStreamHeader, streamID)
+	clientGone := false
+
+	// sendEvent adds a synthetic event to the stream
+	sendEvent := func(event map[string]any) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		line := fmt.Sprintf("data: %s\n", data)
+		id := replay.append(line)
+		if !clientGone {
+			if err := writeChatEvent(w, id, line); err != nil {
+				clientGone = true
+			} else {
+				flusher.Flush()
+			}
+		}
+	}
+
+	// sendTransient adds a synthetic event of the given type to the stream,
+	// marked transient so the client doesn't keep it in the message
+	sendTransient := func(eventType string, payload any) {
+		sendEvent(map[string]any{"type": eventType, "data": payload, "transient": true})
+	}
+
+	// Creates record their progress as an operation, also sent as transient
+	// data-operation events at each stage
+	var op *OperationTracker
+	opFinished := false
+	if createPrompt != "" {
+		op = h.startOperation(ctx, w, projectID, opID, "create", StageGenerating)
+		op.onChange = func(progress Operation) { sendTransient("data-operation", progress) }
+		defer func() {
+			if !opFinished {
+				op.Finish(ctx, errors.New("stream ended before the app was created"))
+			}
+		}()
+	}
 	w.WriteHeader(resp.StatusCode)
+	if resp.StatusCode == http.StatusOK {
+		h.markCommentsIncluded(ctx, projectID, comments)
+	}
+	hw := startHeartbeat(w, flusher, h.cfg.ChatHeartbeatInterval)
+	defer hw.stop()
+	w, flusher = hw, hw
 
 	// Create SSE parser to intercept file operations
 	parser := NewSSEParser(resp.Body, existingFiles)
-	var hadFileOps bool
+	var hadFileOps, finished bool
+	var buildErr error
+
+	// The turn's requests, and any follow-ups in the agent's reply, go on
+	// the task list once the stream ends
+	prompt := createPrompt
+	if prompt == "" {
+		prompt = lastUserText(chatBody.Messages)
+	}
+	defer func() {
+		if !hadFileOps {
+			// A reply without changes only answered the user
+			prompt = ""
+		}
+		if hadFileOps || finished {
+			h.trackTasks(ctx, projectID, trigger, prompt, parser.Text(), finished && buildErr == nil)
+		}
+	}()
+
+	// build compiles the files the stream has written, reporting it as
+	// data-build events
+	build := func() {
+		buildCtx := withBuildTrigger(context.WithoutCancel(agentCtx), trigger)
+		// Build errors would otherwise only show up in the build
+		// status, leaving the client looking at the previous app
+		repairs := 0
+		if h.cfg.BuildErrorEvents {
+			buildCtx = withBuildFailureObserver(buildCtx, func(attempt int, err error) {
+				repairs = attempt + 1
+				sendTransient("data-build", BuildEvent{State: BuildRepairing, Attempt: attempt, Error: err.Error()})
+			})
+		}
+		if createPrompt != "" {
+			op.SetStage(buildCtx, StageStoring)
+			h.finishStreamedCreate(buildCtx, projectID, createPrompt, parser.GetFiles())
+			op.SetStage(buildCtx, StageBuilding)
+		}
+		buildErr = h.compileAndStore(buildCtx, projectID, parser.GetFiles(), channel)
+		if h.cfg.BuildErrorEvents {
+			event := BuildEvent{State: BuildSucceeded, Attempt: repairs}
+			if buildErr != nil {
+				event.State, event.Error = BuildFailed, buildErr.Error()
+			}
+			sendTransient("data-build", event)
+		}
+		if createPrompt != "" && channel == ChannelProduction {
+			h.ensureTitle(buildCtx, projectID, createPrompt, "")
+		}
+		if op != nil {
+			op.Finish(buildCtx, buildErr)
+			opFinished = true
+		}
+	}
 
 	// Stream and parse events
 	for {
 		event, readErr := parser.ReadEvent()
 		if readErr != nil {
-			if readErr != io.EOF {
+			if readErr != io.EOF && !replay.wasAborted() {
 				log.Printf("Error reading from Python Agent: %v", readErr)
 			}
 			break
 		}
 
-		// Write the raw event to the client
-		if _, writeErr := w.Write([]byte(event.RawLine)); writeErr != nil {
-			log.Printf("Error writing to client: %v", writeErr)
-			return
+		// Write the raw event to the client, numbering data lines so it can
+		// resume; if it has gone, keep going for anyone who resumes
+		line := event.RawLine
+		if strings.HasPrefix(line, "data:") {
+			line = fmt.Sprintf("id: %d\n%s", replay.append(line), line)
+		}
+		if !clientGone {
+			if _, writeErr := w.Write([]byte(line)); writeErr != nil {
+				log.Printf("Error writing to client: %v", writeErr)
+				clientGone = true
+			} else {
+				flusher.Flush()
+			}
+		}
+
+		// Drop new files that break the path policy
+		if event.FileOp != nil {
+			if problem := h.chatFileOpProblem(event.FileOp, parser); problem != "" {
+				log.Printf("Rejected file %s in project %s: %s", event.FileOp.FilePath, projectID, problem)
+				parser.Discard(event.FileOp.FilePath)
+				event.FileOp = nil
+			}
 		}
-		flusher.Flush()
 
-		// Process file operations
+		// Process file operations, which an abort doesn't interrupt once
+		// the agent has made them
 		if event.FileOp != nil {
 			hadFileOps = true
+			storeCtx := context.WithoutCancel(r.Context())
 			switch event.FileOp.Type {
 			case "create", "edit":
 				// Get the updated content from the parser's tracked state
 				content := parser.GetFiles()[event.FileOp.FilePath]
-				if storeErr := h.storage.StoreSourceFile(r.Context(), projectID, event.FileOp.FilePath, content); storeErr != nil {
+				if storeErr := h.storage.StoreSourceFile(storeCtx, projectID, event.FileOp.FilePath, content); storeErr != nil {
 					log.Printf("Error storing file %s: %v", event.FileOp.FilePath, storeErr)
+				} else {
+					op.FileStored(storeCtx)
+					h.changes.PublishFiles(projectID, trigger, map[string]string{event.FileOp.FilePath: content}, nil)
 				}
 			case "delete":
-				if delErr := h.storage.DeleteSourceFile(r.Context(), projectID, event.FileOp.FilePath); delErr != nil {
+				if delErr := h.storage.DeleteSourceFile(storeCtx, projectID, event.FileOp.FilePath); delErr != nil {
 					log.Printf("Error deleting file %s: %v", event.FileOp.FilePath, delErr)
+				} else {
+					h.changes.PublishFiles(projectID, trigger, nil, []string{event.FileOp.FilePath})
 				}
 			}
 		}
 
 		// On finish, trigger compilation if there were file operations
 		// Run synchronously so the client knows the app is ready when the stream ends
+		finished = finished || event.IsFinished
 		if event.IsFinished && hadFileOps {
-			h.compileAndStore(projectID, parser.GetFiles())
+			build()
 		}
 	}
+
+	// An aborted stream keeps the files written so far, built as if the
+	// agent had finished, and tells the client it was stopped
+	if replay.wasAborted() {
+		if hadFileOps && !finished {
+			build()
+		}
+		sendEvent(map[string]any{"type": "abort"})
+	}
 }
 
-// compileAndStore compiles source files and stores the compiled output.
-func (h *Handlers) compileAndStore(projectID string, files map[string]string) {
-	ctx := context.Background()
+// finishStreamedCreate removes source files left from before a streamed
+// create, which the agent started without, and keeps the prompt for
+// blueprints.
+func (h *Handlers) finishStreamedCreate(ctx context.Context, projectID, prompt string, files map[string]string) {
+	existing, err := h.storage.GetSourceFiles(ctx, projectID)
+	if err != nil {
+		log.Printf("Error listing source files for project %s: %v", projectID, err)
+	} else {
+		var removed []string
+		for path := range existing {
+			if _, ok := files[path]; !ok {
+				removed = append(removed, path)
+			}
+		}
+		if len(removed) > 0 {
+			if err := h.storage.ReplaceSourceFiles(ctx, projectID, files); err != nil {
+				log.Printf("Error replacing source files for project %s: %v", projectID, err)
+			} else {
+				h.changes.PublishFiles(projectID, "create", nil, removed)
+			}
+		}
+	}
+	if err := h.storage.StorePrompt(ctx, projectID, prompt); err != nil {
+		log.Printf("Error storing prompt for project %s: %v", projectID, err)
+	}
+}
 
-	// Compile via Node Build
-	compiledFiles, err := h.nodeBuildClient.Build(ctx, files)
+// compileAndStore builds source files through the build queue and stores
+// the compiled output in the given channel, publishing it under the
+// context's build trigger. It returns the build's error once it has run.
+func (h *Handlers) compileAndStore(ctx context.Context, projectID string, files map[string]string, channel string) error {
+	err := <-h.builds.Submit(ctx, projectID, channel, func(ctx context.Context) error {
+		return h.compileAndStoreNow(ctx, projectID, files, channel)
+	})
 	if err != nil {
-		log.Printf("Error compiling project %s: %v", projectID, err)
-		return
+		log.Printf("Error building project %s: %v", projectID, redactPayload(err))
+		return err
 	}
+	log.Printf("Successfully compiled and stored project %s", projectID)
+	return nil
+}
 
-	// Store compiled files
-	if err := h.storage.StoreCompiledFiles(ctx, projectID, compiledFiles); err != nil {
-		log.Printf("Error storing compiled files for project %s: %v", projectID, err)
+// compileAndStoreNow is a compileAndStore build, run by the queue.
+func (h *Handlers) compileAndStoreNow(ctx context.Context, projectID string, files map[string]string, channel string) error {
+	// Compile via Node Build
+	compiledFiles, err := h.buildWithRepair(ctx, projectID, files)
+	if err != nil {
+		return err
+	}
+	compiledFiles, err = h.postProcessBuild(ctx, projectID, compiledFiles)
+	if err != nil {
+		return fmt.Errorf("post-processing failed: %w", err)
 	}
 
-	log.Printf("Successfully compiled and stored project %s", projectID)
+	// Store compiled files, unless a stalled holder's lock has been taken
+	// over meanwhile
+	if err := h.checkLock(ctx, projectID); err != nil {
+		return err
+	}
+	if channel == ChannelStaging {
+		err = h.storage.StoreStagingFiles(ctx, projectID, compiledFiles)
+	} else {
+		err = h.storage.StoreCompiledFiles(ctx, projectID, compiledFiles)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store compiled files: %w", err)
+	}
+	h.changes.PublishCompiled(projectID, buildTrigger(ctx))
+	if channel == ChannelProduction {
+		h.ensureTitle(ctx, projectID, "", "")
+		h.snapshotVersion(ctx, projectID, buildTrigger(ctx), "")
+		h.queueLighthouse(projectID, buildTrigger(ctx))
+	}
+	return nil
 }
 
 // StateResponse is the response for the state endpoint.
@@ -433,6 +1310,7 @@ type StateResponse struct {
 	HasApp       bool            `json:"hasApp"`
 	Conversation json.RawMessage `json:"conversation,omitempty"`
 	Metadata     *AppMetadata    `json:"metadata,omitempty"`
+	Warnings     []QuotaWarning  `json:"warnings,omitempty"`
 }
 
 // HandleGetState returns the current state of a project.
@@ -443,20 +1321,24 @@ func (h *Handlers) HandleGetState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := StateResponse{
-		HasApp: h.storage.HasApp(r.Context(), projectID),
+	state, err := h.storage.GetState(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to get state", err))
+		return
 	}
 
-	// Try to get conversation
-	conversation, err := h.storage.GetConversation(r.Context(), projectID)
-	if err == nil {
-		resp.Conversation = conversation
+	resp := StateResponse{
+		HasApp:       state.Metadata != nil,
+		Conversation: state.Conversation,
+		Metadata:     state.Metadata,
+		Warnings:     state.Warnings,
 	}
 
-	// Try to get metadata
-	metadata, err := h.storage.GetMetadata(r.Context(), projectID)
-	if err == nil {
-		resp.Metadata = metadata
+	// Prefer the summary in the client's language
+	if resp.Metadata != nil {
+		if summary, ok := resp.Metadata.Summaries[requestLanguage(r)]; ok {
+			resp.Metadata.Summary = summary
+		}
 	}
 
 	writeJSON(w, http.StatusOK, resp)
@@ -476,10 +1358,16 @@ func (h *Handlers) HandleSaveConversation(w http.ResponseWriter, r *http.Request
 	}
 
 	var req SaveConversationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "conversation")
+	if !ok {
 		return
 	}
+	defer release()
 
 	if err := h.storage.StoreConversation(r.Context(), projectID, req.Messages); err != nil {
 		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store conversation: %v", err)})
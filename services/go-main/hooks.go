@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Actions that hooks and schedules can trigger.
+const (
+	ActionRebuild = "rebuild"
+	ActionEdit    = "edit"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the request body, GitHub style.
+const signatureHeader = "X-Hub-Signature-256"
+
+// hookTimestampHeader carries the Unix time a hook trigger was sent at,
+// which its signature covers.
+const hookTimestampHeader = "X-Hook-Timestamp"
+
+// hookReplayWindow is how far a hook trigger's timestamp may be from now.
+const hookReplayWindow = 5 * time.Minute
+
+// maxHookSignatures caps the signatures remembered for the latest
+// timestamp, against which replays are checked.
+const maxHookSignatures = 100
+
+var hookIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Hook is an incoming webhook definition stored per project.
+type Hook struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	// Prompt is a text/template rendered with PromptTemplateData for edit hooks.
+	Prompt string `json:"prompt,omitempty"`
+	// SealedSecret is the signing secret encrypted with SECRETS_KEY; the
+	// plaintext is only returned when the hook is saved.
+	SealedSecret []byte `json:"sealed_secret"`
+	// LastTimestamp is the timestamp of the newest accepted trigger, and
+	// LastSignatures the signatures accepted with it. Older triggers, and
+	// these again, are refused as replays.
+	LastTimestamp  int64     `json:"last_timestamp,omitempty"`
+	LastSignatures []string  `json:"last_signatures,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SaveHookResponse is the response for saving a hook, the only one that
+// carries its secret.
+type SaveHookResponse struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	Prompt    string    `json:"prompt,omitempty"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PromptTemplateData is the data available to hook and schedule prompt templates.
+type PromptTemplateData struct {
+	Now     time.Time
+	Payload map[string]any
+}
+
+// SaveHookRequest is the request body for registering a hook.
+type SaveHookRequest struct {
+	Action string `json:"action"`
+	Prompt string `json:"prompt"`
+	Secret string `json:"secret"`
+}
+
+// HandleSaveHook creates or replaces a webhook definition. The secret is
+// stored encrypted, so hooks need SECRETS_KEY, and is only returned in
+// this response; if omitted one is generated.
+func (h *Handlers) HandleSaveHook(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	hookID := chi.URLParam(r, "hookID")
+	if !hookIDPattern.MatchString(hookID) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid hook ID"})
+		return
+	}
+
+	var req SaveHookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	switch req.Action {
+	case ActionRebuild:
+	case ActionEdit:
+		if req.Prompt == "" {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "Prompt is required for edit hooks"})
+			return
+		}
+		if _, err := template.New(hookID).Parse(req.Prompt); err != nil {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid prompt template: %v", err)})
+			return
+		}
+	default:
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Action must be 'rebuild' or 'edit'"})
+		return
+	}
+
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			writeError(w, err)
+			return
+		}
+		req.Secret = hex.EncodeToString(secret)
+	}
+
+	sealed, err := h.secrets.Seal([]byte(req.Secret))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	hook := Hook{
+		ID:           hookID,
+		Action:       req.Action,
+		Prompt:       req.Prompt,
+		SealedSecret: sealed,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := h.storage.StoreHook(r.Context(), projectID, &hook, ""); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store hook: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SaveHookResponse{
+		ID:        hook.ID,
+		Action:    hook.Action,
+		Prompt:    hook.Prompt,
+		Secret:    req.Secret,
+		CreatedAt: hook.CreatedAt,
+	})
+}
+
+// HandleDeleteHook removes a webhook definition.
+func (h *Handlers) HandleDeleteHook(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.storage.DeleteHook(r.Context(), projectID, chi.URLParam(r, "hookID")); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to delete hook: %v", err)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTriggerHook verifies a webhook's signature and runs its action in
+// the background. The signature covers "{timestamp}.{body}", with the
+// timestamp sent in X-Hook-Timestamp, which must be within
+// hookReplayWindow of now and no older than the last accepted trigger's;
+// a trigger accepted once is refused if sent again.
+func (h *Handlers) HandleTriggerHook(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	hook, etag, err := h.storage.GetHook(r.Context(), projectID, chi.URLParam(r, "hookID"))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "Hook not found"})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Failed to read request body"})
+		return
+	}
+
+	secret, err := h.secrets.Open(hook.SealedSecret)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Invalid signature"})
+		return
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(hookTimestampHeader), 10, 64)
+	signature := r.Header.Get(signatureHeader)
+	signed := append([]byte(strconv.FormatInt(timestamp, 10)+"."), body...)
+	if err != nil || !verifySignature(string(secret), signed, signature) {
+		writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Invalid signature"})
+		return
+	}
+
+	// Record the trigger before running it, conditionally, so a replay
+	// racing it is refused too
+	sent := time.Unix(timestamp, 0)
+	if time.Since(sent).Abs() > hookReplayWindow || timestamp < hook.LastTimestamp ||
+		(timestamp == hook.LastTimestamp && slices.Contains(hook.LastSignatures, signature)) {
+		writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Stale or replayed hook trigger"})
+		return
+	}
+	if timestamp > hook.LastTimestamp {
+		hook.LastTimestamp, hook.LastSignatures = timestamp, nil
+	}
+	hook.LastSignatures = append(hook.LastSignatures, signature)
+	if len(hook.LastSignatures) > maxHookSignatures {
+		hook.LastSignatures = hook.LastSignatures[len(hook.LastSignatures)-maxHookSignatures:]
+	}
+	err = h.storage.StoreHook(r.Context(), projectID, hook, etag)
+	if errors.Is(err, ErrPreconditionFailed) {
+		writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Stale or replayed hook trigger"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to record hook trigger", err))
+		return
+	}
+
+	var prompt string
+	if hook.Action == ActionEdit {
+		prompt, err = renderHookPrompt(hook, body)
+		if err != nil {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Failed to render prompt: %v", err)})
+			return
+		}
+	}
+
+	go h.runHook(projectID, hook, prompt)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// runHook executes a hook action detached from the triggering request.
+func (h *Handlers) runHook(projectID string, hook *Hook, prompt string) {
+	if err := h.runAction(withBuildTrigger(context.Background(), "hook"), projectID, hook.Action, prompt); err != nil {
+		log.Printf("Hook %s failed for project %s: %v", hook.ID, projectID, redactPayload(err))
+	}
+}
+
+// runAction runs a rebuild or an agent edit with the given prompt, the
+// latter through the same path and within the same GENERATION_TIMEOUT as
+// edit requests. It fails with ErrProjectBusy if another change to the
+// project is in progress.
+func (h *Handlers) runAction(ctx context.Context, projectID, action, prompt string) error {
+	release, err := h.locks.Acquire(ctx, projectID, action)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	switch action {
+	case ActionRebuild:
+		return h.rebuild(ctx, projectID)
+	case ActionEdit:
+		ctx, cancel := context.WithTimeout(ctx, h.cfg.GenerationTimeout)
+		defer cancel()
+		agentCtx, err := h.projectAgentContext(ctx, projectID, nil)
+		if err != nil {
+			return err
+		}
+		_, err = h.editApp(ctx, agentCtx, projectID, prompt, buildTrigger(ctx), defaultLanguage)
+		return err
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
+
+// rebuild compiles the project's current source files through the build
+// queue and stores the output, returning once the build has run.
+func (h *Handlers) rebuild(ctx context.Context, projectID string) error {
+	files, err := h.storage.GetSourceFiles(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return ErrNotFound
+	}
+	return <-h.builds.Submit(ctx, projectID, ChannelProduction, func(ctx context.Context) error {
+		return h.rebuildNow(ctx, projectID, files)
+	})
+}
+
+// rebuildNow is a rebuild, run by the queue.
+func (h *Handlers) rebuildNow(ctx context.Context, projectID string, files map[string]string) error {
+	compiledFiles, err := h.buildWithRepair(ctx, projectID, files)
+	if err != nil {
+		return err
+	}
+	compiledFiles, err = h.postProcessBuild(ctx, projectID, compiledFiles)
+	if err != nil {
+		return err
+	}
+	if err := h.checkLock(ctx, projectID); err != nil {
+		return err
+	}
+	if err := h.storage.StoreCompiledFiles(ctx, projectID, compiledFiles); err != nil {
+		return err
+	}
+	h.changes.PublishCompiled(projectID, ActionRebuild)
+	h.queueLighthouse(projectID, buildTrigger(ctx))
+	return nil
+}
+
+// verifySignature checks a "sha256=<hex>" HMAC signature of body.
+func verifySignature(secret string, body []byte, signature string) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// renderHookPrompt renders an edit hook's prompt template against the payload.
+func renderHookPrompt(hook *Hook, body []byte) (string, error) {
+	data := PromptTemplateData{Now: time.Now().UTC()}
+	if len(bytes.TrimSpace(body)) > 0 {
+		// Non-object payloads are allowed, they just aren't exposed to the template
+		_ = json.Unmarshal(body, &data.Payload)
+	}
+	return renderPrompt(hook.ID, hook.Prompt, data)
+}
+
+// renderPrompt renders a prompt template, treating missing keys as empty.
+func renderPrompt(name, prompt string, data PromptTemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(prompt)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
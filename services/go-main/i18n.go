@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when the client doesn't ask for a supported language.
+const defaultLanguage = "en"
+
+// messageCatalog maps English messages to their translations by language.
+// Messages missing from the catalog are served in English.
+var messageCatalog = map[string]map[string]string{
+	"es": {
+		"Not found":                                   "No encontrado",
+		"Invalid request":                             "Solicitud no válida",
+		"Invalid project ID":                          "ID de proyecto no válido",
+		"Invalid JSON":                                "JSON no válido",
+		"Invalid JSON in request body":                "JSON no válido en el cuerpo de la solicitud",
+		"Failed to read request body":                 "No se pudo leer el cuerpo de la solicitud",
+		"Prompt is required":                          "Se requiere una instrucción",
+		"No app exists for this project":              "No existe ninguna aplicación para este proyecto",
+		"No app generated yet":                        "Todavía no se ha generado ninguna aplicación",
+		"No version of this app from that time":       "No hay ninguna versión de esta aplicación de esa fecha",
+		"Asset not found":                             "Recurso no encontrado",
+		"Agent returned invalid files":                "El agente devolvió archivos no válidos",
+		"Internal server error":                       "Error interno del servidor",
+		"Request timed out":                           "La solicitud superó el tiempo límite",
+		"Temporarily unavailable, please retry later": "No disponible temporalmente, inténtalo más tarde",
+		"Some files could not be stored":              "No se pudieron guardar algunos archivos",
+		"Stored content failed an integrity check":    "El contenido almacenado no superó la comprobación de integridad",
+		"Streaming not supported":                     "Streaming no compatible",
+		"Failed to create app":                        "No se pudo crear la aplicación",
+		"Failed to edit app":                          "No se pudo editar la aplicación",
+		"Failed to store app":                         "No se pudo guardar la aplicación",
+		"Failed to update app":                        "No se pudo actualizar la aplicación",
+		"Failed to get existing files":                "No se pudieron obtener los archivos existentes",
+	},
+	"fr": {
+		"Not found":                                   "Introuvable",
+		"Invalid request":                             "Requête invalide",
+		"Invalid project ID":                          "Identifiant de projet invalide",
+		"Invalid JSON":                                "JSON invalide",
+		"Invalid JSON in request body":                "JSON invalide dans le corps de la requête",
+		"Failed to read request body":                 "Impossible de lire le corps de la requête",
+		"Prompt is required":                          "Une instruction est requise",
+		"No app exists for this project":              "Aucune application n'existe pour ce projet",
+		"No app generated yet":                        "Aucune application générée pour l'instant",
+		"No version of this app from that time":       "Aucune version de cette application à cette date",
+		"Asset not found":                             "Ressource introuvable",
+		"Agent returned invalid files":                "L'agent a renvoyé des fichiers invalides",
+		"Internal server error":                       "Erreur interne du serveur",
+		"Request timed out":                           "La requête a expiré",
+		"Temporarily unavailable, please retry later": "Temporairement indisponible, veuillez réessayer plus tard",
+		"Some files could not be stored":              "Certains fichiers n'ont pas pu être enregistrés",
+		"Stored content failed an integrity check":    "Le contenu stocké a échoué au contrôle d'intégrité",
+		"Streaming not supported":                     "Streaming non pris en charge",
+		"Failed to create app":                        "Impossible de créer l'application",
+		"Failed to edit app":                          "Impossible de modifier l'application",
+		"Failed to store app":                         "Impossible d'enregistrer l'application",
+		"Failed to update app":                        "Impossible de mettre à jour l'application",
+		"Failed to get existing files":                "Impossible de récupérer les fichiers existants",
+	},
+	"de": {
+		"Not found":                                   "Nicht gefunden",
+		"Invalid request":                             "Ungültige Anfrage",
+		"Invalid project ID":                          "Ungültige Projekt-ID",
+		"Invalid JSON":                                "Ungültiges JSON",
+		"Invalid JSON in request body":                "Ungültiges JSON im Anfragetext",
+		"Failed to read request body":                 "Anfragetext konnte nicht gelesen werden",
+		"Prompt is required":                          "Eine Anweisung ist erforderlich",
+		"No app exists for this project":              "Für dieses Projekt existiert keine App",
+		"No app generated yet":                        "Noch keine App generiert",
+		"No version of this app from that time":       "Keine Version dieser App aus dieser Zeit",
+		"Asset not found":                             "Ressource nicht gefunden",
+		"Agent returned invalid files":                "Der Agent hat ungültige Dateien zurückgegeben",
+		"Internal server error":                       "Interner Serverfehler",
+		"Request timed out":                           "Zeitüberschreitung der Anfrage",
+		"Temporarily unavailable, please retry later": "Vorübergehend nicht verfügbar, bitte später erneut versuchen",
+		"Some files could not be stored":              "Einige Dateien konnten nicht gespeichert werden",
+		"Stored content failed an integrity check":    "Gespeicherte Inhalte haben die Integritätsprüfung nicht bestanden",
+		"Streaming not supported":                     "Streaming wird nicht unterstützt",
+		"Failed to create app":                        "App konnte nicht erstellt werden",
+		"Failed to edit app":                          "App konnte nicht bearbeitet werden",
+		"Failed to store app":                         "App konnte nicht gespeichert werden",
+		"Failed to update app":                        "App konnte nicht aktualisiert werden",
+		"Failed to get existing files":                "Vorhandene Dateien konnten nicht abgerufen werden",
+	},
+}
+
+// supportedLanguages lists every language we can respond in.
+var supportedLanguages = []string{defaultLanguage, "es", "fr", "de"}
+
+type languageContextKey struct{}
+
+// languageWriter carries the negotiated language to writeError, which only
+// has the ResponseWriter to hand.
+type languageWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+// Flush implements http.Flusher so streaming handlers keep working.
+func (w *languageWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker so WebSocket upgrades keep working.
+func (w *languageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *languageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// LanguageMiddleware negotiates the response language from Accept-Language.
+func LanguageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+		ctx := context.WithValue(r.Context(), languageContextKey{}, lang)
+		next.ServeHTTP(&languageWriter{ResponseWriter: w, lang: lang}, r.WithContext(ctx))
+	})
+}
+
+// requestLanguage returns the language negotiated for a request.
+func requestLanguage(r *http.Request) string {
+	if lang, ok := r.Context().Value(languageContextKey{}).(string); ok {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// responseLanguage returns the language negotiated for a response.
+func responseLanguage(w http.ResponseWriter) string {
+	if lw, ok := w.(*languageWriter); ok {
+		return lw.lang
+	}
+	return defaultLanguage
+}
+
+// negotiateLanguage picks the supported language with the highest q-value.
+func negotiateLanguage(header string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		// Only the primary subtag matters, "fr-CA" is served as "fr"
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if slices.Contains(supportedLanguages, primary) && q > 0 {
+			candidates = append(candidates, candidate{primary, q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return defaultLanguage
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].lang
+}
+
+// localize translates a message. Messages of the form "Prefix: detail" have
+// their prefix translated and the detail left as is.
+func localize(lang, msg string) string {
+	catalog := messageCatalog[lang]
+	if catalog == nil {
+		return msg
+	}
+	if translated, ok := catalog[msg]; ok {
+		return translated
+	}
+	if prefix, detail, ok := strings.Cut(msg, ": "); ok {
+		if translated, ok := catalog[prefix]; ok {
+			return translated + ": " + detail
+		}
+	}
+	return msg
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// ClientIdentity is who a request came from, as resolved from the peer
+// address and headers set by trusted proxies.
+type ClientIdentity struct {
+	IP       string `json:"ip"`
+	User     string `json:"user,omitempty"`
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Tier is the API key's priority tier (see priority.go).
+	Tier string `json:"tier,omitempty"`
+}
+
+type identityKey struct{}
+
+// requestIdentity returns the identity resolved for the request.
+func requestIdentity(ctx context.Context) ClientIdentity {
+	identity, _ := ctx.Value(identityKey{}).(ClientIdentity)
+	return identity
+}
+
+// IdentityResolver resolves client identity, only believing forwarding and
+// identity headers when the direct peer is a trusted proxy.
+type IdentityResolver struct {
+	trusted    []netip.Prefix
+	userHeader string
+	keyHeader  string
+	tierHeader string
+}
+
+// NewIdentityResolver creates a resolver trusting the given proxy CIDRs.
+func NewIdentityResolver(trustedProxies []string, userHeader, keyHeader, tierHeader string) (*IdentityResolver, error) {
+	trusted, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy list: %w", err)
+	}
+	return &IdentityResolver{trusted: trusted, userHeader: userHeader, keyHeader: keyHeader, tierHeader: tierHeader}, nil
+}
+
+// clientIP walks X-Forwarded-For from the right, skipping trusted proxies,
+// and returns the first address a trusted hop vouched for.
+func (ir *IdentityResolver) clientIP(peer netip.Addr, r *http.Request) netip.Addr {
+	if !containsAddr(ir.trusted, peer) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !containsAddr(ir.trusted, client) {
+			break
+		}
+	}
+	if client == peer {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			client = addr.Unmap()
+		}
+	}
+	return client
+}
+
+// Middleware resolves the client identity, sets r.RemoteAddr to the client
+// IP, and records the identity on the request span. It replaces chi's
+// RealIP, which trusts forwarding headers from anyone.
+func (ir *IdentityResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var identity ClientIdentity
+		if peer, ok := remoteAddr(r); ok {
+			client := ir.clientIP(peer, r)
+			identity.IP = client.String()
+			r.RemoteAddr = identity.IP
+
+			// Identity headers are only meaningful when a trusted proxy set them
+			if containsAddr(ir.trusted, peer) {
+				identity.User = r.Header.Get(ir.userHeader)
+				identity.APIKeyID = r.Header.Get(ir.keyHeader)
+				identity.Tier = parseTier(r.Header.Get(ir.tierHeader))
+			}
+		}
+
+		span := oteltrace.SpanFromContext(r.Context())
+		span.SetAttributes(attribute.String("client.address", identity.IP))
+		if identity.User != "" {
+			span.SetAttributes(attribute.String("enduser.id", identity.User))
+		}
+		if identity.APIKeyID != "" {
+			span.SetAttributes(attribute.String("enduser.api_key_id", identity.APIKeyID))
+		}
+		if identity.Tier != "" {
+			span.SetAttributes(attribute.String("enduser.tier", identity.Tier))
+		}
+
+		ctx := context.WithValue(r.Context(), identityKey{}, identity)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
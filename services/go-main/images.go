@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// optimizedImageExtensions are the image types optimize_images builds add
+// variants of.
+var optimizedImageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true}
+
+// isOptimizedImage reports whether assetPath is an image optimize_images
+// builds add variants of.
+func isOptimizedImage(assetPath string) bool {
+	return optimizedImageExtensions[strings.ToLower(path.Ext(assetPath))]
+}
+
+// responsiveWidths are the widths of resized image variants. Keep in sync
+// with RESPONSIVE_WIDTHS in node-build.
+var responsiveWidths = []int{480, 960, 1600}
+
+// acceptsType reports whether an Accept header lists mediaType with a
+// nonzero q-value. Wildcards don't count: browsers send image/* whether or
+// not they can decode the newer formats.
+func acceptsType(header, mediaType string) bool {
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), mediaType) {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// imageVariants returns the optimized variants of the compiled image at
+// assetPath the request could be served, best first: the smallest resized
+// copy at least ?w= pixels wide, then the full size image, each in AVIF or
+// WebP if the Accept header allows.
+func imageVariants(r *http.Request, assetPath string) []string {
+	if !isOptimizedImage(assetPath) {
+		return nil
+	}
+	ext := path.Ext(assetPath)
+	stem := strings.TrimSuffix(assetPath, ext)
+
+	var formats []string
+	accept := r.Header.Get("Accept")
+	if acceptsType(accept, "image/avif") {
+		formats = append(formats, ".avif")
+	}
+	if acceptsType(accept, "image/webp") {
+		formats = append(formats, ".webp")
+	}
+
+	var variants []string
+	if width, err := strconv.Atoi(r.URL.Query().Get("w")); err == nil && width > 0 {
+		for _, size := range responsiveWidths {
+			if size >= width {
+				for _, format := range append(formats, ext) {
+					variants = append(variants, fmt.Sprintf("%s.%dw%s", stem, size, format))
+				}
+				break
+			}
+		}
+	}
+	for _, format := range formats {
+		variants = append(variants, stem+format)
+	}
+	return variants
+}
+
+// negotiateImage returns the compiled file to serve for a request for the
+// image at assetPath: its best variant in the production build, or
+// assetPath itself. Variants are looked up in the metadata hashes rather
+// than read.
+func (h *Handlers) negotiateImage(r *http.Request, projectID, assetPath string) string {
+	variants := imageVariants(r, assetPath)
+	if len(variants) == 0 {
+		return assetPath
+	}
+	meta, err := h.storage.GetMetadata(r.Context(), projectID)
+	if err != nil {
+		return assetPath
+	}
+	for _, variant := range variants {
+		if meta.Hashes["compiled/"+variant] != "" {
+			return variant
+		}
+	}
+	return assetPath
+}
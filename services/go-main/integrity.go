@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// IntegrityErrorCode identifies integrity failures in error responses.
+const IntegrityErrorCode = "integrity_error"
+
+// integrityErrors counts stored files whose content didn't match the hash
+// recorded in the manifest when they were read.
+var integrityErrors, _ = otel.Meter("go-main").Int64Counter(
+	"storage.integrity_errors",
+	metric.WithDescription("Files read from storage whose content did not match the manifest hash"),
+)
+
+// IntegrityError reports that storage returned content whose hash doesn't
+// match the manifest, meaning it was corrupted or truncated.
+type IntegrityError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("content of %s does not match its manifest hash (expected %s, got %s)", e.Key, e.Expected, e.Actual)
+}
+
+// IntegrityErrorResponse is the JSON body for an integrity failure.
+type IntegrityErrorResponse struct {
+	Message string `json:"error"`
+	Code    string `json:"code"`
+	Key     string `json:"key"`
+}
+
+// reportIntegrityError records a hash mismatch for key in the log, the
+// integrity error counter and the current span.
+func reportIntegrityError(ctx context.Context, projectID, key, expected, actual string, size int) *IntegrityError {
+	log.Printf("Integrity error for project %s key %s: expected %s, got %s (%d bytes)", projectID, key, expected, actual, size)
+	prefix, _, _ := strings.Cut(key, "/")
+	attrs := attribute.NewSet(attribute.String("prefix", prefix))
+	integrityErrors.Add(ctx, 1, metric.WithAttributeSet(attrs))
+	oteltrace.SpanFromContext(ctx).AddEvent("integrity error", oteltrace.WithAttributes(
+		attribute.String("storage.key", key),
+		attribute.String("storage.expected_hash", expected),
+		attribute.String("storage.actual_hash", actual),
+	))
+	return &IntegrityError{Key: key, Expected: expected, Actual: actual}
+}
+
+// verifyContent checks content read from key against its manifest hash.
+// Keys without a recorded hash pass.
+func verifyContent(ctx context.Context, projectID, key string, content []byte, hashes map[string]string) error {
+	expected, ok := hashes[key]
+	if !ok {
+		return nil
+	}
+	actual := contentETag(content)
+	if actual == expected {
+		return nil
+	}
+
+	return reportIntegrityError(ctx, projectID, key, expected, actual, len(content))
+}
+
+// verifyingReader checks a streamed file against its manifest hash as it is
+// read. A mismatch can only be found once the content has been read, so it
+// is returned as the error at the end of the stream instead of io.EOF.
+type verifyingReader struct {
+	io.ReadCloser
+	ctx       context.Context
+	projectID string
+	key       string
+	expected  string
+	hash      hash.Hash
+	size      int
+}
+
+// verifyStream wraps stream to check it against key's manifest hash, if
+// there is one.
+func verifyStream(ctx context.Context, projectID, key string, stream *StoredStream, hashes map[string]string) *StoredStream {
+	expected, ok := hashes[key]
+	if !ok {
+		return stream
+	}
+	stream.ReadCloser = &verifyingReader{ReadCloser: stream.ReadCloser, ctx: ctx, projectID: projectID, key: key, expected: expected, hash: md5.New()}
+	return stream
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	v.size += n
+	if errors.Is(err, io.EOF) {
+		if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+			return n, reportIntegrityError(v.ctx, v.projectID, v.key, v.expected, actual, v.size)
+		}
+	}
+	return n, err
+}
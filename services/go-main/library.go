@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// libraryRefPrefix marks a reference to an asset in the owner's library.
+const libraryRefPrefix = "library://"
+
+// libraryAssetsDir is where library references point among the compiled
+// files; requests under it are served from the project's linked library.
+const libraryAssetsDir = "assets/library/"
+
+// maxLibraryAssets caps how many assets one library holds.
+const maxLibraryAssets = 500
+
+// defaultLibraryOwner owns the library when requests carry no identity,
+// i.e. without AUTH_ENABLED or a user header.
+const defaultLibraryOwner = "default"
+
+// libraryNamespace is the UUID namespace library namespaces are derived
+// from, so each owner's library has a stable storage namespace.
+var libraryNamespace = uuid.MustParse("6f1d8c52-3a0e-4b7f-9c1e-5d2a7b40e913")
+
+// libraryRefPattern matches library:// references in compiled files.
+var libraryRefPattern = regexp.MustCompile(`library://([A-Za-z0-9_][A-Za-z0-9._/-]*)`)
+
+// libraryID returns the storage namespace of an owner's library, a
+// reserved namespace project routes can't reach.
+func libraryID(owner string) string {
+	return reserveUUID(uuid.NewSHA1(libraryNamespace, []byte(owner)))
+}
+
+// resolveLibraryRefs points library:// references in compiled HTML, JS and
+// CSS at assets/library/, where HandleAsset serves the linked library's
+// current asset. Assets aren't copied, so re-uploading one updates every
+// project using it without a rebuild.
+func resolveLibraryRefs(compiledFiles map[string]string) map[string]string {
+	var out map[string]string
+	for name, content := range compiledFiles {
+		ext := path.Ext(name)
+		if (ext != ".html" && ext != ".js" && ext != ".css") || !strings.Contains(content, libraryRefPrefix) {
+			continue
+		}
+		if out == nil {
+			out = maps.Clone(compiledFiles)
+		}
+		out[name] = libraryRefPattern.ReplaceAllStringFunc(content, func(ref string) string {
+			target := libraryAssetsDir + strings.TrimPrefix(ref, libraryRefPrefix)
+			// CSS urls resolve against the stylesheet, everything else
+			// against the page
+			if dir := path.Dir(name) + "/"; ext == ".css" && strings.HasPrefix(target, dir) {
+				return strings.TrimPrefix(target, dir)
+			}
+			return "./" + target
+		})
+	}
+	if out == nil {
+		return compiledFiles
+	}
+	return out
+}
+
+// libraryOwner returns whose library a request acts on: the user from
+// IDENTITY_USER_HEADER, else the API key's ID, else the default owner.
+// Routes outside the project API authenticate here, requiring a write key
+// for scope write; it writes the error response and returns false if the
+// request isn't allowed.
+func (h *Handlers) libraryOwner(w http.ResponseWriter, r *http.Request, scope string) (string, bool) {
+	identity := requestIdentity(r.Context())
+	if h.cfg.AuthEnabled && identity.APIKeyID == "" {
+		secret := bearerToken(r)
+		if secret == "" {
+			writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+			return "", false
+		}
+		key, err := h.authenticate(r.Context(), secret)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+			return "", false
+		}
+		if err != nil {
+			writeError(w, upstreamError("Failed to check API key", err))
+			return "", false
+		}
+		if scope == ScopeWrite && key.Scope != ScopeWrite {
+			writeError(w, AppError{Code: http.StatusForbidden, Message: "API key lacks write access to the library"})
+			return "", false
+		}
+		identity.APIKeyID = key.ID
+	}
+	return identityOwner(identity), true
+}
+
+// identityOwner returns the library owner of an identity.
+func identityOwner(identity ClientIdentity) string {
+	switch {
+	case identity.User != "":
+		return identity.User
+	case identity.APIKeyID != "":
+		return identity.APIKeyID
+	default:
+		return defaultLibraryOwner
+	}
+}
+
+// libraryAssetName reads and checks the asset name from the URL, writing
+// the error response and returning false if it is invalid.
+func libraryAssetName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "*")
+	if problem := validatePath(name); problem != "" || !libraryRefPattern.MatchString(libraryRefPrefix+name) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid asset name"})
+		return "", false
+	}
+	return name, true
+}
+
+// LibraryAsset describes an asset in a library.
+type LibraryAsset struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Hash     string `json:"hash"`
+	// Reference is what apps use to refer to the asset.
+	Reference string `json:"reference"`
+}
+
+// LibraryResponse is the response for listing a library.
+type LibraryResponse struct {
+	Owner  string         `json:"owner"`
+	Assets []LibraryAsset `json:"assets"`
+}
+
+// HandleListLibrary lists the caller's library assets.
+func (h *Handlers) HandleListLibrary(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.libraryOwner(w, r, ScopeRead)
+	if !ok {
+		return
+	}
+	entries, err := h.storage.ListLibraryAssets(r.Context(), libraryID(owner))
+	if err != nil {
+		writeError(w, upstreamError("Failed to list library", err))
+		return
+	}
+
+	resp := LibraryResponse{Owner: owner, Assets: make([]LibraryAsset, 0, len(entries))}
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.Key, libraryAssetsPrefix)
+		resp.Assets = append(resp.Assets, LibraryAsset{
+			Name:      name,
+			MimeType:  entry.MimeType,
+			Size:      entry.Size,
+			Hash:      entry.ETag,
+			Reference: libraryRefPrefix + name,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleGetLibraryAsset returns one of the caller's library assets.
+func (h *Handlers) HandleGetLibraryAsset(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.libraryOwner(w, r, ScopeRead)
+	if !ok {
+		return
+	}
+	name, ok := libraryAssetName(w, r)
+	if !ok {
+		return
+	}
+	h.serveLibraryAsset(w, r, libraryID(owner), name)
+}
+
+// HandlePutLibraryAsset uploads an asset to the caller's library from the
+// raw request body, typed by its Content-Type or else its extension.
+func (h *Handlers) HandlePutLibraryAsset(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.libraryOwner(w, r, ScopeWrite)
+	if !ok {
+		return
+	}
+	name, ok := libraryAssetName(w, r)
+	if !ok {
+		return
+	}
+
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(h.cfg.MaxFileSize)))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, AppError{Code: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Asset exceeds %d bytes", h.cfg.MaxFileSize)})
+		return
+	}
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Failed to read request body"})
+		return
+	}
+	if len(content) == 0 {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Asset is empty"})
+		return
+	}
+	mimeType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = getMimeType(name)
+	}
+
+	library := libraryID(owner)
+	entries, err := h.storage.ListLibraryAssets(r.Context(), library)
+	if err != nil {
+		writeError(w, upstreamError("Failed to list library", err))
+		return
+	}
+	if len(entries) >= maxLibraryAssets && !slices.ContainsFunc(entries, func(e KeyInfo) bool { return e.Key == libraryAssetsPrefix+name }) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("A library holds at most %d assets", maxLibraryAssets)})
+		return
+	}
+	if err := h.storage.StoreLibraryAsset(r.Context(), library, name, mimeType, content); err != nil {
+		writeError(w, upstreamError("Failed to store asset", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, LibraryAsset{
+		Name:      name,
+		MimeType:  mimeType,
+		Size:      int64(len(content)),
+		Hash:      contentETag(content),
+		Reference: libraryRefPrefix + name,
+	})
+}
+
+// HandleDeleteLibraryAsset removes an asset from the caller's library.
+// Apps still referring to it get 404 for it.
+func (h *Handlers) HandleDeleteLibraryAsset(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.libraryOwner(w, r, ScopeWrite)
+	if !ok {
+		return
+	}
+	name, ok := libraryAssetName(w, r)
+	if !ok {
+		return
+	}
+	if err := h.storage.DeleteLibraryAsset(r.Context(), libraryID(owner), name); err != nil {
+		writeError(w, upstreamError("Failed to delete asset", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetProjectLibrary returns the library the project is linked to.
+func (h *Handlers) HandleGetProjectLibrary(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	lib, err := h.storage.GetProjectLibrary(r.Context(), projectID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Project is not linked to a library"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to load library link", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, lib)
+}
+
+// HandleLinkProjectLibrary links the project to the caller's library, so
+// its library:// references resolve to the caller's assets.
+func (h *Handlers) HandleLinkProjectLibrary(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	owner, ok := h.libraryOwner(w, r, ScopeWrite)
+	if !ok {
+		return
+	}
+
+	lib := &ProjectLibrary{Owner: owner, Namespace: libraryID(owner)}
+	if err := h.storage.StoreProjectLibrary(r.Context(), projectID, lib); err != nil {
+		writeError(w, upstreamError("Failed to store library link", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, lib)
+}
+
+// HandleUnlinkProjectLibrary unlinks the project from its library.
+func (h *Handlers) HandleUnlinkProjectLibrary(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.storage.DeleteProjectLibrary(r.Context(), projectID); err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to delete library link", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveProjectLibraryAsset serves an assets/library/ request of a project
+// from the library it is linked to.
+func (h *Handlers) serveProjectLibraryAsset(w http.ResponseWriter, r *http.Request, projectID, name string) {
+	lib, err := h.storage.GetProjectLibrary(r.Context(), projectID)
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(localize(requestLanguage(r), "Asset not found")))
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to load library link", err))
+		return
+	}
+	if validatePath(name) != "" {
+		writeError(w, ErrNotFound)
+		return
+	}
+	h.viewStats.RecordAsset(projectID)
+	h.serveLibraryAsset(w, r, lib.Namespace, name)
+}
+
+// serveLibraryAsset writes a library asset, revalidated by its hash on
+// every use since it can be replaced under the same name.
+func (h *Handlers) serveLibraryAsset(w http.ResponseWriter, r *http.Request, library, name string) {
+	content, mimeType, err := h.storage.GetLibraryAsset(r.Context(), library, name)
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(localize(requestLanguage(r), "Asset not found")))
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to load asset", err))
+		return
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = getMimeType(name)
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", quoteETag(contentETag(content)))
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+}
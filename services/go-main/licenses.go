@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// LicenseReport lists the npm packages bundled into a project's app and
+// their licenses, as reported by Node Build.
+type LicenseReport struct {
+	// InputHash identifies the source files the report was built from.
+	InputHash    string       `json:"input_hash"`
+	GeneratedAt  time.Time    `json:"generated_at"`
+	Dependencies []Dependency `json:"dependencies"`
+	// Licenses counts the dependencies under each license.
+	Licenses map[string]int `json:"licenses"`
+}
+
+// newLicenseReport summarises the dependencies of a build of the source
+// files with hash inputHash.
+func newLicenseReport(inputHash string, dependencies []Dependency) *LicenseReport {
+	report := &LicenseReport{
+		InputHash:    inputHash,
+		GeneratedAt:  time.Now().UTC(),
+		Dependencies: dependencies,
+		Licenses:     make(map[string]int),
+	}
+	if report.Dependencies == nil {
+		report.Dependencies = []Dependency{}
+	}
+	for _, dep := range report.Dependencies {
+		report.Licenses[dep.License]++
+	}
+	return report
+}
+
+// saveLicenseReport stores the license report of a successful build,
+// logging rather than failing the build if it can't.
+func (h *Handlers) saveLicenseReport(ctx context.Context, projectID, inputHash string, dependencies []Dependency) {
+	report := newLicenseReport(inputHash, dependencies)
+	if err := h.storage.StoreLicenseReport(context.WithoutCancel(ctx), projectID, report); err != nil {
+		log.Printf("Error storing license report for project %s: %v", projectID, err)
+	}
+}
+
+// HandleLicenses returns the npm dependencies bundled into the project's
+// app and their licenses. The report from the last Node Build compile is
+// used if the source hasn't changed since; otherwise (for example after
+// the agent compiled the app itself) the source is compiled to produce one.
+func (h *Handlers) HandleLicenses(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	files, err := h.storage.GetSourceFiles(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load source files", err))
+		return
+	}
+	if len(files) == 0 {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	hash := inputHash(files)
+	report, err := h.storage.GetLicenseReport(r.Context(), projectID)
+	if err == nil && report.InputHash == hash {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to load license report", err))
+		return
+	}
+
+	settings, err := h.projectSettings(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	result, err := h.nodeBuildClient.Build(r.Context(), files, settings.BuildProfile, false)
+	if err != nil {
+		writeError(w, upstreamError("Failed to build app", err))
+		return
+	}
+	report = newLicenseReport(hash, result.Dependencies)
+	if err := h.storage.StoreLicenseReport(r.Context(), projectID, report); err != nil {
+		log.Printf("Error storing license report for project %s: %v", projectID, err)
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxLighthouseRuns is how many audits are kept per project.
+const maxLighthouseRuns = 100
+
+// LighthouseRun is one Lighthouse audit of a project's preview, taken
+// after the build named by Trigger.
+type LighthouseRun struct {
+	At      time.Time          `json:"at"`
+	Trigger string             `json:"trigger"`
+	Scores  map[string]int     `json:"scores"`
+	Metrics map[string]float64 `json:"metrics"`
+	Version string             `json:"lighthouse_version"`
+}
+
+// LighthouseStatsResponse is a project's Lighthouse history.
+type LighthouseStatsResponse struct {
+	// Runs are oldest first.
+	Runs []LighthouseRun `json:"runs"`
+	// Change is each score of the latest run minus the one before it, so a
+	// build that made the app worse shows negative numbers.
+	Change map[string]int `json:"change,omitempty"`
+}
+
+// LighthouseRunner runs at most one audit per project at a time. Builds
+// that finish during a run are coalesced into a single follow-up run.
+type LighthouseRunner struct {
+	mu      sync.Mutex
+	running map[string]bool
+	pending map[string]string
+}
+
+// NewLighthouseRunner creates an idle runner.
+func NewLighthouseRunner() *LighthouseRunner {
+	return &LighthouseRunner{running: make(map[string]bool), pending: make(map[string]string)}
+}
+
+// claim reports whether the caller should start a run for projectID, or
+// queues trigger behind the run in progress.
+func (l *LighthouseRunner) claim(projectID, trigger string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running[projectID] {
+		l.pending[projectID] = trigger
+		return false
+	}
+	l.running[projectID] = true
+	return true
+}
+
+// next returns the trigger of a build that finished during the last run,
+// or releases the project if there was none.
+func (l *LighthouseRunner) next(projectID string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	trigger, ok := l.pending[projectID]
+	if ok {
+		delete(l.pending, projectID)
+		return trigger, true
+	}
+	delete(l.running, projectID)
+	return "", false
+}
+
+// isLighthouse reports whether r comes from a Lighthouse audit, which
+// isn't counted as a view.
+func isLighthouse(r *http.Request) bool {
+	return strings.Contains(r.UserAgent(), "Chrome-Lighthouse")
+}
+
+// queueLighthouse audits the project's preview in the background after a
+// production build, if Lighthouse is configured.
+func (h *Handlers) queueLighthouse(projectID, trigger string) {
+	if h.cfg.LighthouseBaseURL == "" || !h.lighthouse.claim(projectID, trigger) {
+		return
+	}
+	go func() {
+		for {
+			h.runLighthouse(projectID, trigger)
+			next, ok := h.lighthouse.next(projectID)
+			if !ok {
+				return
+			}
+			trigger = next
+		}
+	}()
+}
+
+// runLighthouse audits the preview and records the result.
+func (h *Handlers) runLighthouse(projectID, trigger string) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.LighthouseTimeout)
+	defer cancel()
+
+	pageURL := fmt.Sprintf("%s/api/%s/%s/view", strings.TrimSuffix(h.cfg.LighthouseBaseURL, "/"), APIVersion, projectID)
+	result, err := h.nodeBuildClient.Lighthouse(ctx, pageURL)
+	if err != nil {
+		log.Printf("Lighthouse audit failed for project %s: %v", projectID, redactPayload(err))
+		return
+	}
+
+	run := LighthouseRun{
+		At:      time.Now().UTC(),
+		Trigger: trigger,
+		Scores:  result.Scores,
+		Metrics: result.Metrics,
+		Version: result.Version,
+	}
+	if err := h.storage.AddLighthouseRun(ctx, projectID, run, maxLighthouseRuns); err != nil {
+		log.Printf("Error storing Lighthouse audit for project %s: %v", projectID, err)
+	}
+}
+
+// HandleGetLighthouseStats returns the project's Lighthouse scores over
+// time, with the change from the previous audit.
+func (h *Handlers) HandleGetLighthouseStats(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	runs, err := h.storage.GetLighthouseRuns(r.Context(), projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to load Lighthouse stats", err))
+		return
+	}
+
+	resp := LighthouseStatsResponse{Runs: runs}
+	if resp.Runs == nil {
+		resp.Runs = []LighthouseRun{}
+	}
+	if n := len(runs); n >= 2 {
+		resp.Change = make(map[string]int)
+		for category, score := range runs[n-1].Scores {
+			if previous, ok := runs[n-2].Scores[category]; ok {
+				resp.Change[category] = score - previous
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// projectLockKey holds the lease of the instance currently mutating a
+// project, or the last one, released, with its fencing token.
+const projectLockKey = "_meta/lock.json"
+
+// projectLockPoll is how often a waiting request retries another
+// instance's lease.
+const projectLockPoll = 250 * time.Millisecond
+
+// projectLockRetryAfter is the Retry-After, in seconds, sent with 409 for a
+// busy project.
+const projectLockRetryAfter = 5
+
+// ErrProjectBusy is returned when another mutating operation holds the
+// project's lock.
+var ErrProjectBusy = AppError{Code: http.StatusConflict, Message: "Another change to this project is in progress"}
+
+// ErrLockLost is returned when a lock holder finds its lease was taken over
+// by another instance, for example after it failed to renew it in time, so
+// its writes must not go ahead.
+var ErrLockLost = AppError{Code: http.StatusConflict, Message: "The project's lock was taken over by another instance"}
+
+// projectLease is the lock document stored in the project. An instance that
+// dies leaves it behind until ExpiresAt, after which anyone may take it over.
+// Released leases are kept, expired, so Token keeps increasing: it is a
+// fencing token, and a holder whose token is no longer the stored one has
+// lost the lock.
+type projectLease struct {
+	Owner      string     `json:"owner"`
+	Operation  string     `json:"operation"`
+	Token      int64      `json:"token"`
+	AcquiredAt time.Time  `json:"acquired_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+}
+
+// heldLock is a project lock taken by this instance.
+type heldLock struct {
+	released   chan struct{}
+	operation  string
+	acquiredAt time.Time
+	// token is the lease's fencing token once it has been acquired, and
+	// lost is set if a renewal finds another instance has taken it over.
+	token atomic.Int64
+	lost  atomic.Bool
+}
+
+// ProjectLocks allows one mutating operation per project at a time. Within
+// an instance, requests queue on an in-memory lock; across instances they
+// contend for a lease in storage, created with If-None-Match and renewed or
+// released with If-Match so two instances can never both hold it.
+type ProjectLocks struct {
+	client StorageBackend
+	owner  string
+	ttl    time.Duration
+	wait   time.Duration
+
+	mu   sync.Mutex
+	held map[string]*heldLock
+}
+
+// NewProjectLocks creates a lock manager whose leases last ttl without
+// renewal and whose callers wait up to wait for a busy project.
+func NewProjectLocks(client StorageBackend, ttl, wait time.Duration) *ProjectLocks {
+	return &ProjectLocks{
+		client: client,
+		owner:  uuid.NewString(),
+		ttl:    ttl,
+		wait:   wait,
+		held:   make(map[string]*heldLock),
+	}
+}
+
+// Acquire locks projectID for operation, returning a func that releases it.
+// If the project stays busy for longer than the configured wait it returns
+// ErrProjectBusy.
+func (l *ProjectLocks) Acquire(ctx context.Context, projectID, operation string) (func(), error) {
+	deadline := time.Now().Add(l.wait)
+	held := l.lockLocal(ctx, projectID, operation, deadline)
+	if held == nil {
+		return nil, ErrProjectBusy
+	}
+
+	lease, etag, err := l.acquireLease(ctx, projectID, operation, deadline)
+	if err != nil {
+		l.unlockLocal(projectID)
+		return nil, err
+	}
+	held.token.Store(lease.Token)
+
+	// The lease is renewed and released independently of the request, so a
+	// cancelled client can't leave it behind
+	leaseCtx := context.WithoutCancel(ctx)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				lease.ExpiresAt = time.Now().Add(l.ttl)
+				renewed, renewErr := l.storeLease(leaseCtx, projectID, lease, etag)
+				if errors.Is(renewErr, ErrPreconditionFailed) {
+					log.Printf("Lost lock on project %s (token %d) to another instance", projectID, lease.Token)
+					held.lost.Store(true)
+					return
+				}
+				if renewErr != nil {
+					log.Printf("Failed to renew lock on project %s: %v", projectID, renewErr)
+					continue
+				}
+				etag = renewed
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-stopped
+			if !held.lost.Load() {
+				now := time.Now().UTC()
+				lease.ExpiresAt, lease.ReleasedAt = now, &now
+				if _, err := l.storeLease(leaseCtx, projectID, lease, etag); err != nil {
+					log.Printf("Failed to release lock on project %s: %v", projectID, err)
+				}
+			}
+			l.unlockLocal(projectID)
+		})
+	}, nil
+}
+
+// lockLocal takes the in-memory lock for projectID, waiting until deadline
+// for the current holder to release it, and returns nil if it can't.
+func (l *ProjectLocks) lockLocal(ctx context.Context, projectID, operation string, deadline time.Time) *heldLock {
+	for {
+		l.mu.Lock()
+		current, busy := l.held[projectID]
+		if !busy {
+			held := &heldLock{released: make(chan struct{}), operation: operation, acquiredAt: time.Now().UTC()}
+			l.held[projectID] = held
+			l.mu.Unlock()
+			return held
+		}
+		l.mu.Unlock()
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-current.released:
+			timer.Stop()
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+func (l *ProjectLocks) unlockLocal(projectID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if held, ok := l.held[projectID]; ok {
+		close(held.released)
+		delete(l.held, projectID)
+	}
+}
+
+// Check returns ErrLockLost if this instance holds projectID's lock but its
+// fencing token is no longer the stored lease's, so a holder that stalled
+// past its TTL can't overwrite the next holder's changes. It is a no-op for
+// projects this instance doesn't hold.
+func (l *ProjectLocks) Check(ctx context.Context, projectID string) error {
+	l.mu.Lock()
+	held := l.held[projectID]
+	l.mu.Unlock()
+	if held == nil || held.token.Load() == 0 {
+		return nil
+	}
+	if held.lost.Load() {
+		return ErrLockLost
+	}
+
+	lease, _, err := l.readLease(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if lease == nil || lease.Owner != l.owner || lease.Token != held.token.Load() {
+		held.lost.Store(true)
+		return ErrLockLost
+	}
+	return nil
+}
+
+// acquireLease takes the project's lease, retrying until deadline while
+// another instance holds it, and returns the lease and its stored ETag.
+func (l *ProjectLocks) acquireLease(ctx context.Context, projectID, operation string, deadline time.Time) (*projectLease, string, error) {
+	for {
+		lease, etag, err := l.tryLease(ctx, projectID, operation)
+		if !errors.Is(err, ErrProjectBusy) {
+			return lease, etag, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, "", err
+		}
+		select {
+		case <-time.After(min(remaining, projectLockPoll)):
+		case <-ctx.Done():
+			return nil, "", err
+		}
+	}
+}
+
+// tryLease creates the lease, or takes over one that has been released or
+// has expired with the next fencing token.
+func (l *ProjectLocks) tryLease(ctx context.Context, projectID, operation string) (*projectLease, string, error) {
+	now := time.Now().UTC()
+	lease := &projectLease{Owner: l.owner, Operation: operation, Token: 1, AcquiredAt: now, ExpiresAt: now.Add(l.ttl)}
+	etag, err := l.storeLease(ctx, projectID, lease, "*")
+	if !errors.Is(err, ErrPreconditionFailed) {
+		return lease, etag, err
+	}
+
+	current, currentETag, err := l.readLease(ctx, projectID)
+	if errors.Is(err, ErrNotFound) {
+		// Deleted since we tried; the next attempt can create it
+		return nil, "", ErrProjectBusy
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if time.Now().Before(current.ExpiresAt) {
+		return nil, "", ErrProjectBusy
+	}
+
+	if current.ReleasedAt == nil {
+		log.Printf("Taking over expired lock on project %s from %s", projectID, current.Owner)
+	}
+	lease.Token = current.Token + 1
+	etag, err = l.storeLease(ctx, projectID, lease, currentETag)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil, "", ErrProjectBusy
+	}
+	return lease, etag, err
+}
+
+// readLease returns the project's stored lease and its ETag. A lease that
+// can't be parsed is returned zero, so it counts as expired.
+func (l *ProjectLocks) readLease(ctx context.Context, projectID string) (*projectLease, string, error) {
+	data, _, err := l.client.Get(ctx, projectID, projectLockKey)
+	if err != nil {
+		return nil, "", err
+	}
+	var lease projectLease
+	_ = json.Unmarshal(data, &lease)
+	return &lease, contentETag(data), nil
+}
+
+// storeLease writes lease if the current one has the ETag ifMatch, or "*"
+// for none, and returns the new lease's ETag.
+func (l *ProjectLocks) storeLease(ctx context.Context, projectID string, lease *projectLease, ifMatch string) (string, error) {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return "", err
+	}
+	if err := l.client.StoreIf(ctx, projectID, projectLockKey, "application/json", data, ifMatch); err != nil {
+		return "", err
+	}
+	return contentETag(data), nil
+}
+
+// Remove deletes projectID's lease, once the project itself has been
+// deleted and the lock released.
+func (l *ProjectLocks) Remove(ctx context.Context, projectID string) error {
+	return l.client.Delete(ctx, projectID, projectLockKey)
+}
+
+// lockProject takes the project's lock for operation, writing 409 with
+// Retry-After, or the storage error, and returning false if it can't.
+func (h *Handlers) lockProject(w http.ResponseWriter, r *http.Request, projectID, operation string) (func(), bool) {
+	release, err := h.locks.Acquire(r.Context(), projectID, operation)
+	if errors.Is(err, ErrProjectBusy) {
+		w.Header().Set("Retry-After", strconv.Itoa(projectLockRetryAfter))
+		writeError(w, err)
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to lock project", err))
+		return nil, false
+	}
+	return release, true
+}
+
+// checkLock is Check for handlers, passing ErrLockLost through and wrapping
+// storage errors.
+func (h *Handlers) checkLock(ctx context.Context, projectID string) error {
+	err := h.locks.Check(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrLockLost) {
+		return upstreamError("Failed to check project lock", err)
+	}
+	return err
+}
+
+// LockInfo describes a project lock for the admin API.
+type LockInfo struct {
+	ProjectID  string     `json:"project_id"`
+	Owner      string     `json:"owner,omitempty"`
+	Operation  string     `json:"operation"`
+	Token      int64      `json:"token"`
+	AcquiredAt time.Time  `json:"acquired_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+	// Held is whether the lease is currently in force: acquired, not
+	// released and not expired.
+	Held bool `json:"held"`
+	// Lost is set on this instance's locks whose lease another instance
+	// has taken over.
+	Lost bool `json:"lost,omitempty"`
+}
+
+// Held lists the locks this instance holds, oldest first. Locks still
+// waiting for their lease have a zero token.
+func (l *ProjectLocks) Held() []LockInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	locks := make([]LockInfo, 0, len(l.held))
+	for projectID, held := range l.held {
+		locks = append(locks, LockInfo{
+			ProjectID:  projectID,
+			Owner:      l.owner,
+			Operation:  held.operation,
+			Token:      held.token.Load(),
+			AcquiredAt: held.acquiredAt,
+			Held:       held.token.Load() != 0 && !held.lost.Load(),
+			Lost:       held.lost.Load(),
+		})
+	}
+	slices.SortFunc(locks, func(a, b LockInfo) int { return a.AcquiredAt.Compare(b.AcquiredAt) })
+	return locks
+}
+
+// Lease returns projectID's stored lease, whichever instance holds it.
+func (l *ProjectLocks) Lease(ctx context.Context, projectID string) (*LockInfo, error) {
+	lease, _, err := l.readLease(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &LockInfo{
+		ProjectID:  projectID,
+		Owner:      lease.Owner,
+		Operation:  lease.Operation,
+		Token:      lease.Token,
+		AcquiredAt: lease.AcquiredAt,
+		ExpiresAt:  &lease.ExpiresAt,
+		ReleasedAt: lease.ReleasedAt,
+		Held:       lease.ReleasedAt == nil && time.Now().Before(lease.ExpiresAt),
+	}, nil
+}
+
+// HandleListLocks returns the project locks held by this instance, with
+// its lock owner ID.
+func (h *Handlers) HandleListLocks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"owner": h.locks.owner, "locks": h.locks.Held()})
+}
+
+// HandleGetLock returns a project's stored lease, as seen by every
+// instance.
+func (h *Handlers) HandleGetLock(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	lock, err := h.locks.Lease(r.Context(), projectID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Project has never been locked"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to get lock", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, lock)
+}
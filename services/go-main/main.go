@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,11 +15,18 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/riandyrn/otelchi"
+	"golang.org/x/net/netutil"
 )
 
 func main() {
 	cfg := LoadConfig()
 
+	redaction, err := NewRedaction(cfg.RedactionMode, cfg.RedactionMaxLength)
+	if err != nil {
+		log.Fatalf("Failed to configure redaction: %v", err)
+	}
+	payloadRedaction = redaction
+
 	// Initialize OpenTelemetry
 	ctx := context.Background()
 	shutdown, err := InitTracer(ctx)
@@ -32,13 +40,82 @@ func main() {
 	}()
 
 	// Initialize clients
-	pythonClient := NewPythonAgentClient(cfg.PythonAgentURL)
-	nodeBuildClient := NewNodeBuildClient(cfg.NodeBuildURL)
-	dbClient := NewRustDBClient(cfg.RustDBURL)
-	storage := NewStorage(dbClient)
+	serviceTransport, err := newServiceTransport(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure internal service transport: %v", err)
+	}
+	// Only storage calls are tracked for shedding; agent and build latency
+	// is dominated by generation and compile time
+	health := NewDownstreamHealth(cfg.ShedWindow, cfg.ShedMinRequests, cfg.ShedErrorPercent, cfg.ShedLatency)
+	rustDBTransport := withResilience(withBearerToken(withHealthTracking(withMetrics(serviceTransport, "rust-db"), health), cfg.RustDBToken),
+		"rust-db", cfg.DownstreamRetries, cfg.DownstreamRetryBackoff, NewCircuitBreaker("rust-db", cfg.CircuitBreakerFailures, cfg.CircuitBreakerCooldown))
+	backend, err := NewStorageBackend(cfg, rustDBTransport)
+	if err != nil {
+		log.Fatalf("Failed to configure storage: %v", err)
+	}
+	if cfg.StorageBackend != BackendRustDB {
+		log.Printf("Using %s storage backend; projects aren't shared with other instances", cfg.StorageBackend)
+	}
+	var events *EventLog
+	if cfg.EventLog {
+		events = NewEventLog(backend)
+	}
+	storage := NewStorage(backend, cfg.VerifyContentHashes, cfg.StorageFetchConcurrency, cfg.MaxFileHistory, events)
+	agentTransport := withMetrics(serviceTransport, "python-agent")
+	if cfg.CaptureFailedRequests {
+		agentTransport = withCapture(agentTransport, storage.StoreCapture)
+	}
+	agentTransport = withResilience(withBearerToken(agentTransport, cfg.PythonAgentToken),
+		"python-agent", cfg.DownstreamRetries, cfg.DownstreamRetryBackoff, NewCircuitBreaker("python-agent", cfg.CircuitBreakerFailures, cfg.CircuitBreakerCooldown))
+	pythonClient := NewPythonAgentClient(cfg.PythonAgentURL, cfg.AgentTimeout, agentTransport,
+		NewPriorityLimiter("agent", cfg.AgentConcurrency, cfg.ReservedInteractiveSlots))
+	nodeBuildClient := NewNodeBuildClient(cfg.NodeBuildURL, cfg.NodeBuildTimeout, cfg.NodeBuildRetries, withBearerToken(withMetrics(serviceTransport, "node-build"), cfg.NodeBuildToken),
+		NewPriorityLimiter("build", cfg.BuildConcurrency, cfg.ReservedInteractiveSlots))
+	var replayClient *PythonAgentClient
+	if cfg.ReplayAgentURL != "" {
+		replayClient = NewPythonAgentClient(cfg.ReplayAgentURL, cfg.AgentTimeout, withBearerToken(serviceTransport, cfg.ReplayAgentToken), nil)
+	}
+	secrets, err := NewSecrets(cfg.SecretsKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize secrets: %v", err)
+	}
+	defaults := defaultSettings(cfg)
+	if err := defaults.validate(cfg); err != nil {
+		log.Fatalf("Invalid default project settings: %v", err)
+	}
+	if cfg.ProjectLockTTL <= 0 {
+		log.Fatalf("PROJECT_LOCK_TTL must be positive")
+	}
+	if cfg.ReadyCheckTimeout <= 0 {
+		log.Fatalf("READY_CHECK_TIMEOUT must be positive")
+	}
+	apiKeys, err := parseAPIKeys(cfg.APIKeys)
+	if err != nil {
+		log.Fatalf("Invalid API_KEYS: %v", err)
+	}
+	replicas, err := parseReplicas(cfg.Replicas)
+	if err != nil {
+		log.Fatalf("Invalid REPLICAS: %v", err)
+	}
+	replicaRing, err := NewReplicaRing(cfg.ReplicaID, replicas, cfg.ReplicaAffinity)
+	if err != nil {
+		log.Fatalf("Failed to configure replica affinity: %v", err)
+	}
 
 	// Initialize handlers
-	h := NewHandlers(pythonClient, nodeBuildClient, storage)
+	h := NewHandlers(cfg, pythonClient, nodeBuildClient, storage, secrets, health, replayClient, apiKeys, replicaRing)
+	metrics.gauge("active_streams", "Active chat streams, viewer requests and builds", "kind", h.streams.Counts)
+
+	// Start background workers
+	bgCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	if cfg.ReadOnly {
+		log.Printf("Running read-only: generation, builds and writes are refused")
+	} else if cfg.SchedulerEnabled {
+		go NewScheduler(h).Run(bgCtx)
+	}
+	go h.exposures.Run(bgCtx)
+	go h.viewStats.Run(bgCtx)
 
 	// Setup router
 	r := chi.NewRouter()
@@ -46,28 +123,27 @@ func main() {
 	// Middleware
 	r.Use(otelchi.Middleware("go-main", otelchi.WithChiRoutes(r)))
 	r.Use(OtelMiddleware)
+	r.Use(MetricsMiddleware)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(120 * time.Second))
-	r.Use(middleware.RealIP)
+	identityResolver, err := NewIdentityResolver(cfg.TrustedProxies, cfg.IdentityUserHeader, cfg.IdentityKeyHeader, cfg.IdentityTierHeader)
+	if err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	r.Use(identityResolver.Middleware)
+	if len(cfg.IPAllowlist) > 0 || len(cfg.IPDenylist) > 0 {
+		ipFilter, err := NewIPFilter(cfg.IPAllowlist, cfg.IPDenylist)
+		if err != nil {
+			log.Fatalf("Failed to configure IP filter: %v", err)
+		}
+		r.Use(ipFilter.Middleware)
+	}
 	r.Use(middleware.RequestID)
+	r.Use(LanguageMiddleware)
 
 	// API routes
-	r.Route("/api", func(r chi.Router) {
-		r.Get("/health", h.HandleHealth)
-
-		// Project API routes
-		r.Route("/{uuid}", func(r chi.Router) {
-			r.Get("/state", h.HandleGetState)
-			r.Post("/conversation", h.HandleSaveConversation)
-			r.Post("/create", h.HandleCreate)
-			r.Post("/edit", h.HandleEdit)
-			r.Post("/chat", h.HandleChat)
-			r.Get("/view", h.HandleView)
-			r.Get("/view/assets/*", h.HandleAsset)
-			r.Get("/assets/*", h.HandleAsset) // Alias for relative URL resolution from /view
-		})
-	})
+	mountAPIRoutes(r, h)
+	r.Get("/metrics", h.HandleMetrics)
 
 	// Serve static files from dist/ directory
 	fileServer := http.FileServer(http.Dir("dist"))
@@ -90,18 +166,48 @@ func main() {
 	log.Printf("Starting server on %s", addr)
 	log.Printf("Python Agent URL: %s", cfg.PythonAgentURL)
 	log.Printf("Rust DB URL: %s", cfg.RustDBURL)
+	log.Printf("Node Build URL: %s", cfg.NodeBuildURL)
+
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+
+	protocols, h2, err := serverProtocols(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure HTTP/2: %v", err)
+	}
 
 	srv := &http.Server{
-		Addr:         addr,
-		Handler:      r,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 130 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              addr,
+		Handler:           r,
+		TLSConfig:         tlsConfig,
+		Protocols:         protocols,
+		HTTP2:             h2,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.GenerationTimeout + 10*time.Second, // Chat streams lift this per request
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	if cfg.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, cfg.MaxConnections)
 	}
 
 	// Graceful shutdown
 	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			err = srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
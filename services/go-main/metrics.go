@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// latencyBuckets are the histogram bounds in seconds, extended past
+// Prometheus' defaults to cover generation and builds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Metrics exposed on /metrics in the Prometheus text format. Traces and
+// the OpenTelemetry meter are unaffected.
+var (
+	metrics = &MetricsRegistry{}
+
+	requestDuration = metrics.histogram("http_request_duration_seconds",
+		"Time to serve API requests, by route pattern and status", "method", "route", "status")
+	downstreamDuration = metrics.histogram("downstream_request_duration_seconds",
+		"Time to response headers of calls to internal services", "service")
+	downstreamErrors = metrics.counter("downstream_errors_total",
+		"Calls to internal services that failed or returned 5xx", "service")
+	fileOperations = metrics.counter("file_operations_total",
+		"Source files written or deleted, by what changed them", "source", "operation")
+)
+
+// metricsCollector writes one metric family in the text format.
+type metricsCollector interface {
+	write(w io.Writer)
+}
+
+// MetricsRegistry holds the metrics served on /metrics. It implements the
+// small subset of the Prometheus client this service needs.
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	collectors []metricsCollector
+}
+
+func (m *MetricsRegistry) register(c metricsCollector) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, c)
+}
+
+func (m *MetricsRegistry) histogram(name, help string, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, series: make(map[string]*histogramSeries)}
+	m.register(h)
+	return h
+}
+
+func (m *MetricsRegistry) counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+	m.register(c)
+	return c
+}
+
+// gauge registers a gauge whose values, by the value of label, are read
+// from fn on each scrape.
+func (m *MetricsRegistry) gauge(name, help, label string, fn func() map[string]int) {
+	m.register(&gaugeFunc{name: name, help: help, label: label, fn: fn})
+}
+
+// Expose writes every metric in the Prometheus text exposition format.
+func (m *MetricsRegistry) Expose(w io.Writer) {
+	m.mu.Lock()
+	collectors := slices.Clone(m.collectors)
+	m.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe records value for the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: labelValues, counts: make([]uint64, len(latencyBuckets))}
+		h.series[key] = s
+	}
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	for _, key := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[key]
+		for i, bound := range latencyBuckets {
+			labels := formatLabels(append(slices.Clone(h.labels), "le"), append(slices.Clone(s.values), formatFloat(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i])
+		}
+		labels := formatLabels(append(slices.Clone(h.labels), "le"), append(slices.Clone(s.values), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count)
+		labels = formatLabels(h.labels, s.values)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// Add increases the counter for the given label values by n.
+func (c *CounterVec) Add(n float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: labelValues}
+		c.series[key] = s
+	}
+	s.value += n
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeMetricHeader(w, c.name, c.help, "counter")
+	for _, key := range slices.Sorted(maps.Keys(c.series)) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.values), formatFloat(s.value))
+	}
+}
+
+type gaugeFunc struct {
+	name, help, label string
+	fn                func() map[string]int
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	values := g.fn()
+	writeMetricHeader(w, g.name, g.help, "gauge")
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(w, "%s%s %d\n", g.name, formatLabels([]string{g.label}, []string{key}), values[key])
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// MetricsMiddleware records the duration of each request by its route
+// pattern, so path parameters like project IDs don't become labels.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requestDuration.Observe(time.Since(start).Seconds(), r.Method, route, strconv.Itoa(status))
+	})
+}
+
+// metricsTransport records the latency and failures of calls to service.
+type metricsTransport struct {
+	service string
+	base    http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	// Calls cancelled by our own client say nothing about the downstream
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		return resp, err
+	}
+	downstreamDuration.Observe(time.Since(start).Seconds(), t.service)
+	if err != nil || resp.StatusCode >= 500 {
+		downstreamErrors.Add(1, t.service)
+	}
+	return resp, err
+}
+
+// withMetrics wraps base so its calls are recorded as calls to service.
+func withMetrics(base http.RoundTripper, service string) http.RoundTripper {
+	return &metricsTransport{service: service, base: base}
+}
+
+// HandleMetrics serves the metrics in the Prometheus text format, requiring
+// METRICS_TOKEN as a bearer token when it is set.
+func (h *Handlers) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.MetricsToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.MetricsToken)) != 1 {
+			writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Expose(w)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// serverTLSConfig returns the TLS config for the public listener, or nil
+// when TLS is not configured. With a client CA bundle every client must
+// present a certificate signed by it.
+func serverTLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		if cfg.TLSClientCA != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA requires TLS_CERT and TLS_KEY")
+		}
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA bundle %s", cfg.TLSClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// serverProtocols returns the protocols for the public listener and the
+// HTTP/2 settings to use with them. h2c is only allowed when trusted
+// proxies are configured, since it is meant for traffic they forward.
+func serverProtocols(cfg Config) (*http.Protocols, *http.HTTP2Config, error) {
+	if cfg.H2C && !cfg.HTTP2Enabled {
+		return nil, nil, fmt.Errorf("H2C requires HTTP2_ENABLED")
+	}
+	if cfg.H2C && len(cfg.TrustedProxies) == 0 {
+		return nil, nil, fmt.Errorf("H2C requires TRUSTED_PROXIES")
+	}
+	// Frame sizes outside the range RFC 9113 allows are rejected by peers
+	if cfg.HTTP2MaxReadFrameSize < 16<<10 || cfg.HTTP2MaxReadFrameSize > 1<<24-1 {
+		return nil, nil, fmt.Errorf("HTTP2_MAX_READ_FRAME_SIZE must be between 16384 and 16777215")
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.HTTP2Enabled)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+
+	h2 := &http.HTTP2Config{
+		MaxConcurrentStreams: cfg.HTTP2MaxConcurrentStreams,
+		MaxReadFrameSize:     cfg.HTTP2MaxReadFrameSize,
+	}
+	return protocols, h2, nil
+}
+
+// IPFilter allows or denies requests by client address. Deny entries take
+// precedence; a non-empty allowlist rejects anything not on it.
+type IPFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewIPFilter parses allow and deny lists of IPs or CIDR ranges.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	allowPrefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP allowlist: %w", err)
+	}
+	denyPrefixes, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP denylist: %w", err)
+	}
+	return &IPFilter{allow: allowPrefixes, deny: denyPrefixes}, nil
+}
+
+// parsePrefixes parses entries like "10.0.0.0/8" or "192.168.1.5".
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// containsAddr reports whether any prefix contains addr.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether a client address may make requests.
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// Middleware rejects requests from addresses the filter doesn't allow.
+// It must run after the client IP has been resolved from trusted proxies.
+func (f *IPFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := remoteAddr(r)
+		if !ok || !f.Allowed(addr) {
+			writeError(w, AppError{Code: http.StatusForbidden, Message: "Forbidden"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteAddr parses the client IP from r.RemoteAddr, with or without a port.
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
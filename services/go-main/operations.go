@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Operation states.
+const (
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// Operation stages. Creates and edits go through them in this order;
+// rebuilds only build, and promotions and exports have a stage of their own.
+const (
+	StageGenerating = "generating"
+	StageProcessing = "processing"
+	StageStoring    = "storing"
+	StageBuilding   = "building"
+	StagePromoting  = "promoting"
+	StageExporting  = "exporting"
+)
+
+// asyncStages are the kinds of request AsyncMiddleware can run as an
+// operation, with the stage each starts in.
+var asyncStages = map[string]string{
+	"create":  StageGenerating,
+	"edit":    StageGenerating,
+	"rebuild": StageBuilding,
+	"promote": StagePromoting,
+	"export":  StageExporting,
+}
+
+// operationHeader carries the ID a create's progress is polled with. A
+// client may choose it by sending it, so it can poll before the response.
+const operationHeader = "X-Operation-ID"
+
+const (
+	// maxOperations is how many operations a project keeps for polling.
+	maxOperations = 20
+	// operationSaveInterval throttles saves of file counts; stage changes
+	// are always saved.
+	operationSaveInterval = 500 * time.Millisecond
+	// operationPollInterval is how often a streamed operation is checked
+	// for changes.
+	operationPollInterval = 500 * time.Millisecond
+)
+
+// Operation is the progress of a long-running request, kept in
+// _meta/operations.json for clients to poll while they wait on it.
+type Operation struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	State string `json:"state"`
+	Stage string `json:"stage"`
+	// FilesTotal is how many files are being stored, once known.
+	FilesTotal  int        `json:"files_total,omitempty"`
+	FilesStored int        `json:"files_stored"`
+	StartedAt   time.Time  `json:"started_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	// Status is the HTTP status an async request finished with. Its
+	// response is kept at /{uuid}/operations/{id}/result.
+	Status int `json:"status,omitempty"`
+}
+
+// OperationTracker records an operation's progress as it runs. A nil
+// tracker records nothing, so code shared with untracked requests can
+// report progress unconditionally.
+type OperationTracker struct {
+	storage   *Storage
+	projectID string
+	// onChange, if set, is called with the operation when its stage changes
+	// and when it finishes.
+	onChange func(Operation)
+
+	mu    sync.Mutex
+	op    Operation
+	saved time.Time
+}
+
+type operationTrackerKey struct{}
+
+// withOperation reports progress of work done with ctx to op.
+func withOperation(ctx context.Context, op *OperationTracker) context.Context {
+	return context.WithValue(ctx, operationTrackerKey{}, op)
+}
+
+// operationFrom returns the tracker of ctx's operation, or nil.
+func operationFrom(ctx context.Context) *OperationTracker {
+	op, _ := ctx.Value(operationTrackerKey{}).(*OperationTracker)
+	return op
+}
+
+// operationID returns the operation ID the client chose, or a new one.
+func operationID(r *http.Request) (string, error) {
+	id := r.Header.Get(operationHeader)
+	if id == "" {
+		return uuid.NewString(), nil
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return "", AppError{Code: http.StatusBadRequest, Message: "Invalid operation ID"}
+	}
+	return id, nil
+}
+
+// startOperation records a running operation and returns its tracker,
+// setting the ID in the response headers.
+func (h *Handlers) startOperation(ctx context.Context, w http.ResponseWriter, projectID, id, kind, stage string) *OperationTracker {
+	now := time.Now().UTC()
+	op := &OperationTracker{
+		storage:   h.storage,
+		projectID: projectID,
+		op:        Operation{ID: id, Kind: kind, State: OperationRunning, Stage: stage, StartedAt: now, UpdatedAt: now},
+	}
+	w.Header().Set(operationHeader, id)
+	op.mu.Lock()
+	op.save(ctx)
+	op.mu.Unlock()
+	return op
+}
+
+// ID returns the operation's ID.
+func (t *OperationTracker) ID() string {
+	if t == nil {
+		return ""
+	}
+	return t.op.ID
+}
+
+// SetStage records that the operation has moved on to stage.
+func (t *OperationTracker) SetStage(ctx context.Context, stage string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.op.Stage = stage
+	t.save(ctx)
+	op := t.op
+	t.mu.Unlock()
+	t.notify(op)
+}
+
+// AddFiles records that total more files are being stored.
+func (t *OperationTracker) AddFiles(total int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.op.FilesTotal += total
+}
+
+// FileStored records that a file was stored, saving the count at most
+// every operationSaveInterval.
+func (t *OperationTracker) FileStored(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.op.FilesStored++
+	if time.Since(t.saved) >= operationSaveInterval {
+		t.save(ctx)
+	}
+}
+
+// Snapshot returns the operation as it stands.
+func (t *OperationTracker) Snapshot() Operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.op
+}
+
+// Finish records the operation's outcome.
+func (t *OperationTracker) Finish(ctx context.Context, err error) {
+	t.finish(ctx, 0, err)
+}
+
+// finish records the operation's outcome and, for async requests, the
+// status of its stored response.
+func (t *OperationTracker) finish(ctx context.Context, status int, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	finished := time.Now().UTC()
+	t.op.FinishedAt = &finished
+	t.op.Status = status
+	t.op.State = OperationSucceeded
+	if err != nil {
+		t.op.State = OperationFailed
+		t.op.Error = err.Error()
+	}
+	t.save(ctx)
+	op := t.op
+	t.mu.Unlock()
+	t.notify(op)
+}
+
+// save stores the operation. The caller holds t.mu.
+func (t *OperationTracker) save(ctx context.Context) {
+	t.op.UpdatedAt = time.Now().UTC()
+	t.saved = t.op.UpdatedAt
+	if err := t.storage.SaveOperation(context.WithoutCancel(ctx), t.projectID, t.op, maxOperations); err != nil {
+		log.Printf("Error storing operation %s for project %s: %v", t.op.ID, t.projectID, err)
+	}
+}
+
+func (t *OperationTracker) notify(op Operation) {
+	if t.onChange != nil {
+		t.onChange(op)
+	}
+}
+
+// prefersAsync reports whether the client asked for the request to run in
+// the background, with Prefer: respond-async.
+func prefersAsync(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for pref := range strings.SplitSeq(header, ",") {
+			name, _, _ := strings.Cut(pref, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// operationRecorder captures the response of a request run as an
+// operation.
+type operationRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *operationRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *operationRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *operationRecorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// AsyncMiddleware runs the request in the background as an operation of
+// kind when the client sends Prefer: respond-async, answering at once with
+// 202 and the operation, whose URL is in Location. The handler's response
+// is stored as the operation's result. Other requests run as usual.
+func (h *Handlers) AsyncMiddleware(kind string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			projectID := chi.URLParam(r, "uuid")
+			if !prefersAsync(r) || validateUUID(projectID) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := operationID(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			body, err := readBody(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			op := h.startOperation(r.Context(), w, projectID, id, kind, asyncStages[kind])
+
+			// chi reuses the request's route context once it returns
+			routeCtx := chi.RouteContext(r.Context())
+			bgRouteCtx := chi.NewRouteContext()
+			bgRouteCtx.URLParams.Keys = slices.Clone(routeCtx.URLParams.Keys)
+			bgRouteCtx.URLParams.Values = slices.Clone(routeCtx.URLParams.Values)
+			bgRouteCtx.RoutePatterns = slices.Clone(routeCtx.RoutePatterns)
+			ctx := context.WithValue(context.WithoutCancel(r.Context()), chi.RouteCtxKey, bgRouteCtx)
+			bg := r.Clone(withOperation(ctx, op))
+			bg.Body = io.NopCloser(bytes.NewReader(body))
+			go h.runOperation(op, next, bg, responseLanguage(w))
+
+			prefix, _, _ := strings.Cut(r.URL.Path, "/"+projectID)
+			w.Header().Set("Location", prefix+"/"+projectID+"/operations/"+id)
+			w.Header().Set("Preference-Applied", "respond-async")
+			writeJSON(w, http.StatusAccepted, op.Snapshot())
+		})
+	}
+}
+
+// runOperation serves an async request, storing its response as the
+// operation's result in the request's language. Error responses fail the
+// operation with their message.
+func (h *Handlers) runOperation(op *OperationTracker, next http.Handler, r *http.Request, lang string) {
+	ctx := r.Context()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Panic in operation %s for project %s: %v", op.ID(), op.projectID, p)
+			op.finish(ctx, http.StatusInternalServerError, fmt.Errorf("internal error: %v", p))
+		}
+	}()
+
+	runCtx, cancel := context.WithTimeout(ctx, h.cfg.GenerationTimeout)
+	defer cancel()
+	rec := &operationRecorder{header: http.Header{}}
+	next.ServeHTTP(&languageWriter{ResponseWriter: rec, lang: lang}, r.WithContext(runCtx))
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	var err error
+	if rec.status >= http.StatusBadRequest {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(rec.body.Bytes(), &resp) != nil || resp.Error == "" {
+			resp.Error = http.StatusText(rec.status)
+		}
+		err = errors.New(resp.Error)
+	}
+	mimeType := rec.header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	if storeErr := h.storage.StoreOperationResult(ctx, op.projectID, op.ID(), mimeType, rec.body.Bytes()); storeErr != nil {
+		log.Printf("Error storing result of operation %s for project %s: %v", op.ID(), op.projectID, storeErr)
+	}
+	op.finish(ctx, rec.status, err)
+}
+
+// findOperation returns one of the project's recent operations.
+func (h *Handlers) findOperation(ctx context.Context, projectID, id string) (*Operation, error) {
+	operations, err := h.storage.GetOperations(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, upstreamError("Failed to get operations", err)
+	}
+	for _, op := range operations {
+		if op.ID == id {
+			return &op, nil
+		}
+	}
+	return nil, AppError{Code: http.StatusNotFound, Message: "Operation not found"}
+}
+
+// HandleGetOperation returns the progress of one of the project's recent
+// long-running requests. With Accept: text/event-stream, the operation is
+// streamed as an event each time it changes until it finishes.
+func (h *Handlers) HandleGetOperation(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamOperation(w, r, projectID, id)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.RouteTimeout)
+	defer cancel()
+	op, err := h.findOperation(ctx, projectID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, op)
+}
+
+// streamOperation sends the operation as a server-sent event whenever it
+// changes, polling storage so it works whichever instance runs it.
+func (h *Handlers) streamOperation(w http.ResponseWriter, r *http.Request, projectID, id string) {
+	op, err := h.findOperation(r.Context(), projectID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: "Streaming not supported"})
+		return
+	}
+
+	ctx, done := h.streams.Track(r.Context(), StreamViewer, projectID)
+	defer done()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Could not lift write deadline for operation stream: %v", err)
+	}
+
+	ticker := time.NewTicker(operationPollInterval)
+	defer ticker.Stop()
+	var sent time.Time
+	for {
+		if !op.UpdatedAt.Equal(sent) {
+			data, err := json.Marshal(op)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+			sent = op.UpdatedAt
+		}
+		if op.State != OperationRunning {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if op, err = h.findOperation(ctx, projectID, id); err != nil {
+			log.Printf("Error polling operation %s for project %s: %v", id, projectID, err)
+			return
+		}
+	}
+}
+
+// HandleGetOperationResult returns the response an async request finished
+// with, as it would have been returned without Prefer: respond-async.
+func (h *Handlers) HandleGetOperationResult(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	op, err := h.findOperation(r.Context(), projectID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if op.State == OperationRunning {
+		writeError(w, AppError{Code: http.StatusConflict, Message: "Operation is still running"})
+		return
+	}
+	if op.Status == 0 {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Operation has no stored result"})
+		return
+	}
+	content, mimeType, err := h.storage.GetOperationResult(r.Context(), projectID, op.ID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Operation has no stored result"})
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to get operation result", err))
+		return
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.WriteHeader(op.Status)
+	_, _ = w.Write(content)
+}
@@ -64,6 +64,13 @@ func InitTracer(ctx context.Context) (func(context.Context) error, error) {
 	return tp.Shutdown, nil
 }
 
+// sensitiveHeaders are never recorded on spans.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":      true,
+	"Cookie":             true,
+	providerAPIKeyHeader: true,
+}
+
 // OtelMiddleware captures HTTP request attributes as span attributes.
 func OtelMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -77,8 +84,11 @@ func OtelMiddleware(next http.Handler) http.Handler {
 			fullURL := scheme + "://" + r.Host + r.RequestURI
 			span.SetAttributes(attribute.String("http.url", fullURL))
 
-			// Capture headers
+			// Capture headers, leaving out credentials
 			for name, values := range r.Header {
+				if sensitiveHeaders[name] {
+					continue
+				}
 				attrName := "http.request.header." + strings.ToLower(strings.ReplaceAll(name, "-", "_"))
 				span.SetAttributes(attribute.StringSlice(attrName, values))
 			}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Placeholders without dimensions in the markup use this size.
+const (
+	placeholderWidth     = 640
+	placeholderHeight    = 360
+	maxPlaceholderLength = 4000
+)
+
+// placeholderExtensions are the image types placeholders stand in for.
+var placeholderExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".webp": true, ".avif": true, ".bmp": true, ".ico": true,
+}
+
+// placeholderSVG draws a labelled placeholder for the image name. Browsers
+// go by the content type, so it works wherever a PNG or JPEG was expected.
+func placeholderSVG(name string, width, height int) []byte {
+	fontSize := min(max(min(width, height)/10, 10), 48)
+	label := fmt.Sprintf("Placeholder %d×%d", width, height)
+	return fmt.Appendf(nil, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="%[2]d" viewBox="0 0 %[1]d %[2]d">`+
+		`<title>Placeholder for missing image %[3]s</title>`+
+		`<rect width="100%%" height="100%%" fill="#e2e8f0"/>`+
+		`<path d="M0 0L%[1]d %[2]dM%[1]d 0L0 %[2]d" stroke="#cbd5e1" stroke-width="2"/>`+
+		`<text x="50%%" y="50%%" font-family="system-ui,sans-serif" font-size="%[4]d" fill="#475569" text-anchor="middle" dominant-baseline="middle">%[5]s</text>`+
+		`</svg>`, width, height, html.EscapeString(name), fontSize, label)
+}
+
+// placeholderSize returns the size the latest build's markup gives the
+// missing image at assetPath, or the default size.
+func (h *Handlers) placeholderSize(r *http.Request, projectID, assetPath string) (int, int) {
+	records, err := h.storage.ListBuildRecords(r.Context(), projectID, 1)
+	if err == nil && len(records) > 0 {
+		for _, missing := range records[0].MissingAssets {
+			if missing.Path == assetPath && missing.Width > 0 && missing.Height > 0 {
+				return min(missing.Width, maxPlaceholderLength), min(missing.Height, maxPlaceholderLength)
+			}
+		}
+	}
+	if width, height := nameDimensions(assetPath); width > 0 && height > 0 {
+		return min(width, maxPlaceholderLength), min(height, maxPlaceholderLength)
+	}
+	return placeholderWidth, placeholderHeight
+}
+
+// servePlaceholder writes a placeholder for a missing image if the project
+// has placeholder_images enabled, reporting whether it did. Placeholders
+// carry X-Placeholder and aren't cached, so the real image shows up as soon
+// as the app has one.
+func (h *Handlers) servePlaceholder(w http.ResponseWriter, r *http.Request, projectID, assetPath string) bool {
+	if !placeholderExtensions[strings.ToLower(path.Ext(assetPath))] {
+		return false
+	}
+	settings, err := h.projectSettings(r.Context(), projectID)
+	if err != nil || !settings.PlaceholderImages {
+		return false
+	}
+
+	width, height := h.placeholderSize(r, projectID, assetPath)
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Placeholder", "true")
+	_, _ = w.Write(placeholderSVG(path.Base(assetPath), width, height))
+	return true
+}
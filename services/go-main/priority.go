@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Priority tiers for API clients. Interactive clients are people using the
+// UI; batch clients are automation, which yields to interactive work.
+const (
+	TierInteractive = "interactive"
+	TierBatch       = "batch"
+)
+
+// parseTier maps a tier header value to a tier. Only an explicit "batch"
+// lowers a client's priority.
+func parseTier(value string) string {
+	if value == TierBatch {
+		return TierBatch
+	}
+	return TierInteractive
+}
+
+// requestTier returns the priority tier of the client behind ctx. Work with
+// no client, such as scheduled jobs, runs as batch.
+func requestTier(ctx context.Context) string {
+	identity, ok := ctx.Value(identityKey{}).(ClientIdentity)
+	if !ok {
+		return TierBatch
+	}
+	return parseTier(identity.Tier)
+}
+
+// PriorityLimiter bounds concurrent calls to a downstream service. Waiting
+// interactive callers are admitted before batch ones, and batch callers
+// can never hold the slots reserved for interactive work.
+type PriorityLimiter struct {
+	name          string
+	capacity      int
+	batchCapacity int
+
+	mu      sync.Mutex
+	inUse   int
+	batch   int
+	waiting map[string][]chan struct{}
+}
+
+// NewPriorityLimiter creates a limiter with capacity slots, reserved of
+// which batch callers can't use. It returns nil, meaning no limit, when
+// capacity is not positive.
+func NewPriorityLimiter(name string, capacity, reserved int) *PriorityLimiter {
+	if capacity <= 0 {
+		return nil
+	}
+	return &PriorityLimiter{
+		name:          name,
+		capacity:      capacity,
+		batchCapacity: max(capacity-reserved, 1),
+		waiting:       map[string][]chan struct{}{},
+	}
+}
+
+// Acquire waits for a slot for a caller in tier and returns the function
+// that releases it.
+func (l *PriorityLimiter) Acquire(ctx context.Context, tier string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	if l.admissible(tier) && len(l.waiting[TierInteractive]) == 0 && (tier == TierInteractive || len(l.waiting[TierBatch]) == 0) {
+		l.take(tier)
+		l.mu.Unlock()
+		return func() { l.release(tier) }, nil
+	}
+	ready := make(chan struct{})
+	l.waiting[tier] = append(l.waiting[tier], ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return func() { l.release(tier) }, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-ready:
+			// Admitted while giving up; hand the slot on
+			l.releaseLocked(tier)
+		default:
+			l.waiting[tier] = removeWaiter(l.waiting[tier], ready)
+		}
+		return nil, fmt.Errorf("waiting for %s slot: %w", l.name, ctx.Err())
+	}
+}
+
+func (l *PriorityLimiter) admissible(tier string) bool {
+	if l.inUse >= l.capacity {
+		return false
+	}
+	return tier == TierInteractive || l.batch < l.batchCapacity
+}
+
+func (l *PriorityLimiter) take(tier string) {
+	l.inUse++
+	if tier == TierBatch {
+		l.batch++
+	}
+}
+
+func (l *PriorityLimiter) release(tier string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(tier)
+}
+
+// releaseLocked frees a slot and admits waiters, interactive first.
+func (l *PriorityLimiter) releaseLocked(tier string) {
+	l.inUse--
+	if tier == TierBatch {
+		l.batch--
+	}
+	for _, t := range []string{TierInteractive, TierBatch} {
+		for len(l.waiting[t]) > 0 && l.admissible(t) {
+			ready := l.waiting[t][0]
+			l.waiting[t] = l.waiting[t][1:]
+			l.take(t)
+			close(ready)
+		}
+	}
+}
+
+func removeWaiter(waiters []chan struct{}, ready chan struct{}) []chan struct{} {
+	for i, w := range waiters {
+		if w == ready {
+			return append(waiters[:i], waiters[i+1:]...)
+		}
+	}
+	return waiters
+}
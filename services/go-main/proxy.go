@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// proxyPath is the view-relative path of the external asset proxy.
+const proxyPath = "_proxy"
+
+var (
+	// proxyAttrPattern matches src/href attributes and CSS url() references
+	// to absolute https URLs.
+	proxyAttrPattern = regexp.MustCompile(`((?:src|href)=["'])(https://[^"']+)`)
+	proxyCSSPattern  = regexp.MustCompile(`(url\(\s*["']?)(https://[^"')\s]+)`)
+)
+
+// proxyClient fetches external assets, refusing redirects off the allowlist.
+func (h *Handlers) proxyClient() *http.Client {
+	return &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: httpClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !h.proxyAllowed(req.URL) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// proxyAllowed reports whether u may be fetched through the proxy.
+func (h *Handlers) proxyAllowed(u *url.URL) bool {
+	return u.Scheme == "https" && u.User == nil && u.Port() == "" && slices.Contains(h.cfg.ProxyAllowedHosts, strings.ToLower(u.Hostname()))
+}
+
+// proxiedURL returns the proxy URL for target, relative to base.
+func proxiedURL(base, target string) string {
+	return base + proxyPath + "?url=" + url.QueryEscape(target)
+}
+
+// rewriteProxiedURLs points references to allowlisted hosts at the proxy.
+// base is the path from the document to the view directory.
+func (h *Handlers) rewriteProxiedURLs(doc string, pattern *regexp.Regexp, base string) string {
+	if len(h.cfg.ProxyAllowedHosts) == 0 {
+		return doc
+	}
+	return pattern.ReplaceAllStringFunc(doc, func(match string) string {
+		parts := pattern.FindStringSubmatch(match)
+		u, err := url.Parse(parts[2])
+		if err != nil || !h.proxyAllowed(u) {
+			return match
+		}
+		return parts[1] + proxiedURL(base, parts[2])
+	})
+}
+
+// fetchProxied downloads an allowlisted resource, enforcing the size limit.
+func (h *Handlers) fetchProxied(ctx context.Context, target string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	// Ask for woff2 fonts, as a modern browser would
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+
+	resp, err := h.proxyClient().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, int64(h.cfg.ProxyMaxSize)+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) > h.cfg.ProxyMaxSize {
+		return nil, "", fmt.Errorf("resource exceeds %d bytes", h.cfg.ProxyMaxSize)
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err != nil || !proxyableType(mediaType) {
+		return nil, "", fmt.Errorf("content type %q is not allowed", mimeType)
+	}
+	return content, mimeType, nil
+}
+
+// proxyFetchError is a failure fetching an external resource, as opposed
+// to reading the cache.
+type proxyFetchError struct{ err error }
+
+func (e *proxyFetchError) Error() string { return e.err.Error() }
+func (e *proxyFetchError) Unwrap() error { return e.err }
+
+// fetchCached returns an external resource from the shared proxy cache,
+// fetching and caching it on a miss.
+func (h *Handlers) fetchCached(ctx context.Context, target string) ([]byte, string, error) {
+	content, mimeType, err := h.storage.GetProxyCache(ctx, target)
+	if !errors.Is(err, ErrNotFound) {
+		return content, mimeType, err
+	}
+	content, mimeType, err = h.fetchProxied(ctx, target)
+	if err != nil {
+		return nil, "", &proxyFetchError{err}
+	}
+	if storeErr := h.storage.StoreProxyCache(ctx, target, mimeType, content); storeErr != nil {
+		// Use it anyway; it will be fetched again next time
+		log.Printf("Error caching proxied resource %s: %v", target, storeErr)
+	}
+	return content, mimeType, nil
+}
+
+// proxyableType reports whether a media type may be served through the
+// proxy. Scripts and HTML are excluded so the proxy can't inject code.
+func proxyableType(mediaType string) bool {
+	return mediaType == "text/css" ||
+		strings.HasPrefix(mediaType, "font/") ||
+		strings.HasPrefix(mediaType, "application/font-") ||
+		strings.HasPrefix(mediaType, "application/x-font-") ||
+		(strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml")
+}
+
+// HandleProxy serves an allowlisted external resource, caching it so
+// viewers that can't reach third-party CDNs still get it.
+func (h *Handlers) HandleProxy(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	target := r.URL.Query().Get("url")
+	u, err := url.Parse(target)
+	if err != nil || !h.proxyAllowed(u) {
+		writeError(w, AppError{Code: http.StatusForbidden, Message: "URL is not allowed through the proxy"})
+		return
+	}
+
+	content, mimeType, err := h.fetchCached(r.Context(), target)
+	var fetchErr *proxyFetchError
+	if errors.As(err, &fetchErr) {
+		writeError(w, AppError{Code: http.StatusBadGateway, Message: fmt.Sprintf("Failed to fetch resource: %v", fetchErr.err)})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Stylesheets pull in fonts and images; route those through the proxy too
+	if strings.HasPrefix(mimeType, "text/css") {
+		content = []byte(h.rewriteProxiedURLs(string(content), proxyCSSPattern, "./"))
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
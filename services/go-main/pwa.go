@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// serviceWorkerPath is where the injected service worker is stored in the
+// compiled output and served from, relative to the view URL.
+const serviceWorkerPath = "sw.js"
+
+// serviceWorkerTemplate precaches the app shell and serves it offline.
+// Navigations go to the network first so new builds show up immediately.
+const serviceWorkerTemplate = `const CACHE = "app-%s";
+const PRECACHE = %s;
+
+self.addEventListener("install", (event) => {
+  event.waitUntil(caches.open(CACHE).then((cache) => cache.addAll(PRECACHE)).then(() => self.skipWaiting()));
+});
+
+self.addEventListener("activate", (event) => {
+  event.waitUntil(
+    caches.keys()
+      .then((keys) => Promise.all(keys.filter((k) => k !== CACHE).map((k) => caches.delete(k))))
+      .then(() => self.clients.claim())
+  );
+});
+
+self.addEventListener("fetch", (event) => {
+  if (event.request.method !== "GET") return;
+  if (event.request.mode === "navigate") {
+    event.respondWith(fetch(event.request).catch(() => caches.match("./view")));
+    return;
+  }
+  event.respondWith(caches.match(event.request).then((hit) => hit || fetch(event.request)));
+});
+`
+
+// serviceWorkerRegistration registers the service worker from the page.
+const serviceWorkerRegistration = `<script>if ("serviceWorker" in navigator) { navigator.serviceWorker.register("./` + serviceWorkerPath + `"); }</script>`
+
+// PWASettings controls whether builds are made installable and offline-capable.
+type PWASettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+// generateServiceWorker renders a service worker that precaches the
+// compiled files. The cache name changes whenever their contents do.
+func generateServiceWorker(compiledFiles map[string]string) (string, error) {
+	paths := slices.Sorted(maps.Keys(compiledFiles))
+
+	hash := sha256.New()
+	precache := []string{"./view"}
+	for _, path := range paths {
+		hash.Write([]byte(path))
+		hash.Write([]byte(compiledFiles[path]))
+		if path != "index.html" {
+			precache = append(precache, "./"+path)
+		}
+	}
+
+	precacheJSON, err := json.Marshal(precache)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(serviceWorkerTemplate, hex.EncodeToString(hash.Sum(nil))[:12], precacheJSON), nil
+}
+
+// postProcessBuild applies go-main's own build steps to compiled output
+// before it is stored: library references, then self-hosted fonts, the
+// brand kit, PWA support and subresource integrity, when each is enabled,
+// and last gzip copies of the text files.
+func (h *Handlers) postProcessBuild(ctx context.Context, projectID string, compiledFiles map[string]string) (map[string]string, error) {
+	out, err := h.selfHostFonts(ctx, projectID, resolveLibraryRefs(compiledFiles))
+	if err != nil {
+		return nil, err
+	}
+	out, err = h.addBrandKit(ctx, projectID, out)
+	if err != nil {
+		return nil, err
+	}
+	out, err = h.addPWA(ctx, projectID, out)
+	if err != nil {
+		return nil, err
+	}
+	out, err = h.addSRI(ctx, projectID, out)
+	if err != nil {
+		return nil, err
+	}
+	return addGzipVariants(out), nil
+}
+
+// addPWA injects a manifest, icon and service worker when PWA support is
+// enabled.
+func (h *Handlers) addPWA(ctx context.Context, projectID string, compiledFiles map[string]string) (map[string]string, error) {
+	pwa, err := h.storage.GetPWA(ctx, projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return compiledFiles, nil
+		}
+		return nil, fmt.Errorf("failed to load PWA settings: %w", err)
+	}
+	if !pwa.Enabled {
+		return compiledFiles, nil
+	}
+
+	out := maps.Clone(compiledFiles)
+	page, ok := out["index.html"]
+	if !ok {
+		return out, nil
+	}
+
+	if _, ok := out["manifest.webmanifest"]; !ok {
+		manifest, err := json.Marshal(newWebManifest(projectID, pageTitle(page)))
+		if err != nil {
+			return nil, err
+		}
+		out["manifest.webmanifest"] = string(manifest)
+	}
+	if _, ok := out["favicon.svg"]; !ok {
+		out["favicon.svg"] = generateFavicon(projectID, pageTitle(page))
+	}
+
+	page = injectIconLinks(page)
+	if !strings.Contains(page, serviceWorkerRegistration) {
+		if i := strings.LastIndex(page, "</body>"); i >= 0 {
+			page = page[:i] + serviceWorkerRegistration + page[i:]
+		} else {
+			page += serviceWorkerRegistration
+		}
+	}
+	out["index.html"] = page
+
+	sw, err := generateServiceWorker(out)
+	if err != nil {
+		return nil, err
+	}
+	out[serviceWorkerPath] = sw
+	return out, nil
+}
+
+// HandleGetPWA returns the project's PWA settings.
+func (h *Handlers) HandleGetPWA(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pwa, err := h.storage.GetPWA(r.Context(), projectID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			writeError(w, err)
+			return
+		}
+		pwa = &PWASettings{}
+	}
+
+	writeJSON(w, http.StatusOK, pwa)
+}
+
+// HandleSavePWA toggles PWA support and rebuilds the app so the change
+// takes effect without waiting for the next edit.
+func (h *Handlers) HandleSavePWA(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var pwa PWASettings
+	if err := json.NewDecoder(r.Body).Decode(&pwa); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	if err := h.storage.StorePWA(r.Context(), projectID, &pwa); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store PWA settings: %v", err)})
+		return
+	}
+
+	go func(ctx context.Context) {
+		if err := h.rebuild(withBuildTrigger(ctx, "pwa"), projectID); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Error rebuilding project %s after PWA change: %v", projectID, redactPayload(err))
+		}
+	}(context.WithoutCancel(r.Context()))
+
+	writeJSON(w, http.StatusOK, pwa)
+}
+
+// HandleServiceWorker serves the injected service worker.
+func (h *Handlers) HandleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	content, _, err := h.storage.GetChannelFile(r.Context(), projectID, ChannelProduction, serviceWorkerPath)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Browsers re-check the worker on navigation; don't let caches pin an old one
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/javascript")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
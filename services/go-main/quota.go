@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Quota resources.
+const (
+	QuotaStorage      = "storage"
+	QuotaTokens       = "tokens"
+	QuotaBuildMinutes = "build_minutes"
+)
+
+// quotaThresholds are the percentages of a limit at which warnings are raised.
+var quotaThresholds = []int{80, 90, 100}
+
+// ProjectUsage is a project's metered usage for one calendar month.
+type ProjectUsage struct {
+	Period       string  `json:"period"` // YYYY-MM, UTC
+	Tokens       int64   `json:"tokens"`
+	BuildSeconds float64 `json:"build_seconds"`
+}
+
+// QuotaWarning reports that a resource has reached a warning threshold.
+type QuotaWarning struct {
+	Resource  string `json:"resource"`
+	Threshold int    `json:"threshold"`
+	Used      int64  `json:"used"`
+	Limit     int64  `json:"limit"`
+}
+
+// usagePeriod returns the usage period containing t.
+func usagePeriod(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// periodReset returns when the usage period containing t ends.
+func periodReset(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// QuotaUsage is a project's usage against its monthly quotas, returned with
+// generation responses so clients can back off before reaching them.
+// Limits are omitted when unlimited.
+type QuotaUsage struct {
+	Period            string    `json:"period"`
+	Tokens            int64     `json:"tokens"`
+	TokenLimit        int64     `json:"token_limit,omitempty"`
+	BuildMinutes      int64     `json:"build_minutes"`
+	BuildMinutesLimit int       `json:"build_minutes_limit,omitempty"`
+	ResetsAt          time.Time `json:"resets_at"`
+}
+
+// quotaUsage reports usage against the configured quotas, or nil when
+// usage is unknown.
+func (h *Handlers) quotaUsage(usage *ProjectUsage) *QuotaUsage {
+	if usage == nil {
+		return nil
+	}
+	start, err := time.Parse("2006-01", usage.Period)
+	if err != nil {
+		return nil
+	}
+	return &QuotaUsage{
+		Period:            usage.Period,
+		Tokens:            usage.Tokens,
+		TokenLimit:        h.cfg.TokenQuota,
+		BuildMinutes:      int64(usage.BuildSeconds / 60),
+		BuildMinutesLimit: h.cfg.BuildMinutesQuota,
+		ResetsAt:          periodReset(start),
+	}
+}
+
+// setRateLimitHeaders reports the monthly token quota in
+// X-RateLimit-Limit/Remaining/Reset, with Reset in Unix seconds. Nothing
+// is set when tokens are unlimited or usage is unknown.
+func (h *Handlers) setRateLimitHeaders(w http.ResponseWriter, quota *QuotaUsage) {
+	if quota == nil || quota.TokenLimit <= 0 {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(quota.TokenLimit, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(max(quota.TokenLimit-quota.Tokens, 0), 10))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(quota.ResetsAt.Unix(), 10))
+}
+
+// currentQuota returns the project's usage this period without changing
+// it, logging rather than failing if it can't be read.
+func (h *Handlers) currentQuota(ctx context.Context, projectID string) *QuotaUsage {
+	usage, err := h.storage.GetUsage(ctx, projectID, usagePeriod(time.Now()))
+	if err != nil {
+		log.Printf("Failed to load usage for project %s: %v", projectID, err)
+		return nil
+	}
+	return h.quotaUsage(usage)
+}
+
+// quotaWarning returns a warning for the highest threshold used has reached,
+// if any. A limit of zero or less means unlimited.
+func quotaWarning(resource string, used, limit int64) (QuotaWarning, bool) {
+	if limit <= 0 {
+		return QuotaWarning{}, false
+	}
+	percent := used * 100 / limit
+	for i := len(quotaThresholds) - 1; i >= 0; i-- {
+		if percent >= int64(quotaThresholds[i]) {
+			return QuotaWarning{Resource: resource, Threshold: quotaThresholds[i], Used: used, Limit: limit}, true
+		}
+	}
+	return QuotaWarning{}, false
+}
+
+// quotaWarnings returns a warning for each resource at or past a threshold.
+func (h *Handlers) quotaWarnings(usage *ProjectUsage, storageBytes int64) []QuotaWarning {
+	var warnings []QuotaWarning
+	if w, ok := quotaWarning(QuotaStorage, storageBytes, int64(h.cfg.MaxProjectSize)); ok {
+		warnings = append(warnings, w)
+	}
+	if w, ok := quotaWarning(QuotaTokens, usage.Tokens, h.cfg.TokenQuota); ok {
+		warnings = append(warnings, w)
+	}
+	if w, ok := quotaWarning(QuotaBuildMinutes, int64(usage.BuildSeconds/60), int64(h.cfg.BuildMinutesQuota)); ok {
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+// recordUsage adds metered usage to the project's monthly totals, then
+// re-evaluates its quotas. Warnings are stored in the project state and
+// each newly crossed threshold is published on the change stream. Failures
+// are logged rather than returned since usage never blocks the caller. The
+// new totals are returned, or nil if they couldn't be recorded.
+func (h *Handlers) recordUsage(ctx context.Context, projectID string, tokens int64, build time.Duration) *QuotaUsage {
+	usage, err := h.storage.AddUsage(ctx, projectID, usagePeriod(time.Now()), tokens, build.Seconds())
+	if err != nil {
+		log.Printf("Failed to record usage for project %s: %v", projectID, err)
+		return nil
+	}
+	quota := h.quotaUsage(usage)
+
+	storageBytes, err := h.storage.SourceSize(ctx, projectID)
+	if err != nil {
+		log.Printf("Failed to measure storage for project %s: %v", projectID, err)
+		return quota
+	}
+
+	warnings := h.quotaWarnings(usage, storageBytes)
+	previous, err := h.storage.SetQuotaWarnings(ctx, projectID, warnings)
+	if err != nil {
+		log.Printf("Failed to store quota warnings for project %s: %v", projectID, err)
+		return quota
+	}
+
+	reached := make(map[string]int, len(previous))
+	for _, w := range previous {
+		reached[w.Resource] = w.Threshold
+	}
+	for _, w := range warnings {
+		if w.Threshold > reached[w.Resource] {
+			h.changes.PublishQuotaWarning(projectID, w)
+		}
+	}
+	return quota
+}
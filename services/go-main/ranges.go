@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// byteRange is the part of a resource a Range request asks for.
+type byteRange struct {
+	start, length int64
+}
+
+// contentRange formats r as a Content-Range value for a resource of size
+// bytes.
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+// errRangeUnsatisfiable is returned by parseByteRange for a range that lies
+// wholly past the end of the resource.
+var errRangeUnsatisfiable = errors.New("range not satisfiable")
+
+// parseByteRange parses a Range header against a resource of size bytes.
+// Only a single range is supported; ok is false when the header should be
+// ignored and the whole resource served, as it is for a missing, malformed
+// or multi-range header, which RFC 9110 allows.
+func parseByteRange(header string, size int64) (r byteRange, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	if first == "" {
+		// bytes=-n asks for the final n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, errRangeUnsatisfiable
+		}
+		n = min(n, size)
+		return byteRange{start: size - n, length: n}, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return byteRange{}, false, errRangeUnsatisfiable
+	}
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// ifRangeMatches reports whether a Range request should be honoured: it
+// has no If-Range, or its If-Range is the current strong entity tag. Dates
+// are not compared, as assets carry no Last-Modified, so a date validator
+// always gets the whole resource.
+func ifRangeMatches(r *http.Request, etag string) bool {
+	ifRange := r.Header.Get("If-Range")
+	return ifRange == "" || (etag != "" && ifRange == etag)
+}
+
+// skipBytes advances rd by n bytes, seeking when the stream supports it
+// (files on disk) and otherwise reading past them, since the storage
+// backends have no ranged reads.
+func skipBytes(rd io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if seeker, ok := rd.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, rd, n); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
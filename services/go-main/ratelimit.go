@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// WindowLimiter counts requests per key in fixed windows, all starting
+// together so the counts can be dropped at once when a window ends.
+type WindowLimiter struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+// NewWindowLimiter creates a limiter with windows of the given length.
+func NewWindowLimiter(window time.Duration) *WindowLimiter {
+	return &WindowLimiter{window: window, start: time.Now(), counts: make(map[string]int)}
+}
+
+// Allow counts a request for key, reporting whether it is within limit for
+// the current window and, if not, how long until the window ends. A limit
+// of zero or less allows everything.
+func (l *WindowLimiter) Allow(key string, limit int) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.start) >= l.window {
+		l.start, l.counts = now, make(map[string]int)
+	}
+	if l.counts[key] >= limit {
+		return false, l.start.Add(l.window).Sub(now)
+	}
+	l.counts[key]++
+	return true, 0
+}
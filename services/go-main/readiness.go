@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DependencyStatus is the outcome of one readiness check.
+type DependencyStatus struct {
+	Status string `json:"status"`
+	// Critical dependencies make the instance unready when they fail.
+	Critical  bool   `json:"critical"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// ReadinessResponse is the response for /readyz.
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// readinessCheck is a cheap probe of one downstream.
+type readinessCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// Readiness probes the downstream services, caching the result for ttl so
+// frequent load balancer polls don't turn into downstream load. Concurrent
+// callers during a refresh wait for it rather than probing again.
+type Readiness struct {
+	checks  []readinessCheck
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu   sync.Mutex
+	last *ReadinessResponse
+}
+
+// NewReadiness creates a readiness checker for the instance's downstreams.
+// Storage is always critical; the Python agent and Node Build are critical
+// unless the instance is read-only, as it then never generates or builds.
+// Each probe gets timeout.
+func NewReadiness(cfg Config, storage *Storage, pythonClient *PythonAgentClient, nodeBuildClient *NodeBuildClient) *Readiness {
+	return &Readiness{
+		checks: []readinessCheck{
+			{name: "storage", critical: true, check: storage.Ping},
+			{name: "python_agent", critical: !cfg.ReadOnly, check: pythonClient.Health},
+			{name: "node_build", critical: !cfg.ReadOnly, check: nodeBuildClient.Health},
+		},
+		timeout: cfg.ReadyCheckTimeout,
+		ttl:     cfg.ReadyCacheTTL,
+	}
+}
+
+// Check returns the cached result if it is recent enough, otherwise probes
+// every dependency in parallel.
+func (rd *Readiness) Check(ctx context.Context) *ReadinessResponse {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.last != nil && time.Since(rd.last.CheckedAt) < rd.ttl {
+		return rd.last
+	}
+
+	// Probes are cut short by timeout, not by the caller going away, since
+	// their result is shared
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rd.timeout)
+	defer cancel()
+
+	statuses := make([]DependencyStatus, len(rd.checks))
+	var wg sync.WaitGroup
+	for i, c := range rd.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := c.check(ctx)
+			statuses[i] = DependencyStatus{Status: "ok", Critical: c.critical, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				statuses[i].Status = "unavailable"
+				statuses[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	resp := &ReadinessResponse{Status: "ready", CheckedAt: time.Now().UTC(), Dependencies: make(map[string]DependencyStatus, len(rd.checks))}
+	for i, c := range rd.checks {
+		resp.Dependencies[c.name] = statuses[i]
+		if statuses[i].Status != "ok" {
+			if c.critical {
+				resp.Status = "unready"
+			} else if resp.Status == "ready" {
+				resp.Status = "degraded"
+			}
+		}
+	}
+	rd.last = resp
+	return resp
+}
+
+// HandleReady reports whether the instance can serve requests: 200 with
+// each dependency's status, or 503 when a critical one is unavailable.
+func (h *Handlers) HandleReady(w http.ResponseWriter, r *http.Request) {
+	resp := h.readiness.Check(r.Context())
+	status := http.StatusOK
+	if resp.Status == "unready" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, resp)
+}
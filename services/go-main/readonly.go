@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+)
+
+// errReadOnly is returned for requests a READ_ONLY instance can't serve.
+var errReadOnly = AppError{Code: http.StatusMethodNotAllowed, Message: "This instance is read-only"}
+
+// ReadOnlyMiddleware refuses every request but reads when the instance runs
+// with READ_ONLY, so replicas only serve views, assets and state.
+func (h *Handlers) ReadOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.cfg.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			writeError(w, errReadOnly)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WriterOnly refuses, on READ_ONLY instances, reads that need a writer: ones
+// that run builds, and ones following chat streams and change events, which
+// only exist in the memory of the instance doing the writes.
+func (h *Handlers) WriterOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.cfg.ReadOnly {
+			writeError(w, errReadOnly)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
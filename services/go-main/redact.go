@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Redaction modes for text that may carry prompts, file contents or
+// conversations when it is logged or attached to spans.
+const (
+	RedactOff      = "off"
+	RedactTruncate = "truncate"
+	RedactHash     = "hash"
+)
+
+// Redaction controls how payload text is recorded.
+type Redaction struct {
+	Mode      string
+	MaxLength int
+}
+
+// payloadRedaction is applied by redactPayload. It is set from the config
+// at startup and left off by default.
+var payloadRedaction = Redaction{Mode: RedactOff}
+
+// NewRedaction validates a redaction mode.
+func NewRedaction(mode string, maxLength int) (Redaction, error) {
+	switch mode {
+	case RedactOff, RedactTruncate, RedactHash:
+		return Redaction{Mode: mode, MaxLength: max(maxLength, 0)}, nil
+	default:
+		return Redaction{}, fmt.Errorf("unknown redaction mode %q", mode)
+	}
+}
+
+// Apply returns s truncated to MaxLength runes or replaced by a short
+// hash, so equal payloads can still be correlated without being readable.
+func (r Redaction) Apply(s string) string {
+	switch r.Mode {
+	case RedactTruncate:
+		runes := []rune(s)
+		if len(runes) <= r.MaxLength {
+			return s
+		}
+		return fmt.Sprintf("%s... (%d bytes)", string(runes[:r.MaxLength]), len(s))
+	case RedactHash:
+		sum := sha256.Sum256([]byte(s))
+		return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:8]), len(s))
+	default:
+		return s
+	}
+}
+
+// redactPayload applies the configured redaction to an error or text that
+// may echo payloads, such as build output or agent errors.
+func redactPayload(v any) string {
+	return payloadRedaction.Apply(fmt.Sprint(v))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// buildRepairPrompt asks the agent to fix a failed build.
+const buildRepairPrompt = `The app failed to build with the following error. Fix the source files so that it builds, without changing the app's behaviour.
+
+%s`
+
+// BuildEvent is sent to chat streams as a transient data-build event when
+// BUILD_ERROR_EVENTS is set: repairing for each failed compile sent back to
+// the agent, then succeeded or failed once the build is done. Attempt is
+// the number of repairs made so far.
+type BuildEvent struct {
+	State   string `json:"state"`
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error,omitempty"`
+}
+
+type buildFailureObserverKey struct{}
+
+// withBuildFailureObserver has observe called, for builds run with ctx,
+// with each failed compile that is about to be sent to the agent for
+// repair, numbered from zero. A failure that is given up on is left to the
+// build's caller.
+func withBuildFailureObserver(ctx context.Context, observe func(attempt int, err error)) context.Context {
+	return context.WithValue(ctx, buildFailureObserverKey{}, observe)
+}
+
+// observeBuildFailure reports a failed compile to ctx's observer, if any.
+func observeBuildFailure(ctx context.Context, attempt int, err error) {
+	if observe, ok := ctx.Value(buildFailureObserverKey{}).(func(int, error)); ok {
+		observe(attempt, err)
+	}
+}
+
+// buildWithRepair builds the files and, when the build fails, feeds the error
+// back to the agent and retries with its fixes, up to BuildRepairAttempts
+// times. Repaired source files are written to storage as they are applied.
+func (h *Handlers) buildWithRepair(ctx context.Context, projectID string, files map[string]string) (map[string]string, error) {
+	ctx, done := h.streams.Track(ctx, StreamBuild, projectID)
+	defer done()
+
+	var tokens int64
+	var buildTime time.Duration
+	defer func() { h.recordUsage(ctx, projectID, tokens, buildTime) }()
+
+	compiledFiles, elapsed, err := h.recordedBuild(ctx, projectID, files, 0)
+	buildTime += elapsed
+	for attempt := 1; err != nil && attempt <= h.cfg.BuildRepairAttempts; attempt++ {
+		observeBuildFailure(ctx, attempt-1, err)
+		log.Printf("Build failed for project %s, asking agent to repair (attempt %d/%d): %v", projectID, attempt, h.cfg.BuildRepairAttempts, redactPayload(err))
+
+		result, editErr := h.pythonClient.EditApp(ctx, fmt.Sprintf(buildRepairPrompt, err), files)
+		if editErr != nil {
+			return nil, fmt.Errorf("build repair failed: %w (build error: %v)", editErr, err)
+		}
+		tokens += result.Tokens
+		if validErr := h.validateSourceFiles(result.Files); validErr != nil {
+			return nil, validErr
+		}
+		if storeErr := h.storage.ReplaceSourceFiles(ctx, projectID, result.Files); storeErr != nil {
+			return nil, storeErr
+		}
+		written, removed := diffFiles(files, result.Files)
+		h.changes.PublishFiles(projectID, "repair", written, removed)
+
+		files = result.Files
+		compiledFiles, elapsed, err = h.recordedBuild(ctx, projectID, files, attempt)
+		buildTime += elapsed
+	}
+	return compiledFiles, err
+}
+
+// recordedBuild compiles files and stores a build record for the attempt,
+// returning the compiled files and how long the build took.
+func (h *Handlers) recordedBuild(ctx context.Context, projectID string, files map[string]string, attempt int) (map[string]string, time.Duration, error) {
+	record := newBuildRecord(ctx, files)
+	record.Attempt = attempt
+	profile, optimizeImages := h.cfg.DefaultBuildProfile, h.cfg.OptimizeImages
+	if settings, settingsErr := h.projectSettings(ctx, projectID); settingsErr == nil {
+		profile, optimizeImages = settings.BuildProfile, settings.OptimizeImages
+	}
+	result, err := h.nodeBuildClient.Build(ctx, files, profile, optimizeImages)
+	record.finish(result, err)
+	h.saveBuildRecord(ctx, projectID, record)
+	if err == nil {
+		h.saveLicenseReport(ctx, projectID, record.InputHash, result.Dependencies)
+	}
+
+	elapsed := time.Duration(record.DurationMS) * time.Millisecond
+	if err != nil {
+		return nil, elapsed, err
+	}
+	return result.Compiled, elapsed, nil
+}
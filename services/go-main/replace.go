@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxReplacePreviewLines caps the changed lines previewed per file.
+const maxReplacePreviewLines = 20
+
+// ReplaceRequest is the request body for POST /{uuid}/files/replace.
+type ReplaceRequest struct {
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+	// Regex treats Search as a Go regular expression; Replace may then use
+	// $1-style group references.
+	Regex bool `json:"regex"`
+	// Paths optionally restricts the replacement to files matching any of
+	// these globs, e.g. "src/components/*.tsx".
+	Paths  []string `json:"paths,omitempty"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// LineChange previews one changed line.
+type LineChange struct {
+	Line   int    `json:"line"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// FileReplaceResult reports the replacements made in one file.
+type FileReplaceResult struct {
+	Path    string       `json:"path"`
+	Matches int          `json:"matches"`
+	Preview []LineChange `json:"preview"`
+}
+
+// ReplaceResponse is the response for POST /{uuid}/files/replace.
+type ReplaceResponse struct {
+	Files   []FileReplaceResult `json:"files"`
+	Matches int                 `json:"matches"`
+	DryRun  bool                `json:"dry_run"`
+	// Revision is the change event revision of the applied replacement.
+	Revision   int64  `json:"revision,omitempty"`
+	BuildError string `json:"build_error,omitempty"`
+}
+
+// matchesAnyGlob reports whether p matches one of globs, or globs is empty.
+func matchesAnyGlob(p string, globs []string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// previewLineChanges lists lines that differ between before and after.
+// Replacements that add or remove lines are previewed from the first
+// difference only, since line numbers no longer align after it.
+func previewLineChanges(before, after string) []LineChange {
+	beforeLines := strings.Split(before, "\n")
+	afterLines := strings.Split(after, "\n")
+
+	var changes []LineChange
+	for i := 0; i < min(len(beforeLines), len(afterLines)) && len(changes) < maxReplacePreviewLines; i++ {
+		if beforeLines[i] != afterLines[i] {
+			changes = append(changes, LineChange{Line: i + 1, Before: beforeLines[i], After: afterLines[i]})
+			if len(beforeLines) != len(afterLines) {
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// HandleReplace performs a literal or regex search/replace across source
+// files. Dry runs only preview; otherwise changed files are written as one
+// change and the app is rebuilt.
+func (h *Handlers) HandleReplace(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req ReplaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if req.Search == "" {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "search is required"})
+		return
+	}
+	for _, glob := range req.Paths {
+		if _, err := path.Match(glob, ""); err != nil {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid path glob: " + glob})
+			return
+		}
+	}
+
+	var pattern *regexp.Regexp
+	if req.Regex {
+		var err error
+		if pattern, err = regexp.Compile(req.Search); err != nil {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid regex: " + err.Error()})
+			return
+		}
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "replace")
+	if !ok {
+		return
+	}
+	defer release()
+
+	files, err := h.storage.GetSourceFiles(r.Context(), projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, err)
+		return
+	}
+	if len(files) == 0 {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"})
+		return
+	}
+
+	resp := ReplaceResponse{Files: []FileReplaceResult{}, DryRun: req.DryRun}
+	updated := make(map[string]string)
+	for _, p := range slices.Sorted(maps.Keys(files)) {
+		if !matchesAnyGlob(p, req.Paths) {
+			continue
+		}
+		content := files[p]
+
+		var matches int
+		var replaced string
+		if pattern != nil {
+			matches = len(pattern.FindAllStringIndex(content, -1))
+			replaced = pattern.ReplaceAllString(content, req.Replace)
+		} else {
+			matches = strings.Count(content, req.Search)
+			replaced = strings.ReplaceAll(content, req.Search, req.Replace)
+		}
+		if matches == 0 || replaced == content {
+			continue
+		}
+
+		updated[p] = replaced
+		resp.Matches += matches
+		resp.Files = append(resp.Files, FileReplaceResult{
+			Path:    p,
+			Matches: matches,
+			Preview: previewLineChanges(content, replaced),
+		})
+	}
+
+	if req.DryRun || len(updated) == 0 {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	next := maps.Clone(files)
+	maps.Copy(next, updated)
+	if err := h.validateSourceFiles(next); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for p, content := range updated {
+		if err := h.storage.StoreSourceFile(r.Context(), projectID, p, content); err != nil {
+			writeError(w, upstreamError("Failed to store files", err))
+			return
+		}
+	}
+	resp.Revision = h.changes.PublishFiles(projectID, "replace", updated, nil)
+
+	if err := h.rebuild(withBuildTrigger(r.Context(), "replace"), projectID); err != nil {
+		resp.BuildError = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
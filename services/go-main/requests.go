@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+// RequestError reports a request body that is too large (413) or fails
+// validation (422), naming the field and limit it broke where there is one.
+type RequestError struct {
+	Code    int    `json:"-"`
+	Message string `json:"error"`
+	Field   string `json:"field,omitempty"`
+	Limit   int64  `json:"limit,omitempty"`
+}
+
+func (e RequestError) Error() string {
+	return e.Message
+}
+
+// limitedBody caps a request body at the limit of the innermost
+// BodyLimitMiddleware, which it applies when first read.
+type limitedBody struct {
+	w             http.ResponseWriter
+	body          io.ReadCloser
+	contentLength int64
+	limit         int64
+	reader        io.Reader
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		switch {
+		case b.limit <= 0:
+			b.reader = b.body
+		case b.contentLength > b.limit:
+			// Declared too large, so refused without reading any of it
+			return 0, &http.MaxBytesError{Limit: b.limit}
+		default:
+			b.reader = http.MaxBytesReader(b.w, b.body, b.limit)
+		}
+	}
+	return b.reader.Read(p)
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// BodyLimitMiddleware caps request bodies at limit bytes; zero or less
+// leaves them unlimited. A route's own BodyLimitMiddleware replaces the
+// limit of its group's rather than adding to it. Bodies declaring a larger
+// Content-Length fail on their first read.
+func BodyLimitMiddleware(limit int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if body, ok := r.Body.(*limitedBody); ok {
+				body.limit = int64(limit)
+			} else if r.Body != nil {
+				r.Body = &limitedBody{w: w, body: r.Body, contentLength: r.ContentLength, limit: int64(limit)}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyTooLarge is the error for a body over limit bytes.
+func bodyTooLarge(limit int64) RequestError {
+	return RequestError{Code: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Request body exceeds %d bytes", limit), Limit: limit}
+}
+
+// readBody reads the request body, reporting one over its
+// BodyLimitMiddleware limit as a RequestError.
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, bodyTooLarge(maxBytesErr.Limit)
+	}
+	if err != nil {
+		return nil, AppError{Code: http.StatusBadRequest, Message: "Failed to read request body"}
+	}
+	return body, nil
+}
+
+// readJSONBody reads a JSON request body, checking it is valid UTF-8:
+// decoding would quietly replace invalid bytes.
+func readJSONBody(r *http.Request) ([]byte, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(body) {
+		return nil, RequestError{Code: http.StatusUnprocessableEntity, Message: "Request body is not valid UTF-8"}
+	}
+	return body, nil
+}
+
+// decodeJSONBody reads a JSON request body into v.
+func decodeJSONBody(r *http.Request, v any) error {
+	body, err := readJSONBody(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"}
+	}
+	return nil
+}
+
+// validatePrompt checks a create or edit prompt against MAX_PROMPT_LENGTH.
+func (h *Handlers) validatePrompt(prompt string) error {
+	if prompt == "" {
+		return AppError{Code: http.StatusBadRequest, Message: "Prompt is required"}
+	}
+	if h.cfg.MaxPromptLength > 0 && utf8.RuneCountInString(prompt) > h.cfg.MaxPromptLength {
+		return RequestError{
+			Code:    http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("Prompt exceeds %d characters", h.cfg.MaxPromptLength),
+			Field:   "prompt",
+			Limit:   int64(h.cfg.MaxPromptLength),
+		}
+	}
+	return nil
+}
+
+// validateChatRequest checks an AI SDK chat request body against
+// MAX_CHAT_MESSAGES, and the text of each user message against
+// MAX_PROMPT_LENGTH.
+func (h *Handlers) validateChatRequest(body []byte) error {
+	var req struct {
+		Messages []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return AppError{Code: http.StatusBadRequest, Message: "Invalid JSON in request body"}
+	}
+	if h.cfg.MaxChatMessages > 0 && len(req.Messages) > h.cfg.MaxChatMessages {
+		return RequestError{
+			Code:    http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("A chat has at most %d messages", h.cfg.MaxChatMessages),
+			Field:   "messages",
+			Limit:   int64(h.cfg.MaxChatMessages),
+		}
+	}
+	if h.cfg.MaxPromptLength <= 0 {
+		return nil
+	}
+	for i, message := range req.Messages {
+		if message.Role != "user" {
+			continue
+		}
+		length := 0
+		for _, part := range message.Parts {
+			if part.Type == "text" {
+				length += utf8.RuneCountInString(part.Text)
+			}
+		}
+		if length > h.cfg.MaxPromptLength {
+			return RequestError{
+				Code:    http.StatusUnprocessableEntity,
+				Message: fmt.Sprintf("Message %d exceeds %d characters", i+1, h.cfg.MaxPromptLength),
+				Field:   fmt.Sprintf("messages[%d]", i),
+				Limit:   int64(h.cfg.MaxPromptLength),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRetryBackoff caps the wait between retries of a downstream call.
+const maxRetryBackoff = 2 * time.Second
+
+var downstreamRetries = metrics.counter("downstream_retries_total",
+	"Calls to internal services retried after a transient failure", "service")
+
+// CircuitOpenError is returned without calling a downstream whose circuit
+// breaker is open.
+type CircuitOpenError struct {
+	Service    string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable (circuit breaker open for another %s)", e.Service, e.RetryAfter.Round(time.Millisecond))
+}
+
+// CircuitBreaker stops calls to a downstream after consecutive failures so
+// requests fail fast instead of each waiting on a service that is down.
+// Once open, one call per cooldown is let through to probe for recovery; a
+// success closes the circuit again.
+type CircuitBreaker struct {
+	service  string
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	consecutive int
+	retryAt     time.Time
+}
+
+// NewCircuitBreaker creates a breaker for service that opens after failures
+// consecutive failures, or never if failures is zero.
+func NewCircuitBreaker(service string, failures int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{service: service, failures: failures, cooldown: cooldown}
+}
+
+// allow reports whether a call may proceed, or the error to fail it with.
+func (b *CircuitBreaker) allow() error {
+	if b.failures <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.consecutive < b.failures {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(b.retryAt) {
+		return &CircuitOpenError{Service: b.service, RetryAfter: b.retryAt.Sub(now)}
+	}
+	// Let this call probe the downstream and hold everyone else back
+	b.retryAt = now.Add(b.cooldown)
+	return nil
+}
+
+// record adds the outcome of a call.
+func (b *CircuitBreaker) record(failed bool) {
+	if b.failures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.consecutive >= b.failures {
+			log.Printf("%s recovered, closing circuit breaker", b.service)
+		}
+		b.consecutive = 0
+		return
+	}
+	b.consecutive++
+	if b.consecutive == b.failures {
+		log.Printf("%s failed %d times in a row, opening circuit breaker for %s", b.service, b.consecutive, b.cooldown)
+	}
+	if b.consecutive >= b.failures {
+		b.retryAt = time.Now().Add(b.cooldown)
+	}
+}
+
+// isTransient reports whether a call failed in a way another attempt may
+// fix: the connection failed or the service said it was unavailable.
+func isTransient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isIdempotent reports whether req can safely be sent again. Conditional
+// writes aren't: a retry of one that succeeded would fail its precondition.
+// Callers mark other safe requests with a nil Idempotency-Key header, as
+// net/http does, which isn't sent.
+func isIdempotent(req *http.Request) bool {
+	if _, ok := req.Header["Idempotency-Key"]; ok {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPut, http.MethodDelete:
+		return req.Header.Get("If-Match") == "" && req.Header.Get("If-None-Match") == ""
+	}
+	return false
+}
+
+// retryBackoff returns the wait before retry attempt (from 1): exponential
+// from base, capped, with jitter so callers don't retry in lockstep.
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	backoff := min(base<<(attempt-1), maxRetryBackoff)
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// resilienceTransport retries transient failures of idempotent calls and
+// fails calls fast while the downstream's circuit breaker is open.
+type resilienceTransport struct {
+	service string
+	retries int
+	backoff time.Duration
+	breaker *CircuitBreaker
+	base    http.RoundTripper
+}
+
+func (t *resilienceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	retries := t.retries
+	if !isIdempotent(req) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		// Calls cancelled by our own client say nothing about the downstream
+		if errors.Is(req.Context().Err(), context.Canceled) {
+			return resp, err
+		}
+		transient := isTransient(resp, err)
+		if !transient || attempt >= retries {
+			t.breaker.record(transient)
+			return resp, err
+		}
+
+		wait := retryBackoff(t.backoff, attempt+1)
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < wait {
+			t.breaker.record(true)
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		}
+		downstreamRetries.Add(1, t.service)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// withResilience wraps base so transient failures of idempotent calls to
+// service are retried up to retries times, starting backoff apart, and
+// calls fail fast while breaker is open.
+func withResilience(base http.RoundTripper, service string, retries int, backoff time.Duration, breaker *CircuitBreaker) http.RoundTripper {
+	return &resilienceTransport{service: service, retries: retries, backoff: backoff, breaker: breaker, base: base}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// robotsDirectives are the X-Robots-Tag directives a project may set.
+var robotsDirectives = []string{
+	"all", "none", "noindex", "nofollow", "noarchive", "nosnippet",
+	"noimageindex", "notranslate", "indexifembedded",
+}
+
+// RobotsSettings controls how search engines may index a project's app.
+type RobotsSettings struct {
+	// Tag is sent as X-Robots-Tag on view and asset responses,
+	// e.g. "noindex, nofollow". Empty sends no header.
+	Tag string `json:"tag"`
+	// DisallowAll makes the served robots.txt disallow every crawler.
+	DisallowAll bool `json:"disallow_all"`
+	// InjectMeta adds a <meta name="robots"> tag carrying Tag to the page.
+	InjectMeta bool `json:"inject_meta"`
+}
+
+// normalizeRobotsTag validates a comma-separated directive list and returns
+// it in canonical form.
+func normalizeRobotsTag(tag string) (string, error) {
+	var directives []string
+	for d := range strings.SplitSeq(tag, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" {
+			continue
+		}
+		if !slices.Contains(robotsDirectives, d) {
+			return "", AppError{Code: http.StatusBadRequest, Message: "Unsupported robots directive: " + d}
+		}
+		directives = append(directives, d)
+	}
+	return strings.Join(directives, ", "), nil
+}
+
+// setRobotsHeader adds the project's X-Robots-Tag, if any.
+func setRobotsHeader(w http.ResponseWriter, robots *RobotsSettings) {
+	if robots != nil && robots.Tag != "" {
+		w.Header().Set("X-Robots-Tag", robots.Tag)
+	}
+}
+
+// injectRobotsMeta adds a robots meta tag to the page's head.
+func injectRobotsMeta(page, tag string) string {
+	meta := `<meta name="robots" content="` + html.EscapeString(tag) + `">`
+	if i := strings.Index(page, "</head>"); i >= 0 {
+		return page[:i] + meta + page[i:]
+	}
+	return meta + page
+}
+
+// HandleGetRobots returns the project's indexing settings.
+func (h *Handlers) HandleGetRobots(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	robots, err := h.storage.GetRobots(r.Context(), projectID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			writeError(w, err)
+			return
+		}
+		robots = &RobotsSettings{}
+	}
+
+	writeJSON(w, http.StatusOK, robots)
+}
+
+// HandleSaveRobots replaces the project's indexing settings.
+func (h *Handlers) HandleSaveRobots(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var robots RobotsSettings
+	if err := json.NewDecoder(r.Body).Decode(&robots); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	tag, err := normalizeRobotsTag(robots.Tag)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	robots.Tag = tag
+	if robots.InjectMeta && robots.Tag == "" {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "inject_meta requires a tag"})
+		return
+	}
+
+	if err := h.storage.StoreRobots(r.Context(), projectID, &robots); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store robots settings: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, robots)
+}
+
+// HandleRobotsTxt serves a robots.txt for the project's view path.
+func (h *Handlers) HandleRobotsTxt(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	robots, err := h.storage.GetRobots(r.Context(), projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, err)
+		return
+	}
+
+	rule := "Allow: /"
+	if robots != nil && robots.DisallowAll {
+		// Scope the rule to this project's view path
+		rule = "Disallow: " + strings.TrimSuffix(r.URL.Path, "robots.txt")
+	}
+
+	setRobotsHeader(w, robots)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("User-agent: *\n" + rule + "\n"))
+}
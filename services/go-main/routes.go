@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// APIVersion is the current API version prefix. New endpoints are only
+// served under it; the unversioned /api prefix keeps the routes that
+// predate versioning as aliases for existing clients.
+const APIVersion = "v1"
+
+// legacyProjectRoutes are the project routes served under the unversioned
+// /api prefix, by method and path after the project ID. Paths ending in
+// "/" match everything below them.
+var legacyProjectRoutes = map[string][]string{
+	http.MethodGet:  {"/state", "/view", "/view/assets/", "/assets/"},
+	http.MethodPost: {"/conversation", "/create", "/edit", "/chat"},
+}
+
+// isLegacyRoute reports whether a request under the unversioned /api
+// prefix is for a route that predates versioning.
+func isLegacyRoute(r *http.Request) bool {
+	rest := strings.TrimPrefix(r.URL.Path, "/api")
+	if rest == "/health" {
+		return r.Method == http.MethodGet
+	}
+	_, sub, ok := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	if !ok {
+		return false
+	}
+	for _, route := range legacyProjectRoutes[r.Method] {
+		if "/"+sub == route || (strings.HasSuffix(route, "/") && strings.HasPrefix("/"+sub, route)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LegacyRoutesMiddleware answers 404 for routes added since versioning
+// when requested without the version prefix.
+func LegacyRoutesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLegacyRoute(r) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mountAPIRoutes registers the API under /api/v1, and the routes that
+// predate versioning under the legacy /api prefix too.
+func mountAPIRoutes(r chi.Router, h *Handlers) {
+	r.Route("/api", func(r chi.Router) {
+		r.Use(h.ReadOnlyMiddleware)
+		r.Use(BodyLimitMiddleware(h.cfg.MaxBodyBytes))
+
+		r.Route("/"+APIVersion, apiRoutes(h))
+
+		// Legacy unversioned routes
+		r.Group(func(r chi.Router) {
+			r.Use(LegacyRoutesMiddleware)
+			apiRoutes(h)(r)
+		})
+	})
+}
+
+// apiRoutes returns the route definitions shared by every API prefix.
+// Routes are grouped by timeout class: most get the short RouteTimeout so
+// slow storage can't hold them for minutes, generation gets
+// GenerationTimeout, and streaming has no limit.
+func apiRoutes(h *Handlers) func(chi.Router) {
+	return func(r chi.Router) {
+		r.With(BudgetMiddleware(h.cfg.RouteTimeout)).Get("/health", h.HandleHealth)
+		r.With(BudgetMiddleware(h.cfg.RouteTimeout)).Get("/readyz", h.HandleReady)
+
+		// Operator endpoints
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(h.AdminMiddleware)
+
+			r.Group(func(r chi.Router) {
+				r.Use(BudgetMiddleware(h.cfg.RouteTimeout))
+
+				r.Get("/streams", h.HandleListStreams)
+				r.Get("/locks", h.HandleListLocks)
+				r.Get("/locks/{uuid}", h.HandleGetLock)
+				r.Delete("/streams/{streamID}", h.HandleTerminateStream)
+				r.Get("/projects", h.HandleListProjects)
+				r.Get("/captures", h.HandleListCaptures)
+				r.Get("/captures/{captureID}", h.HandleGetCapture)
+				r.Get("/settings", h.HandleGetOrgDefaults)
+				r.Patch("/settings", h.HandlePatchOrgDefaults)
+				r.Get("/keys", h.HandleListAPIKeys)
+				r.Post("/keys", h.HandleCreateAPIKey)
+				r.Delete("/keys/{keyID}", h.HandleDeleteAPIKey)
+			})
+
+			// Capture replays run a full generation, and seeding and event replays
+			// write many files
+			r.Group(func(r chi.Router) {
+				r.Use(BudgetMiddleware(h.cfg.GenerationTimeout))
+
+				r.Post("/captures/{captureID}/replay", h.HandleReplayCapture)
+				r.Post("/seed", h.HandleSeed)
+				r.Post("/projects/{uuid}/replay", h.HandleReplayEvents)
+			})
+		})
+
+		// Workspaces group projects; access is checked against each member
+		// project rather than by AuthMiddleware
+		r.Route("/workspaces", func(r chi.Router) {
+			r.Use(BudgetMiddleware(h.cfg.RouteTimeout))
+
+			r.Post("/", h.HandleCreateWorkspace)
+			r.Get("/{workspaceID}", h.HandleGetWorkspace)
+			r.Delete("/{workspaceID}", h.HandleDeleteWorkspace)
+			r.Put("/{workspaceID}/projects/{uuid}", h.HandleAddWorkspaceProject)
+			r.Delete("/{workspaceID}/projects/{uuid}", h.HandleRemoveWorkspaceProject)
+			r.Get("/{workspaceID}/conversation", h.HandleGetWorkspaceConversation)
+			r.With(BodyLimitMiddleware(h.cfg.MaxChatBodyBytes)).Post("/{workspaceID}/conversation", h.HandleSaveWorkspaceConversation)
+		})
+
+		// The caller's asset library, shared by their projects
+		r.Route("/library", func(r chi.Router) {
+			r.Use(BudgetMiddleware(h.cfg.RouteTimeout))
+
+			r.Get("/", h.HandleListLibrary)
+			r.Get("/assets/*", h.HandleGetLibraryAsset)
+			r.With(BodyLimitMiddleware(h.cfg.MaxFileSize)).Put("/assets/*", h.HandlePutLibraryAsset)
+			r.Delete("/assets/*", h.HandleDeleteLibraryAsset)
+
+			r.Get("/brand", h.HandleGetBrandKit)
+			r.Put("/brand", h.HandlePutBrandKit)
+			r.Delete("/brand", h.HandleDeleteBrandKit)
+		})
+
+		// Project API routes
+		r.Route("/{uuid}", func(r chi.Router) {
+			r.Use(h.AuthMiddleware)
+			r.Use(h.AuditMiddleware)
+
+			// Agent generation and bulk copies
+			r.Group(func(r chi.Router) {
+				r.Use(BudgetMiddleware(h.cfg.GenerationTimeout))
+				r.Use(h.AffinityMiddleware)
+
+				r.With(h.AsyncMiddleware("create")).Post("/create", h.HandleCreate)
+				r.With(h.AsyncMiddleware("create")).Post("/blueprint", h.HandleCreateFromBlueprint)
+				r.With(h.AsyncMiddleware("edit")).Post("/edit", h.HandleEdit)
+				r.With(h.AsyncMiddleware("promote")).Post("/promote", h.HandlePromote)
+				r.With(h.AsyncMiddleware("rebuild")).Post("/rebuild", h.HandleRebuild)
+				r.Post("/files/replace", h.HandleReplace)
+				r.Post("/files/*", h.HandleFilePost) // Comments and single-file reverts, which rebuild
+				r.Post("/fsck", h.HandleFsck)
+				r.Post("/fork", h.HandleFork)
+				r.Post("/rollback/{n}", h.HandleRollback)
+				r.With(h.WriterOnly).Get("/licenses", h.HandleLicenses)
+				r.Post("/audit/security/remediate", h.HandleRemediateSecurity)
+			})
+
+			// Streaming, bounded only by the client connection (operation
+			// polls set their own RouteTimeout)
+			r.With(BodyLimitMiddleware(h.cfg.MaxChatBodyBytes), h.AffinityMiddleware).Post("/chat", h.HandleChat)
+			r.With(h.AffinityMiddleware).Post("/create/stream", h.HandleCreateStream)
+			r.With(h.WriterOnly, h.AffinityMiddleware).Get("/chat/{streamID}", h.HandleResumeChat)
+			r.With(h.AffinityMiddleware).Post("/chat/abort", h.HandleAbortChat)
+			r.With(h.WriterOnly, h.AffinityMiddleware).Get("/changes", h.HandleChanges)
+			r.Get("/operations/{id}", h.HandleGetOperation)
+
+			r.Group(func(r chi.Router) {
+				r.Use(BudgetMiddleware(h.cfg.RouteTimeout))
+
+				r.Delete("/", h.HandleDeleteProject)
+				r.Post("/delete/cancel", h.HandleCancelDeletion)
+
+				r.Get("/state", h.HandleGetState)
+				r.With(BodyLimitMiddleware(h.cfg.MaxChatBodyBytes)).Post("/conversation", h.HandleSaveConversation)
+
+				r.Get("/experiment", h.HandleGetExperiment)
+				r.Put("/experiment", h.HandleSaveExperiment)
+				r.Delete("/experiment", h.HandleDeleteExperiment)
+
+				r.With(h.ShedMiddleware(allRequests)).Get("/stats/views", h.HandleGetViewStats)
+				r.With(h.ShedMiddleware(allRequests)).Get("/stats/lighthouse", h.HandleGetLighthouseStats)
+				r.With(h.ShedMiddleware(allRequests)).Get("/audit", h.HandleGetAudit)
+				r.Get("/audit/security", h.HandleSecurityAudit)
+				r.Get("/versions", h.HandleListVersions)
+				r.Get("/build", h.HandleGetBuildStatus)
+				r.Get("/operations/{id}/result", h.HandleGetOperationResult)
+				r.Get("/builds", h.HandleListBuilds)
+				r.Get("/builds/{a}/diff/{b}", h.HandleDiffBuilds)
+				r.Get("/tasks", h.HandleListTasks)
+				r.Patch("/tasks/{taskID}", h.HandleUpdateTask)
+				r.Delete("/tasks/{taskID}", h.HandleDeleteTask)
+				r.Get("/comments", h.HandleListComments)
+				r.Delete("/comments/{commentID}", h.HandleDeleteComment)
+				r.Get("/files/*", h.HandleFileGet)
+				r.Get("/events", h.HandleListEvents)
+				r.Get("/events/manifest", h.HandleGetEventManifest)
+				r.With(h.WriterOnly, h.AsyncMiddleware("export")).Get("/export/repo", h.HandleExportRepo)
+				r.Get("/blueprint", h.HandleExportBlueprint)
+
+				r.Put("/secrets/{name}", h.HandleSaveSecret)
+				r.Delete("/secrets/{name}", h.HandleDeleteSecret)
+				r.Get("/library", h.HandleGetProjectLibrary)
+				r.Put("/library", h.HandleLinkProjectLibrary)
+				r.Delete("/library", h.HandleUnlinkProjectLibrary)
+				r.Get("/robots", h.HandleGetRobots)
+				r.Put("/robots", h.HandleSaveRobots)
+
+				r.Get("/view", h.HandleView)
+				r.Get("/embed.js", h.HandleEmbedScript)
+				r.Get("/view/robots.txt", h.HandleRobotsTxt)
+				r.Get("/view/"+proxyPath, h.HandleProxy)
+				r.Post("/view/"+formsPath+"/{name}", h.HandleSubmitForm)
+				r.Get("/forms/{name}/submissions", h.HandleListFormSubmissions)
+				r.Delete("/forms/{name}/submissions/{submissionID}", h.HandleDeleteFormSubmission)
+				r.Get("/view/"+dataPath, h.HandleListAppData)
+				r.Get("/view/"+dataPath+"/*", h.HandleGetAppData)
+				r.With(BodyLimitMiddleware(h.cfg.MaxDataValueBytes)).Put("/view/"+dataPath+"/*", h.HandlePutAppData)
+				r.Delete("/view/"+dataPath+"/*", h.HandleDeleteAppData)
+				r.Get("/pwa", h.HandleGetPWA)
+				r.Put("/pwa", h.HandleSavePWA)
+				r.Get("/sri", h.HandleGetSRI)
+				r.Put("/sri", h.HandleSaveSRI)
+				r.Get("/settings", h.HandleGetSettings)
+				r.Patch("/settings", h.HandlePatchSettings)
+				r.Get("/"+serviceWorkerPath, h.HandleServiceWorker)
+				r.Get("/favicon.svg", h.HandleFavicon)
+				r.Get("/manifest.webmanifest", h.HandleManifest)
+				r.With(h.ShedMiddleware(isPrefetch)).Get("/view/assets/*", h.HandleAsset)
+				r.With(h.ShedMiddleware(isPrefetch)).Get("/assets/*", h.HandleAsset) // Alias for relative URL resolution from /view
+
+				r.Put("/hooks/{hookID}", h.HandleSaveHook)
+				r.Delete("/hooks/{hookID}", h.HandleDeleteHook)
+				r.Post("/hooks/{hookID}", h.HandleTriggerHook)
+
+				r.Put("/git", h.HandleLinkGit)
+				r.Delete("/git", h.HandleUnlinkGit)
+				r.Post("/git/push", h.HandleGitPush)
+
+				r.Get("/schedule", h.HandleGetSchedule)
+				r.Put("/schedule", h.HandleSaveSchedule)
+				r.Delete("/schedule", h.HandleDeleteSchedule)
+			})
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Schedule is a per-project cron job that rebuilds or regenerates the app.
+type Schedule struct {
+	Cron   string `json:"cron"`
+	Action string `json:"action"`
+	// Prompt is a text/template rendered with PromptTemplateData for edit schedules.
+	Prompt    string    `json:"prompt,omitempty"`
+	LastRun   time.Time `json:"last_run,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Scheduler runs project schedules and purges expired deletions once a minute.
+type Scheduler struct {
+	h *Handlers
+}
+
+// NewScheduler creates a new Scheduler.
+func NewScheduler(h *Handlers) *Scheduler {
+	return &Scheduler{h: h}
+}
+
+// Run fires due schedules at the top of every minute until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		s.tick(ctx, next.UTC())
+		s.purgeDueDeletions(ctx, next.UTC())
+	}
+}
+
+// tick starts every schedule that matches the given minute.
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	projectIDs, err := s.h.storage.ListScheduledProjects(ctx)
+	if err != nil {
+		log.Printf("Error listing schedules: %v", err)
+		return
+	}
+
+	for _, projectID := range projectIDs {
+		sched, err := s.h.storage.GetSchedule(ctx, projectID)
+		if err != nil {
+			log.Printf("Error loading schedule for project %s: %v", projectID, err)
+			continue
+		}
+		cron, err := ParseCron(sched.Cron)
+		if err != nil || !cron.Matches(now) {
+			continue
+		}
+		go s.run(ctx, projectID, sched, now)
+	}
+}
+
+// run executes a due schedule and records the outcome.
+func (s *Scheduler) run(ctx context.Context, projectID string, sched *Schedule, now time.Time) {
+	prompt, err := renderPrompt(projectID, sched.Prompt, PromptTemplateData{Now: now})
+	if err == nil {
+		err = s.h.runAction(withBuildTrigger(ctx, "schedule"), projectID, sched.Action, prompt)
+	}
+
+	sched.LastRun = now
+	sched.LastError = ""
+	if err != nil {
+		log.Printf("Scheduled %s failed for project %s: %v", sched.Action, projectID, redactPayload(err))
+		sched.LastError = err.Error()
+	}
+	if storeErr := s.h.storage.StoreSchedule(ctx, projectID, sched); storeErr != nil {
+		log.Printf("Error saving schedule for project %s: %v", projectID, storeErr)
+	}
+}
+
+// HandleGetSchedule returns the project's schedule.
+func (h *Handlers) HandleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sched, err := h.storage.GetSchedule(r.Context(), projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: "No schedule for this project"})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sched)
+}
+
+// HandleSaveSchedule creates or replaces the project's schedule.
+func (h *Handlers) HandleSaveSchedule(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var sched Schedule
+	if err := json.NewDecoder(r.Body).Decode(&sched); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	if _, err := ParseCron(sched.Cron); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid cron expression: %v", err)})
+		return
+	}
+
+	switch sched.Action {
+	case ActionRebuild:
+	case ActionEdit:
+		if sched.Prompt == "" {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "Prompt is required for edit schedules"})
+			return
+		}
+		if _, err := renderPrompt(projectID, sched.Prompt, PromptTemplateData{Now: time.Now().UTC()}); err != nil {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid prompt template: %v", err)})
+			return
+		}
+	default:
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Action must be 'rebuild' or 'edit'"})
+		return
+	}
+
+	sched.LastRun = time.Time{}
+	sched.LastError = ""
+	if err := h.storage.StoreSchedule(r.Context(), projectID, &sched); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store schedule: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sched)
+}
+
+// HandleDeleteSchedule removes the project's schedule.
+func (h *Handlers) HandleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.storage.DeleteSchedule(r.Context(), projectID); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to delete schedule: %v", err)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// providerAPIKeySecret is the project secret holding the user's LLM provider key.
+const providerAPIKeySecret = "provider_api_key"
+
+var secretNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// ErrSecretsDisabled is returned when no SECRETS_KEY is configured.
+var ErrSecretsDisabled = AppError{Code: http.StatusServiceUnavailable, Message: "Secrets are not configured"}
+
+// Secrets encrypts values with AES-256-GCM before they are stored.
+type Secrets struct {
+	aead cipher.AEAD
+}
+
+// NewSecrets creates a Secrets instance from a hex-encoded 32 byte key.
+// An empty key disables secrets.
+func NewSecrets(hexKey string) (*Secrets, error) {
+	if hexKey == "" {
+		return &Secrets{}, nil
+	}
+
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("SECRETS_KEY must be 32 hex-encoded bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Secrets{aead: aead}, nil
+}
+
+// Seal encrypts a value, prefixing the random nonce.
+func (s *Secrets) Seal(plaintext []byte) ([]byte, error) {
+	if s.aead == nil {
+		return nil, ErrSecretsDisabled
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a value produced by Seal.
+func (s *Secrets) Open(ciphertext []byte) ([]byte, error) {
+	if s.aead == nil {
+		return nil, ErrSecretsDisabled
+	}
+	if len(ciphertext) < s.aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:s.aead.NonceSize()], ciphertext[s.aead.NonceSize():]
+	return s.aead.Open(nil, nonce, sealed, nil)
+}
+
+// SaveSecretRequest is the request body for storing a secret.
+type SaveSecretRequest struct {
+	Value string `json:"value"`
+}
+
+// HandleSaveSecret encrypts and stores a project secret. Values are write-only.
+func (h *Handlers) HandleSaveSecret(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if !secretNamePattern.MatchString(name) {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid secret name"})
+		return
+	}
+
+	var req SaveSecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if req.Value == "" {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Value is required"})
+		return
+	}
+
+	sealed, err := h.secrets.Seal([]byte(req.Value))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.storage.StoreSecret(r.Context(), projectID, name, sealed); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store secret: %v", err)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteSecret removes a project secret.
+func (h *Handlers) HandleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.storage.DeleteSecret(r.Context(), projectID, chi.URLParam(r, "name")); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to delete secret: %v", err)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getSecret loads and decrypts a project secret.
+func (h *Handlers) getSecret(ctx context.Context, projectID, name string) (string, error) {
+	sealed, err := h.storage.GetSecret(ctx, projectID, name)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := h.secrets.Open(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
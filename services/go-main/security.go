@@ -0,0 +1,308 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Severities of security findings, most severe first.
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+	SeverityInfo   = "info"
+)
+
+var severityRank = map[string]int{SeverityHigh: 0, SeverityMedium: 1, SeverityLow: 2, SeverityInfo: 3}
+
+var (
+	// inlineScriptPattern matches script elements, capturing their
+	// attributes and body.
+	inlineScriptPattern = regexp.MustCompile(`(?is)<script\b([^>]*)>(.*?)</script>`)
+	// eventHandlerPattern matches inline event handler attributes.
+	eventHandlerPattern = regexp.MustCompile(`(?i)<[a-z][^>]*?\s(on[a-z]+)\s*=\s*["']`)
+	// externalScriptPattern matches script tags loading an absolute URL.
+	externalScriptPattern = regexp.MustCompile(`(?is)<script\b[^>]*\bsrc\s*=\s*["']((?:https?:)?//[^"']+)["'][^>]*>`)
+	// htmlURLPattern, cssURLPattern and jsURLPattern match absolute URLs an
+	// app loads or connects to from markup, stylesheets and scripts.
+	htmlURLPattern = regexp.MustCompile(`(?i)\b(?:src|href|action|poster|data)\s*=\s*["']((?:https?:)?//[^"']+)["']`)
+	cssURLPattern  = regexp.MustCompile(`url\(\s*["']?((?:https?:)?//[^"')\s]+)`)
+	jsURLPattern   = regexp.MustCompile("(?:fetch|import|new\\s+(?:WebSocket|EventSource|Worker))\\s*\\(\\s*[\"'`]((?:(?:https?|wss?):)?//[^\"'`\\s]+)")
+	// evalPattern matches eval, the Function constructor and string timers.
+	evalPattern = regexp.MustCompile("(?:^|[^.\\w$])(eval\\s*\\(|new\\s+Function\\s*\\(|set(?:Timeout|Interval)\\s*\\(\\s*[\"'`])")
+)
+
+// SecurityFinding is one issue found in a project's compiled app. File is
+// empty for findings about the headers it is served with.
+type SecurityFinding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+// SecurityReport is the result of auditing a project's compiled app.
+type SecurityReport struct {
+	Findings []SecurityFinding `json:"findings"`
+	// Origins are the external origins the app loads from or connects to.
+	Origins []string `json:"origins"`
+	// Severities counts the findings at each severity.
+	Severities map[string]int `json:"severities"`
+	// CSP is the Content-Security-Policy the app is served with.
+	CSP string `json:"csp"`
+}
+
+// SecurityRemediation is the response of a remediation: the findings sent
+// to the agent and the audit of the edited app.
+type SecurityRemediation struct {
+	Before     *SecurityReport `json:"before"`
+	After      *SecurityReport `json:"after"`
+	Remediated bool            `json:"remediated"`
+}
+
+// securityAudit collects findings from compiled files and the CSP.
+type securityAudit struct {
+	findings []SecurityFinding
+	seen     map[string]bool
+	origins  map[string]bool
+}
+
+func (a *securityAudit) add(check, severity, file, content string, offset int, detail string) {
+	key := check + "\x00" + file + "\x00" + detail
+	if a.seen[key] {
+		return
+	}
+	a.seen[key] = true
+	finding := SecurityFinding{Check: check, Severity: severity, File: file, Detail: detail}
+	if file != "" {
+		finding.Line = strings.Count(content[:offset], "\n") + 1
+	}
+	a.findings = append(a.findings, finding)
+}
+
+// checkURLs records the origins of the URLs pattern matches in content,
+// flagging those loaded over plain HTTP.
+func (a *securityAudit) checkURLs(file, content string, pattern *regexp.Regexp) {
+	for _, m := range pattern.FindAllStringSubmatchIndex(content, -1) {
+		raw := content[m[2]:m[3]]
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if u.Scheme == "" {
+			u.Scheme = "https"
+		}
+		origin := u.Scheme + "://" + u.Host
+		a.origins[origin] = true
+		a.add("external_origin", SeverityInfo, file, content, m[2], "References "+origin)
+		if (u.Scheme == "http" || u.Scheme == "ws") && !isLoopbackHost(u.Hostname()) {
+			a.add("mixed_content", SeverityHigh, file, content, m[2], "Insecure "+u.Scheme+" URL "+truncateDetail(raw))
+		}
+	}
+}
+
+func (a *securityAudit) checkHTML(file, content string) {
+	for _, m := range inlineScriptPattern.FindAllStringSubmatchIndex(content, -1) {
+		attrs := strings.ToLower(content[m[2]:m[3]])
+		body := strings.TrimSpace(content[m[4]:m[5]])
+		if body == "" || strings.Contains(attrs, "src=") || strings.Contains(attrs, "json") {
+			continue
+		}
+		a.add("inline_script", SeverityMedium, file, content, m[0], "Inline script "+truncateDetail(body))
+	}
+	for _, m := range eventHandlerPattern.FindAllStringSubmatchIndex(content, -1) {
+		a.add("inline_script", SeverityMedium, file, content, m[2], "Inline event handler "+content[m[2]:m[3]])
+	}
+	for _, m := range externalScriptPattern.FindAllStringSubmatchIndex(content, -1) {
+		if !strings.Contains(content[m[0]:m[1]], "integrity=") {
+			a.add("external_script", SeverityMedium, file, content, m[2], "Script from another origin without integrity "+truncateDetail(content[m[2]:m[3]]))
+		}
+	}
+	a.checkURLs(file, content, htmlURLPattern)
+	a.checkURLs(file, content, cssURLPattern)
+}
+
+func (a *securityAudit) checkJS(file, content string) {
+	for _, m := range evalPattern.FindAllStringSubmatchIndex(content, -1) {
+		a.add("eval", SeverityHigh, file, content, m[2], "Dynamic code evaluation "+strings.Join(strings.Fields(content[m[2]:m[3]]), " "))
+	}
+	a.checkURLs(file, content, jsURLPattern)
+}
+
+func (a *securityAudit) checkCSP(csp string) {
+	if csp == "" {
+		a.add("csp", SeverityMedium, "", "", 0, "No Content-Security-Policy is set")
+		return
+	}
+	for _, source := range []string{"'unsafe-inline'", "'unsafe-eval'"} {
+		if strings.Contains(csp, source) {
+			a.add("csp", SeverityLow, "", "", 0, "Content-Security-Policy allows "+source)
+		}
+	}
+}
+
+// auditCompiledFiles inspects compiled HTML, CSS and JS for inline
+// scripts, external origins, mixed content and eval, and the CSP the app
+// is served with.
+func auditCompiledFiles(compiled map[string]string, csp string) *SecurityReport {
+	audit := &securityAudit{seen: make(map[string]bool), origins: make(map[string]bool)}
+	for _, file := range slices.Sorted(maps.Keys(compiled)) {
+		content := compiled[file]
+		switch path.Ext(file) {
+		case ".html", ".htm":
+			audit.checkHTML(file, content)
+		case ".css":
+			audit.checkURLs(file, content, cssURLPattern)
+		case ".js", ".mjs":
+			audit.checkJS(file, content)
+		}
+	}
+	audit.checkCSP(csp)
+
+	slices.SortStableFunc(audit.findings, func(a, b SecurityFinding) int {
+		return cmp.Or(
+			cmp.Compare(severityRank[a.Severity], severityRank[b.Severity]),
+			cmp.Compare(a.File, b.File),
+			cmp.Compare(a.Line, b.Line),
+		)
+	})
+	report := &SecurityReport{
+		Findings:   audit.findings,
+		Origins:    []string{},
+		Severities: make(map[string]int),
+		CSP:        csp,
+	}
+	if report.Findings == nil {
+		report.Findings = []SecurityFinding{}
+	}
+	for origin := range audit.origins {
+		report.Origins = append(report.Origins, origin)
+	}
+	slices.Sort(report.Origins)
+	for _, finding := range report.Findings {
+		report.Severities[finding.Severity]++
+	}
+	return report
+}
+
+// isLoopbackHost reports whether host is a local development address,
+// which browsers don't treat as mixed content.
+func isLoopbackHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// truncateDetail shortens code quoted in a finding.
+func truncateDetail(s string) string {
+	runes := []rune(strings.Join(strings.Fields(s), " "))
+	if len(runes) > 80 {
+		return string(runes[:80]) + "..."
+	}
+	return string(runes)
+}
+
+// remediationPrompt asks the agent to fix the high and medium findings in
+// the app's files, returning "" if there are none. Header findings are left
+// out since they come from the project settings, not the app.
+func remediationPrompt(report *SecurityReport) string {
+	var b strings.Builder
+	for _, finding := range report.Findings {
+		if finding.File == "" || (finding.Severity != SeverityHigh && finding.Severity != SeverityMedium) {
+			continue
+		}
+		fmt.Fprintf(&b, "- [%s] %s (compiled %s, line %d)\n", finding.Severity, finding.Detail, finding.File, finding.Line)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "A security audit of the compiled app found the issues below. Fix those caused by the app's own source " +
+		"without changing its behaviour: avoid eval and inline scripts or event handlers, load resources over HTTPS, " +
+		"and don't load scripts from other origins. Ignore issues that come from third-party libraries.\n\n" + b.String()
+}
+
+// auditSecurity audits the project's current compiled app.
+func (h *Handlers) auditSecurity(ctx context.Context, projectID string) (*SecurityReport, error) {
+	compiled, err := h.storage.GetCompiledFiles(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, upstreamError("Failed to get compiled files", err)
+	}
+	if len(compiled) == 0 {
+		return nil, AppError{Code: http.StatusNotFound, Message: "No app exists for this project"}
+	}
+	settings, err := h.projectSettings(ctx, projectID)
+	if err != nil {
+		return nil, upstreamError("Failed to load settings", err)
+	}
+	return auditCompiledFiles(compiled, settings.contentSecurityPolicy()), nil
+}
+
+// HandleSecurityAudit reports security issues in the project's compiled
+// app and the headers it is served with.
+func (h *Handlers) HandleSecurityAudit(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	report, err := h.auditSecurity(r.Context(), projectID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// HandleRemediateSecurity audits the app and, if there are high or medium
+// findings, has the agent edit the app to fix them, then audits it again.
+func (h *Handlers) HandleRemediateSecurity(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	before, err := h.auditSecurity(r.Context(), projectID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	prompt := remediationPrompt(before)
+	if prompt == "" {
+		writeJSON(w, http.StatusOK, SecurityRemediation{Before: before, After: before})
+		return
+	}
+
+	if err := h.runAction(withBuildTrigger(r.Context(), "security"), projectID, ActionEdit, prompt); err != nil {
+		var appErr AppError
+		var validationErr ValidationError
+		if errors.As(err, &appErr) || errors.As(err, &validationErr) {
+			if errors.Is(err, ErrProjectBusy) {
+				w.Header().Set("Retry-After", strconv.Itoa(projectLockRetryAfter))
+			}
+			writeError(w, err)
+			return
+		}
+		writeError(w, upstreamError("Failed to remediate security findings", err))
+		return
+	}
+
+	after, err := h.auditSecurity(r.Context(), projectID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, SecurityRemediation{Before: before, After: after, Remediated: true})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxSeedProjects caps how many projects one seed request creates.
+const maxSeedProjects = 1000
+
+// SeedRequest is the request body for generating synthetic projects.
+type SeedRequest struct {
+	Projects int `json:"projects"`
+	Files    int `json:"files"`
+	FileSize int `json:"file_size"`
+}
+
+// SeedResponse lists the projects created by a seed request.
+type SeedResponse struct {
+	ProjectIDs []string `json:"project_ids"`
+	Bytes      int64    `json:"bytes"`
+	DurationMS int64    `json:"duration_ms"`
+}
+
+// seedFile returns a source file of roughly size bytes.
+func seedFile(index, size int) string {
+	header := fmt.Sprintf("// Synthetic file %d\nexport const value%d = ", index, index)
+	padding := max(size-len(header)-3, 0)
+	return header + `"` + strings.Repeat("x", padding) + "\"\n"
+}
+
+// seedApp returns synthetic source and compiled files for one project.
+func seedApp(files, size int) (map[string]string, map[string]string) {
+	source := make(map[string]string, files)
+	source["app.tsx"] = "export default function App() {\n  return <div>Synthetic project</div>;\n}\n"
+	for i := 1; i < files; i++ {
+		source[fmt.Sprintf("lib/file%d.ts", i)] = seedFile(i, size)
+	}
+	compiled := map[string]string{
+		"index.html":      `<!DOCTYPE html><html><head><script type="module" src="/assets/index.js"></script></head><body><div id="root"></div></body></html>`,
+		"assets/index.js": seedFile(0, size),
+	}
+	return source, compiled
+}
+
+// HandleSeed generates synthetic projects directly in storage, without the
+// agent or builds, for load and capacity testing.
+func (h *Handlers) HandleSeed(w http.ResponseWriter, r *http.Request) {
+	var req SeedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if req.Files == 0 {
+		req.Files = 5
+	}
+	if req.FileSize == 0 {
+		req.FileSize = 1 << 10
+	}
+	switch {
+	case req.Projects < 1 || req.Projects > maxSeedProjects:
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("projects must be between 1 and %d", maxSeedProjects)})
+		return
+	case req.Files < 1 || req.Files > h.cfg.MaxFilesPerProject:
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("files must be between 1 and %d", h.cfg.MaxFilesPerProject)})
+		return
+	case req.FileSize < 1 || req.FileSize > h.cfg.MaxFileSize:
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("file_size must be between 1 and %d", h.cfg.MaxFileSize)})
+		return
+	}
+
+	start := time.Now()
+	resp := SeedResponse{ProjectIDs: make([]string, 0, req.Projects)}
+	for i := range req.Projects {
+		projectID := uuid.NewString()
+		source, compiled := seedApp(req.Files, req.FileSize)
+		if err := h.storage.StoreApp(r.Context(), projectID, source, compiled, "Synthetic project", defaultLanguage); err != nil {
+			writeError(w, upstreamError(fmt.Sprintf("Failed to store project %d of %d", i+1, req.Projects), err))
+			return
+		}
+		if err := h.storage.SetTitle(r.Context(), projectID, fmt.Sprintf("Seed project %d", i+1), "Synthetic project for load testing"); err != nil {
+			writeError(w, upstreamError("Failed to set title", err))
+			return
+		}
+		for _, files := range []map[string]string{source, compiled} {
+			for _, content := range files {
+				resp.Bytes += int64(len(content))
+			}
+		}
+		resp.ProjectIDs = append(resp.ProjectIDs, projectID)
+	}
+	resp.DurationMS = time.Since(start).Milliseconds()
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// newServiceTransport returns the transport for calls to internal services
+// (Rust DB, Python Agent, Node Build). When configured it presents a client
+// certificate for mTLS and trusts a private CA for the services' certificates.
+func newServiceTransport(cfg Config) (http.RoundTripper, error) {
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	if cfg.ServiceTLSCert != "" || cfg.ServiceTLSCA != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if cfg.ServiceTLSCert != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.ServiceTLSCert, cfg.ServiceTLSKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load service client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		if cfg.ServiceTLSCA != "" {
+			pem, err := os.ReadFile(cfg.ServiceTLSCA)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read service CA bundle: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in service CA bundle %s", cfg.ServiceTLSCA)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return otelhttp.NewTransport(transport), nil
+}
+
+// bearerTransport adds a static bearer token to every request.
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// withBearerToken wraps base so requests carry token, if one is set.
+func withBearerToken(base http.RoundTripper, token string) http.RoundTripper {
+	if token == "" {
+		return base
+	}
+	return &bearerTransport{token: token, base: base}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Build profiles.
+const (
+	BuildProduction  = "production"
+	BuildDevelopment = "development"
+)
+
+// agentTools are the tools a project's agent can be limited to.
+var agentTools = []string{"create_file", "edit_file", "delete_file"}
+
+// Headers carrying a project's settings to the agent.
+const (
+	agentModelHeader     = "X-Agent-Model"
+	agentToolsHeader     = "X-Agent-Tools"
+	buildProfileHeader   = "X-Build-Profile"
+	optimizeImagesHeader = "X-Optimize-Images"
+)
+
+const (
+	maxCSPLength     = 4096
+	maxEmbedOrigins  = 20
+	maxRetentionDays = 3650
+)
+
+// EmbedPolicy controls whether the app can be embedded on other sites.
+type EmbedPolicy struct {
+	// Enabled allows embed scripts and embed tokens for the project.
+	Enabled bool `json:"enabled"`
+	// Origins, when set, are the only sites allowed to frame the app.
+	Origins []string `json:"origins"`
+}
+
+// ProjectSettings are a project's options. Only fields a project has
+// changed are stored; the rest follow the defaults in Config.
+type ProjectSettings struct {
+	// CSP is sent as the Content-Security-Policy of the served app.
+	CSP   string      `json:"csp"`
+	Embed EmbedPolicy `json:"embed"`
+	// BuildProfile is "production" (minified) or "development".
+	BuildProfile string `json:"build_profile"`
+	// Model is one of AGENT_MODELS, or empty for the agent's default.
+	Model string `json:"model"`
+	// Tools are the agent tools the project allows.
+	Tools []string `json:"tools"`
+	// BuildRetentionDays is how long build records are kept, zero for ever.
+	BuildRetentionDays int `json:"build_retention_days"`
+	// PlaceholderImages serves generated placeholders for images the app
+	// references but doesn't have, instead of 404s.
+	PlaceholderImages bool `json:"placeholder_images"`
+	// OptimizeImages has builds recompress images and add WebP, AVIF and
+	// resized variants, served by the request's Accept header and width.
+	OptimizeImages bool `json:"optimize_images"`
+	// SelfHostFonts downloads the Google Fonts the app links to into its
+	// compiled assets, keeping only the subsets it needs.
+	SelfHostFonts bool `json:"self_host_fonts"`
+	// Public leaves the app's view routes open without an API key when
+	// AUTH_ENABLED is set.
+	Public bool `json:"public"`
+}
+
+// defaultSettings returns the settings of a project that has changed none.
+func defaultSettings(cfg Config) ProjectSettings {
+	return ProjectSettings{
+		CSP:                cfg.DefaultCSP,
+		Embed:              EmbedPolicy{Enabled: true, Origins: []string{}},
+		BuildProfile:       cfg.DefaultBuildProfile,
+		Tools:              slices.Clone(agentTools),
+		BuildRetentionDays: cfg.BuildRetentionDays,
+		PlaceholderImages:  cfg.PlaceholderImages,
+		OptimizeImages:     cfg.OptimizeImages,
+		SelfHostFonts:      cfg.SelfHostFonts,
+		Public:             cfg.PublicProjects,
+	}
+}
+
+// settingsFields are the JSON names of ProjectSettings fields.
+var settingsFields = []string{"csp", "embed", "build_profile", "model", "tools", "build_retention_days", "placeholder_images", "optimize_images", "self_host_fonts", "public"}
+
+// OrgDefaults are settings every project inherits, layered between the
+// defaults in Config and the project's own overrides. Projects can't
+// override Locked settings, so admins can enforce e.g. a CSP baseline.
+type OrgDefaults struct {
+	Settings map[string]json.RawMessage `json:"settings"`
+	Locked   []string                   `json:"locked"`
+}
+
+// ProjectSettingsResponse is a project's resolved settings and which of
+// them are locked by the organization defaults.
+type ProjectSettingsResponse struct {
+	ProjectSettings
+	Locked []string `json:"locked,omitempty"`
+}
+
+// applySettings decodes overrides onto settings, skipping names in skip.
+func applySettings(settings *ProjectSettings, overrides map[string]json.RawMessage, skip []string) error {
+	for _, name := range slices.Sorted(maps.Keys(overrides)) {
+		if slices.Contains(skip, name) {
+			continue
+		}
+		doc, err := json.Marshal(map[string]json.RawMessage{name: overrides[name]})
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(settings); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveSettings applies the organization defaults and then the project's
+// stored overrides to the defaults in cfg.
+func resolveSettings(cfg Config, org *OrgDefaults, overrides map[string]json.RawMessage) (*ProjectSettings, error) {
+	settings := defaultSettings(cfg)
+	if err := applySettings(&settings, org.Settings, nil); err != nil {
+		return nil, fmt.Errorf("organization defaults: %w", err)
+	}
+	if err := applySettings(&settings, overrides, org.Locked); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// mergeSettingsPatch applies a JSON merge patch to stored overrides; null
+// removes an override.
+func mergeSettingsPatch(overrides, patch map[string]json.RawMessage) error {
+	for name, value := range patch {
+		if !slices.Contains(settingsFields, name) {
+			return AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Unknown setting %q", name)}
+		}
+		if string(value) == "null" {
+			delete(overrides, name)
+		} else {
+			overrides[name] = value
+		}
+	}
+	return nil
+}
+
+// validate checks settings against the limits and choices in cfg.
+func (s *ProjectSettings) validate(cfg Config) error {
+	if len(s.CSP) > maxCSPLength || strings.ContainsAny(s.CSP, "\r\n") {
+		return fmt.Errorf("csp must be a single line of at most %d characters", maxCSPLength)
+	}
+	if len(s.Embed.Origins) > maxEmbedOrigins {
+		return fmt.Errorf("embed.origins can list at most %d origins", maxEmbedOrigins)
+	}
+	for _, origin := range s.Embed.Origins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+			return fmt.Errorf("embed.origins: %q is not an http(s) origin", origin)
+		}
+	}
+	if s.BuildProfile != BuildProduction && s.BuildProfile != BuildDevelopment {
+		return fmt.Errorf("build_profile must be %q or %q", BuildProduction, BuildDevelopment)
+	}
+	if s.Model != "" && !slices.Contains(cfg.AgentModels, s.Model) {
+		return fmt.Errorf("model must be one of %s", strings.Join(cfg.AgentModels, ", "))
+	}
+	if len(s.Tools) == 0 {
+		return errors.New("tools must allow at least one tool")
+	}
+	for i, tool := range s.Tools {
+		if !slices.Contains(agentTools, tool) {
+			return fmt.Errorf("tools: unknown tool %q, must be one of %s", tool, strings.Join(agentTools, ", "))
+		}
+		if slices.Contains(s.Tools[:i], tool) {
+			return fmt.Errorf("tools: %q is listed twice", tool)
+		}
+	}
+	if s.BuildRetentionDays < 0 || s.BuildRetentionDays > maxRetentionDays {
+		return fmt.Errorf("build_retention_days must be between 0 and %d", maxRetentionDays)
+	}
+	return nil
+}
+
+// orgDefaults returns the organization defaults, empty if none are stored.
+func (h *Handlers) orgDefaults(ctx context.Context) (*OrgDefaults, error) {
+	org, err := h.storage.GetOrgDefaults(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return &OrgDefaults{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load organization defaults: %w", err)
+	}
+	return org, nil
+}
+
+// projectSettings returns the project's settings, inheriting the
+// organization defaults for any it hasn't changed.
+func (h *Handlers) projectSettings(ctx context.Context, projectID string) (*ProjectSettings, error) {
+	org, err := h.orgDefaults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := h.storage.GetSettings(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	return resolveSettings(h.cfg, org, overrides)
+}
+
+// contentSecurityPolicy returns the policy to serve the app with, adding
+// frame-ancestors for the embed origins unless the CSP sets its own.
+func (s *ProjectSettings) contentSecurityPolicy() string {
+	csp := strings.TrimSpace(s.CSP)
+	if len(s.Embed.Origins) == 0 || strings.Contains(csp, "frame-ancestors") {
+		return csp
+	}
+	ancestors := "frame-ancestors 'self' " + strings.Join(s.Embed.Origins, " ")
+	if csp == "" {
+		return ancestors
+	}
+	return strings.TrimSuffix(csp, ";") + "; " + ancestors
+}
+
+func (h *Handlers) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	org, err := h.orgDefaults(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	overrides, err := h.storage.GetSettings(r.Context(), projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	settings, err := resolveSettings(h.cfg, org, overrides)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Stored settings are invalid: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ProjectSettingsResponse{ProjectSettings: *settings, Locked: org.Locked})
+}
+
+// HandlePatchSettings merges a JSON object into the project's settings.
+// A null value resets that setting to the organization default; locked
+// settings can't be changed. Changing the build profile, optimize_images or
+// self_host_fonts rebuilds the app.
+func (h *Handlers) HandlePatchSettings(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	org, err := h.orgDefaults(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	for name := range patch {
+		if slices.Contains(org.Locked, name) {
+			writeError(w, AppError{Code: http.StatusForbidden, Message: fmt.Sprintf("Setting %q is locked by the organization defaults", name)})
+			return
+		}
+	}
+
+	overrides, err := h.storage.GetSettings(r.Context(), projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeError(w, upstreamError("Failed to load settings", err))
+		return
+	}
+	if overrides == nil {
+		overrides = map[string]json.RawMessage{}
+	}
+	before, err := resolveSettings(h.cfg, org, overrides)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Stored settings are invalid: %v", err)})
+		return
+	}
+
+	if err := mergeSettingsPatch(overrides, patch); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	settings, err := resolveSettings(h.cfg, org, overrides)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	if err := settings.validate(h.cfg); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	if err := h.storage.StoreSettings(r.Context(), projectID, overrides); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store settings: %v", err)})
+		return
+	}
+
+	if settings.BuildProfile != before.BuildProfile || settings.OptimizeImages != before.OptimizeImages || settings.SelfHostFonts != before.SelfHostFonts {
+		go func(ctx context.Context) {
+			if err := h.rebuild(withBuildTrigger(ctx, "settings"), projectID); err != nil && !errors.Is(err, ErrNotFound) {
+				log.Printf("Error rebuilding project %s after settings change: %v", projectID, redactPayload(err))
+			}
+		}(context.WithoutCancel(r.Context()))
+	}
+
+	writeJSON(w, http.StatusOK, ProjectSettingsResponse{ProjectSettings: *settings, Locked: org.Locked})
+}
+
+// OrgDefaultsResponse is the stored organization defaults and the settings
+// a project that changed none would have.
+type OrgDefaultsResponse struct {
+	OrgDefaults
+	Effective *ProjectSettings `json:"effective"`
+}
+
+// HandleGetOrgDefaults returns the organization defaults.
+func (h *Handlers) HandleGetOrgDefaults(w http.ResponseWriter, r *http.Request) {
+	org, err := h.orgDefaults(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to load organization defaults", err))
+		return
+	}
+	effective, err := resolveSettings(h.cfg, org, nil)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Stored organization defaults are invalid: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, OrgDefaultsResponse{OrgDefaults: *org, Effective: effective})
+}
+
+// HandlePatchOrgDefaults merges settings into the organization defaults
+// (null resets a setting to the environment default) and, if given,
+// replaces the locked list. Projects pick the changes up on their next
+// request or build; nothing is rebuilt.
+func (h *Handlers) HandlePatchOrgDefaults(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Settings map[string]json.RawMessage `json:"settings"`
+		Locked   *[]string                  `json:"locked"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	org, err := h.orgDefaults(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to load organization defaults", err))
+		return
+	}
+	if org.Settings == nil {
+		org.Settings = map[string]json.RawMessage{}
+	}
+	if err := mergeSettingsPatch(org.Settings, req.Settings); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Locked != nil {
+		for _, name := range *req.Locked {
+			if !slices.Contains(settingsFields, name) {
+				writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Unknown setting %q", name)})
+				return
+			}
+		}
+		org.Locked = slices.Compact(slices.Sorted(slices.Values(*req.Locked)))
+	}
+
+	effective, err := resolveSettings(h.cfg, org, nil)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	if err := effective.validate(h.cfg); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	if err := h.storage.StoreOrgDefaults(r.Context(), org); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store organization defaults: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, OrgDefaultsResponse{OrgDefaults: *org, Effective: effective})
+}
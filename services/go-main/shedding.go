@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHealthSamples bounds the samples kept per window.
+const maxHealthSamples = 4096
+
+type healthSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// DownstreamHealth tracks recent latency and error rates of calls to
+// internal services over a sliding window, and reports when they are bad
+// enough that low-priority requests should be shed.
+type DownstreamHealth struct {
+	window       time.Duration
+	minRequests  int
+	errorPercent int
+	latency      time.Duration
+
+	mu         sync.Mutex
+	samples    []healthSample
+	overloaded bool
+}
+
+// NewDownstreamHealth creates a tracker that considers downstreams
+// overloaded once at least minRequests calls in window fail more than
+// errorPercent of the time or average more than latency. A zero threshold
+// disables that check.
+func NewDownstreamHealth(window time.Duration, minRequests, errorPercent int, latency time.Duration) *DownstreamHealth {
+	return &DownstreamHealth{
+		window:       window,
+		minRequests:  max(minRequests, 1),
+		errorPercent: errorPercent,
+		latency:      latency,
+	}
+}
+
+// Record adds the outcome of one downstream call.
+func (d *DownstreamHealth) Record(latency time.Duration, failed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.samples = append(d.samples, healthSample{at: time.Now(), latency: latency, failed: failed})
+	if len(d.samples) > maxHealthSamples {
+		d.samples = d.samples[len(d.samples)-maxHealthSamples:]
+	}
+}
+
+// Overloaded reports whether recent downstream calls exceed the thresholds.
+func (d *DownstreamHealth) Overloaded() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cutoff := time.Now().Add(-d.window)
+	i := 0
+	for i < len(d.samples) && d.samples[i].at.Before(cutoff) {
+		i++
+	}
+	d.samples = d.samples[i:]
+
+	overloaded := false
+	if len(d.samples) >= d.minRequests {
+		var failed int
+		var total time.Duration
+		for _, s := range d.samples {
+			total += s.latency
+			if s.failed {
+				failed++
+			}
+		}
+		errorRate := failed * 100 / len(d.samples)
+		avgLatency := total / time.Duration(len(d.samples))
+		overloaded = (d.errorPercent > 0 && errorRate > d.errorPercent) ||
+			(d.latency > 0 && avgLatency > d.latency)
+		if overloaded && !d.overloaded {
+			log.Printf("Downstream overloaded (%d%% errors, %s average latency over %d calls), shedding low-priority requests",
+				errorRate, avgLatency.Round(time.Millisecond), len(d.samples))
+		}
+	}
+	if !overloaded && d.overloaded {
+		log.Printf("Downstream recovered, no longer shedding requests")
+	}
+	d.overloaded = overloaded
+	return overloaded
+}
+
+// healthTransport records each request's time to response headers, and
+// whether it failed or returned a 5xx, in a DownstreamHealth.
+type healthTransport struct {
+	health *DownstreamHealth
+	base   http.RoundTripper
+}
+
+func (t *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	// Calls cancelled by our own client say nothing about the downstream
+	if !errors.Is(req.Context().Err(), context.Canceled) {
+		t.health.Record(time.Since(start), err != nil || resp.StatusCode >= 500)
+	}
+	return resp, err
+}
+
+// withHealthTracking wraps base so its calls are recorded in health.
+func withHealthTracking(base http.RoundTripper, health *DownstreamHealth) http.RoundTripper {
+	return &healthTransport{health: health, base: base}
+}
+
+// isPrefetch reports whether the browser marked the request as a
+// speculative prefetch.
+func isPrefetch(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Sec-Purpose"), "prefetch") || r.Header.Get("Purpose") == "prefetch"
+}
+
+// allRequests treats every request on a route as low priority.
+func allRequests(*http.Request) bool {
+	return true
+}
+
+// ShedMiddleware rejects low-priority requests with 503 and Retry-After
+// while downstream services are overloaded, leaving capacity for
+// generation and views.
+func (h *Handlers) ShedMiddleware(lowPriority func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if lowPriority(r) && h.health.Overloaded() {
+				w.Header().Set("Retry-After", strconv.Itoa(int(max(h.health.window.Seconds(), 1))))
+				writeError(w, AppError{Code: http.StatusServiceUnavailable, Message: "Temporarily unavailable, please retry later"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
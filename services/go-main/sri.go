@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// sriTagPattern matches script and stylesheet/modulepreload link tags that
+// load a compiled asset, capturing the asset path.
+var sriTagPattern = regexp.MustCompile(`<(?:script|link)\b[^>]*?\b(?:src|href)=["']\.?/?(assets/[^"']+)["'][^>]*>`)
+
+// SRISettings controls whether served pages pin their scripts and
+// stylesheets with subresource integrity hashes.
+type SRISettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+// sriHash returns the integrity value for content.
+func sriHash(content string) string {
+	sum := sha512.Sum384([]byte(content))
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// injectIntegrity adds integrity attributes to tags in page that load JS
+// or CSS assets from compiled, leaving tags that already have one.
+func injectIntegrity(page string, compiled map[string]string) string {
+	return sriTagPattern.ReplaceAllStringFunc(page, func(tag string) string {
+		path := sriTagPattern.FindStringSubmatch(tag)[1]
+		content, ok := compiled[path]
+		if !ok || strings.Contains(tag, "integrity=") || !(strings.HasSuffix(path, ".js") || strings.HasSuffix(path, ".css")) {
+			return tag
+		}
+		attrs := fmt.Sprintf(` integrity="%s"`, sriHash(content))
+		if !strings.Contains(tag, "crossorigin") {
+			attrs += ` crossorigin`
+		}
+		end := len(tag) - 1
+		if strings.HasSuffix(tag, "/>") {
+			end--
+		}
+		return strings.TrimRight(tag[:end], " ") + attrs + tag[end:]
+	})
+}
+
+// addSRI injects integrity attributes into index.html when enabled. Assets
+// are served byte for byte as stored, so hashes of the compiled output
+// match what browsers receive.
+func (h *Handlers) addSRI(ctx context.Context, projectID string, compiledFiles map[string]string) (map[string]string, error) {
+	sri, err := h.storage.GetSRI(ctx, projectID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return compiledFiles, nil
+		}
+		return nil, fmt.Errorf("failed to load SRI settings: %w", err)
+	}
+	page, ok := compiledFiles["index.html"]
+	if !sri.Enabled || !ok {
+		return compiledFiles, nil
+	}
+
+	out := maps.Clone(compiledFiles)
+	out["index.html"] = injectIntegrity(page, compiledFiles)
+	return out, nil
+}
+
+func (h *Handlers) HandleGetSRI(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sri, err := h.storage.GetSRI(r.Context(), projectID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			writeError(w, err)
+			return
+		}
+		sri = &SRISettings{}
+	}
+
+	writeJSON(w, http.StatusOK, sri)
+}
+
+// HandleSaveSRI toggles subresource integrity and rebuilds the app so the
+// change takes effect without waiting for the next edit.
+func (h *Handlers) HandleSaveSRI(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var sri SRISettings
+	if err := json.NewDecoder(r.Body).Decode(&sri); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+
+	if err := h.storage.StoreSRI(r.Context(), projectID, &sri); err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to store SRI settings: %v", err)})
+		return
+	}
+
+	go func(ctx context.Context) {
+		if err := h.rebuild(withBuildTrigger(ctx, "sri"), projectID); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Error rebuilding project %s after SRI change: %v", projectID, redactPayload(err))
+		}
+	}(context.WithoutCancel(r.Context()))
+
+	writeJSON(w, http.StatusOK, sri)
+}
@@ -52,6 +52,7 @@ type DeleteFileArgs struct {
 type FileOperation struct {
 	Type     string // "create", "edit", "delete"
 	FilePath string
+	Existed  bool      // Whether the file existed before this operation
 	Content  string    // For create - the full file content
 	Diff     *DiffArgs // For edit
 }
@@ -67,6 +68,7 @@ type SSEParser struct {
 	reader       *bufio.Reader
 	files        map[string]string           // Track current file state
 	pendingCalls map[string]*pendingToolCall // Track in-progress tool calls by ID
+	text         strings.Builder             // The agent's text replies
 }
 
 // NewSSEParser creates a new SSE parser.
@@ -132,6 +134,9 @@ func (p *SSEParser) ReadEvent() (*ParsedEvent, error) {
 			delete(p.pendingCalls, event.ToolCallID)
 		}
 
+	case "text-delta":
+		p.text.WriteString(event.Delta)
+
 	case "finish":
 		result.IsFinished = true
 	}
@@ -148,10 +153,12 @@ func (p *SSEParser) extractFileOperation(toolName, inputJSON string) *FileOperat
 			return nil
 		}
 		// Update tracked file state
+		_, existed := p.files[args.FilePath]
 		p.files[args.FilePath] = args.Content
 		return &FileOperation{
 			Type:     "create",
 			FilePath: args.FilePath,
+			Existed:  existed,
 			Content:  args.Content,
 		}
 
@@ -189,9 +196,19 @@ func (p *SSEParser) extractFileOperation(toolName, inputJSON string) *FileOperat
 	return nil
 }
 
+// Discard drops a file from the tracked state, e.g. when a create was rejected.
+func (p *SSEParser) Discard(path string) {
+	delete(p.files, path)
+}
+
 // GetFiles returns the current state of all files.
 func (p *SSEParser) GetFiles() map[string]string {
 	result := make(map[string]string)
 	maps.Copy(result, p.files)
 	return result
 }
+
+// Text returns the agent's text replies so far.
+func (p *SSEParser) Text() string {
+	return p.text.String()
+}
@@ -1,262 +1,2907 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
-// Storage provides a high-level interface over the Rust DB client.
+// systemProject is the reserved rust-db namespace for cross-project indexes.
+var systemProject = uuid.Nil.String()
+
+// reservedUUIDVersion is the UUID version of namespaces that aren't
+// projects (workspaces and libraries). Project IDs can't use it, so
+// project routes can't reach those namespaces.
+const reservedUUIDVersion = 8
+
+// reserveUUID marks id as a non-project namespace.
+func reserveUUID(id uuid.UUID) string {
+	id[6] = id[6]&0x0f | reservedUUIDVersion<<4
+	return id.String()
+}
+
+// isReservedNamespace reports whether id is the system namespace or a
+// non-project namespace.
+func isReservedNamespace(id uuid.UUID) bool {
+	return id == uuid.Nil || id.Version() == reservedUUIDVersion
+}
+
+// Storage provides a high-level interface over the storage backend.
 type Storage struct {
-	client *RustDBClient
+	client StorageBackend
+
+	// verifyHashes checks app files against their manifest hashes on read.
+	verifyHashes bool
+	// fetchConcurrency bounds the get-many requests getFiles has in flight.
+	fetchConcurrency int
+	// fileHistory is how many previous contents of each source file are
+	// kept; zero disables file history.
+	fileHistory int
+	// events is the project mutation log, or nil when it is disabled.
+	events *EventLog
+
+	// stateMu and usageMu serialise read-modify-write updates of state
+	// and usage documents.
+	stateMu sync.Mutex
+	usageMu sync.Mutex
+	// versionsMu serialises snapshots so version numbers are unique.
+	versionsMu sync.Mutex
+	// lighthouseMu serialises appends to Lighthouse histories.
+	lighthouseMu sync.Mutex
+	// operationsMu serialises updates of operation lists.
+	operationsMu sync.Mutex
+	// commentsMu serialises updates of file comment lists.
+	commentsMu sync.Mutex
+	// tasksMu serialises updates of task lists.
+	tasksMu sync.Mutex
+	// historyMu serialises updates of file history indexes.
+	historyMu sync.Mutex
+}
+
+// NewStorage creates a new Storage instance. With verifyHashes, source and
+// compiled files are checked against their manifest hashes when read,
+// costing an extra metadata read. fetchConcurrency bounds the parallel
+// reads of a project's files. Overwritten source files keep their last
+// fileHistory contents. With events, source and compiled file writes,
+// builds and settings changes are recorded in the event log.
+func NewStorage(client StorageBackend, verifyHashes bool, fetchConcurrency, fileHistory int, events *EventLog) *Storage {
+	if events != nil {
+		client = withEventLog(client, events)
+	}
+	return &Storage{client: client, verifyHashes: verifyHashes, fetchConcurrency: max(fetchConcurrency, 1), fileHistory: max(fileHistory, 0), events: events}
+}
+
+// recordBuild appends a build_completed event for a compiled set stored in
+// channel.
+func (s *Storage) recordBuild(ctx context.Context, projectID, channel string, compiledFiles []string) error {
+	return s.events.Append(ctx, projectID, ProjectEvent{Type: EventBuildCompleted, Channel: channel, Files: slices.Sorted(slices.Values(compiledFiles))})
+}
+
+// ListEvents retrieves up to limit of the project's events after sequence
+// number after, oldest first; zero limit returns them all.
+func (s *Storage) ListEvents(ctx context.Context, projectID string, after int64, limit int) ([]ProjectEvent, error) {
+	entries, err := s.client.List(ctx, projectID, eventsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	first := eventKey(after + 1)
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Key >= first && (limit <= 0 || len(keys) < limit) {
+			keys = append(keys, entry.Key)
+		}
+	}
+
+	values, err := s.fetchMany(ctx, projectID, keys)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]ProjectEvent, 0, len(keys))
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		var event ProjectEvent
+		if err := json.Unmarshal(value.Content, &event); err != nil {
+			return nil, fmt.Errorf("invalid event %s: %w", key, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// EventManifest derives the project's files and settings from its whole
+// event log.
+func (s *Storage) EventManifest(ctx context.Context, projectID string) (*EventManifest, error) {
+	return s.events.Manifest(ctx, projectID)
+}
+
+// ReplayEvents reconstructs the project's files, metadata manifest and
+// settings from its event log alone: files whose stored content differs
+// from the last write the log records are rewritten from the log's blobs,
+// files the log has deleted are removed, and the manifest is rebuilt from
+// the log. With dryRun nothing is written and the report only says what
+// would change. Files whose blob is missing can't be restored; they are
+// reported and recorded as an inconsistency in the metadata. The writes
+// aren't themselves recorded, as the log already ends in the state they
+// restore.
+func (s *Storage) ReplayEvents(ctx context.Context, projectID string, dryRun bool) (*ReplayReport, error) {
+	manifest, err := s.EventManifest(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Seq == 0 {
+		return nil, ErrNotFound
+	}
+	stored, err := s.ListAppKeys(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReplayReport{Seq: manifest.Seq, Restored: []string{}, Removed: []string{}, Unrecoverable: []string{}, DryRun: dryRun}
+	present := make(map[string]string, len(stored))
+	for _, entry := range stored {
+		present[entry.Key] = entry.ETag
+		if _, ok := manifest.Hashes[entry.Key]; !ok {
+			report.Removed = append(report.Removed, entry.Key)
+		}
+	}
+	var blobKeys []string
+	for _, key := range slices.Sorted(maps.Keys(manifest.Hashes)) {
+		if etag, ok := present[key]; !ok || etag != manifest.Hashes[key] {
+			report.Restored = append(report.Restored, key)
+			blobKeys = append(blobKeys, eventBlobsPrefix+manifest.Hashes[key])
+		}
+	}
+	slices.Sort(blobKeys)
+	blobs, err := s.fetchMany(ctx, projectID, slices.Compact(blobKeys))
+	if err != nil {
+		return nil, err
+	}
+	report.Restored = slices.DeleteFunc(report.Restored, func(key string) bool {
+		blob, ok := blobs[eventBlobsPrefix+manifest.Hashes[key]]
+		if !ok || contentETag(blob.Content) != manifest.Hashes[key] {
+			report.Unrecoverable = append(report.Unrecoverable, key)
+			return true
+		}
+		return false
+	})
+
+	if manifest.Settings != nil {
+		current, _, err := s.client.Get(ctx, projectID, "_meta/settings.json")
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		report.SettingsRestored = !bytes.Equal(current, manifest.Settings)
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	ctx = withoutEvents(ctx)
+	for _, key := range report.Restored {
+		blob := blobs[eventBlobsPrefix+manifest.Hashes[key]]
+		if err := s.client.Store(ctx, projectID, key, manifest.MimeTypes[key], blob.Content); err != nil {
+			return nil, fmt.Errorf("restoring %s: %w", key, err)
+		}
+	}
+	for _, key := range report.Removed {
+		if err := s.client.Delete(ctx, projectID, key); err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("removing %s: %w", key, err)
+		}
+	}
+	if report.SettingsRestored {
+		if err := s.client.Store(ctx, projectID, "_meta/settings.json", "application/json", manifest.Settings); err != nil {
+			return nil, fmt.Errorf("restoring settings: %w", err)
+		}
+	}
+
+	// Summaries, titles and the like aren't in the log, so they are kept
+	// when the metadata can still be read
+	meta, err := s.GetMetadata(ctx, projectID)
+	var syntaxErr *json.SyntaxError
+	if errors.Is(err, ErrNotFound) || errors.As(err, &syntaxErr) {
+		meta, err = &AppMetadata{CreatedAt: time.Now().UTC()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta.SourceFiles = manifest.SourceFiles
+	meta.CompiledFiles = manifest.CompiledFiles
+	meta.Hashes = manifest.Hashes
+	meta.UpdatedAt = time.Now().UTC()
+	meta.Inconsistent = nil
+	if len(report.Unrecoverable) > 0 {
+		failed := make([]StoreFailure, len(report.Unrecoverable))
+		for i, key := range report.Unrecoverable {
+			failed[i] = StoreFailure{Path: key, Error: "content missing from the event log"}
+		}
+		meta.Inconsistent = &Inconsistency{Failed: failed, DetectedAt: meta.UpdatedAt}
+	}
+	if err := s.storeMetadata(ctx, projectID, meta); err != nil {
+		return nil, fmt.Errorf("restoring metadata: %w", err)
+	}
+	return report, nil
 }
 
-// NewStorage creates a new Storage instance.
-func NewStorage(client *RustDBClient) *Storage {
-	return &Storage{client: client}
+// manifestHashes returns the content hashes to verify reads against, or nil
+// when verification is off or the project has no metadata.
+func (s *Storage) manifestHashes(ctx context.Context, projectID string) map[string]string {
+	if !s.verifyHashes {
+		return nil
+	}
+	meta, err := s.GetMetadata(ctx, projectID)
+	if err != nil {
+		return nil
+	}
+	return meta.Hashes
 }
 
 // AppMetadata contains metadata about a stored app.
 type AppMetadata struct {
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	Summary       string    `json:"summary"`
-	SourceFiles   []string  `json:"source_files"`
-	CompiledFiles []string  `json:"compiled_files"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Summary   string    `json:"summary"`
+	// Summaries holds the latest summary produced in each language.
+	Summaries     map[string]string `json:"summaries,omitempty"`
+	SourceFiles   []string          `json:"source_files"`
+	CompiledFiles []string          `json:"compiled_files"`
+	GitCommit     string            `json:"git_commit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Description   string            `json:"description,omitempty"`
+	// Hashes maps each source/ and compiled/ key to the MD5 of its content,
+	// matching the ETags rust-db reports.
+	Hashes map[string]string `json:"hashes,omitempty"`
+	// Inconsistent is set while the stored files are known not to match
+	// the last generation.
+	Inconsistent *Inconsistency `json:"inconsistent,omitempty"`
+	// ForkedFrom is the project this one was forked from.
+	ForkedFrom string `json:"forked_from,omitempty"`
 }
 
-// StoreApp saves all app files and metadata to the database.
-func (s *Storage) StoreApp(ctx context.Context, projectID string, files, compiledFiles map[string]string, summary string) error {
-	sourceFileList := make([]string, 0, len(files))
-	compiledFileList := make([]string, 0, len(compiledFiles))
+// StoreFailure is a file that could not be written.
+type StoreFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Inconsistency records that a project's stored files don't match its last
+// generation, because some writes failed and could not be undone.
+type Inconsistency struct {
+	Failed     []StoreFailure `json:"failed"`
+	DetectedAt time.Time      `json:"detected_at"`
+}
+
+// PartialStoreError is returned when only some of an app's files could be
+// stored. RolledBack reports whether the files that were written have been
+// removed again; otherwise the project's metadata is marked inconsistent.
+type PartialStoreError struct {
+	Failed     []StoreFailure
+	RolledBack bool
+}
 
-	// Store source files
+func (e *PartialStoreError) Error() string {
+	return fmt.Sprintf("failed to store %d file(s), first %s: %s", len(e.Failed), e.Failed[0].Path, e.Failed[0].Error)
+}
+
+// storedFiles tracks the outcome of writing an app's files.
+type storedFiles struct {
+	source   []string
+	compiled []string
+	keys     []string
+	hashes   map[string]string
+	failed   []StoreFailure
+}
+
+// storeAppFiles writes source and compiled files, carrying on past failures
+// so every failed file is reported.
+func (s *Storage) storeAppFiles(ctx context.Context, projectID string, files, compiledFiles map[string]string) *storedFiles {
+	stored := &storedFiles{
+		source:   make([]string, 0, len(files)),
+		compiled: make([]string, 0, len(compiledFiles)),
+		hashes:   make(map[string]string, len(files)+len(compiledFiles)),
+	}
+	op := operationFrom(ctx)
+	op.AddFiles(len(files) + len(compiledFiles))
+	s.saveHistory(ctx, projectID, files)
 	for path, content := range files {
 		key := "source/" + path
-		mimeType := getMimeType(path)
-		if err := s.client.Store(ctx, projectID, key, mimeType, []byte(content)); err != nil {
-			return err
+		if err := s.client.Store(ctx, projectID, key, getMimeType(path), []byte(content)); err != nil {
+			stored.failed = append(stored.failed, StoreFailure{Path: key, Error: err.Error()})
+			continue
 		}
-		sourceFileList = append(sourceFileList, path)
+		stored.source = append(stored.source, path)
+		stored.keys = append(stored.keys, key)
+		stored.hashes[key] = contentETag([]byte(content))
+		op.FileStored(ctx)
 	}
-
-	// Store compiled files
 	for path, content := range compiledFiles {
-		key := "compiled/" + path
-		mimeType := getMimeType(path)
-		if err := s.client.Store(ctx, projectID, key, mimeType, []byte(content)); err != nil {
-			return err
+		hash, err := s.storeCompiledFile(ctx, projectID, "compiled/", path, content)
+		if err != nil {
+			stored.failed = append(stored.failed, StoreFailure{Path: "compiled/" + path, Error: err.Error()})
+			continue
 		}
-		compiledFileList = append(compiledFileList, path)
+		stored.compiled = append(stored.compiled, path)
+		stored.keys = append(stored.keys, "compiled/"+path)
+		stored.hashes["compiled/"+path] = hash
+		op.FileStored(ctx)
 	}
+	return stored
+}
+
+// StoreApp saves all app files and metadata to the database.
+// The summary is recorded as written in lang. If some files fail to store,
+// the ones that were written are deleted again and a PartialStoreError is
+// returned; if that also fails the project is left marked inconsistent.
+func (s *Storage) StoreApp(ctx context.Context, projectID string, files, compiledFiles map[string]string, summary, lang string) error {
+	stored := s.storeAppFiles(ctx, projectID, files, compiledFiles)
 
-	// Store metadata
 	now := time.Now().UTC()
 	meta := AppMetadata{
 		CreatedAt:     now,
 		UpdatedAt:     now,
 		Summary:       summary,
-		SourceFiles:   sourceFileList,
-		CompiledFiles: compiledFileList,
+		Summaries:     map[string]string{lang: summary},
+		SourceFiles:   stored.source,
+		CompiledFiles: stored.compiled,
+		Hashes:        stored.hashes,
 	}
-	metaJSON, err := json.Marshal(meta)
-	if err != nil {
-		return err
+	if len(stored.failed) == 0 {
+		if err := s.recordBuild(ctx, projectID, ChannelProduction, stored.compiled); err != nil {
+			return err
+		}
+		return s.storeMetadata(ctx, projectID, &meta)
 	}
-	return s.client.Store(ctx, projectID, "_meta/app.json", "application/json", metaJSON)
-}
 
-// UpdateApp updates existing app files and metadata.
-func (s *Storage) UpdateApp(ctx context.Context, projectID string, files, compiledFiles map[string]string, summary string) error {
-	// Delete old compiled files first
-	oldCompiled, err := s.client.List(ctx, projectID, "compiled/")
-	if err == nil {
-		for _, entry := range oldCompiled {
-			_ = s.client.Delete(ctx, projectID, entry.Key)
+	partial := &PartialStoreError{Failed: stored.failed, RolledBack: true}
+	for _, key := range stored.keys {
+		if err := s.client.Delete(ctx, projectID, key); err != nil && !errors.Is(err, ErrNotFound) {
+			partial.RolledBack = false
+			break
 		}
 	}
-
-	// Delete old source files
-	oldSource, err := s.client.List(ctx, projectID, "source/")
-	if err == nil {
-		for _, entry := range oldSource {
-			_ = s.client.Delete(ctx, projectID, entry.Key)
+	if !partial.RolledBack {
+		meta.Inconsistent = &Inconsistency{Failed: stored.failed, DetectedAt: now}
+		if err := s.storeMetadata(ctx, projectID, &meta); err != nil {
+			return fmt.Errorf("%w (and failed to mark project inconsistent: %v)", partial, err)
 		}
 	}
+	return partial
+}
 
-	// Get existing metadata for created_at timestamp
-	var createdAt time.Time
-	existingMeta, err := s.GetMetadata(ctx, projectID)
-	if err == nil {
-		createdAt = existingMeta.CreatedAt
-	} else {
-		createdAt = time.Now().UTC()
+// UpdateApp updates existing app files and metadata.
+// The summary is recorded as written in lang. New files are written before
+// stale ones are removed, so if some fail to store the previous files are
+// kept, the metadata is marked inconsistent and a PartialStoreError is
+// returned.
+func (s *Storage) UpdateApp(ctx context.Context, projectID string, files, compiledFiles map[string]string, summary, lang string) error {
+	// Start from existing metadata so created_at, summaries, title and git
+	// state carry over
+	meta := AppMetadata{CreatedAt: time.Now().UTC()}
+	if existingMeta, metaErr := s.GetMetadata(ctx, projectID); metaErr == nil {
+		meta = *existingMeta
+	}
+	if meta.Summaries == nil {
+		meta.Summaries = make(map[string]string)
 	}
 
-	sourceFileList := make([]string, 0, len(files))
-	compiledFileList := make([]string, 0, len(compiledFiles))
+	stored := s.storeAppFiles(ctx, projectID, files, compiledFiles)
+	meta.UpdatedAt = time.Now().UTC()
 
-	// Store new source files
-	for path, content := range files {
-		key := "source/" + path
-		mimeType := getMimeType(path)
-		if storeErr := s.client.Store(ctx, projectID, key, mimeType, []byte(content)); storeErr != nil {
-			return storeErr
+	if len(stored.failed) > 0 {
+		meta.Inconsistent = &Inconsistency{Failed: stored.failed, DetectedAt: meta.UpdatedAt}
+		partial := &PartialStoreError{Failed: stored.failed}
+		if err := s.storeMetadata(ctx, projectID, &meta); err != nil {
+			return fmt.Errorf("%w (and failed to mark project inconsistent: %v)", partial, err)
 		}
-		sourceFileList = append(sourceFileList, path)
+		return partial
 	}
 
-	// Store new compiled files
-	for path, content := range compiledFiles {
-		key := "compiled/" + path
-		mimeType := getMimeType(path)
-		if storeErr := s.client.Store(ctx, projectID, key, mimeType, []byte(content)); storeErr != nil {
-			return storeErr
+	// Remove files the new version no longer has
+	current := make(map[string]bool, len(stored.keys))
+	for _, key := range stored.keys {
+		current[key] = true
+	}
+	for _, prefix := range []string{"source/", "compiled/"} {
+		oldEntries, err := s.client.List(ctx, projectID, prefix)
+		if err != nil {
+			continue
+		}
+		for _, entry := range oldEntries {
+			if !current[entry.Key] {
+				_ = s.client.Delete(ctx, projectID, entry.Key)
+			}
 		}
-		compiledFileList = append(compiledFileList, path)
 	}
 
-	// Update metadata
-	meta := AppMetadata{
-		CreatedAt:     createdAt,
-		UpdatedAt:     time.Now().UTC(),
-		Summary:       summary,
-		SourceFiles:   sourceFileList,
-		CompiledFiles: compiledFileList,
+	if err := s.recordBuild(ctx, projectID, ChannelProduction, stored.compiled); err != nil {
+		return err
 	}
-	metaJSON, err := json.Marshal(meta)
+	meta.Summary = summary
+	meta.Summaries[lang] = summary
+	meta.SourceFiles = stored.source
+	meta.CompiledFiles = stored.compiled
+	meta.Hashes = stored.hashes
+	meta.Inconsistent = nil
+	return s.storeMetadata(ctx, projectID, &meta)
+}
+
+// ListAppKeys lists the project's source and compiled keys with their
+// sizes and ETags.
+func (s *Storage) ListAppKeys(ctx context.Context, projectID string) ([]KeyInfo, error) {
+	source, err := s.client.ListDetailed(ctx, projectID, "source/")
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := s.client.ListDetailed(ctx, projectID, "compiled/")
+	if err != nil {
+		return nil, err
+	}
+	return append(source, compiled...), nil
+}
+
+// RelistManifest rebuilds the metadata's file lists and hashes from what
+// storage actually holds, and clears any inconsistency.
+func (s *Storage) RelistManifest(ctx context.Context, projectID string) error {
+	meta, err := s.GetMetadata(ctx, projectID)
 	if err != nil {
 		return err
 	}
-	return s.client.Store(ctx, projectID, "_meta/app.json", "application/json", metaJSON)
+	entries, err := s.ListAppKeys(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	meta.SourceFiles = []string{}
+	meta.CompiledFiles = []string{}
+	meta.Hashes = make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if path, ok := strings.CutPrefix(entry.Key, "source/"); ok {
+			meta.SourceFiles = append(meta.SourceFiles, path)
+		} else {
+			meta.CompiledFiles = append(meta.CompiledFiles, strings.TrimPrefix(entry.Key, "compiled/"))
+		}
+		meta.Hashes[entry.Key] = entry.ETag
+	}
+	meta.UpdatedAt = time.Now().UTC()
+	meta.Inconsistent = nil
+	return s.storeMetadata(ctx, projectID, meta)
+}
+
+// Ping checks that storage answers, with a listing of an empty prefix of
+// the system namespace.
+func (s *Storage) Ping(ctx context.Context) error {
+	_, err := s.client.List(ctx, systemProject, "_ping/")
+	return err
 }
 
 // GetSourceFiles retrieves all source files for a project.
 func (s *Storage) GetSourceFiles(ctx context.Context, projectID string) (map[string]string, error) {
-	entries, err := s.client.List(ctx, projectID, "source/")
+	return s.getFiles(ctx, projectID, "source/")
+}
+
+// GetSourceFile retrieves one source file, checked against the manifest.
+func (s *Storage) GetSourceFile(ctx context.Context, projectID, path string) (string, error) {
+	key := "source/" + path
+	content, _, err := s.client.Get(ctx, projectID, key)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyContent(ctx, projectID, key, content, s.manifestHashes(ctx, projectID)); err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// GetCompiledFiles retrieves all compiled files for a project.
+func (s *Storage) GetCompiledFiles(ctx context.Context, projectID string) (map[string]string, error) {
+	return s.getFiles(ctx, projectID, "compiled/")
+}
+
+// getFiles retrieves the files under prefix, checked against the manifest.
+func (s *Storage) getFiles(ctx context.Context, projectID, prefix string) (map[string]string, error) {
+	entries, err := s.client.List(ctx, projectID, prefix)
 	if err != nil {
 		return nil, err
 	}
 
-	files := make(map[string]string)
-	for _, entry := range entries {
-		content, _, err := s.client.Get(ctx, projectID, entry.Key)
-		if err != nil {
+	values, err := s.fetchMany(ctx, projectID, entryKeys(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := s.manifestHashes(ctx, projectID)
+	files := make(map[string]string, len(values))
+	for key, value := range values {
+		if err := verifyContent(ctx, projectID, key, value.Content, hashes); err != nil {
 			return nil, err
 		}
-		path := strings.TrimPrefix(entry.Key, "source/")
-		files[path] = string(content)
+		path := strings.TrimPrefix(key, prefix)
+		files[path] = string(value.Content)
 	}
 	return files, nil
 }
 
+// fetchChunkSize is the number of keys read per get-many request when a
+// project's files are fetched in parallel.
+const fetchChunkSize = 16
+
+// fetchMany reads keys in chunks of fetchChunkSize, with up to
+// fetchConcurrency requests in flight. The first failure cancels the
+// remaining requests and is returned.
+func (s *Storage) fetchMany(ctx context.Context, projectID string, keys []string) (map[string]StoredValue, error) {
+	if len(keys) <= fetchChunkSize || s.fetchConcurrency <= 1 {
+		return s.client.GetMany(ctx, projectID, keys)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Each chunk writes only its own slot, so the merge below is the same
+	// whatever order the requests finish in
+	chunks := slices.Collect(slices.Chunk(keys, fetchChunkSize))
+	results := make([]map[string]StoredValue, len(chunks))
+	sem := make(chan struct{}, s.fetchConcurrency)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			values, err := s.client.GetMany(ctx, projectID, chunk)
+			if err != nil {
+				cancel(err)
+				return
+			}
+			results[i] = values
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+
+	merged := make(map[string]StoredValue, len(keys))
+	for _, values := range results {
+		maps.Copy(merged, values)
+	}
+	return merged, nil
+}
+
 // GetCompiledFile retrieves a single compiled file.
 func (s *Storage) GetCompiledFile(ctx context.Context, projectID, path string) ([]byte, string, error) {
 	key := "compiled/" + path
-	return s.client.Get(ctx, projectID, key)
-}
-
-// GetMetadata retrieves the app metadata.
-func (s *Storage) GetMetadata(ctx context.Context, projectID string) (*AppMetadata, error) {
-	content, _, err := s.client.Get(ctx, projectID, "_meta/app.json")
+	content, mimeType, err := s.client.Get(ctx, projectID, key)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	var meta AppMetadata
-	if err := json.Unmarshal(content, &meta); err != nil {
-		return nil, err
+	if err := verifyContent(ctx, projectID, key, content, s.manifestHashes(ctx, projectID)); err != nil {
+		return nil, "", err
 	}
-	return &meta, nil
+	return content, mimeType, nil
 }
 
-// HasApp checks if an app exists for the project.
-func (s *Storage) HasApp(ctx context.Context, projectID string) bool {
-	_, err := s.GetMetadata(ctx, projectID)
-	return err == nil
+// GetChannelFileStream opens a compiled file from the given channel for
+// streaming. Production files are checked against the manifest as they are
+// read when hashes are verified.
+func (s *Storage) GetChannelFileStream(ctx context.Context, projectID, channel, path string) (*StoredStream, error) {
+	if channel == ChannelStaging {
+		return s.client.GetStream(ctx, projectID, "staging/"+path)
+	}
+	key := "compiled/" + path
+	stream, err := s.client.GetStream(ctx, projectID, key)
+	if err != nil {
+		return nil, err
+	}
+	return verifyStream(ctx, projectID, key, stream, s.manifestHashes(ctx, projectID)), nil
 }
 
-// StoreSourceFile stores a single source file.
-func (s *Storage) StoreSourceFile(ctx context.Context, projectID, path, content string) error {
-	key := "source/" + path
-	mimeType := getMimeType(path)
-	return s.client.Store(ctx, projectID, key, mimeType, []byte(content))
+// CompiledFileETag returns the hash of a production compiled file recorded
+// in the metadata, or "" if there is none, so conditional requests can be
+// answered without reading the file.
+func (s *Storage) CompiledFileETag(ctx context.Context, projectID, path string) string {
+	meta, err := s.GetMetadata(ctx, projectID)
+	if err != nil {
+		return ""
+	}
+	return meta.Hashes["compiled/"+path]
 }
 
-// DeleteSourceFile deletes a single source file.
-func (s *Storage) DeleteSourceFile(ctx context.Context, projectID, path string) error {
-	key := "source/" + path
-	return s.client.Delete(ctx, projectID, key)
+// GetChannelFile retrieves a single compiled file from the given channel.
+func (s *Storage) GetChannelFile(ctx context.Context, projectID, channel, path string) ([]byte, string, error) {
+	if channel == ChannelStaging {
+		return s.client.Get(ctx, projectID, "staging/"+path)
+	}
+	return s.GetCompiledFile(ctx, projectID, path)
 }
 
-// StoreCompiledFiles stores all compiled files and updates metadata.
-func (s *Storage) StoreCompiledFiles(ctx context.Context, projectID string, compiledFiles map[string]string) error {
-	// Delete old compiled files first
-	oldCompiled, err := s.client.List(ctx, projectID, "compiled/")
+// StoreStagingFiles replaces the staging compiled set.
+func (s *Storage) StoreStagingFiles(ctx context.Context, projectID string, compiledFiles map[string]string) error {
+	oldStaging, err := s.client.List(ctx, projectID, "staging/")
 	if err == nil {
-		for _, entry := range oldCompiled {
+		for _, entry := range oldStaging {
 			_ = s.client.Delete(ctx, projectID, entry.Key)
 		}
 	}
 
-	compiledFileList := make([]string, 0, len(compiledFiles))
-
-	// Store new compiled files
 	for path, content := range compiledFiles {
-		key := "compiled/" + path
-		mimeType := getMimeType(path)
-		if storeErr := s.client.Store(ctx, projectID, key, mimeType, []byte(content)); storeErr != nil {
-			return storeErr
+		if _, err := s.storeCompiledFile(ctx, projectID, "staging/", path, content); err != nil {
+			return err
 		}
-		compiledFileList = append(compiledFileList, path)
 	}
+	return s.recordBuild(ctx, projectID, ChannelStaging, slices.Collect(maps.Keys(compiledFiles)))
+}
 
-	// Update metadata with compiled file list
-	existingMeta, err := s.GetMetadata(ctx, projectID)
+// PromoteStaging copies the staging compiled set over production.
+// Assets are written before index.html and stale production files are only
+// removed afterwards, so viewers switch from the old app to the new one in a
+// single step when index.html is replaced.
+func (s *Storage) PromoteStaging(ctx context.Context, projectID string) ([]string, error) {
+	staged, err := s.client.ListDetailed(ctx, projectID, "staging/")
 	if err != nil {
-		// Create new metadata if none exists
-		now := time.Now().UTC()
-		existingMeta = &AppMetadata{
-			CreatedAt:   now,
-			UpdatedAt:   now,
-			SourceFiles: []string{},
+		return nil, err
+	}
+	if len(staged) == 0 {
+		return nil, ErrNotFound
+	}
+
+	files := make(map[string]bool, len(staged))
+	var index *KeyInfo
+	for i, entry := range staged {
+		path := strings.TrimPrefix(entry.Key, "staging/")
+		files[path] = true
+		if path == "index.html" {
+			index = &staged[i]
+			continue
+		}
+		if err := s.copyKey(ctx, projectID, entry.Key, "compiled/"+path); err != nil {
+			return nil, err
+		}
+	}
+	if index != nil {
+		if err := s.copyKey(ctx, projectID, index.Key, "compiled/index.html"); err != nil {
+			return nil, err
 		}
 	}
 
-	// Get current source files
-	sourceEntries, err := s.client.List(ctx, projectID, "source/")
+	// Remove production files that aren't part of the promoted set
+	oldCompiled, err := s.client.List(ctx, projectID, "compiled/")
 	if err == nil {
-		sourceFiles := make([]string, 0, len(sourceEntries))
-		for _, entry := range sourceEntries {
-			sourceFiles = append(sourceFiles, strings.TrimPrefix(entry.Key, "source/"))
+		for _, entry := range oldCompiled {
+			if !files[strings.TrimPrefix(entry.Key, "compiled/")] {
+				_ = s.client.Delete(ctx, projectID, entry.Key)
+			}
 		}
-		existingMeta.SourceFiles = sourceFiles
+	}
+	for _, entry := range staged {
+		_ = s.client.Delete(ctx, projectID, entry.Key)
 	}
 
-	existingMeta.UpdatedAt = time.Now().UTC()
-	existingMeta.CompiledFiles = compiledFileList
-
-	metaJSON, err := json.Marshal(existingMeta)
-	if err != nil {
-		return err
+	compiledFileList := make([]string, 0, len(files))
+	for path := range files {
+		compiledFileList = append(compiledFileList, path)
+	}
+	if err := s.recordBuild(ctx, projectID, ChannelProduction, compiledFileList); err != nil {
+		return nil, err
 	}
-	return s.client.Store(ctx, projectID, "_meta/app.json", "application/json", metaJSON)
-}
 
-// GetConversation retrieves the stored conversation for a project.
-func (s *Storage) GetConversation(ctx context.Context, projectID string) (json.RawMessage, error) {
-	content, _, err := s.client.Get(ctx, projectID, "_meta/conversation.json")
+	meta, err := s.GetMetadata(ctx, projectID)
 	if err != nil {
 		return nil, err
 	}
-	return content, nil
+	meta.UpdatedAt = time.Now().UTC()
+	meta.CompiledFiles = compiledFileList
+	hashes := make(map[string]string, len(meta.Hashes))
+	for key, hash := range meta.Hashes {
+		if !strings.HasPrefix(key, "compiled/") {
+			hashes[key] = hash
+		}
+	}
+	for _, entry := range staged {
+		hashes["compiled/"+strings.TrimPrefix(entry.Key, "staging/")] = entry.ETag
+	}
+	meta.Hashes = hashes
+	if err := s.storeMetadata(ctx, projectID, meta); err != nil {
+		return nil, err
+	}
+	return compiledFileList, nil
+}
+
+// copyKey copies a single entry within a project.
+func (s *Storage) copyKey(ctx context.Context, projectID, from, to string) error {
+	content, mimeType, err := s.client.Get(ctx, projectID, from)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, to, mimeType, content)
+}
+
+// GetMetadata retrieves the app metadata. With the event log, the file
+// lists and hashes are the ones the log produces.
+func (s *Storage) GetMetadata(ctx context.Context, projectID string) (*AppMetadata, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/app.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var meta AppMetadata
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, err
+	}
+	if s.events != nil {
+		manifest, err := s.events.Manifest(ctx, projectID)
+		if err != nil {
+			return nil, fmt.Errorf("reading the event log: %w", err)
+		}
+		// A project with no events yet has only the stored manifest
+		if manifest.Seq > 0 {
+			meta.SourceFiles = manifest.SourceFiles
+			meta.CompiledFiles = manifest.CompiledFiles
+			meta.Hashes = manifest.Hashes
+		}
+	}
+	return &meta, nil
+}
+
+// HasApp checks if an app exists for the project.
+func (s *Storage) HasApp(ctx context.Context, projectID string) bool {
+	_, err := s.GetMetadata(ctx, projectID)
+	return err == nil
+}
+
+// StoreSourceFile stores a single source file, keeping the content it
+// replaces in the file's history.
+func (s *Storage) StoreSourceFile(ctx context.Context, projectID, path, content string) error {
+	s.saveHistory(ctx, projectID, map[string]string{path: content})
+	key := "source/" + path
+	mimeType := getMimeType(path)
+	return s.client.Store(ctx, projectID, key, mimeType, []byte(content))
+}
+
+// ReplaceSourceFiles stores the given source files and deletes any others.
+func (s *Storage) ReplaceSourceFiles(ctx context.Context, projectID string, files map[string]string) error {
+	oldSource, err := s.client.List(ctx, projectID, "source/")
+	if err != nil {
+		return err
+	}
+
+	for path, content := range files {
+		if err := s.StoreSourceFile(ctx, projectID, path, content); err != nil {
+			return err
+		}
+	}
+	for _, entry := range oldSource {
+		if _, ok := files[strings.TrimPrefix(entry.Key, "source/")]; !ok {
+			if err := s.client.Delete(ctx, projectID, entry.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteSourceFile deletes a single source file.
+func (s *Storage) DeleteSourceFile(ctx context.Context, projectID, path string) error {
+	key := "source/" + path
+	return s.client.Delete(ctx, projectID, key)
+}
+
+// StoreCompiledFiles stores all compiled files and updates metadata.
+func (s *Storage) StoreCompiledFiles(ctx context.Context, projectID string, compiledFiles map[string]string) error {
+	// Delete old compiled files first
+	oldCompiled, err := s.client.List(ctx, projectID, "compiled/")
+	if err == nil {
+		for _, entry := range oldCompiled {
+			_ = s.client.Delete(ctx, projectID, entry.Key)
+		}
+	}
+
+	compiledFileList := make([]string, 0, len(compiledFiles))
+	hashes := make(map[string]string, len(compiledFiles))
+
+	// Store new compiled files
+	for path, content := range compiledFiles {
+		hash, storeErr := s.storeCompiledFile(ctx, projectID, "compiled/", path, content)
+		if storeErr != nil {
+			return storeErr
+		}
+		compiledFileList = append(compiledFileList, path)
+		hashes["compiled/"+path] = hash
+	}
+
+	// Update metadata with compiled file list
+	existingMeta, err := s.GetMetadata(ctx, projectID)
+	if err != nil {
+		// Create new metadata if none exists
+		now := time.Now().UTC()
+		existingMeta = &AppMetadata{
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			SourceFiles: []string{},
+		}
+	}
+
+	// Get current source files, which chat writes without updating the
+	// metadata
+	sourceEntries, err := s.client.ListDetailed(ctx, projectID, "source/")
+	if err == nil {
+		sourceFiles := make([]string, 0, len(sourceEntries))
+		for _, entry := range sourceEntries {
+			sourceFiles = append(sourceFiles, strings.TrimPrefix(entry.Key, "source/"))
+			hashes[entry.Key] = entry.ETag
+		}
+		existingMeta.SourceFiles = sourceFiles
+		existingMeta.Hashes = hashes
+	}
+
+	if err := s.recordBuild(ctx, projectID, ChannelProduction, compiledFileList); err != nil {
+		return err
+	}
+	existingMeta.UpdatedAt = time.Now().UTC()
+	existingMeta.CompiledFiles = compiledFileList
+
+	return s.storeMetadata(ctx, projectID, existingMeta)
+}
+
+// ProjectState is the composed document served to state polls. It is
+// rewritten alongside app.json and conversation.json so a poll costs one
+// round trip instead of three.
+type ProjectState struct {
+	Metadata     *AppMetadata    `json:"metadata,omitempty"`
+	Conversation json.RawMessage `json:"conversation,omitempty"`
+	Warnings     []QuotaWarning  `json:"warnings,omitempty"`
+}
+
+// GetState retrieves the composed state document, rebuilding it from the
+// metadata and conversation if it hasn't been written yet.
+func (s *Storage) GetState(ctx context.Context, projectID string) (*ProjectState, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/state.json")
+	if err == nil {
+		var state ProjectState
+		if err := json.Unmarshal(content, &state); err != nil {
+			return nil, err
+		}
+		return &state, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	// Projects written before the state document existed
+	state := &ProjectState{}
+	if meta, err := s.GetMetadata(ctx, projectID); err == nil {
+		state.Metadata = meta
+	}
+	if conversation, err := s.GetConversation(ctx, projectID); err == nil {
+		state.Conversation = conversation
+	}
+	if state.Metadata == nil && state.Conversation == nil {
+		return state, nil
+	}
+	if err := s.storeState(ctx, projectID, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// GetConversationSummary retrieves the cached summary of compacted chat messages.
+func (s *Storage) GetConversationSummary(ctx context.Context, projectID string) (*ConversationSummary, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/conversation_summary.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var summary ConversationSummary
+	if err := json.Unmarshal(content, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// StoreConversationSummary caches the summary of compacted chat messages.
+func (s *Storage) StoreConversationSummary(ctx context.Context, projectID string, summary *ConversationSummary) error {
+	summaryJSON, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/conversation_summary.json", "application/json", summaryJSON)
+}
+
+// SetQuotaWarnings replaces the quota warnings in the project state and
+// returns the ones it held before.
+func (s *Storage) SetQuotaWarnings(ctx context.Context, projectID string, warnings []QuotaWarning) ([]QuotaWarning, error) {
+	var previous []QuotaWarning
+	err := s.updateState(ctx, projectID, func(state *ProjectState) {
+		previous = state.Warnings
+		state.Warnings = warnings
+	})
+	return previous, err
+}
+
+// GetConversation retrieves the stored conversation for a project.
+func (s *Storage) GetConversation(ctx context.Context, projectID string) (json.RawMessage, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/conversation.json")
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
 }
 
 // StoreConversation saves the conversation for a project.
 func (s *Storage) StoreConversation(ctx context.Context, projectID string, conversation json.RawMessage) error {
-	return s.client.Store(ctx, projectID, "_meta/conversation.json", "application/json", conversation)
+	if err := s.client.Store(ctx, projectID, "_meta/conversation.json", "application/json", conversation); err != nil {
+		return err
+	}
+	return s.updateState(ctx, projectID, func(state *ProjectState) {
+		state.Conversation = conversation
+	})
+}
+
+// forkedMetaKeys are the configuration keys a fork copies: the app's
+// settings and build toggles, not its history, integrations, secrets or
+// memberships.
+var forkedMetaKeys = []string{
+	"_meta/settings.json",
+	"_meta/pwa.json",
+	"_meta/sri.json",
+	"_meta/robots.json",
+	"_meta/prompt.txt",
+	"_meta/library.json",
+	"_meta/licenses.json",
+}
+
+// ForkProject copies a project's source and compiled files, configuration
+// and, with conversation, its chat history into the new project toID, and
+// returns the fork's metadata.
+func (s *Storage) ForkProject(ctx context.Context, fromID, toID string, conversation bool) (*AppMetadata, error) {
+	meta, err := s.GetMetadata(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.ListAppKeys(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+	keys := append(entryKeys(entries), forkedMetaKeys...)
+	if conversation {
+		keys = append(keys, "_meta/conversation_summary.json")
+	}
+	values, err := s.client.GetMany(ctx, fromID, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if err := s.client.Store(ctx, toID, key, values[key].MimeType, values[key].Content); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	fork := *meta
+	fork.CreatedAt, fork.UpdatedAt = now, now
+	fork.GitCommit = ""
+	fork.ForkedFrom = fromID
+	if err := s.storeMetadata(ctx, toID, &fork); err != nil {
+		return nil, err
+	}
+	if fork.Title != "" {
+		if err := s.SetTitle(ctx, toID, fork.Title, fork.Description); err != nil {
+			return nil, err
+		}
+	}
+
+	if conversation {
+		history, err := s.GetConversation(ctx, fromID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			if err := s.StoreConversation(ctx, toID, history); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &fork, nil
+}
+
+// Workspace groups projects that share a conversation and whose agents
+// can read each other's source files. It is stored in its own namespace,
+// named by its ID, and each member project points back to it.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Projects  []string  `json:"projects"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// workspaceKey holds a workspace in its namespace, and in a member
+// project's, a pointer to it.
+const workspaceKey = "_meta/workspace.json"
+
+// GetWorkspace retrieves a workspace and its ETag, for StoreWorkspace.
+func (s *Storage) GetWorkspace(ctx context.Context, workspaceID string) (*Workspace, string, error) {
+	content, _, err := s.client.Get(ctx, workspaceID, workspaceKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ws Workspace
+	if err := json.Unmarshal(content, &ws); err != nil {
+		return nil, "", err
+	}
+	return &ws, contentETag(content), nil
+}
+
+// StoreWorkspace saves a workspace if the stored one's ETag is etag, with
+// StoreIf semantics; its projects must already have joined it. A
+// workspace left with no projects is deleted instead, with its
+// conversation.
+func (s *Storage) StoreWorkspace(ctx context.Context, ws *Workspace, etag string) error {
+	if len(ws.Projects) == 0 {
+		if err := s.client.DeleteIf(ctx, ws.ID, workspaceKey, etag); err != nil {
+			return err
+		}
+		if err := s.client.Delete(ctx, ws.ID, "_meta/conversation.json"); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	wsJSON, err := json.Marshal(ws)
+	if err != nil {
+		return err
+	}
+	return s.client.StoreIf(ctx, ws.ID, workspaceKey, "application/json", wsJSON, etag)
+}
+
+// DeleteWorkspace removes a workspace, its conversation and its projects'
+// pointers to it. The workspace goes first, so a project joining it
+// meanwhile finds it gone; any pointer missed is stale and taken over by
+// the next JoinWorkspace.
+func (s *Storage) DeleteWorkspace(ctx context.Context, ws *Workspace) error {
+	for _, key := range []string{workspaceKey, "_meta/conversation.json"} {
+		if err := s.client.Delete(ctx, ws.ID, key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	for _, projectID := range ws.Projects {
+		if err := s.LeaveWorkspace(ctx, projectID, ws.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// projectWorkspace returns a project's pointer to its workspace and the
+// pointer's ETag, or ErrNotFound.
+func (s *Storage) projectWorkspace(ctx context.Context, projectID string) (string, string, error) {
+	content, _, err := s.client.Get(ctx, projectID, workspaceKey)
+	if err != nil {
+		return "", "", err
+	}
+	var pointer struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(content, &pointer); err != nil {
+		return "", "", err
+	}
+	return pointer.ID, contentETag(content), nil
+}
+
+// ProjectWorkspace returns the ID of the workspace a project belongs to,
+// or ErrNotFound.
+func (s *Storage) ProjectWorkspace(ctx context.Context, projectID string) (string, error) {
+	workspaceID, _, err := s.projectWorkspace(ctx, projectID)
+	return workspaceID, err
+}
+
+// JoinWorkspace points a project at a workspace and returns workspaceID,
+// unless the project already belongs to another, whose ID it returns
+// instead. Pointers are written conditionally, so of two workspaces
+// claiming a project at once only one gets it; a pointer left to a
+// workspace that no longer exists is taken over.
+func (s *Storage) JoinWorkspace(ctx context.Context, projectID, workspaceID string) (string, error) {
+	pointer, err := json.Marshal(map[string]string{"id": workspaceID})
+	if err != nil {
+		return "", err
+	}
+	for range maxWorkspaceRetries {
+		current, etag, err := s.projectWorkspace(ctx, projectID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			etag = "*"
+		case err != nil:
+			return "", err
+		case current == workspaceID:
+			return current, nil
+		default:
+			if _, _, err := s.GetWorkspace(ctx, current); !errors.Is(err, ErrNotFound) {
+				return current, err
+			}
+		}
+		err = s.client.StoreIf(ctx, projectID, workspaceKey, "application/json", pointer, etag)
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return workspaceID, err
+		}
+	}
+	return "", fmt.Errorf("workspace of project %s kept changing", projectID)
+}
+
+// LeaveWorkspace removes a project's pointer to workspaceID, leaving a
+// pointer to any other workspace alone.
+func (s *Storage) LeaveWorkspace(ctx context.Context, projectID, workspaceID string) error {
+	current, etag, err := s.projectWorkspace(ctx, projectID)
+	if errors.Is(err, ErrNotFound) || (err == nil && current != workspaceID) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = s.client.DeleteIf(ctx, projectID, workspaceKey, etag)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrPreconditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// GetWorkspaceConversation retrieves a workspace's shared conversation.
+func (s *Storage) GetWorkspaceConversation(ctx context.Context, workspaceID string) (json.RawMessage, error) {
+	content, _, err := s.client.Get(ctx, workspaceID, "_meta/conversation.json")
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// StoreWorkspaceConversation saves a workspace's shared conversation.
+func (s *Storage) StoreWorkspaceConversation(ctx context.Context, workspaceID string, conversation json.RawMessage) error {
+	return s.client.Store(ctx, workspaceID, "_meta/conversation.json", "application/json", conversation)
+}
+
+// libraryAssetsPrefix is where a library namespace keeps its assets.
+const libraryAssetsPrefix = "assets/"
+
+// ListLibraryAssets lists a library's assets with their sizes and ETags.
+func (s *Storage) ListLibraryAssets(ctx context.Context, library string) ([]KeyInfo, error) {
+	return s.client.ListDetailed(ctx, library, libraryAssetsPrefix)
+}
+
+// GetLibraryAsset retrieves a library asset and its mime type.
+func (s *Storage) GetLibraryAsset(ctx context.Context, library, name string) ([]byte, string, error) {
+	return s.client.Get(ctx, library, libraryAssetsPrefix+name)
+}
+
+// StoreLibraryAsset saves a library asset.
+func (s *Storage) StoreLibraryAsset(ctx context.Context, library, name, mimeType string, content []byte) error {
+	return s.client.Store(ctx, library, libraryAssetsPrefix+name, mimeType, content)
+}
+
+// DeleteLibraryAsset removes a library asset.
+func (s *Storage) DeleteLibraryAsset(ctx context.Context, library, name string) error {
+	return s.client.Delete(ctx, library, libraryAssetsPrefix+name)
+}
+
+// ProjectLibrary is the asset library a project's library:// references
+// resolve to.
+type ProjectLibrary struct {
+	Owner     string `json:"owner"`
+	Namespace string `json:"namespace"`
+}
+
+// GetProjectLibrary retrieves the library a project is linked to, or
+// ErrNotFound.
+func (s *Storage) GetProjectLibrary(ctx context.Context, projectID string) (*ProjectLibrary, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/library.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var lib ProjectLibrary
+	if err := json.Unmarshal(content, &lib); err != nil {
+		return nil, err
+	}
+	return &lib, nil
+}
+
+// StoreProjectLibrary links a project to a library.
+func (s *Storage) StoreProjectLibrary(ctx context.Context, projectID string, lib *ProjectLibrary) error {
+	libJSON, err := json.Marshal(lib)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/library.json", "application/json", libJSON)
+}
+
+// DeleteProjectLibrary unlinks a project from its library.
+func (s *Storage) DeleteProjectLibrary(ctx context.Context, projectID string) error {
+	return s.client.Delete(ctx, projectID, "_meta/library.json")
+}
+
+// BrandKit is an owner's branding, applied to the apps of projects linked
+// to their library.
+type BrandKit struct {
+	// Colors are CSS colors by name, e.g. primary: #0055ff.
+	Colors map[string]string `json:"colors,omitempty"`
+	// Fonts are CSS font families by role, e.g. heading: "Inter", sans-serif.
+	Fonts map[string]string `json:"fonts,omitempty"`
+	// Logo is the name of a library asset.
+	Logo      string    `json:"logo,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetBrandKit retrieves a library's brand kit, or ErrNotFound.
+func (s *Storage) GetBrandKit(ctx context.Context, library string) (*BrandKit, error) {
+	content, _, err := s.client.Get(ctx, library, "_meta/brand.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var kit BrandKit
+	if err := json.Unmarshal(content, &kit); err != nil {
+		return nil, err
+	}
+	return &kit, nil
+}
+
+// StoreBrandKit saves a library's brand kit.
+func (s *Storage) StoreBrandKit(ctx context.Context, library string, kit *BrandKit) error {
+	kitJSON, err := json.Marshal(kit)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, library, "_meta/brand.json", "application/json", kitJSON)
+}
+
+// DeleteBrandKit removes a library's brand kit.
+func (s *Storage) DeleteBrandKit(ctx context.Context, library string) error {
+	return s.client.Delete(ctx, library, "_meta/brand.json")
+}
+
+// storeMetadata saves the app metadata and refreshes the state document.
+func (s *Storage) storeMetadata(ctx context.Context, projectID string, meta *AppMetadata) error {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Store(ctx, projectID, "_meta/app.json", "application/json", metaJSON); err != nil {
+		return err
+	}
+	return s.updateState(ctx, projectID, func(state *ProjectState) {
+		state.Metadata = meta
+	})
+}
+
+// updateState applies fn to the current state document and stores it.
+// Updates are serialised so concurrent metadata and conversation writes on
+// this instance don't drop each other.
+func (s *Storage) updateState(ctx context.Context, projectID string, fn func(*ProjectState)) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	state, err := s.GetState(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	fn(state)
+	return s.storeState(ctx, projectID, state)
+}
+
+// storeState writes the composed state document.
+func (s *Storage) storeState(ctx context.Context, projectID string, state *ProjectState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/state.json", "application/json", stateJSON)
+}
+
+// GetUsage returns the project's usage for period, which is zero when
+// nothing has been recorded since the period began.
+func (s *Storage) GetUsage(ctx context.Context, projectID, period string) (*ProjectUsage, error) {
+	usage := ProjectUsage{Period: period}
+	content, _, err := s.client.Get(ctx, projectID, "_meta/usage.json")
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		var stored ProjectUsage
+		if err := json.Unmarshal(content, &stored); err != nil {
+			return nil, err
+		}
+		if stored.Period == period {
+			usage = stored
+		}
+	}
+	return &usage, nil
+}
+
+// AddUsage adds tokens and build time to the project's usage for period,
+// starting afresh when the period has rolled over, and returns the totals.
+func (s *Storage) AddUsage(ctx context.Context, projectID, period string, tokens int64, buildSeconds float64) (*ProjectUsage, error) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+
+	usage, err := s.GetUsage(ctx, projectID, period)
+	if err != nil {
+		return nil, err
+	}
+
+	usage.Tokens += tokens
+	usage.BuildSeconds += buildSeconds
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.client.Store(ctx, projectID, "_meta/usage.json", "application/json", usageJSON); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// SourceSize returns the total size of the project's source files in bytes.
+func (s *Storage) SourceSize(ctx context.Context, projectID string) (int64, error) {
+	entries, err := s.client.ListDetailed(ctx, projectID, "source/")
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	return size, nil
+}
+
+// GetHook retrieves a webhook definition and its ETag, for StoreHook.
+func (s *Storage) GetHook(ctx context.Context, projectID, hookID string) (*Hook, string, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/hooks/"+hookID+".json")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var hook Hook
+	if err := json.Unmarshal(content, &hook); err != nil {
+		return nil, "", err
+	}
+	return &hook, contentETag(content), nil
+}
+
+// StoreHook saves a webhook definition if the stored one's ETag is etag,
+// with StoreIf semantics.
+func (s *Storage) StoreHook(ctx context.Context, projectID string, hook *Hook, etag string) error {
+	hookJSON, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	return s.client.StoreIf(ctx, projectID, "_meta/hooks/"+hook.ID+".json", "application/json", hookJSON, etag)
+}
+
+// DeleteHook removes a webhook definition.
+func (s *Storage) DeleteHook(ctx context.Context, projectID, hookID string) error {
+	return s.client.Delete(ctx, projectID, "_meta/hooks/"+hookID+".json")
+}
+
+// GetGitLink retrieves the project's linked Git repository.
+func (s *Storage) GetGitLink(ctx context.Context, projectID string) (*GitLink, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/git.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var link GitLink
+	if err := json.Unmarshal(content, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// StoreGitLink saves the project's linked Git repository.
+func (s *Storage) StoreGitLink(ctx context.Context, projectID string, link *GitLink) error {
+	linkJSON, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/git.json", "application/json", linkJSON)
+}
+
+// DeleteGitLink removes the project's linked Git repository.
+func (s *Storage) DeleteGitLink(ctx context.Context, projectID string) error {
+	return s.client.Delete(ctx, projectID, "_meta/git.json")
+}
+
+// SetGitCommit records the last synced commit SHA in the app metadata.
+func (s *Storage) SetGitCommit(ctx context.Context, projectID, sha string) error {
+	meta, err := s.GetMetadata(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	meta.GitCommit = sha
+	return s.storeMetadata(ctx, projectID, meta)
+}
+
+// SetTitle records the project's title and description in its metadata
+// and in the system-wide project index.
+func (s *Storage) SetTitle(ctx context.Context, projectID, title, description string) error {
+	meta, err := s.GetMetadata(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	meta.Title = title
+	meta.Description = description
+	if err := s.storeMetadata(ctx, projectID, meta); err != nil {
+		return err
+	}
+
+	entryJSON, err := json.Marshal(ProjectListEntry{
+		ID:          projectID,
+		Title:       title,
+		Description: description,
+		CreatedAt:   meta.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, systemProject, "projects/"+projectID, "application/json", entryJSON)
+}
+
+// ListProjects retrieves every titled project from the system-wide index.
+func (s *Storage) ListProjects(ctx context.Context) ([]ProjectListEntry, error) {
+	entries, err := s.client.List(ctx, systemProject, "projects/")
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.client.GetMany(ctx, systemProject, entryKeys(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	projects := make([]ProjectListEntry, 0, len(values))
+	for _, entry := range entries {
+		value, ok := values[entry.Key]
+		if !ok {
+			continue
+		}
+		var project ProjectListEntry
+		if err := json.Unmarshal(value.Content, &project); err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// apiKeyPrefix is the system-wide keyspace of stored API keys, each keyed
+// by the SHA-256 of its secret so the secret itself is never stored.
+const apiKeyPrefix = "_auth/"
+
+// GetAPIKey retrieves the stored API key with the given secret hash.
+func (s *Storage) GetAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	content, _, err := s.client.Get(ctx, systemProject, apiKeyPrefix+hash)
+	if err != nil {
+		return nil, err
+	}
+	var key APIKey
+	if err := json.Unmarshal(content, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// StoreAPIKey stores an API key under its secret hash.
+func (s *Storage) StoreAPIKey(ctx context.Context, hash string, key *APIKey) error {
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, systemProject, apiKeyPrefix+hash, "application/json", keyJSON)
+}
+
+// ListAPIKeys retrieves every stored API key, by secret hash.
+func (s *Storage) ListAPIKeys(ctx context.Context) (map[string]APIKey, error) {
+	entries, err := s.client.List(ctx, systemProject, apiKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.client.GetMany(ctx, systemProject, entryKeys(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]APIKey, len(values))
+	for name, value := range values {
+		var key APIKey
+		if err := json.Unmarshal(value.Content, &key); err != nil {
+			return nil, err
+		}
+		keys[strings.TrimPrefix(name, apiKeyPrefix)] = key
+	}
+	return keys, nil
+}
+
+// RemoveAPIKeyProject takes a deleted project out of the stored keys
+// limited to it. A key left with no projects is deleted rather than
+// becoming unrestricted. Keys configured in API_KEYS are left as they are.
+func (s *Storage) RemoveAPIKeyProject(ctx context.Context, projectID string) error {
+	keys, err := s.ListAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for hash, key := range keys {
+		if !slices.Contains(key.Projects, projectID) {
+			continue
+		}
+		key.Projects = slices.DeleteFunc(key.Projects, func(id string) bool { return id == projectID })
+		if len(key.Projects) == 0 {
+			err = s.DeleteAPIKey(ctx, hash)
+		} else {
+			err = s.StoreAPIKey(ctx, hash, &key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAPIKey removes the stored API key with the given secret hash.
+func (s *Storage) DeleteAPIKey(ctx context.Context, hash string) error {
+	return s.client.Delete(ctx, systemProject, apiKeyPrefix+hash)
+}
+
+// proxyCacheKey is the system-wide cache key for an external URL.
+func proxyCacheKey(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return "proxy/" + hex.EncodeToString(sum[:])
+}
+
+// GetProxyCache retrieves a cached external resource.
+func (s *Storage) GetProxyCache(ctx context.Context, target string) ([]byte, string, error) {
+	return s.client.Get(ctx, systemProject, proxyCacheKey(target))
+}
+
+// StoreProxyCache caches an external resource. The cache is shared across
+// projects since the content only depends on the URL.
+func (s *Storage) StoreProxyCache(ctx context.Context, target, mimeType string, content []byte) error {
+	return s.client.Store(ctx, systemProject, proxyCacheKey(target), mimeType, content)
+}
+
+// GetSchedule retrieves the project's schedule.
+func (s *Storage) GetSchedule(ctx context.Context, projectID string) (*Schedule, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/schedule.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var sched Schedule
+	if err := json.Unmarshal(content, &sched); err != nil {
+		return nil, err
+	}
+	return &sched, nil
+}
+
+// StoreSchedule saves the project's schedule and adds it to the schedule index.
+func (s *Storage) StoreSchedule(ctx context.Context, projectID string, sched *Schedule) error {
+	schedJSON, err := json.Marshal(sched)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Store(ctx, projectID, "_meta/schedule.json", "application/json", schedJSON); err != nil {
+		return err
+	}
+	return s.client.Store(ctx, systemProject, "schedules/"+projectID, "text/plain", []byte(projectID))
+}
+
+// DeleteSchedule removes the project's schedule and its index entry.
+func (s *Storage) DeleteSchedule(ctx context.Context, projectID string) error {
+	if err := s.client.Delete(ctx, systemProject, "schedules/"+projectID); err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, projectID, "_meta/schedule.json")
+}
+
+// ListScheduledProjects returns the IDs of all projects with a schedule.
+func (s *Storage) ListScheduledProjects(ctx context.Context) ([]string, error) {
+	entries, err := s.client.List(ctx, systemProject, "schedules/")
+	if err != nil {
+		return nil, err
+	}
+
+	projectIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		projectIDs = append(projectIDs, strings.TrimPrefix(entry.Key, "schedules/"))
+	}
+	return projectIDs, nil
+}
+
+// GetPendingDeletion retrieves the project's scheduled deletion.
+func (s *Storage) GetPendingDeletion(ctx context.Context, projectID string) (*PendingDeletion, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/deletion.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var pending PendingDeletion
+	if err := json.Unmarshal(content, &pending); err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// StorePendingDeletion schedules the project for deletion and indexes it.
+func (s *Storage) StorePendingDeletion(ctx context.Context, projectID string, pending *PendingDeletion) error {
+	pendingJSON, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Store(ctx, projectID, "_meta/deletion.json", "application/json", pendingJSON); err != nil {
+		return err
+	}
+	return s.client.Store(ctx, systemProject, "deletions/"+projectID, "text/plain", []byte(projectID))
+}
+
+// DeletePendingDeletion cancels the project's scheduled deletion.
+func (s *Storage) DeletePendingDeletion(ctx context.Context, projectID string) error {
+	if err := s.client.Delete(ctx, systemProject, "deletions/"+projectID); err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, projectID, "_meta/deletion.json")
+}
+
+// ListPendingDeletions returns the IDs of all projects scheduled for deletion.
+func (s *Storage) ListPendingDeletions(ctx context.Context) ([]string, error) {
+	entries, err := s.client.List(ctx, systemProject, "deletions/")
+	if err != nil {
+		return nil, err
+	}
+
+	projectIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		projectIDs = append(projectIDs, strings.TrimPrefix(entry.Key, "deletions/"))
+	}
+	return projectIDs, nil
+}
+
+// DeleteProject removes every key stored for a project along with its
+// entries in the system indexes, returning how many keys were deleted under
+// each top-level prefix. Deleting a project with no keys is not an error.
+func (s *Storage) DeleteProject(ctx context.Context, projectID string) (map[string]int, error) {
+	// The log goes with the project rather than recording its deletion
+	ctx = withoutEvents(ctx)
+	defer s.events.Forget(projectID)
+	entries, err := s.client.List(ctx, projectID, "")
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]int)
+	for _, entry := range entries {
+		// The deleting request's lock is removed once it has released it
+		if entry.Key == projectLockKey {
+			continue
+		}
+		if err := s.client.Delete(ctx, projectID, entry.Key); err != nil {
+			return deleted, err
+		}
+		prefix, _, _ := strings.Cut(entry.Key, "/")
+		deleted[prefix+"/"]++
+	}
+
+	for _, index := range []string{"schedules/", "deletions/", "projects/"} {
+		if err := s.client.Delete(ctx, systemProject, index+projectID); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// GetExperiment retrieves the project's A/B experiment.
+func (s *Storage) GetExperiment(ctx context.Context, projectID string) (*Experiment, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/experiment.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var exp Experiment
+	if err := json.Unmarshal(content, &exp); err != nil {
+		return nil, err
+	}
+	if exp.Exposures == nil {
+		exp.Exposures = make(map[string]int)
+	}
+	return &exp, nil
+}
+
+// StoreExperiment saves the project's A/B experiment.
+func (s *Storage) StoreExperiment(ctx context.Context, projectID string, exp *Experiment) error {
+	expJSON, err := json.Marshal(exp)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/experiment.json", "application/json", expJSON)
+}
+
+// DeleteExperiment removes the project's A/B experiment.
+func (s *Storage) DeleteExperiment(ctx context.Context, projectID string) error {
+	return s.client.Delete(ctx, projectID, "_meta/experiment.json")
+}
+
+// GetRobots retrieves the project's indexing settings.
+func (s *Storage) GetRobots(ctx context.Context, projectID string) (*RobotsSettings, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/robots.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var robots RobotsSettings
+	if err := json.Unmarshal(content, &robots); err != nil {
+		return nil, err
+	}
+	return &robots, nil
+}
+
+// StoreRobots saves the project's indexing settings.
+func (s *Storage) StoreRobots(ctx context.Context, projectID string, robots *RobotsSettings) error {
+	robotsJSON, err := json.Marshal(robots)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/robots.json", "application/json", robotsJSON)
+}
+
+// GetPWA retrieves the project's PWA settings.
+func (s *Storage) GetPWA(ctx context.Context, projectID string) (*PWASettings, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/pwa.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var pwa PWASettings
+	if err := json.Unmarshal(content, &pwa); err != nil {
+		return nil, err
+	}
+	return &pwa, nil
+}
+
+// StorePWA saves the project's PWA settings.
+func (s *Storage) StorePWA(ctx context.Context, projectID string, pwa *PWASettings) error {
+	pwaJSON, err := json.Marshal(pwa)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/pwa.json", "application/json", pwaJSON)
+}
+
+// GetSettings retrieves the settings a project has changed from the defaults.
+func (s *Storage) GetSettings(ctx context.Context, projectID string) (map[string]json.RawMessage, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/settings.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides map[string]json.RawMessage
+	if err := json.Unmarshal(content, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// StoreSettings saves the settings a project has changed from the defaults.
+func (s *Storage) StoreSettings(ctx context.Context, projectID string, overrides map[string]json.RawMessage) error {
+	settingsJSON, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Store(ctx, projectID, "_meta/settings.json", "application/json", settingsJSON); err != nil {
+		return err
+	}
+	return s.events.Append(ctx, projectID, ProjectEvent{Type: EventSettingsChanged, Settings: settingsJSON})
+}
+
+// versionsPrefix is where app snapshots are stored, as versions/{n}/ followed
+// by the source/ or compiled/ key.
+const versionsPrefix = "versions/"
+
+// VersionInfo describes a snapshot of a project's source and compiled files.
+type VersionInfo struct {
+	N             int       `json:"n"`
+	CreatedAt     time.Time `json:"created_at"`
+	Trigger       string    `json:"trigger"`
+	Summary       string    `json:"summary,omitempty"`
+	SourceFiles   int       `json:"source_files"`
+	CompiledFiles int       `json:"compiled_files"`
+	// RestoredFrom is the version a rollback restored.
+	RestoredFrom int `json:"restored_from,omitempty"`
+}
+
+// ListVersions returns the project's snapshots, oldest first.
+func (s *Storage) ListVersions(ctx context.Context, projectID string) ([]VersionInfo, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/versions.json")
+	if errors.Is(err, ErrNotFound) {
+		return []VersionInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []VersionInfo
+	if err := json.Unmarshal(content, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// SnapshotVersion copies the project's current source and compiled files to
+// a new version, then deletes the oldest versions beyond keep.
+func (s *Storage) SnapshotVersion(ctx context.Context, projectID string, info VersionInfo, keep int) (*VersionInfo, error) {
+	s.versionsMu.Lock()
+	defer s.versionsMu.Unlock()
+
+	versions, err := s.ListVersions(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	info.N = 1
+	if len(versions) > 0 {
+		info.N = versions[len(versions)-1].N + 1
+	}
+
+	entries, err := s.ListAppKeys(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.client.GetMany(ctx, projectID, entryKeys(entries))
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("%s%d/", versionsPrefix, info.N)
+	for key, value := range values {
+		if err := s.client.Store(ctx, projectID, prefix+key, value.MimeType, value.Content); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(key, "source/") {
+			info.SourceFiles++
+		} else {
+			info.CompiledFiles++
+		}
+	}
+	versions = append(versions, info)
+
+	// Drop the oldest snapshots; a failed delete only leaves orphaned keys
+	if len(versions) > keep {
+		for _, old := range versions[:len(versions)-keep] {
+			oldPrefix := fmt.Sprintf("%s%d/", versionsPrefix, old.N)
+			oldEntries, err := s.client.List(ctx, projectID, oldPrefix)
+			if err != nil {
+				continue
+			}
+			for _, entry := range oldEntries {
+				_ = s.client.Delete(ctx, projectID, entry.Key)
+			}
+		}
+		versions = versions[len(versions)-keep:]
+	}
+
+	versionsJSON, err := json.Marshal(versions)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.client.Store(ctx, projectID, "_meta/versions.json", "application/json", versionsJSON); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// VersionAt returns the version that was current at t: the newest one
+// snapshotted at or before it.
+func (s *Storage) VersionAt(ctx context.Context, projectID string, t time.Time) (*VersionInfo, error) {
+	versions, err := s.ListVersions(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].CreatedAt.After(t) {
+			return &versions[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetVersionFile retrieves a compiled file as it was in version n.
+func (s *Storage) GetVersionFile(ctx context.Context, projectID string, n int, path string) ([]byte, string, error) {
+	return s.client.Get(ctx, projectID, fmt.Sprintf("%s%d/compiled/%s", versionsPrefix, n, path))
+}
+
+// GetVersionFileStream opens a compiled file as it was in version n.
+func (s *Storage) GetVersionFileStream(ctx context.Context, projectID string, n int, path string) (*StoredStream, error) {
+	return s.client.GetStream(ctx, projectID, fmt.Sprintf("%s%d/compiled/%s", versionsPrefix, n, path))
+}
+
+// RestoreVersion replaces the project's source and compiled files with
+// version n's and rebuilds the manifest. It returns the restored source
+// files and the source paths that were removed.
+func (s *Storage) RestoreVersion(ctx context.Context, projectID string, n int) (map[string]string, []string, error) {
+	prefix := fmt.Sprintf("%s%d/", versionsPrefix, n)
+	entries, err := s.client.List(ctx, projectID, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	values, err := s.client.GetMany(ctx, projectID, entryKeys(entries))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Write compiled assets before index.html, as PromoteStaging does, so
+	// viewers switch versions in one step
+	restored := make(map[string]bool, len(values))
+	source := make(map[string]string)
+	for key, value := range values {
+		key = strings.TrimPrefix(key, prefix)
+		restored[key] = true
+		if path, ok := strings.CutPrefix(key, "source/"); ok {
+			source[path] = string(value.Content)
+		}
+		if key == "compiled/index.html" {
+			continue
+		}
+		if err := s.client.Store(ctx, projectID, key, value.MimeType, value.Content); err != nil {
+			return nil, nil, err
+		}
+	}
+	if index, ok := values[prefix+"compiled/index.html"]; ok {
+		if err := s.client.Store(ctx, projectID, "compiled/index.html", index.MimeType, index.Content); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	current, err := s.ListAppKeys(ctx, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var removed []string
+	for _, entry := range current {
+		if restored[entry.Key] {
+			continue
+		}
+		if err := s.client.Delete(ctx, projectID, entry.Key); err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, nil, err
+		}
+		if path, ok := strings.CutPrefix(entry.Key, "source/"); ok {
+			removed = append(removed, path)
+		}
+	}
+
+	if err := s.RelistManifest(ctx, projectID); err != nil {
+		return nil, nil, err
+	}
+	return source, removed, nil
+}
+
+// historyPrefix is where previous contents of source files are kept, as
+// history/{path}/{n}, indexed in _meta/history.json.
+const historyPrefix = "history/"
+
+// FileRevision describes a previous content of a source file.
+type FileRevision struct {
+	N int `json:"n"`
+	// ReplacedAt is when this content was overwritten.
+	ReplacedAt time.Time `json:"replaced_at"`
+	Size       int       `json:"size"`
+	Hash       string    `json:"hash"`
+}
+
+// historyKey returns the key of revision n of a source file.
+func historyKey(path string, n int) string {
+	return fmt.Sprintf("%s%s/%d", historyPrefix, path, n)
+}
+
+// getHistoryIndex retrieves the revisions of every source file, oldest
+// first.
+func (s *Storage) getHistoryIndex(ctx context.Context, projectID string) (map[string][]FileRevision, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/history.json")
+	if errors.Is(err, ErrNotFound) {
+		return map[string][]FileRevision{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var index map[string][]FileRevision
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// saveHistory copies the current content of the source files about to be
+// overwritten with files into their history, when it differs, and drops
+// revisions beyond fileHistory. Failures are only logged, so history never
+// fails the write it precedes.
+func (s *Storage) saveHistory(ctx context.Context, projectID string, files map[string]string) {
+	if s.fileHistory == 0 || len(files) == 0 {
+		return
+	}
+	if err := s.updateHistory(ctx, projectID, files); err != nil {
+		log.Printf("Error saving file history for project %s: %v", projectID, err)
+	}
+}
+
+func (s *Storage) updateHistory(ctx context.Context, projectID string, files map[string]string) error {
+	keys := make([]string, 0, len(files))
+	for path := range files {
+		keys = append(keys, "source/"+path)
+	}
+	current, err := s.client.GetMany(ctx, projectID, keys)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(keys, func(key string) bool {
+		value, ok := current[key]
+		return ok && string(value.Content) != files[strings.TrimPrefix(key, "source/")]
+	}) {
+		return nil
+	}
+
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	index, err := s.getHistoryIndex(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, key := range slices.Sorted(maps.Keys(current)) {
+		path := strings.TrimPrefix(key, "source/")
+		value := current[key]
+		if string(value.Content) == files[path] {
+			continue
+		}
+		revisions := index[path]
+		n := 1
+		if len(revisions) > 0 {
+			n = revisions[len(revisions)-1].N + 1
+		}
+		if err := s.client.Store(ctx, projectID, historyKey(path, n), value.MimeType, value.Content); err != nil {
+			return err
+		}
+		revisions = append(revisions, FileRevision{N: n, ReplacedAt: now, Size: len(value.Content), Hash: contentETag(value.Content)})
+
+		// A failed delete only leaves an orphaned key
+		if len(revisions) > s.fileHistory {
+			for _, old := range revisions[:len(revisions)-s.fileHistory] {
+				_ = s.client.Delete(ctx, projectID, historyKey(path, old.N))
+			}
+			revisions = revisions[len(revisions)-s.fileHistory:]
+		}
+		index[path] = revisions
+	}
+
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/history.json", "application/json", indexJSON)
+}
+
+// ListFileHistory returns the stored revisions of a source file, oldest
+// first.
+func (s *Storage) ListFileHistory(ctx context.Context, projectID, path string) ([]FileRevision, error) {
+	index, err := s.getHistoryIndex(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if revisions := index[path]; revisions != nil {
+		return revisions, nil
+	}
+	return []FileRevision{}, nil
+}
+
+// GetFileRevision retrieves revision n of a source file.
+func (s *Storage) GetFileRevision(ctx context.Context, projectID, path string, n int) (string, error) {
+	content, _, err := s.client.Get(ctx, projectID, historyKey(path, n))
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// GetLicenseReport retrieves the dependency license report of the project's
+// latest build.
+func (s *Storage) GetLicenseReport(ctx context.Context, projectID string) (*LicenseReport, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/licenses.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var report LicenseReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// StoreLicenseReport saves the dependency license report of a build.
+func (s *Storage) StoreLicenseReport(ctx context.Context, projectID string, report *LicenseReport) error {
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/licenses.json", "application/json", reportJSON)
+}
+
+// GetLighthouseRuns retrieves the project's Lighthouse audits, oldest first.
+func (s *Storage) GetLighthouseRuns(ctx context.Context, projectID string) ([]LighthouseRun, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/lighthouse.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []LighthouseRun
+	if err := json.Unmarshal(content, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// AddLighthouseRun appends an audit to the project's history, keeping the
+// last maxRuns.
+func (s *Storage) AddLighthouseRun(ctx context.Context, projectID string, run LighthouseRun, maxRuns int) error {
+	s.lighthouseMu.Lock()
+	defer s.lighthouseMu.Unlock()
+
+	runs, err := s.GetLighthouseRuns(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	runs = append(runs, run)
+	if len(runs) > maxRuns {
+		runs = runs[len(runs)-maxRuns:]
+	}
+	runsJSON, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/lighthouse.json", "application/json", runsJSON)
+}
+
+// FileComment is an annotation on a range of lines of a source file.
+type FileComment struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	// Context asks for the comment to be given to the agent on the next
+	// chat or edit turn; IncludedAt is when that happened.
+	Context    bool       `json:"context"`
+	IncludedAt *time.Time `json:"included_at,omitempty"`
+}
+
+// GetComments retrieves the project's file comments, oldest first.
+func (s *Storage) GetComments(ctx context.Context, projectID string) ([]FileComment, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/comments.json")
+	if errors.Is(err, ErrNotFound) {
+		return []FileComment{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []FileComment
+	if err := json.Unmarshal(content, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// UpdateComments replaces the project's file comments with what update
+// returns for the current ones.
+func (s *Storage) UpdateComments(ctx context.Context, projectID string, update func([]FileComment) ([]FileComment, error)) error {
+	s.commentsMu.Lock()
+	defer s.commentsMu.Unlock()
+
+	comments, err := s.GetComments(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	if comments, err = update(comments); err != nil {
+		return err
+	}
+	commentsJSON, err := json.Marshal(comments)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/comments.json", "application/json", commentsJSON)
+}
+
+// Task is a change requested in a prompt, or left for later by the agent,
+// tracked across chat sessions.
+type Task struct {
+	ID     string `json:"id"`
+	Text   string `json:"text"`
+	Source string `json:"source"`
+	Status string `json:"status"`
+	// Trigger is the kind of turn the task was extracted from.
+	Trigger   string     `json:"trigger"`
+	CreatedAt time.Time  `json:"created_at"`
+	DoneAt    *time.Time `json:"done_at,omitempty"`
+}
+
+// GetTasks retrieves the project's tasks, oldest first.
+func (s *Storage) GetTasks(ctx context.Context, projectID string) ([]Task, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/tasks.json")
+	if errors.Is(err, ErrNotFound) {
+		return []Task{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []Task
+	if err := json.Unmarshal(content, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// UpdateTasks replaces the project's tasks with what update returns for
+// the current ones.
+func (s *Storage) UpdateTasks(ctx context.Context, projectID string, update func([]Task) ([]Task, error)) error {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+
+	tasks, err := s.GetTasks(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	if tasks, err = update(tasks); err != nil {
+		return err
+	}
+	tasksJSON, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/tasks.json", "application/json", tasksJSON)
+}
+
+// GetOperations retrieves the project's recent operations, oldest first.
+func (s *Storage) GetOperations(ctx context.Context, projectID string) ([]Operation, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/operations.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var operations []Operation
+	if err := json.Unmarshal(content, &operations); err != nil {
+		return nil, err
+	}
+	return operations, nil
+}
+
+// SaveOperation adds or updates an operation in the project's list,
+// keeping the last maxOps.
+func (s *Storage) SaveOperation(ctx context.Context, projectID string, op Operation, maxOps int) error {
+	s.operationsMu.Lock()
+	defer s.operationsMu.Unlock()
+
+	operations, err := s.GetOperations(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	i := slices.IndexFunc(operations, func(existing Operation) bool { return existing.ID == op.ID })
+	if i >= 0 {
+		operations[i] = op
+	} else {
+		operations = append(operations, op)
+	}
+	var dropped []Operation
+	if len(operations) > maxOps {
+		dropped = operations[:len(operations)-maxOps]
+		operations = operations[len(operations)-maxOps:]
+	}
+	operationsJSON, err := json.Marshal(operations)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Store(ctx, projectID, "_meta/operations.json", "application/json", operationsJSON); err != nil {
+		return err
+	}
+	for _, old := range dropped {
+		if old.Status == 0 {
+			continue
+		}
+		if err := s.client.Delete(ctx, projectID, operationResultKey(old.ID)); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to delete result of operation %s: %w", old.ID, err)
+		}
+	}
+	return nil
+}
+
+func operationResultKey(id string) string {
+	return "_meta/operations/" + id
+}
+
+// GetOperationResult retrieves the response an async operation finished
+// with.
+func (s *Storage) GetOperationResult(ctx context.Context, projectID, id string) ([]byte, string, error) {
+	return s.client.Get(ctx, projectID, operationResultKey(id))
+}
+
+// StoreOperationResult saves the response an async operation finished
+// with, kept until the operation is dropped from the list.
+func (s *Storage) StoreOperationResult(ctx context.Context, projectID, id, mimeType string, body []byte) error {
+	return s.client.Store(ctx, projectID, operationResultKey(id), mimeType, body)
+}
+
+// GetPrompt retrieves the prompt the project's app was created from.
+func (s *Storage) GetPrompt(ctx context.Context, projectID string) (string, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/prompt.txt")
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// StorePrompt saves the prompt the project's app was created from.
+func (s *Storage) StorePrompt(ctx context.Context, projectID, prompt string) error {
+	return s.client.Store(ctx, projectID, "_meta/prompt.txt", "text/plain", []byte(prompt))
+}
+
+// GetOrgDefaults retrieves the settings every project inherits.
+func (s *Storage) GetOrgDefaults(ctx context.Context) (*OrgDefaults, error) {
+	content, _, err := s.client.Get(ctx, systemProject, "settings.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var org OrgDefaults
+	if err := json.Unmarshal(content, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// StoreOrgDefaults saves the settings every project inherits.
+func (s *Storage) StoreOrgDefaults(ctx context.Context, org *OrgDefaults) error {
+	orgJSON, err := json.Marshal(org)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, systemProject, "settings.json", "application/json", orgJSON)
+}
+
+// GetSRI retrieves the project's subresource integrity settings.
+func (s *Storage) GetSRI(ctx context.Context, projectID string) (*SRISettings, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/sri.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var sri SRISettings
+	if err := json.Unmarshal(content, &sri); err != nil {
+		return nil, err
+	}
+	return &sri, nil
+}
+
+// StoreSRI saves the project's subresource integrity settings.
+func (s *Storage) StoreSRI(ctx context.Context, projectID string, sri *SRISettings) error {
+	sriJSON, err := json.Marshal(sri)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/sri.json", "application/json", sriJSON)
+}
+
+// capturesPrefix is where failed agent requests are kept in the system
+// namespace, keyed by capture ID.
+const capturesPrefix = "captures/"
+
+// StoreCapture saves a failed agent request.
+func (s *Storage) StoreCapture(ctx context.Context, capture *RequestCapture) error {
+	captureJSON, err := json.Marshal(capture)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, systemProject, capturesPrefix+capture.ID, "application/json", captureJSON)
+}
+
+// GetCapture retrieves a failed agent request.
+func (s *Storage) GetCapture(ctx context.Context, id string) (*RequestCapture, error) {
+	content, _, err := s.client.Get(ctx, systemProject, capturesPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	var capture RequestCapture
+	if err := json.Unmarshal(content, &capture); err != nil {
+		return nil, err
+	}
+	return &capture, nil
+}
+
+// ListCaptures returns the IDs of stored captures.
+func (s *Storage) ListCaptures(ctx context.Context) ([]string, error) {
+	entries, err := s.client.List(ctx, systemProject, capturesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, strings.TrimPrefix(entry.Key, capturesPrefix))
+	}
+	return ids, nil
+}
+
+// auditPrefix is where a project's audit entries are stored, keyed by a
+// zero-padded timestamp so keys sort chronologically.
+const auditPrefix = "_meta/audit/"
+
+// StoreAuditEntry appends an entry to the project's audit log.
+func (s *Storage) StoreAuditEntry(ctx context.Context, projectID string, entry *AuditEntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%020d.json", auditPrefix, entry.Time.UnixNano())
+	return s.client.Store(ctx, projectID, key, "application/json", entryJSON)
+}
+
+// ListAuditEntries retrieves up to limit of the project's newest audit entries.
+func (s *Storage) ListAuditEntries(ctx context.Context, projectID string, limit int) ([]AuditEntry, error) {
+	keys, err := s.client.List(ctx, projectID, auditPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys = keys[max(len(keys)-limit, 0):]
+	values, err := s.client.GetMany(ctx, projectID, entryKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		value, ok := values[keys[i].Key]
+		if !ok {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(value.Content, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// buildsPrefix is where a project's build records are stored, keyed by ID
+// so they list oldest first.
+const buildsPrefix = "_builds/"
+
+// GetBuildStatus retrieves the state of the project's latest queued build.
+func (s *Storage) GetBuildStatus(ctx context.Context, projectID string) (*BuildStatus, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_meta/build.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var status BuildStatus
+	if err := json.Unmarshal(content, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StoreBuildStatus saves the state of the project's latest queued build.
+func (s *Storage) StoreBuildStatus(ctx context.Context, projectID string, status *BuildStatus) error {
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, "_meta/build.json", "application/json", statusJSON)
+}
+
+// StoreBuildRecord stores a record of one build.
+func (s *Storage) StoreBuildRecord(ctx context.Context, projectID string, record *BuildRecord) error {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, buildsPrefix+record.ID, "application/json", recordJSON)
+}
+
+// GetBuildRecord retrieves one build record.
+func (s *Storage) GetBuildRecord(ctx context.Context, projectID, id string) (*BuildRecord, error) {
+	content, _, err := s.client.Get(ctx, projectID, buildsPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	var record BuildRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ListBuildRecords retrieves up to limit of the project's newest build records.
+func (s *Storage) ListBuildRecords(ctx context.Context, projectID string, limit int) ([]BuildRecord, error) {
+	keys, err := s.client.List(ctx, projectID, buildsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys = keys[max(len(keys)-limit, 0):]
+	values, err := s.client.GetMany(ctx, projectID, entryKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]BuildRecord, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		value, ok := values[keys[i].Key]
+		if !ok {
+			continue
+		}
+		var record BuildRecord
+		if err := json.Unmarshal(value.Content, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// PruneBuildRecords deletes the project's build records started before
+// cutoff.
+func (s *Storage) PruneBuildRecords(ctx context.Context, projectID string, cutoff time.Time) error {
+	keys, err := s.client.List(ctx, projectID, buildsPrefix)
+	if err != nil {
+		return err
+	}
+
+	// IDs are zero-padded start times, so they sort chronologically
+	oldest := buildsPrefix + fmt.Sprintf("%020d", cutoff.UnixNano())
+	for _, key := range keys {
+		if key.Key >= oldest {
+			break
+		}
+		if err := s.client.Delete(ctx, projectID, key.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// viewStatsPrefix is where a project's daily access rollups are stored.
+const viewStatsPrefix = "_meta/stats/views/"
+
+// GetDailyViews retrieves one day's access rollup for a project.
+func (s *Storage) GetDailyViews(ctx context.Context, projectID, date string) (*DailyViews, error) {
+	content, _, err := s.client.Get(ctx, projectID, viewStatsPrefix+date+".json")
+	if err != nil {
+		return nil, err
+	}
+
+	var day DailyViews
+	if err := json.Unmarshal(content, &day); err != nil {
+		return nil, err
+	}
+	if day.Referrers == nil {
+		day.Referrers = make(map[string]int)
+	}
+	return &day, nil
+}
+
+// StoreDailyViews saves one day's access rollup for a project.
+func (s *Storage) StoreDailyViews(ctx context.Context, projectID string, day *DailyViews) error {
+	dayJSON, err := json.Marshal(day)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, viewStatsPrefix+day.Date+".json", "application/json", dayJSON)
+}
+
+// ListDailyViews retrieves all stored access rollups for a project.
+func (s *Storage) ListDailyViews(ctx context.Context, projectID string) ([]*DailyViews, error) {
+	keys, err := s.client.List(ctx, projectID, viewStatsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.client.GetMany(ctx, projectID, entryKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	days := make([]*DailyViews, 0, len(keys))
+	for _, k := range keys {
+		value, ok := values[k.Key]
+		if !ok {
+			continue
+		}
+		var day DailyViews
+		if err := json.Unmarshal(value.Content, &day); err != nil {
+			return nil, err
+		}
+		if day.Referrers == nil {
+			day.Referrers = make(map[string]int)
+		}
+		days = append(days, &day)
+	}
+	return days, nil
+}
+
+// entryKeys returns the keys of a list response.
+func entryKeys(entries []KeyInfo) []string {
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	return keys
+}
+
+// GetSecret retrieves an encrypted project secret.
+func (s *Storage) GetSecret(ctx context.Context, projectID, name string) ([]byte, error) {
+	content, _, err := s.client.Get(ctx, projectID, "_secrets/"+name)
+	return content, err
+}
+
+// StoreSecret saves an encrypted project secret.
+func (s *Storage) StoreSecret(ctx context.Context, projectID, name string, sealed []byte) error {
+	return s.client.Store(ctx, projectID, "_secrets/"+name, "application/octet-stream", sealed)
+}
+
+// DeleteSecret removes a project secret.
+func (s *Storage) DeleteSecret(ctx context.Context, projectID, name string) error {
+	return s.client.Delete(ctx, projectID, "_secrets/"+name)
+}
+
+// binaryExtensions are file types sent to and returned by Node Build base64
+// encoded, since files travel as JSON strings. Keep in sync with
+// BINARY_EXTENSIONS in node-build.
+var binaryExtensions = map[string]bool{
+	".wasm": true, ".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
+	".webp": true, ".avif": true, ".ico": true, ".woff": true, ".woff2": true,
+	".gz": true,
+}
+
+// isBinaryPath reports whether a file is transported base64 encoded.
+func isBinaryPath(path string) bool {
+	return binaryExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// storeCompiledFile stores one compiled file under prefix, decoding
+// binary files back to raw bytes.
+func (s *Storage) storeCompiledFile(ctx context.Context, projectID, prefix, path, content string) (string, error) {
+	data := []byte(content)
+	if isBinaryPath(path) {
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 content for %s: %w", path, err)
+		}
+		data = decoded
+	}
+	if err := s.client.Store(ctx, projectID, prefix+path, getMimeType(path), data); err != nil {
+		return "", err
+	}
+	return contentETag(data), nil
+}
+
+// contentETag returns the hex MD5 of content, as rust-db reports it.
+func contentETag(content []byte) string {
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyHashes returns the ETags of the keys under each prefix.
+func (s *Storage) keyHashes(ctx context.Context, projectID string, prefixes ...string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, prefix := range prefixes {
+		entries, err := s.client.ListDetailed(ctx, projectID, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			hashes[entry.Key] = entry.ETag
+		}
+	}
+	return hashes, nil
+}
+
+// formsPrefix is where form submissions are kept, as forms/{name}/{id}.json
+// with IDs that sort by submission time.
+const formsPrefix = "forms/"
+
+// FormSubmission is one submission to a form of a generated app.
+type FormSubmission struct {
+	ID          string         `json:"id"`
+	Form        string         `json:"form"`
+	Fields      map[string]any `json:"fields"`
+	SubmittedAt time.Time      `json:"submitted_at"`
+}
+
+// formSubmissionKey is where a form submission is stored.
+func formSubmissionKey(form, id string) string {
+	return formsPrefix + form + "/" + id + ".json"
+}
+
+// CountFormSubmissions returns how many submissions a form has.
+func (s *Storage) CountFormSubmissions(ctx context.Context, projectID, form string) (int, error) {
+	keys, err := s.client.List(ctx, projectID, formsPrefix+form+"/")
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// StoreFormSubmission saves a form submission.
+func (s *Storage) StoreFormSubmission(ctx context.Context, projectID string, sub *FormSubmission) error {
+	subJSON, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return s.client.Store(ctx, projectID, formSubmissionKey(sub.Form, sub.ID), "application/json", subJSON)
+}
+
+// ListFormSubmissions returns up to limit of a form's submissions, newest
+// first.
+func (s *Storage) ListFormSubmissions(ctx context.Context, projectID, form string, limit int) ([]FormSubmission, error) {
+	infos, err := s.client.List(ctx, projectID, formsPrefix+form+"/")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	slices.Reverse(keys)
+	keys = keys[:min(limit, len(keys))]
+
+	values, err := s.fetchMany(ctx, projectID, keys)
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]FormSubmission, 0, len(keys))
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		var sub FormSubmission
+		if err := json.Unmarshal(value.Content, &sub); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// DeleteFormSubmission removes a form submission.
+func (s *Storage) DeleteFormSubmission(ctx context.Context, projectID, form, id string) error {
+	return s.client.Delete(ctx, projectID, formSubmissionKey(form, id))
+}
+
+// appDataPrefix is where the key-value data generated apps keep through
+// the data API is stored, as data/{key}.
+const appDataPrefix = "data/"
+
+// ListAppData returns the app data keys starting with prefix, with their
+// sizes and ETags, relative to appDataPrefix.
+func (s *Storage) ListAppData(ctx context.Context, projectID, prefix string) ([]KeyInfo, error) {
+	entries, err := s.client.ListDetailed(ctx, projectID, appDataPrefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Key = strings.TrimPrefix(entries[i].Key, appDataPrefix)
+	}
+	return entries, nil
+}
+
+// GetAppData retrieves an app data value, or ErrNotFound.
+func (s *Storage) GetAppData(ctx context.Context, projectID, key string) ([]byte, error) {
+	content, _, err := s.client.Get(ctx, projectID, appDataPrefix+key)
+	return content, err
+}
+
+// StoreAppData saves an app data value if its current ETag is etag, with
+// StoreIf semantics.
+func (s *Storage) StoreAppData(ctx context.Context, projectID, key string, value []byte, etag string) error {
+	return s.client.StoreIf(ctx, projectID, appDataPrefix+key, "application/json", value, etag)
+}
+
+// DeleteAppData removes an app data value if its current ETag is etag, with
+// DeleteIf semantics.
+func (s *Storage) DeleteAppData(ctx context.Context, projectID, key, etag string) error {
+	return s.client.DeleteIf(ctx, projectID, appDataPrefix+key, etag)
+}
+
+// appDataUsageKey holds the total size of a project's app data, so quota
+// checks don't have to list the values.
+const appDataUsageKey = "_meta/data_usage.json"
+
+// maxAppDataRetries bounds the attempts of an app data update racing
+// other writers of the project's data.
+const maxAppDataRetries = 10
+
+// AppDataUsage is the total size of a project's app data values.
+type AppDataUsage struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// AddAppDataUsage adds delta bytes to the project's app data total,
+// counting it from the stored values the first time. An increase that
+// would take the total over limit (if positive) is refused and reported
+// false. The total is updated conditionally, retrying if another writer
+// changed it, so concurrent writes can't both fit under the limit.
+func (s *Storage) AddAppDataUsage(ctx context.Context, projectID string, delta, limit int64) (bool, error) {
+	for range maxAppDataRetries {
+		var usage AppDataUsage
+		etag := "*"
+		content, _, err := s.client.Get(ctx, projectID, appDataUsageKey)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			entries, err := s.ListAppData(ctx, projectID, "")
+			if err != nil {
+				return false, err
+			}
+			for _, entry := range entries {
+				usage.Bytes += entry.Size
+			}
+		case err != nil:
+			return false, err
+		default:
+			if err := json.Unmarshal(content, &usage); err != nil {
+				return false, err
+			}
+			etag = contentETag(content)
+		}
+
+		usage.Bytes += delta
+		if delta > 0 && limit > 0 && usage.Bytes > limit {
+			return false, nil
+		}
+		usage.Bytes = max(usage.Bytes, 0)
+		usageJSON, err := json.Marshal(usage)
+		if err != nil {
+			return false, err
+		}
+		err = s.client.StoreIf(ctx, projectID, appDataUsageKey, "application/json", usageJSON, etag)
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return err == nil, err
+		}
+	}
+	return false, fmt.Errorf("app data usage of project %s kept changing", projectID)
 }
 
 // getMimeType returns the MIME type for a file path.
@@ -267,8 +2912,13 @@ func getMimeType(path string) string {
 		return "text/html"
 	case ".css":
 		return "text/css"
-	case ".js":
+	case ".js", ".mjs":
 		return "application/javascript"
+	case ".wasm":
+		// Required for WebAssembly.instantiateStreaming
+		return "application/wasm"
+	case ".webmanifest":
+		return "application/manifest+json"
 	case ".ts", ".tsx":
 		return "text/typescript"
 	case ".json":
@@ -283,10 +2933,18 @@ func getMimeType(path string) string {
 		return "image/jpeg"
 	case ".gif":
 		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	case ".avif":
+		return "image/avif"
+	case ".ico":
+		return "image/x-icon"
 	case ".woff":
 		return "font/woff"
 	case ".woff2":
 		return "font/woff2"
+	case ".gz":
+		return "application/gzip"
 	default:
 		return "application/octet-stream"
 	}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Kinds of tracked connections and background work.
+const (
+	StreamChat   = "chat"
+	StreamViewer = "viewer"
+	StreamBuild  = "build"
+)
+
+// StreamInfo describes an active chat stream, viewer request or build.
+type StreamInfo struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	ProjectID string    `json:"project_id"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// trackedStream is a registry entry with the means to stop it.
+type trackedStream struct {
+	info   StreamInfo
+	cancel context.CancelFunc
+}
+
+// StreamRegistry tracks active streams and in-flight builds so operators can
+// see what is running and terminate anything stuck.
+type StreamRegistry struct {
+	mu      sync.Mutex
+	streams map[string]*trackedStream
+}
+
+// NewStreamRegistry creates an empty StreamRegistry.
+func NewStreamRegistry() *StreamRegistry {
+	return &StreamRegistry{streams: make(map[string]*trackedStream)}
+}
+
+// Track registers work of the given kind. The returned context is cancelled
+// if the entry is terminated; call done when the work finishes.
+func (s *StreamRegistry) Track(ctx context.Context, kind, projectID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream := &trackedStream{
+		info: StreamInfo{
+			ID:        uuid.NewString(),
+			Kind:      kind,
+			ProjectID: projectID,
+			ClientIP:  requestIdentity(ctx).IP,
+			StartedAt: time.Now().UTC(),
+		},
+		cancel: cancel,
+	}
+
+	s.mu.Lock()
+	s.streams[stream.info.ID] = stream
+	s.mu.Unlock()
+
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.streams, stream.info.ID)
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// List returns the active entries, oldest first.
+func (s *StreamRegistry) List() []StreamInfo {
+	s.mu.Lock()
+	infos := make([]StreamInfo, 0, len(s.streams))
+	for _, stream := range s.streams {
+		infos = append(infos, stream.info)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(infos, func(a, b StreamInfo) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return infos
+}
+
+// Counts returns the number of active entries of each kind.
+func (s *StreamRegistry) Counts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int{StreamChat: 0, StreamViewer: 0, StreamBuild: 0}
+	for _, stream := range s.streams {
+		counts[stream.info.Kind]++
+	}
+	return counts
+}
+
+// Terminate cancels an entry's context, reporting whether it was found.
+// The entry is removed once its work notices and returns.
+func (s *StreamRegistry) Terminate(id string) bool {
+	s.mu.Lock()
+	stream, ok := s.streams[id]
+	s.mu.Unlock()
+	if ok {
+		stream.cancel()
+	}
+	return ok
+}
+
+// StreamsResponse is the response for GET /admin/streams.
+type StreamsResponse struct {
+	Streams []StreamInfo   `json:"streams"`
+	Counts  map[string]int `json:"counts"`
+}
+
+// HandleListStreams returns active chat streams, viewers and builds.
+func (h *Handlers) HandleListStreams(w http.ResponseWriter, r *http.Request) {
+	streams := h.streams.List()
+	counts := map[string]int{StreamChat: 0, StreamViewer: 0, StreamBuild: 0}
+	for _, stream := range streams {
+		counts[stream.Kind]++
+	}
+	writeJSON(w, http.StatusOK, StreamsResponse{Streams: streams, Counts: counts})
+}
+
+// HandleTerminateStream forcibly stops a stream or build.
+func (h *Handlers) HandleTerminateStream(w http.ResponseWriter, r *http.Request) {
+	if !h.streams.Terminate(chi.URLParam(r, "streamID")) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Stream not found"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
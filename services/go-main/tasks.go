@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Task statuses.
+const (
+	TaskOpen = "open"
+	TaskDone = "done"
+)
+
+// Task sources: what a task was extracted from.
+const (
+	TaskFromUser  = "user"
+	TaskFromAgent = "agent"
+)
+
+// maxTasks bounds a project's task list; the oldest done tasks, then the
+// oldest open ones, are dropped beyond it.
+const maxTasks = 200
+
+// maxTaskLength caps the text of an extracted task, in runes.
+const maxTaskLength = 200
+
+// listItemPattern matches a bulleted or numbered list item.
+var listItemPattern = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s+(.+)$`)
+
+// followUpHeadingPattern matches a heading introducing work the agent left
+// for later, e.g. "Next steps:" or "## Possible improvements".
+var followUpHeadingPattern = regexp.MustCompile(`(?i)^[#*\s]*(next steps|todo|to do|remaining( work)?|not (yet )?(done|implemented)|follow[- ]?ups?|(possible|future) (improvements|enhancements))\b[^a-z]*$`)
+
+// todoPattern matches an inline "TODO: ..." line.
+var todoPattern = regexp.MustCompile(`(?i)^\s*(?:[-*]\s*)?TODO:?\s+(.+)$`)
+
+// extractRequests splits a user prompt into the changes it asks for: one
+// per list item, or the whole prompt when it isn't a list.
+func extractRequests(prompt string) []string {
+	var items []string
+	for line := range strings.Lines(prompt) {
+		line = strings.TrimRight(line, "\r\n")
+		if m := listItemPattern.FindStringSubmatch(line); m != nil {
+			items = append(items, taskText(m[1]))
+		}
+	}
+	if len(items) == 0 {
+		if text := taskText(prompt); text != "" {
+			items = append(items, text)
+		}
+	}
+	return items
+}
+
+// extractFollowUps finds work an agent summary says is left: list items
+// under a "Next steps"-style heading and TODO lines.
+func extractFollowUps(summary string) []string {
+	var items []string
+	inSection := false
+	for line := range strings.Lines(summary) {
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case followUpHeadingPattern.MatchString(strings.TrimSpace(line)):
+			inSection = true
+		case todoPattern.MatchString(line):
+			items = append(items, taskText(todoPattern.FindStringSubmatch(line)[1]))
+		case inSection && listItemPattern.MatchString(line):
+			items = append(items, taskText(listItemPattern.FindStringSubmatch(line)[1]))
+		case strings.TrimSpace(line) == "":
+			// Blank lines may separate a heading from its list
+		default:
+			inSection = false
+		}
+	}
+	return items
+}
+
+// taskText collapses whitespace in s and truncates it to maxTaskLength.
+func taskText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > maxTaskLength {
+		s = string([]rune(s)[:maxTaskLength-1]) + "…"
+	}
+	return s
+}
+
+// lastUserText returns the text of the last user message of an AI SDK chat
+// request.
+func lastUserText(messages []json.RawMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		var message struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"parts"`
+		}
+		if json.Unmarshal(messages[i], &message) != nil || message.Role != "user" {
+			continue
+		}
+		var texts []string
+		for _, part := range message.Parts {
+			if part.Type == "text" {
+				texts = append(texts, part.Text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// trackTasks records a turn in the project's task list: the changes the
+// prompt asked for, done if the turn succeeded and open otherwise, and the
+// follow-ups the agent's summary mentions. Requests matching an open task
+// close or keep it rather than adding a duplicate. Failures are only
+// logged.
+func (h *Handlers) trackTasks(ctx context.Context, projectID, trigger, prompt, summary string, succeeded bool) {
+	requests, followUps := extractRequests(prompt), extractFollowUps(summary)
+	if len(requests) == 0 && len(followUps) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	err := h.storage.UpdateTasks(context.WithoutCancel(ctx), projectID, func(tasks []Task) ([]Task, error) {
+		add := func(text, source, status string) {
+			i := slices.IndexFunc(tasks, func(t Task) bool { return t.Status == TaskOpen && strings.EqualFold(t.Text, text) })
+			if i < 0 {
+				tasks = append(tasks, Task{ID: uuid.NewString(), Text: text, Source: source, Status: TaskOpen, Trigger: trigger, CreatedAt: now})
+				i = len(tasks) - 1
+			}
+			if status == TaskDone {
+				tasks[i].Status, tasks[i].DoneAt = TaskDone, &now
+			}
+		}
+		status := TaskOpen
+		if succeeded {
+			status = TaskDone
+		}
+		for _, text := range requests {
+			add(text, TaskFromUser, status)
+		}
+		for _, text := range followUps {
+			add(text, TaskFromAgent, TaskOpen)
+		}
+		return pruneTasks(tasks), nil
+	})
+	if err != nil {
+		log.Printf("Error updating tasks for project %s: %v", projectID, err)
+	}
+}
+
+// pruneTasks drops the oldest tasks beyond maxTasks, done ones first.
+func pruneTasks(tasks []Task) []Task {
+	for _, status := range []string{TaskDone, TaskOpen} {
+		for len(tasks) > maxTasks {
+			i := slices.IndexFunc(tasks, func(t Task) bool { return t.Status == status })
+			if i < 0 {
+				break
+			}
+			tasks = slices.Delete(tasks, i, i+1)
+		}
+	}
+	return tasks
+}
+
+// TasksResponse is the response for listing tasks.
+type TasksResponse struct {
+	Tasks []Task `json:"tasks"`
+}
+
+// UpdateTaskRequest is the request body for changing a task's status.
+type UpdateTaskRequest struct {
+	Status string `json:"status"`
+}
+
+// HandleListTasks returns the project's tasks, oldest first, optionally
+// only those with ?status=.
+func (h *Handlers) HandleListTasks(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != TaskOpen && status != TaskDone {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "status must be open or done"})
+		return
+	}
+
+	tasks, err := h.storage.GetTasks(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to load tasks", err))
+		return
+	}
+	if status != "" {
+		tasks = slices.DeleteFunc(tasks, func(t Task) bool { return t.Status != status })
+	}
+	writeJSON(w, http.StatusOK, TasksResponse{Tasks: tasks})
+}
+
+// HandleUpdateTask marks a task done or reopens it.
+func (h *Handlers) HandleUpdateTask(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	taskID := chi.URLParam(r, "taskID")
+
+	var req UpdateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if req.Status != TaskOpen && req.Status != TaskDone {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "status must be open or done"})
+		return
+	}
+
+	var updated Task
+	err := h.storage.UpdateTasks(r.Context(), projectID, func(tasks []Task) ([]Task, error) {
+		i := slices.IndexFunc(tasks, func(t Task) bool { return t.ID == taskID })
+		if i < 0 {
+			return nil, AppError{Code: http.StatusNotFound, Message: "Task not found"}
+		}
+		tasks[i].Status, tasks[i].DoneAt = req.Status, nil
+		if req.Status == TaskDone {
+			now := time.Now().UTC()
+			tasks[i].DoneAt = &now
+		}
+		updated = tasks[i]
+		return tasks, nil
+	})
+	var appErr AppError
+	if errors.As(err, &appErr) {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to update task", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// HandleDeleteTask removes a task.
+func (h *Handlers) HandleDeleteTask(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	taskID := chi.URLParam(r, "taskID")
+
+	err := h.storage.UpdateTasks(r.Context(), projectID, func(tasks []Task) ([]Task, error) {
+		i := slices.IndexFunc(tasks, func(t Task) bool { return t.ID == taskID })
+		if i < 0 {
+			return nil, AppError{Code: http.StatusNotFound, Message: "Task not found"}
+		}
+		return slices.Delete(tasks, i, i+1), nil
+	})
+	var appErr AppError
+	if errors.As(err, &appErr) {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to delete task", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,10 +1,23 @@
 """Integration tests for the go-main service."""
 
+import hashlib
+import hmac
+import json
+import os
+import time
 import uuid
 
+import pytest
 import requests
 
 BASE_URL = 'http://localhost:3000'
+API_URL = f'{BASE_URL}/api/v1'
+
+# The auth tests, and those on a seeded app, need the service started with
+# AUTH_ENABLED=true and this ADMIN_TOKEN, and are skipped without it. The
+# rest send it when set, so they pass with auth on or off. Hook and git
+# tests are skipped unless the service has a SECRETS_KEY.
+ADMIN_TOKEN = os.getenv('ADMIN_TOKEN', '')
 
 
 def test_root_redirects_to_uuid() -> None:
@@ -133,3 +146,452 @@ def test_view_returns_html_after_create() -> None:
     response = requests.get(f'{BASE_URL}/{project_id}/view', timeout=10)
     assert response.status_code == 200
     assert 'text/html' in response.headers['Content-Type']
+
+
+def auth_headers() -> dict[str, str]:
+    """Headers that pass auth when the service runs with it: the admin token, if set."""
+    if not ADMIN_TOKEN:
+        return {}
+    return {'Authorization': f'Bearer {ADMIN_TOKEN}'}
+
+
+def test_immediate_delete_twice_deletes_nothing_the_second_time() -> None:
+    """Test that an immediate delete leaves nothing behind, not even its audit entry."""
+    project_id = str(uuid.uuid4())
+    response = requests.patch(
+        f'{API_URL}/{project_id}/settings',
+        json={'build_profile': 'development'},
+        headers=auth_headers(),
+        timeout=10,
+    )
+    assert response.status_code == 200
+
+    response = requests.delete(f'{API_URL}/{project_id}?immediate=true', headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    assert response.json()['total'] > 0
+
+    response = requests.delete(f'{API_URL}/{project_id}?immediate=true', headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    assert response.json() == {'deleted': {}, 'total': 0}
+
+
+@pytest.fixture
+def admin_headers() -> dict[str, str]:
+    """Authorization headers for the admin token, skipping without one."""
+    if not ADMIN_TOKEN:
+        pytest.skip('ADMIN_TOKEN is not set')
+    return {'Authorization': f'Bearer {ADMIN_TOKEN}'}
+
+
+def create_key(admin_headers: dict[str, str], scope: str, projects: list[str]) -> dict[str, str]:
+    """Create an API key with the given scope and projects, returning its authorization headers."""
+    response = requests.post(
+        f'{API_URL}/admin/keys',
+        json={'id': f'test-{uuid.uuid4().hex[:12]}', 'scope': scope, 'projects': projects},
+        headers=admin_headers,
+        timeout=10,
+    )
+    assert response.status_code == 201
+    return {'Authorization': f'Bearer {response.json()["key"]}'}
+
+
+@pytest.fixture
+def seeded_project(admin_headers: dict[str, str]) -> str:
+    """A project with a synthetic app of two source files, stored without the agent or a build."""
+    response = requests.post(
+        f'{API_URL}/admin/seed',
+        json={'projects': 1, 'files': 2, 'file_size': 64},
+        headers=admin_headers,
+        timeout=30,
+    )
+    assert response.status_code == 200
+    return response.json()['project_ids'][0]
+
+
+def test_reserved_namespaces_return_404() -> None:
+    """Test that the nil UUID and version 8 UUIDs can't be used as projects."""
+    reserved = uuid.uuid4().hex
+    reserved = reserved[:12] + '8' + reserved[13:]
+    for project_id in (str(uuid.UUID(int=0)), str(uuid.UUID(reserved))):
+        response = requests.get(f'{API_URL}/{project_id}/state', headers=auth_headers(), timeout=10)
+        assert response.status_code == 404
+        response = requests.patch(
+            f'{API_URL}/{project_id}/settings',
+            json={'public': True},
+            headers=auth_headers(),
+            timeout=10,
+        )
+        assert response.status_code == 404
+
+
+def test_reserved_namespaces_return_404_with_admin_token(admin_headers: dict[str, str]) -> None:
+    """Test that not even the admin token reaches a reserved namespace through the project API."""
+    response = requests.get(f'{API_URL}/{uuid.UUID(int=0)}/state', headers=admin_headers, timeout=10)
+    assert response.status_code == 404
+
+
+def test_project_routes_require_a_key(admin_headers: dict[str, str]) -> None:
+    """Test that project routes refuse requests without a key or with an unknown one."""
+    project_id = str(uuid.uuid4())
+    response = requests.get(f'{API_URL}/{project_id}/state', timeout=10)
+    assert response.status_code == 401
+    response = requests.get(
+        f'{API_URL}/{project_id}/state',
+        headers={'Authorization': 'Bearer not-a-key'},
+        timeout=10,
+    )
+    assert response.status_code == 401
+
+
+def test_scoped_key_allows_its_project_only(admin_headers: dict[str, str]) -> None:
+    """Test that a key limited to a project can use it and no other."""
+    project_id = str(uuid.uuid4())
+    other_id = str(uuid.uuid4())
+    headers = create_key(admin_headers, 'write', [project_id])
+
+    response = requests.get(f'{API_URL}/{project_id}/settings', headers=headers, timeout=10)
+    assert response.status_code == 200
+    response = requests.patch(
+        f'{API_URL}/{project_id}/settings',
+        json={'build_profile': 'development'},
+        headers=headers,
+        timeout=10,
+    )
+    assert response.status_code == 200
+
+    response = requests.get(f'{API_URL}/{other_id}/settings', headers=headers, timeout=10)
+    assert response.status_code == 403
+
+
+def test_read_key_cannot_write(admin_headers: dict[str, str]) -> None:
+    """Test that a read key can make GET requests only."""
+    project_id = str(uuid.uuid4())
+    headers = create_key(admin_headers, 'read', [project_id])
+
+    response = requests.get(f'{API_URL}/{project_id}/settings', headers=headers, timeout=10)
+    assert response.status_code == 200
+    response = requests.patch(
+        f'{API_URL}/{project_id}/settings',
+        json={'build_profile': 'development'},
+        headers=headers,
+        timeout=10,
+    )
+    assert response.status_code == 403
+
+
+def test_public_project_view_needs_no_key(admin_headers: dict[str, str]) -> None:
+    """Test that the view of a public project is open while the rest of the project isn't."""
+    project_id = str(uuid.uuid4())
+
+    # Without an app the view is 404 once past auth
+    response = requests.get(f'{API_URL}/{project_id}/view', timeout=10)
+    assert response.status_code == 401
+
+    response = requests.patch(
+        f'{API_URL}/{project_id}/settings',
+        json={'public': True},
+        headers=admin_headers,
+        timeout=10,
+    )
+    assert response.status_code == 200
+
+    response = requests.get(f'{API_URL}/{project_id}/view', timeout=10)
+    assert response.status_code == 404
+    response = requests.get(f'{API_URL}/{project_id}/settings', timeout=10)
+    assert response.status_code == 401
+
+
+def test_git_push_is_authenticated_by_its_signature(admin_headers: dict[str, str]) -> None:
+    """Test that a forge push needs no key but a valid signature."""
+    project_id = str(uuid.uuid4())
+    secret = uuid.uuid4().hex
+    response = requests.put(
+        f'{API_URL}/{project_id}/git',
+        json={
+            'repo_url': 'https://github.com/example/app',
+            'raw_url_template': 'https://raw.githubusercontent.com/example/app/{sha}/{path}',
+            'secret': secret,
+        },
+        headers=admin_headers,
+        timeout=10,
+    )
+    if response.status_code == 503:
+        pytest.skip('SECRETS_KEY is not set')
+    assert response.status_code == 200
+    assert 'secret' not in response.json()
+    assert 'sealed_secret' not in response.json()
+
+    body = json.dumps({'zen': 'Keep it logically awesome.'}).encode()
+    signature = 'sha256=' + hmac.new(secret.encode(), body, hashlib.sha256).hexdigest()
+    response = requests.post(
+        f'{API_URL}/{project_id}/git/push',
+        data=body,
+        headers={'X-Hub-Signature-256': signature, 'X-GitHub-Event': 'ping'},
+        timeout=10,
+    )
+    assert response.status_code == 204
+
+    response = requests.post(
+        f'{API_URL}/{project_id}/git/push',
+        data=body,
+        headers={'X-Hub-Signature-256': 'sha256=' + '0' * 64, 'X-GitHub-Event': 'ping'},
+        timeout=10,
+    )
+    assert response.status_code == 401
+
+
+def test_hook_trigger_is_refused_when_replayed() -> None:
+    """Test that a signed hook trigger is accepted once and refused when sent again."""
+    project_id = str(uuid.uuid4())
+    secret = uuid.uuid4().hex
+    response = requests.put(
+        f'{API_URL}/{project_id}/hooks/deploy',
+        json={'action': 'rebuild', 'secret': secret},
+        headers=auth_headers(),
+        timeout=10,
+    )
+    if response.status_code == 503:
+        pytest.skip('SECRETS_KEY is not set')
+    assert response.status_code == 200
+    assert response.json()['secret'] == secret
+
+    body = b'{}'
+    timestamp = str(int(time.time()))
+    signature = 'sha256=' + hmac.new(secret.encode(), f'{timestamp}.'.encode() + body, hashlib.sha256).hexdigest()
+    headers = {'X-Hook-Timestamp': timestamp, 'X-Hub-Signature-256': signature}
+    response = requests.post(f'{API_URL}/{project_id}/hooks/deploy', data=body, headers=headers, timeout=10)
+    assert response.status_code == 202
+    response = requests.post(f'{API_URL}/{project_id}/hooks/deploy', data=body, headers=headers, timeout=10)
+    assert response.status_code == 401
+
+    response = requests.post(
+        f'{API_URL}/{project_id}/hooks/missing',
+        data=body,
+        headers=headers,
+        timeout=10,
+    )
+    assert response.status_code == 404
+
+
+def test_git_push_syncs_removed_files(seeded_project: str, admin_headers: dict[str, str]) -> None:
+    """Test that a push removing a file syncs it into the project once, and that replaying it is refused."""
+    secret = uuid.uuid4().hex
+    response = requests.put(
+        f'{API_URL}/{seeded_project}/git',
+        json={
+            'repo_url': 'https://github.com/example/app',
+            'raw_url_template': 'https://raw.githubusercontent.com/example/app/{sha}/{path}',
+            'secret': secret,
+        },
+        headers=admin_headers,
+        timeout=10,
+    )
+    if response.status_code == 503:
+        pytest.skip('SECRETS_KEY is not set')
+    assert response.status_code == 200
+
+    def push(payload: dict[str, object]) -> requests.Response:
+        body = json.dumps(payload).encode()
+        signature = 'sha256=' + hmac.new(secret.encode(), body, hashlib.sha256).hexdigest()
+        return requests.post(
+            f'{API_URL}/{seeded_project}/git/push',
+            data=body,
+            headers={'X-Hub-Signature-256': signature, 'X-GitHub-Event': 'push'},
+            timeout=10,
+        )
+
+    # Pushes to other branches are ignored
+    response = push({'ref': 'refs/heads/feature', 'before': '', 'after': 'a' * 40, 'commits': []})
+    assert response.status_code == 204
+
+    removal = {'ref': 'refs/heads/main', 'before': '', 'after': 'b' * 40, 'commits': [{'removed': ['lib/file1.ts']}]}
+    response = push(removal)
+    assert response.status_code == 202
+
+    # The sync runs in the background and is recorded as a version
+    deadline = time.time() + 60
+    synced = []
+    while not synced and time.time() < deadline:
+        time.sleep(0.5)
+        response = requests.get(f'{API_URL}/{seeded_project}/versions', headers=admin_headers, timeout=10)
+        assert response.status_code == 200
+        synced = [v for v in response.json()['versions'] if v['trigger'] == 'git']
+    assert synced, 'the push was not synced'
+    assert synced[0]['source_files'] == 1
+
+    response = push(removal)
+    assert response.status_code == 409
+
+
+def test_schedule_can_be_saved_read_and_deleted() -> None:
+    """Test that a schedule is validated, stored and removed."""
+    project_id = str(uuid.uuid4())
+    url = f'{API_URL}/{project_id}/schedule'
+    response = requests.put(url, json={'cron': 'not cron', 'action': 'rebuild'}, headers=auth_headers(), timeout=10)
+    assert response.status_code == 400
+    response = requests.put(url, json={'cron': '0 3 * * *', 'action': 'edit'}, headers=auth_headers(), timeout=10)
+    assert response.status_code == 400
+
+    response = requests.put(url, json={'cron': '0 3 * * *', 'action': 'rebuild'}, headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    response = requests.get(url, headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    assert response.json()['cron'] == '0 3 * * *'
+    assert response.json()['action'] == 'rebuild'
+
+    response = requests.delete(url, headers=auth_headers(), timeout=10)
+    assert response.status_code == 204
+    response = requests.get(url, headers=auth_headers(), timeout=10)
+    assert response.status_code == 404
+
+
+def test_promote_without_staging_keeps_production(seeded_project: str, admin_headers: dict[str, str]) -> None:
+    """Test that promoting with nothing staged is refused and leaves the served app alone."""
+    response = requests.post(f'{API_URL}/{seeded_project}/promote', headers=admin_headers, timeout=30)
+    assert response.status_code == 404
+
+    response = requests.get(f'{API_URL}/{seeded_project}/view', headers=admin_headers, timeout=10)
+    assert response.status_code == 200
+    assert 'text/html' in response.headers['Content-Type']
+
+
+def test_experiment_needs_something_staged(seeded_project: str, admin_headers: dict[str, str]) -> None:
+    """Test that an experiment's split is validated and needs a staged build to compare against."""
+    url = f'{API_URL}/{seeded_project}/experiment'
+    response = requests.put(url, json={'staging_percent': 150}, headers=admin_headers, timeout=10)
+    assert response.status_code == 400
+    response = requests.put(url, json={'staging_percent': 50}, headers=admin_headers, timeout=10)
+    assert response.status_code == 409
+    response = requests.get(url, headers=admin_headers, timeout=10)
+    assert response.status_code == 404
+
+
+def test_workspace_holds_each_project_once() -> None:
+    """Test that a project can only be in one workspace, and leaves it when deleted."""
+    first_id, second_id = str(uuid.uuid4()), str(uuid.uuid4())
+    response = requests.post(
+        f'{API_URL}/workspaces/',
+        json={'name': 'Test workspace', 'projects': [first_id, second_id]},
+        headers=auth_headers(),
+        timeout=10,
+    )
+    assert response.status_code == 201
+    workspace_id = response.json()['id']
+
+    response = requests.post(
+        f'{API_URL}/workspaces/',
+        json={'name': 'Another workspace', 'projects': [second_id]},
+        headers=auth_headers(),
+        timeout=10,
+    )
+    assert response.status_code == 409
+
+    response = requests.delete(f'{API_URL}/{first_id}?immediate=true', headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    response = requests.get(f'{API_URL}/workspaces/{workspace_id}', headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    assert response.json()['projects'] == [second_id]
+
+    # Deleting the last project deletes the workspace
+    response = requests.delete(f'{API_URL}/{second_id}?immediate=true', headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    response = requests.get(f'{API_URL}/workspaces/{workspace_id}', headers=auth_headers(), timeout=10)
+    assert response.status_code == 404
+
+
+def test_form_submissions_are_stored(seeded_project: str, admin_headers: dict[str, str]) -> None:
+    """Test that a form submission to the served app can be listed, and that projects without an app take none."""
+    response = requests.post(
+        f'{API_URL}/{uuid.uuid4()}/view/_forms/contact',
+        json={'email': 'someone@example.com'},
+        headers=admin_headers,
+        timeout=10,
+    )
+    assert response.status_code == 404
+
+    response = requests.post(
+        f'{API_URL}/{seeded_project}/view/_forms/contact',
+        json={'email': 'someone@example.com', 'message': 'Hello'},
+        headers=admin_headers,
+        timeout=10,
+    )
+    assert response.status_code == 201
+    submission_id = response.json()['id']
+
+    response = requests.get(f'{API_URL}/{seeded_project}/forms/contact/submissions', headers=admin_headers, timeout=10)
+    assert response.status_code == 200
+    submissions = response.json()['submissions']
+    assert [s['id'] for s in submissions] == [submission_id]
+    assert submissions[0]['fields'] == {'email': 'someone@example.com', 'message': 'Hello'}
+
+
+def test_app_data_updates_are_conditional(seeded_project: str, admin_headers: dict[str, str]) -> None:
+    """Test that app data is stored as JSON and If-Match refuses a stale update."""
+    url = f'{API_URL}/{seeded_project}/view/_data/todos'
+    response = requests.put(url, json=['write tests'], headers=admin_headers, timeout=10)
+    assert response.status_code == 204
+    etag = response.headers['ETag']
+
+    response = requests.get(url, headers=admin_headers, timeout=10)
+    assert response.status_code == 200
+    assert response.json() == ['write tests']
+    assert response.headers['ETag'] == etag
+
+    response = requests.put(url, json=['stale'], headers={**admin_headers, 'If-Match': '"0"'}, timeout=10)
+    assert response.status_code == 412
+    response = requests.put(url, json=['write tests', 'ship'], headers={**admin_headers, 'If-Match': etag}, timeout=10)
+    assert response.status_code == 204
+
+    response = requests.get(f'{API_URL}/{seeded_project}/view/_data', headers=admin_headers, timeout=10)
+    assert response.status_code == 200
+    assert [k['key'] for k in response.json()['keys']] == ['todos']
+
+    response = requests.put(f'{API_URL}/{uuid.uuid4()}/view/_data/todos', json=[], headers=admin_headers, timeout=10)
+    assert response.status_code == 404
+
+
+def test_async_request_is_polled_as_an_operation() -> None:
+    """Test that Prefer: respond-async answers at once with an operation that records the outcome."""
+    project_id = str(uuid.uuid4())
+    response = requests.post(
+        f'{API_URL}/{project_id}/promote',
+        headers={**auth_headers(), 'Prefer': 'respond-async'},
+        timeout=10,
+    )
+    assert response.status_code == 202
+    assert response.json()['kind'] == 'promote'
+    location = response.headers['Location']
+
+    deadline = time.time() + 30
+    operation = response.json()
+    while operation['state'] == 'running' and time.time() < deadline:
+        time.sleep(0.2)
+        response = requests.get(f'{BASE_URL}{location}', headers=auth_headers(), timeout=10)
+        assert response.status_code == 200
+        operation = response.json()
+    # Nothing is staged, so the promotion fails with the 404 it would have answered
+    assert operation['state'] == 'failed'
+    assert operation['status'] == 404
+
+
+def test_library_asset_can_be_uploaded_and_deleted() -> None:
+    """Test that an asset uploaded to the library is listed and served until deleted."""
+    name = f'test-{uuid.uuid4().hex[:12]}.svg'
+    content = b'<svg xmlns="http://www.w3.org/2000/svg"/>'
+    url = f'{API_URL}/library/assets/{name}'
+    response = requests.put(url, data=content, headers={**auth_headers(), 'Content-Type': 'image/svg+xml'}, timeout=10)
+    assert response.status_code == 200
+    assert response.json()['name'] == name
+    assert response.json()['size'] == len(content)
+
+    response = requests.get(url, headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    assert response.content == content
+    response = requests.get(f'{API_URL}/library/', headers=auth_headers(), timeout=10)
+    assert response.status_code == 200
+    assert name in [asset['name'] for asset in response.json()['assets']]
+
+    response = requests.delete(url, headers=auth_headers(), timeout=10)
+    assert response.status_code == 204
+    response = requests.get(url, headers=auth_headers(), timeout=10)
+    assert response.status_code == 404
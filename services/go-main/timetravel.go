@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// versionHeader reports which stored version a time-travel view served.
+const versionHeader = "X-Version"
+
+// parseViewTime parses the ?at= of a time-travel view: an RFC 3339
+// timestamp, Unix seconds, or a date, meaning the app as it was at the end
+// of that day (UTC).
+func parseViewTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if day, err := time.Parse(time.DateOnly, raw); err == nil {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, AppError{Code: http.StatusBadRequest, Message: "at must be an RFC 3339 timestamp, a date or Unix seconds"}
+}
+
+// parseVersion reads the ?version= that time-travel views add to their
+// asset references, returning zero when it is absent.
+func parseVersion(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("version")
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, AppError{Code: http.StatusBadRequest, Message: "Invalid version"}
+	}
+	return n, nil
+}
+
+// viewVersion resolves the ?at= of a view request to the version current
+// at that time, writing the error response and returning false if there is
+// none.
+func (h *Handlers) viewVersion(w http.ResponseWriter, r *http.Request, projectID string) (int, bool) {
+	if r.URL.Query().Has("channel") {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "at can't be combined with channel"})
+		return 0, false
+	}
+	at, err := parseViewTime(r.URL.Query().Get("at"))
+	if err != nil {
+		writeError(w, err)
+		return 0, false
+	}
+	version, err := h.storage.VersionAt(r.Context(), projectID, at)
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(localize(requestLanguage(r), "No version of this app from that time")))
+		return 0, false
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to list versions", err))
+		return 0, false
+	}
+	return version.N, true
+}
+
+// rewriteVersionAssetPaths makes asset references in a version's HTML
+// request that version's assets. Must run after rewriteAssetPaths.
+func rewriteVersionAssetPaths(html string, n int) string {
+	return stagingAssetPattern.ReplaceAllString(html, `$1?version=`+strconv.Itoa(n)+`"`)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLength       = 60
+	maxDescriptionLength = 160
+)
+
+// genericTitles are scaffold page titles that say nothing about the app.
+var genericTitles = []string{"app", "react app", "vite app", "vite + react", "vite + react + ts"}
+
+// promptLeadIn matches the instruction at the start of a prompt,
+// e.g. "Please build me a".
+var promptLeadIn = regexp.MustCompile(`(?i)^(please\s+)?(create|build|make|generate|write|design)\s+(me\s+)?((a|an|the)\s+)?`)
+
+// ProjectListEntry is a project's entry in the project index.
+type ProjectListEntry struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// truncateText shortens s to at most n runes, breaking at a word boundary.
+func truncateText(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	cut := string([]rune(s)[:n-1])
+	if i := strings.LastIndex(cut, " "); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:-") + "…"
+}
+
+// firstSentence returns text up to the first sentence break or newline.
+func firstSentence(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, ".!?\n"); i > 0 {
+		return text[:i]
+	}
+	return text
+}
+
+// capitalize upper-cases the first letter of s.
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// deriveTitle prefers the page's own <title>, falling back to the gist of
+// the prompt when the page still has a scaffold title.
+func deriveTitle(page, prompt string) string {
+	if title := pageTitle(page); !slices.Contains(genericTitles, strings.ToLower(title)) {
+		return truncateText(title, maxTitleLength)
+	}
+	gist := promptLeadIn.ReplaceAllString(firstSentence(prompt), "")
+	if gist == "" {
+		return ""
+	}
+	return truncateText(capitalize(gist), maxTitleLength)
+}
+
+// deriveDescription uses the first sentence of the agent's summary, or of
+// the prompt when there is no summary.
+func deriveDescription(summary, prompt string) string {
+	if s := firstSentence(summary); s != "" {
+		return truncateText(s, maxDescriptionLength)
+	}
+	return truncateText(firstSentence(prompt), maxDescriptionLength)
+}
+
+// ensureTitle gives an untitled project a title and description derived
+// from its page, prompt and summary. Failures are logged, not returned,
+// since a missing title shouldn't fail the generation that triggered it.
+func (h *Handlers) ensureTitle(ctx context.Context, projectID, prompt, summary string) {
+	meta, err := h.storage.GetMetadata(ctx, projectID)
+	if err != nil || meta.Title != "" {
+		return
+	}
+
+	page, _, err := h.storage.GetChannelFile(ctx, projectID, ChannelProduction, "index.html")
+	if err != nil {
+		return
+	}
+	title := deriveTitle(string(page), prompt)
+	if title == "" {
+		return
+	}
+
+	if err := h.storage.SetTitle(ctx, projectID, title, deriveDescription(summary, prompt)); err != nil {
+		log.Printf("Error storing title for project %s: %v", projectID, err)
+	}
+}
+
+// HandleListProjects returns every titled project. Project IDs grant
+// access, so this is only available to operators.
+func (h *Handlers) HandleListProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := h.storage.ListProjects(r.Context())
+	if err != nil {
+		writeError(w, upstreamError("Failed to list projects", err))
+		return
+	}
+
+	slices.SortFunc(projects, func(a, b ProjectListEntry) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	writeJSON(w, http.StatusOK, projects)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// FileProblem names a file that failed validation and why.
+type FileProblem struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+}
+
+// ValidationError reports files that failed validation.
+type ValidationError struct {
+	Message string        `json:"error"`
+	Files   []FileProblem `json:"files"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %d invalid file(s)", e.Message, len(e.Files))
+}
+
+// validatePath checks that a file path is relative, clean and stays inside the project.
+func validatePath(p string) string {
+	switch {
+	case p == "":
+		return "empty path"
+	case strings.ContainsAny(p, "\\\x00"):
+		return "path contains backslash or NUL"
+	case strings.HasPrefix(p, "/"):
+		return "absolute path"
+	case path.Clean(p) != p:
+		return "path is not clean"
+	case p == ".." || strings.HasPrefix(p, "../"):
+		return "path escapes the project"
+	}
+	return ""
+}
+
+// pathPolicyProblem checks a source path against the configured prefix and
+// extension allowlists, describing the problem if it isn't allowed.
+func (h *Handlers) pathPolicyProblem(p string) string {
+	if problem := validatePath(p); problem != "" {
+		return problem
+	}
+	if len(h.cfg.AllowedPathPrefixes) > 0 && !slices.ContainsFunc(h.cfg.AllowedPathPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(p, prefix)
+	}) {
+		return "path prefix not allowed"
+	}
+	if len(h.cfg.AllowedExtensions) > 0 && !slices.Contains(h.cfg.AllowedExtensions, strings.ToLower(path.Ext(p))) {
+		return "file extension not allowed"
+	}
+	return ""
+}
+
+// validateSourcePolicy checks source files against the path policy and file count limit.
+func (h *Handlers) validateSourcePolicy(files map[string]string) []FileProblem {
+	var problems []FileProblem
+	for p := range files {
+		if problem := h.pathPolicyProblem(p); problem != "" {
+			problems = append(problems, FileProblem{Path: p, Problem: problem})
+		}
+	}
+	if len(files) > h.cfg.MaxFilesPerProject {
+		problems = append(problems, FileProblem{Problem: fmt.Sprintf("%d files exceeds the limit of %d", len(files), h.cfg.MaxFilesPerProject)})
+	}
+	return problems
+}
+
+// chatFileOpProblem checks a file created during a chat stream against the
+// path policy and file count limit. Edits and deletes of existing files are
+// always allowed.
+func (h *Handlers) chatFileOpProblem(op *FileOperation, parser *SSEParser) string {
+	if op.Type != "create" || op.Existed {
+		return ""
+	}
+	if problem := h.pathPolicyProblem(op.FilePath); problem != "" {
+		return problem
+	}
+	if n := len(parser.GetFiles()); n > h.cfg.MaxFilesPerProject {
+		return fmt.Sprintf("project would have %d files, exceeding the limit of %d", n, h.cfg.MaxFilesPerProject)
+	}
+	return ""
+}
+
+// validateFileSet checks paths, sizes and UTF-8 validity of a file set,
+// prefixing reported paths with prefix.
+func (h *Handlers) validateFileSet(prefix string, files map[string]string) []FileProblem {
+	var problems []FileProblem
+	total := 0
+	for p, content := range files {
+		total += len(content)
+		if problem := validatePath(p); problem != "" {
+			problems = append(problems, FileProblem{Path: prefix + p, Problem: problem})
+			continue
+		}
+		if len(content) > h.cfg.MaxFileSize {
+			problems = append(problems, FileProblem{Path: prefix + p, Problem: fmt.Sprintf("file exceeds %d bytes", h.cfg.MaxFileSize)})
+			continue
+		}
+		if !utf8.ValidString(content) {
+			problems = append(problems, FileProblem{Path: prefix + p, Problem: "content is not valid UTF-8"})
+		}
+	}
+	if total > h.cfg.MaxProjectSize {
+		problems = append(problems, FileProblem{Path: prefix, Problem: fmt.Sprintf("files total %d bytes, exceeding %d", total, h.cfg.MaxProjectSize)})
+	}
+	return problems
+}
+
+// validateAgentOutput checks files returned by the agent before they are
+// stored. Compiled output must include index.html.
+func (h *Handlers) validateAgentOutput(files, compiledFiles map[string]string) error {
+	problems := h.validateFileSet("", files)
+	problems = append(problems, h.validateSourcePolicy(files)...)
+	problems = append(problems, h.validateFileSet("compiled/", compiledFiles)...)
+	if _, ok := compiledFiles["index.html"]; !ok {
+		problems = append(problems, FileProblem{Path: "compiled/index.html", Problem: "required file missing"})
+	}
+	return problemsError(problems)
+}
+
+// validateSourceFiles checks source files returned by the agent.
+func (h *Handlers) validateSourceFiles(files map[string]string) error {
+	return problemsError(append(h.validateFileSet("", files), h.validateSourcePolicy(files)...))
+}
+
+// problemsError wraps problems in a ValidationError, or returns nil if there are none.
+func problemsError(problems []FileProblem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	slices.SortFunc(problems, func(a, b FileProblem) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return ValidationError{Message: "Agent returned invalid files", Files: problems}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// VersionsResponse is the response for the versions endpoint.
+type VersionsResponse struct {
+	Versions []VersionInfo `json:"versions"`
+}
+
+// RollbackResponse is the response for rolling back to a version.
+type RollbackResponse struct {
+	// Version is the new version recording the rollback, null when
+	// versioning is disabled.
+	Version *VersionInfo `json:"version"`
+}
+
+// snapshotVersion records the project's current app as a new version. It
+// only logs failures, so a snapshot never fails the change it follows.
+func (h *Handlers) snapshotVersion(ctx context.Context, projectID, trigger, summary string) {
+	if h.cfg.MaxVersions == 0 {
+		return
+	}
+	info := VersionInfo{CreatedAt: time.Now().UTC(), Trigger: trigger, Summary: summary}
+	if _, err := h.storage.SnapshotVersion(context.WithoutCancel(ctx), projectID, info, h.cfg.MaxVersions); err != nil {
+		log.Printf("Error snapshotting version of project %s: %v", projectID, err)
+	}
+}
+
+// HandleListVersions returns the project's stored versions, newest first.
+func (h *Handlers) HandleListVersions(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	versions, err := h.storage.ListVersions(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to list versions", err))
+		return
+	}
+	slices.Reverse(versions)
+
+	writeJSON(w, http.StatusOK, VersionsResponse{Versions: versions})
+}
+
+// HandleRollback restores the project's source and compiled files to a
+// stored version. The result is recorded as a new version, so a rollback
+// can itself be undone.
+func (h *Handlers) HandleRollback(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || n < 1 {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid version"})
+		return
+	}
+
+	release, ok := h.lockProject(w, r, projectID, "rollback")
+	if !ok {
+		return
+	}
+	defer release()
+
+	versions, err := h.storage.ListVersions(r.Context(), projectID)
+	if err != nil {
+		writeError(w, upstreamError("Failed to list versions", err))
+		return
+	}
+	i := slices.IndexFunc(versions, func(v VersionInfo) bool { return v.N == n })
+	if i < 0 {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: fmt.Sprintf("Version %d not found", n)})
+		return
+	}
+
+	source, removed, err := h.storage.RestoreVersion(r.Context(), projectID, n)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, AppError{Code: http.StatusNotFound, Message: fmt.Sprintf("Version %d not found", n)})
+			return
+		}
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to restore version: %v", err)})
+		return
+	}
+	h.changes.PublishFiles(projectID, "rollback", source, removed)
+	h.changes.PublishCompiled(projectID, "rollback")
+	h.queueLighthouse(projectID, "rollback")
+
+	resp := RollbackResponse{}
+	if h.cfg.MaxVersions > 0 {
+		info := VersionInfo{CreatedAt: time.Now().UTC(), Trigger: "rollback", Summary: versions[i].Summary, RestoredFrom: n}
+		resp.Version, err = h.storage.SnapshotVersion(context.WithoutCancel(r.Context()), projectID, info, h.cfg.MaxVersions)
+		if err != nil {
+			log.Printf("Error snapshotting version of project %s: %v", projectID, err)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// viewStatsFlushInterval is how often buffered access counts are persisted.
+	viewStatsFlushInterval = time.Minute
+
+	// maxReferrersPerDay caps distinct referrer hosts kept in a daily rollup;
+	// anything beyond it is counted under otherReferrer.
+	maxReferrersPerDay = 50
+
+	directReferrer = "(direct)"
+	otherReferrer  = "(other)"
+
+	viewStatsDateFormat = "2006-01-02"
+)
+
+// DailyViews is one day's access rollup for a project.
+type DailyViews struct {
+	Date      string         `json:"date"`
+	Views     int            `json:"views"`
+	Assets    int            `json:"assets"`
+	Referrers map[string]int `json:"referrers"`
+}
+
+// add merges other's counts into d.
+func (d *DailyViews) add(other *DailyViews) {
+	d.Views += other.Views
+	d.Assets += other.Assets
+	for ref, n := range other.Referrers {
+		d.addReferrer(ref, n)
+	}
+}
+
+// addReferrer counts n hits from ref, folding new hosts into otherReferrer
+// once the daily cap is reached.
+func (d *DailyViews) addReferrer(ref string, n int) {
+	if d.Referrers == nil {
+		d.Referrers = make(map[string]int)
+	}
+	if _, ok := d.Referrers[ref]; !ok && len(d.Referrers) >= maxReferrersPerDay {
+		ref = otherReferrer
+	}
+	d.Referrers[ref] += n
+}
+
+// ViewStatsResponse is the response for GET /{uuid}/stats/views.
+type ViewStatsResponse struct {
+	Days []*DailyViews `json:"days"`
+}
+
+// scrubReferrer reduces a Referer header to its host so paths and query
+// strings (which can carry tokens or personal data) are never stored.
+// Self-referrals from the app's own pages count as direct traffic.
+func scrubReferrer(r *http.Request) string {
+	ref, err := url.Parse(r.Referer())
+	if err != nil || ref.Hostname() == "" {
+		return directReferrer
+	}
+	host := strings.ToLower(ref.Hostname())
+	if host == strings.ToLower(hostWithoutPort(r.Host)) {
+		return directReferrer
+	}
+	return host
+}
+
+// hostWithoutPort strips any port from a Host header value.
+func hostWithoutPort(host string) string {
+	if u, err := url.Parse("//" + host); err == nil {
+		return u.Hostname()
+	}
+	return host
+}
+
+// ViewStatsRecorder buffers sampled view and asset hits in memory and
+// periodically adds them to the stored daily rollups.
+type ViewStatsRecorder struct {
+	storage *Storage
+	percent int
+	mu      sync.Mutex
+	pending map[string]map[string]*DailyViews // project -> date -> counts
+}
+
+// NewViewStatsRecorder creates a new ViewStatsRecorder that records
+// percent% of hits.
+func NewViewStatsRecorder(storage *Storage, percent int) *ViewStatsRecorder {
+	return &ViewStatsRecorder{
+		storage: storage,
+		percent: min(max(percent, 0), 100),
+		pending: make(map[string]map[string]*DailyViews),
+	}
+}
+
+// RecordView counts a page view of the project's app.
+func (v *ViewStatsRecorder) RecordView(r *http.Request, projectID string) {
+	v.record(projectID, func(d *DailyViews, weight int) {
+		d.Views += weight
+		d.addReferrer(scrubReferrer(r), weight)
+	})
+}
+
+// RecordAsset counts an asset request for the project's app.
+func (v *ViewStatsRecorder) RecordAsset(projectID string) {
+	v.record(projectID, func(d *DailyViews, weight int) {
+		d.Assets += weight
+	})
+}
+
+// record applies update to today's pending rollup if the hit is sampled.
+// Each sampled hit stands in for 100/percent real ones.
+func (v *ViewStatsRecorder) record(projectID string, update func(d *DailyViews, weight int)) {
+	if v.percent == 0 {
+		return
+	}
+	if v.percent < 100 && rand.IntN(100) >= v.percent { //nolint:gosec // sampling, not security sensitive
+		return
+	}
+	weight := 100 / v.percent
+
+	date := time.Now().UTC().Format(viewStatsDateFormat)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	days := v.pending[projectID]
+	if days == nil {
+		days = make(map[string]*DailyViews)
+		v.pending[projectID] = days
+	}
+	if days[date] == nil {
+		days[date] = &DailyViews{Date: date, Referrers: make(map[string]int)}
+	}
+	update(days[date], weight)
+}
+
+// Pending returns copies of the unflushed rollups for a project.
+func (v *ViewStatsRecorder) Pending(projectID string) map[string]*DailyViews {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := make(map[string]*DailyViews, len(v.pending[projectID]))
+	for date, d := range v.pending[projectID] {
+		cp := &DailyViews{Date: date}
+		cp.add(d)
+		out[date] = cp
+	}
+	return out
+}
+
+// Run flushes buffered counts until ctx is cancelled.
+func (v *ViewStatsRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(viewStatsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Final flush so shutdown doesn't drop counts
+			v.flush(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			v.flush(ctx)
+		}
+	}
+}
+
+// flush persists and clears the buffered counts.
+func (v *ViewStatsRecorder) flush(ctx context.Context) {
+	v.mu.Lock()
+	pending := v.pending
+	v.pending = make(map[string]map[string]*DailyViews)
+	v.mu.Unlock()
+
+	for projectID, days := range pending {
+		for date, counts := range days {
+			day, err := v.storage.GetDailyViews(ctx, projectID, date)
+			if err != nil {
+				day = &DailyViews{Date: date, Referrers: make(map[string]int)}
+			}
+			day.add(counts)
+			if err := v.storage.StoreDailyViews(ctx, projectID, day); err != nil {
+				log.Printf("Error storing view stats for project %s: %v", projectID, err)
+			}
+		}
+	}
+}
+
+// HandleGetViewStats returns daily access rollups for the last N days
+// (?days=, default 30, max 365), oldest first.
+func (h *Handlers) HandleGetViewStats(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	days := 30
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 365 {
+			writeError(w, AppError{Code: http.StatusBadRequest, Message: "days must be between 1 and 365"})
+			return
+		}
+		days = n
+	}
+	since := time.Now().UTC().AddDate(0, 0, -(days - 1)).Format(viewStatsDateFormat)
+
+	stored, err := h.storage.ListDailyViews(r.Context(), projectID)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Failed to load view stats: %v", err)})
+		return
+	}
+
+	// Include counts that haven't been flushed yet
+	byDate := make(map[string]*DailyViews)
+	for _, d := range stored {
+		byDate[d.Date] = d
+	}
+	for date, d := range h.viewStats.Pending(projectID) {
+		if byDate[date] == nil {
+			byDate[date] = &DailyViews{Date: date, Referrers: make(map[string]int)}
+		}
+		byDate[date].add(d)
+	}
+
+	resp := ViewStatsResponse{Days: []*DailyViews{}}
+	for date, d := range byDate {
+		if date >= since {
+			resp.Days = append(resp.Days, d)
+		}
+	}
+	slices.SortFunc(resp.Days, func(a, b *DailyViews) int {
+		return strings.Compare(a.Date, b.Date)
+	})
+
+	writeJSON(w, http.StatusOK, resp)
+}
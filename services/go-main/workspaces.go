@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxWorkspaceContextBytes caps the source the agent is given from the
+// other projects of a workspace; files beyond it are left out.
+const maxWorkspaceContextBytes = 1 << 20
+
+// maxWorkspaceProjects caps how many projects a workspace can hold.
+const maxWorkspaceProjects = 20
+
+// maxWorkspaceRetries bounds the attempts of a membership change racing
+// others to the same workspace or project.
+const maxWorkspaceRetries = 10
+
+type workspaceFilesKey struct{}
+
+// withWorkspaceFiles attaches the source files of a project's workspace
+// peers to agent requests made with ctx.
+func withWorkspaceFiles(ctx context.Context, files map[string]map[string]string) context.Context {
+	if len(files) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, workspaceFilesKey{}, files)
+}
+
+// workspaceFiles returns the workspace files attached to ctx, if any.
+func workspaceFiles(ctx context.Context) map[string]map[string]string {
+	files, _ := ctx.Value(workspaceFilesKey{}).(map[string]map[string]string)
+	return files
+}
+
+// workspaceContext loads the source files of the other projects in
+// projectID's workspace, by project ID, up to maxWorkspaceContextBytes.
+// Projects outside a workspace get nil; failures are only logged, so the
+// agent just sees less.
+func (h *Handlers) workspaceContext(ctx context.Context, projectID string) map[string]map[string]string {
+	workspaceID, err := h.storage.ProjectWorkspace(ctx, projectID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	var ws *Workspace
+	if err == nil {
+		ws, _, err = h.storage.GetWorkspace(ctx, workspaceID)
+	}
+	if errors.Is(err, ErrNotFound) {
+		// Left behind by a workspace that has since been deleted
+		return nil
+	}
+	if err != nil {
+		log.Printf("Error loading workspace of project %s: %v", projectID, err)
+		return nil
+	}
+
+	files := make(map[string]map[string]string)
+	size := 0
+	for _, peer := range ws.Projects {
+		if peer == projectID {
+			continue
+		}
+		source, err := h.storage.GetSourceFiles(ctx, peer)
+		if err != nil {
+			log.Printf("Error loading files of workspace project %s: %v", peer, err)
+			continue
+		}
+		kept := make(map[string]string)
+		for _, path := range slices.Sorted(maps.Keys(source)) {
+			if size+len(source[path]) > maxWorkspaceContextBytes {
+				continue
+			}
+			size += len(source[path])
+			kept[path] = source[path]
+		}
+		files[peer] = kept
+	}
+	return files
+}
+
+// authorizeProjects checks that the request's API key has scope on every
+// one of projectIDs, writing 401 or 403 and returning false if not. Keys
+// limited to some projects can thus only see workspaces made of them, and
+// no workspace without any.
+func (h *Handlers) authorizeProjects(w http.ResponseWriter, r *http.Request, projectIDs []string, scope string) bool {
+	if !h.cfg.AuthEnabled {
+		return true
+	}
+	secret := bearerToken(r)
+	if secret == "" {
+		writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+		return false
+	}
+	key, err := h.authenticate(r.Context(), secret)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusUnauthorized, Message: "Unauthorized"})
+		return false
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to check API key", err))
+		return false
+	}
+	if len(projectIDs) == 0 && len(key.Projects) > 0 {
+		writeError(w, AppError{Code: http.StatusForbidden, Message: "API key is limited to other projects"})
+		return false
+	}
+	for _, projectID := range projectIDs {
+		if !key.allows(projectID, scope) {
+			writeError(w, AppError{Code: http.StatusForbidden, Message: fmt.Sprintf("API key lacks %s access to project %s", scope, projectID)})
+			return false
+		}
+	}
+	return true
+}
+
+// loadWorkspace reads the workspace named in the URL, writing the error
+// response and returning nil if it can't.
+func (h *Handlers) loadWorkspace(w http.ResponseWriter, r *http.Request) *Workspace {
+	workspaceID := chi.URLParam(r, "workspaceID")
+	parsed, err := uuid.Parse(workspaceID)
+	if err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid workspace ID"})
+		return nil
+	}
+	// Workspaces live in reserved namespaces, so a project's namespace
+	// can't be used as one
+	var ws *Workspace
+	if parsed.Version() == reservedUUIDVersion {
+		ws, _, err = h.storage.GetWorkspace(r.Context(), workspaceID)
+	} else {
+		err = ErrNotFound
+	}
+	if err == nil && len(ws.Projects) == 0 {
+		err = ErrNotFound
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, AppError{Code: http.StatusNotFound, Message: "Workspace not found"})
+		return nil
+	}
+	if err != nil {
+		writeError(w, upstreamError("Failed to load workspace", err))
+		return nil
+	}
+	return ws
+}
+
+// joinWorkspace points projectIDs at workspaceID, failing with 409 if one
+// already belongs to another workspace, in which case the ones that
+// joined are taken out again.
+func (h *Handlers) joinWorkspace(ctx context.Context, workspaceID string, projectIDs []string) error {
+	for i, projectID := range projectIDs {
+		current, err := h.storage.JoinWorkspace(ctx, projectID, workspaceID)
+		if err == nil && current == workspaceID {
+			continue
+		}
+		h.leaveWorkspace(ctx, workspaceID, projectIDs[:i])
+		if err != nil {
+			return upstreamError("Failed to join workspace", err)
+		}
+		return AppError{Code: http.StatusConflict, Message: fmt.Sprintf("Project %s is already in workspace %s", projectID, current)}
+	}
+	return nil
+}
+
+// leaveWorkspace takes projectIDs out of workspaceID after a failed
+// change, only logging failures.
+func (h *Handlers) leaveWorkspace(ctx context.Context, workspaceID string, projectIDs []string) {
+	for _, projectID := range projectIDs {
+		if err := h.storage.LeaveWorkspace(context.WithoutCancel(ctx), projectID, workspaceID); err != nil {
+			log.Printf("Error removing project %s from workspace %s: %v", projectID, workspaceID, err)
+		}
+	}
+}
+
+// updateWorkspace applies update to the stored workspace and saves it
+// conditionally, starting again from the latest version if another change
+// was saved first, so concurrent changes to its projects aren't lost.
+func (h *Handlers) updateWorkspace(ctx context.Context, workspaceID string, update func(*Workspace) error) (*Workspace, error) {
+	for range maxWorkspaceRetries {
+		ws, etag, err := h.storage.GetWorkspace(ctx, workspaceID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, AppError{Code: http.StatusNotFound, Message: "Workspace not found"}
+		}
+		if err != nil {
+			return nil, upstreamError("Failed to load workspace", err)
+		}
+		if err := update(ws); err != nil {
+			return nil, err
+		}
+		ws.UpdatedAt = time.Now().UTC()
+		err = h.storage.StoreWorkspace(ctx, ws, etag)
+		if errors.Is(err, ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return nil, upstreamError("Failed to store workspace", err)
+		}
+		return ws, nil
+	}
+	return nil, AppError{Code: http.StatusConflict, Message: "The workspace kept changing, try again"}
+}
+
+// CreateWorkspaceRequest is the request body for creating a workspace.
+type CreateWorkspaceRequest struct {
+	Name     string   `json:"name"`
+	Projects []string `json:"projects"`
+}
+
+// removeFromWorkspace takes a project out of the workspace it belongs to,
+// if any, deleting the workspace if it was the last.
+func (h *Handlers) removeFromWorkspace(ctx context.Context, projectID string) error {
+	workspaceID, err := h.storage.ProjectWorkspace(ctx, projectID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = h.updateWorkspace(ctx, workspaceID, func(ws *Workspace) error {
+		ws.Projects = slices.DeleteFunc(ws.Projects, func(id string) bool { return id == projectID })
+		return nil
+	})
+	var appErr AppError
+	if err != nil && !(errors.As(err, &appErr) && appErr.Code == http.StatusNotFound) {
+		return err
+	}
+	return h.storage.LeaveWorkspace(ctx, projectID, workspaceID)
+}
+
+// HandleCreateWorkspace groups projects into a new workspace. A project
+// can only be in one workspace at a time: the workspace is stored first,
+// so its claims on its projects aren't taken for stale, and deleted again
+// if one of them is already taken.
+func (h *Handlers) HandleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var req CreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "name must be between 1 and 100 characters"})
+		return
+	}
+	slices.Sort(req.Projects)
+	req.Projects = slices.Compact(req.Projects)
+	if len(req.Projects) == 0 || len(req.Projects) > maxWorkspaceProjects {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("projects must list between 1 and %d projects", maxWorkspaceProjects)})
+		return
+	}
+	for _, projectID := range req.Projects {
+		if err := validateUUID(projectID); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if !h.authorizeProjects(w, r, req.Projects, ScopeWrite) {
+		return
+	}
+
+	now := time.Now().UTC()
+	ws := &Workspace{ID: reserveUUID(uuid.New()), Name: req.Name, Projects: req.Projects, CreatedAt: now, UpdatedAt: now}
+	if err := h.storage.StoreWorkspace(r.Context(), ws, "*"); err != nil {
+		writeError(w, upstreamError("Failed to store workspace", err))
+		return
+	}
+	if err := h.joinWorkspace(r.Context(), ws.ID, ws.Projects); err != nil {
+		if delErr := h.storage.DeleteWorkspace(context.WithoutCancel(r.Context()), ws); delErr != nil {
+			log.Printf("Error deleting workspace %s: %v", ws.ID, delErr)
+		}
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ws)
+}
+
+// HandleGetWorkspace returns a workspace.
+func (h *Handlers) HandleGetWorkspace(w http.ResponseWriter, r *http.Request) {
+	ws := h.loadWorkspace(w, r)
+	if ws == nil || !h.authorizeProjects(w, r, ws.Projects, ScopeRead) {
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// HandleDeleteWorkspace dissolves a workspace; its projects are kept.
+func (h *Handlers) HandleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	ws := h.loadWorkspace(w, r)
+	if ws == nil || !h.authorizeProjects(w, r, ws.Projects, ScopeWrite) {
+		return
+	}
+	if err := h.storage.DeleteWorkspace(r.Context(), ws); err != nil {
+		writeError(w, upstreamError("Failed to delete workspace", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAddWorkspaceProject adds a project to a workspace.
+func (h *Handlers) HandleAddWorkspaceProject(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	ws := h.loadWorkspace(w, r)
+	if ws == nil || !h.authorizeProjects(w, r, append(slices.Clone(ws.Projects), projectID), ScopeWrite) {
+		return
+	}
+	member := slices.Contains(ws.Projects, projectID)
+	if err := h.joinWorkspace(r.Context(), ws.ID, []string{projectID}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ws, err := h.updateWorkspace(r.Context(), ws.ID, func(ws *Workspace) error {
+		if slices.Contains(ws.Projects, projectID) {
+			return nil
+		}
+		if len(ws.Projects) >= maxWorkspaceProjects {
+			return AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("A workspace holds at most %d projects", maxWorkspaceProjects)}
+		}
+		ws.Projects = append(ws.Projects, projectID)
+		slices.Sort(ws.Projects)
+		return nil
+	})
+	if err != nil {
+		if !member {
+			h.leaveWorkspace(r.Context(), chi.URLParam(r, "workspaceID"), []string{projectID})
+		}
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// HandleRemoveWorkspaceProject takes a project out of a workspace. A
+// workspace whose last project leaves is deleted.
+func (h *Handlers) HandleRemoveWorkspaceProject(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "uuid")
+	if err := validateUUID(projectID); err != nil {
+		writeError(w, err)
+		return
+	}
+	ws := h.loadWorkspace(w, r)
+	if ws == nil || !h.authorizeProjects(w, r, []string{projectID}, ScopeWrite) {
+		return
+	}
+
+	ws, err := h.updateWorkspace(r.Context(), ws.ID, func(ws *Workspace) error {
+		i := slices.Index(ws.Projects, projectID)
+		if i < 0 {
+			return AppError{Code: http.StatusNotFound, Message: "Project is not in this workspace"}
+		}
+		ws.Projects = slices.Delete(ws.Projects, i, i+1)
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.storage.LeaveWorkspace(r.Context(), projectID, ws.ID); err != nil {
+		writeError(w, upstreamError("Failed to remove project from workspace", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// WorkspaceConversationResponse is the response for a workspace's shared
+// conversation.
+type WorkspaceConversationResponse struct {
+	Messages json.RawMessage `json:"messages"`
+}
+
+// HandleGetWorkspaceConversation returns the workspace's shared
+// conversation, an empty list if none has been saved.
+func (h *Handlers) HandleGetWorkspaceConversation(w http.ResponseWriter, r *http.Request) {
+	ws := h.loadWorkspace(w, r)
+	if ws == nil || !h.authorizeProjects(w, r, ws.Projects, ScopeRead) {
+		return
+	}
+	conversation, err := h.storage.GetWorkspaceConversation(r.Context(), ws.ID)
+	if errors.Is(err, ErrNotFound) {
+		conversation = json.RawMessage("[]")
+	} else if err != nil {
+		writeError(w, upstreamError("Failed to load conversation", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, WorkspaceConversationResponse{Messages: conversation})
+}
+
+// HandleSaveWorkspaceConversation saves the workspace's shared
+// conversation.
+func (h *Handlers) HandleSaveWorkspaceConversation(w http.ResponseWriter, r *http.Request) {
+	ws := h.loadWorkspace(w, r)
+	if ws == nil || !h.authorizeProjects(w, r, ws.Projects, ScopeWrite) {
+		return
+	}
+	var req SaveConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, AppError{Code: http.StatusBadRequest, Message: "Invalid JSON"})
+		return
+	}
+	if err := h.storage.StoreWorkspaceConversation(r.Context(), ws.ID, req.Messages); err != nil {
+		writeError(w, upstreamError("Failed to store conversation", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -8,7 +8,9 @@ import { build } from 'vite';
 import react from '@vitejs/plugin-react';
 import tailwindcss from '@tailwindcss/vite';
 import * as logfire from '@pydantic/logfire-node';
-import type { BuildRequest, BuildOutput, BuildResponse } from './schema.js';
+import type { BuildRequest, BuildOutput, BuildResponse, Dependency } from './schema.js';
+import { MAIN_TSX, indexHtml } from './scaffold.js';
+import { optimizeImages } from './images.js';
 
 const execFileAsync = promisify(execFile);
 
@@ -16,6 +18,22 @@ const __dirname = path.dirname(fileURLToPath(import.meta.url));
 const SERVER_ROOT = path.resolve(__dirname, '..');
 const SHADCN_DIR = path.join(SERVER_ROOT, 'shadcn');
 
+/**
+ * File types sent and returned base64 encoded, since reading them as UTF-8
+ * would corrupt them. Keep in sync with binaryExtensions in go-main.
+ */
+const BINARY_EXTENSIONS = new Set([
+  '.wasm', '.png', '.jpg', '.jpeg', '.gif', '.webp', '.avif', '.ico', '.woff', '.woff2',
+  '.gz',
+]);
+
+/**
+ * Encoding a file travels in over JSON.
+ */
+function fileEncoding(file: string): BufferEncoding {
+  return BINARY_EXTENSIONS.has(path.extname(file).toLowerCase()) ? 'base64' : 'utf-8';
+}
+
 /**
  * Recursively copy a directory to a destination.
  */
@@ -68,15 +86,143 @@ async function runBiomeCheck(tempDir: string, inputFiles: string[]): Promise<str
 
 /**
  * Read source files back from temp directory (may have been modified by biome).
+ * Binary files are left out, since biome doesn't touch them.
  */
 async function readSourceFiles(tempDir: string, inputFiles: string[]): Promise<Record<string, string>> {
   const source: Record<string, string> = {}
   for (const file of inputFiles) {
-    source[file] = await fs.readFile(path.join(tempDir, file), 'utf-8')
+    if (!BINARY_EXTENSIONS.has(path.extname(file).toLowerCase())) {
+      source[file] = await fs.readFile(path.join(tempDir, file), 'utf-8')
+    }
   }
   return source
 }
 
+/**
+ * Packages whose installed versions are reported with each build.
+ */
+const TOOL_PACKAGES = ['vite', '@vitejs/plugin-react', 'tailwindcss', '@tailwindcss/vite', 'react', 'react-dom'];
+
+let toolVersionsCache: Record<string, string> | undefined;
+
+/**
+ * Installed versions of the build toolchain, so a build can be traced to the tools that produced it.
+ */
+async function toolVersions(): Promise<Record<string, string>> {
+  if (!toolVersionsCache) {
+    const versions: Record<string, string> = { node: process.version };
+    for (const pkg of TOOL_PACKAGES) {
+      try {
+        const manifest = await fs.readFile(path.join(SERVER_ROOT, 'node_modules', pkg, 'package.json'), 'utf-8');
+        versions[pkg] = JSON.parse(manifest).version;
+      } catch {
+        // Not installed; leave it out
+      }
+    }
+    toolVersionsCache = versions;
+  }
+  return toolVersionsCache;
+}
+
+/**
+ * Map each output chunk to the input files bundled into it, from Rollup's module graph, so errors in a
+ * bundle can be traced back to source.
+ */
+function artifactSources(
+  output: Awaited<ReturnType<typeof build>>,
+  tempDir: string,
+  inputFiles: string[]
+): Record<string, string[]> {
+  const inputs = new Set(inputFiles);
+  const sources: Record<string, string[]> = {};
+  for (const result of Array.isArray(output) ? output : [output]) {
+    if (!('output' in result)) {
+      continue;
+    }
+    for (const chunk of result.output) {
+      if (chunk.type !== 'chunk') {
+        continue;
+      }
+      const files = Object.keys(chunk.modules)
+        .map((id) => path.relative(tempDir, id.split('?')[0]))
+        .filter((file) => inputs.has(file));
+      if (files.length > 0) {
+        sources[chunk.fileName] = [...new Set(files)].sort();
+      }
+    }
+  }
+  return sources;
+}
+
+/**
+ * Manifest fields read for the license report, cached by package directory since they don't change while the
+ * server runs.
+ */
+const packageManifestCache = new Map<string, Dependency | null>();
+
+/**
+ * Read a package's name, version and license from its package.json, or null if it can't be read.
+ */
+async function readPackageManifest(packageDir: string): Promise<Dependency | null> {
+  const cached = packageManifestCache.get(packageDir);
+  if (cached !== undefined) {
+    return cached;
+  }
+  let dependency: Dependency | null = null;
+  try {
+    const manifest = JSON.parse(await fs.readFile(path.join(packageDir, 'package.json'), 'utf-8'));
+    // Older packages use a { type } object or a "licenses" array
+    const licenses: unknown[] = manifest.license ? [manifest.license] : Array.isArray(manifest.licenses) ? manifest.licenses : [];
+    const license = licenses
+      .map((l) => (typeof l === 'string' ? l : (l as { type?: string })?.type))
+      .filter(Boolean)
+      .join(' OR ');
+    dependency = { name: manifest.name, version: manifest.version, license: license || 'UNKNOWN' };
+  } catch {
+    // Unreadable manifest; leave the package out
+  }
+  packageManifestCache.set(packageDir, dependency);
+  return dependency;
+}
+
+/**
+ * List the npm packages bundled into the output, from the node_modules paths in Rollup's module graph, so users can
+ * vet the licenses of what their app ships.
+ */
+async function bundledDependencies(output: Awaited<ReturnType<typeof build>>): Promise<Dependency[]> {
+  const packageDirs = new Set<string>();
+  for (const result of Array.isArray(output) ? output : [output]) {
+    if (!('output' in result)) {
+      continue;
+    }
+    for (const chunk of result.output) {
+      if (chunk.type !== 'chunk') {
+        continue;
+      }
+      for (const id of Object.keys(chunk.modules)) {
+        const file = id.split('?')[0];
+        // The last node_modules segment is the package itself, also under pnpm's nested layout
+        const marker = file.lastIndexOf('/node_modules/');
+        if (file.startsWith('\0') || marker < 0) {
+          continue;
+        }
+        const rest = file.slice(marker + '/node_modules/'.length).split('/');
+        const name = rest[0].startsWith('@') ? rest.slice(0, 2).join('/') : rest[0];
+        packageDirs.add(file.slice(0, marker) + '/node_modules/' + name);
+      }
+    }
+  }
+
+  const dependencies = new Map<string, Dependency>();
+  for (const dir of packageDirs) {
+    const dependency = await readPackageManifest(dir);
+    if (dependency) {
+      dependencies.set(`${dependency.name}@${dependency.version}`, dependency);
+    }
+  }
+  return [...dependencies.values()].sort((a, b) => a.name.localeCompare(b.name) || a.version.localeCompare(b.version));
+}
+
 export async function buildProject(request: BuildRequest): Promise<BuildResponse> {
   const buildId = randomUUID();
   const tempDir = path.join(SERVER_ROOT, `tmp_build_${buildId}`);
@@ -97,37 +243,14 @@ export async function buildProject(request: BuildRequest): Promise<BuildResponse
             for (const [filePath, content] of Object.entries(request.files)) {
               const fullPath = path.join(tempDir, filePath);
               await fs.mkdir(path.dirname(fullPath), { recursive: true });
-              await fs.writeFile(fullPath, content, 'utf-8');
+              await fs.writeFile(fullPath, content, fileEncoding(filePath));
             }
 
             // Generate main.tsx entry point that imports App from ./app
-            const mainTsx = `import { StrictMode } from 'react';
-import { createRoot } from 'react-dom/client';
-import './shadcn/globals.css';
-import App from './app';
-
-createRoot(document.getElementById('root')!).render(
-  <StrictMode>
-    <App />
-  </StrictMode>
-);
-`;
-            await fs.writeFile(path.join(tempDir, 'main.tsx'), mainTsx, 'utf-8');
+            await fs.writeFile(path.join(tempDir, 'main.tsx'), MAIN_TSX, 'utf-8');
 
             // Generate index.html that references main.tsx
-            const indexHtml = `<!DOCTYPE html>
-<html lang="en">
-  <head>
-    <meta charset="UTF-8" />
-    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
-    <title>Build</title>
-  </head>
-  <body>
-    <div id="root"></div>
-    <script type="module" src="/main.tsx"></script>
-  </body>
-</html>`;
-            await fs.writeFile(path.join(tempDir, 'index.html'), indexHtml, 'utf-8');
+            await fs.writeFile(path.join(tempDir, 'index.html'), indexHtml('Build'), 'utf-8');
           },
         });
 
@@ -145,12 +268,14 @@ createRoot(document.getElementById('root')!).render(
         }
 
         // Run Vite build programmatically
-        await logfire.span('vite build', {
+        const warnings: string[] = [];
+        const output = await logfire.span('vite build', {
           callback: async () => {
             const nm = (pkg: string) => path.join(SERVER_ROOT, 'node_modules', pkg);
-            await build({
+            return await build({
               root: tempDir,
               configFile: false,
+              mode: request.profile,
               logLevel: 'error',
               plugins: [react(), tailwindcss()],
               resolve: {
@@ -193,9 +318,13 @@ createRoot(document.getElementById('root')!).render(
               build: {
                 outDir: 'dist',
                 sourcemap: true,
+                minify: request.profile === 'production',
                 emptyOutDir: true,
                 rollupOptions: {
                   input: path.join(tempDir, 'index.html'),
+                  onwarn: (warning) => {
+                    warnings.push(warning.message);
+                  },
                 },
               },
               cacheDir: path.join(SERVER_ROOT, 'node_modules/.vite'),
@@ -203,6 +332,12 @@ createRoot(document.getElementById('root')!).render(
           },
         });
 
+        if (request.optimize_images) {
+          await logfire.span('optimize images', {
+            callback: async () => optimizeImages(path.join(distDir, 'assets'), warnings),
+          });
+        }
+
         // Read output files from dist/
         const compiled: BuildOutput = await logfire.span('read output files', {
           callback: async () => {
@@ -220,7 +355,7 @@ createRoot(document.getElementById('root')!).render(
                 const filePath = path.join(assetsDir, file);
                 const stat = await fs.stat(filePath);
                 if (stat.isFile()) {
-                  const content = await fs.readFile(filePath, 'utf-8');
+                  const content = await fs.readFile(filePath, fileEncoding(file));
                   result[`assets/${file}`] = content;
                 }
               }
@@ -237,7 +372,10 @@ createRoot(document.getElementById('root')!).render(
           },
         });
 
-        return { compiled, source };
+        const sources = artifactSources(output, tempDir, inputFiles);
+        const dependencies = await bundledDependencies(output);
+
+        return { compiled, source, warnings, versions: await toolVersions(), sources, dependencies };
       } finally {
         // Clean up temp directory
         fs.rm(tempDir, { recursive: true, force: true }).catch(() => {
@@ -0,0 +1,103 @@
+import { execFile } from 'node:child_process';
+import * as fs from 'node:fs/promises';
+import * as path from 'node:path';
+import { promisify } from 'node:util';
+
+const execFileAsync = promisify(execFile);
+
+// libvips' command line tools are installed in the image
+const VIPS_BIN = process.env.VIPS_BIN || 'vips';
+const VIPSHEADER_BIN = process.env.VIPSHEADER_BIN || 'vipsheader';
+const VIPS_TIMEOUT_MS = 30_000;
+
+/**
+ * Widths of the responsive variants, in pixels. Only widths smaller than the
+ * original are generated.
+ */
+export const RESPONSIVE_WIDTHS = [480, 960, 1600];
+
+// Save options per output format: quality, and metadata stripped
+const SAVE_OPTIONS: Record<string, string> = {
+  '.png': '[compression=9,strip]',
+  '.jpg': '[Q=82,strip,optimize_coding]',
+  '.jpeg': '[Q=82,strip,optimize_coding]',
+  '.webp': '[Q=80,strip]',
+  '.avif': '[Q=55,strip]',
+};
+
+// Formats each image gets a variant in, besides its own
+const VARIANT_FORMATS = ['.webp', '.avif'];
+
+async function vips(args: string[]): Promise<string> {
+  const { stdout } = await execFileAsync(VIPS_BIN, args, { timeout: VIPS_TIMEOUT_MS });
+  return stdout;
+}
+
+async function imageWidth(file: string): Promise<number> {
+  const { stdout } = await execFileAsync(VIPSHEADER_BIN, ['-f', 'width', file], { timeout: VIPS_TIMEOUT_MS });
+  return Number.parseInt(stdout.trim(), 10);
+}
+
+/**
+ * Recompresses file in place, keeping the result only if it is smaller.
+ */
+async function recompress(file: string, ext: string): Promise<void> {
+  const tmp = `${file}.tmp${ext}`;
+  try {
+    await vips(['copy', file, tmp + SAVE_OPTIONS[ext]]);
+    const [before, after] = await Promise.all([fs.stat(file), fs.stat(tmp)]);
+    if (after.size < before.size) {
+      await fs.rename(tmp, file);
+    }
+  } finally {
+    await fs.rm(tmp, { force: true });
+  }
+}
+
+/**
+ * Optimizes the PNG and JPEG images in assetsDir: each is recompressed, and
+ * gets WebP and AVIF variants (`logo-abc.webp`) plus resized copies in every
+ * format (`logo-abc.480w.webp`) for go-main to choose from by the request's
+ * Accept header and width. Images that can't be processed are left as they
+ * are, with a warning.
+ */
+export async function optimizeImages(assetsDir: string, warnings: string[]): Promise<void> {
+  let files: string[];
+  try {
+    files = await fs.readdir(assetsDir);
+  } catch {
+    return;
+  }
+  const images = files.filter((file) => ['.png', '.jpg', '.jpeg'].includes(path.extname(file).toLowerCase()));
+  if (images.length === 0) return;
+
+  try {
+    await vips(['--version']);
+  } catch {
+    warnings.push(`Image optimization skipped: ${VIPS_BIN} is not installed`);
+    return;
+  }
+
+  for (const file of images) {
+    const ext = path.extname(file);
+    const stem = file.slice(0, -ext.length);
+    const filePath = path.join(assetsDir, file);
+    try {
+      await recompress(filePath, ext.toLowerCase());
+
+      for (const format of VARIANT_FORMATS) {
+        await vips(['copy', filePath, path.join(assetsDir, stem + format) + SAVE_OPTIONS[format]]);
+      }
+
+      const width = await imageWidth(filePath);
+      for (const size of RESPONSIVE_WIDTHS.filter((size) => size < width)) {
+        for (const format of [ext, ...VARIANT_FORMATS]) {
+          const out = path.join(assetsDir, `${stem}.${size}w${format}`);
+          await vips(['thumbnail', filePath, out + SAVE_OPTIONS[format.toLowerCase()], String(size)]);
+        }
+      }
+    } catch (err) {
+      warnings.push(`Image optimization failed for assets/${file}: ${(err as Error).message}`);
+    }
+  }
+}
@@ -0,0 +1,70 @@
+import { execFile } from 'node:child_process';
+import { promisify } from 'node:util';
+import type { LighthouseRequest, LighthouseResponse } from './schema.js';
+
+const execFileAsync = promisify(execFile);
+
+// The Lighthouse CLI is installed globally in the image, alongside Chromium
+const LIGHTHOUSE_BIN = process.env.LIGHTHOUSE_BIN || 'lighthouse';
+const LIGHTHOUSE_TIMEOUT_MS = 120_000;
+
+const CATEGORIES = ['performance', 'accessibility', 'best-practices', 'seo'];
+
+// Audits reported as metrics, keyed by the name they are returned under
+const METRICS: Record<string, string> = {
+  first_contentful_paint_ms: 'first-contentful-paint',
+  largest_contentful_paint_ms: 'largest-contentful-paint',
+  total_blocking_time_ms: 'total-blocking-time',
+  speed_index_ms: 'speed-index',
+  cumulative_layout_shift: 'cumulative-layout-shift',
+  total_byte_weight: 'total-byte-weight',
+};
+
+interface LighthouseResult {
+  lighthouseVersion: string;
+  runtimeError?: { code: string; message: string };
+  categories: Record<string, { score: number | null } | undefined>;
+  audits: Record<string, { numericValue?: number } | undefined>;
+}
+
+/**
+ * Runs a Lighthouse audit of a page in headless Chromium and returns its
+ * category scores and key metrics.
+ */
+export async function runLighthouse(req: LighthouseRequest): Promise<LighthouseResponse> {
+  const { stdout } = await execFileAsync(
+    LIGHTHOUSE_BIN,
+    [
+      req.url,
+      '--output=json',
+      '--output-path=stdout',
+      '--quiet',
+      `--only-categories=${CATEGORIES.join(',')}`,
+      '--chrome-flags=--headless=new --no-sandbox --disable-gpu',
+    ],
+    { maxBuffer: 64 * 1024 * 1024, timeout: LIGHTHOUSE_TIMEOUT_MS }
+  );
+
+  const result = JSON.parse(stdout) as LighthouseResult;
+  if (result.runtimeError) {
+    throw new Error(`Lighthouse could not audit ${req.url}: ${result.runtimeError.code} ${result.runtimeError.message}`);
+  }
+
+  const scores: Record<string, number> = {};
+  for (const id of CATEGORIES) {
+    const score = result.categories[id]?.score;
+    if (score != null) {
+      scores[id.replace('-', '_')] = Math.round(score * 100);
+    }
+  }
+
+  const metrics: Record<string, number> = {};
+  for (const [name, audit] of Object.entries(METRICS)) {
+    const value = result.audits[audit]?.numericValue;
+    if (value != null) {
+      metrics[name] = value;
+    }
+  }
+
+  return { scores, metrics, lighthouse_version: result.lighthouseVersion };
+}
@@ -0,0 +1,24 @@
+import { createHash } from 'node:crypto';
+
+const REDACTION_MODE = process.env.REDACTION_MODE ?? 'off';
+const REDACTION_MAX_LENGTH = Number(process.env.REDACTION_MAX_LENGTH ?? 64);
+
+if (!['off', 'truncate', 'hash'].includes(REDACTION_MODE)) {
+  throw new Error(`Unknown REDACTION_MODE '${REDACTION_MODE}'`);
+}
+
+/**
+ * Truncate or hash text that may contain source code (such as build error code frames)
+ * before it is logged, according to REDACTION_MODE.
+ */
+export function redact(text: string): string {
+  const size = Buffer.byteLength(text);
+  if (REDACTION_MODE === 'truncate' && text.length > REDACTION_MAX_LENGTH) {
+    return `${text.slice(0, REDACTION_MAX_LENGTH)}... (${size} bytes)`;
+  }
+  if (REDACTION_MODE === 'hash') {
+    const digest = createHash('sha256').update(text).digest('hex').slice(0, 16);
+    return `sha256:${digest} (${size} bytes)`;
+  }
+  return text;
+}
@@ -0,0 +1,176 @@
+import * as fs from 'node:fs/promises';
+import * as path from 'node:path';
+import { fileURLToPath } from 'node:url';
+
+const __dirname = path.dirname(fileURLToPath(import.meta.url));
+const SERVER_ROOT = path.resolve(__dirname, '..');
+const SHADCN_DIR = path.join(SERVER_ROOT, 'shadcn');
+
+/**
+ * Entry point that mounts the generated app, as used by the hosted build.
+ */
+export const MAIN_TSX = `import { StrictMode } from 'react';
+import { createRoot } from 'react-dom/client';
+import './shadcn/globals.css';
+import App from './app';
+
+createRoot(document.getElementById('root')!).render(
+  <StrictMode>
+    <App />
+  </StrictMode>
+);
+`;
+
+/**
+ * HTML page that loads main.tsx, as used by the hosted build.
+ */
+export function indexHtml(title: string): string {
+  return `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <title>${title.replace(/[<>&]/g, '')}</title>
+  </head>
+  <body>
+    <div id="root"></div>
+    <script type="module" src="/main.tsx"></script>
+  </body>
+</html>`;
+}
+
+/**
+ * Packages the hosted build makes available to generated apps.
+ */
+const APP_DEPENDENCIES = [
+  '@radix-ui/react-accordion',
+  '@radix-ui/react-alert-dialog',
+  '@radix-ui/react-avatar',
+  '@radix-ui/react-checkbox',
+  '@radix-ui/react-dialog',
+  '@radix-ui/react-dropdown-menu',
+  '@radix-ui/react-label',
+  '@radix-ui/react-popover',
+  '@radix-ui/react-progress',
+  '@radix-ui/react-scroll-area',
+  '@radix-ui/react-select',
+  '@radix-ui/react-separator',
+  '@radix-ui/react-slot',
+  '@radix-ui/react-switch',
+  '@radix-ui/react-tabs',
+  '@radix-ui/react-tooltip',
+  'class-variance-authority',
+  'clsx',
+  'lucide-react',
+  'react',
+  'react-dom',
+  'tailwind-merge',
+];
+
+const APP_DEV_DEPENDENCIES = [
+  '@tailwindcss/vite',
+  '@types/react',
+  '@types/react-dom',
+  '@vitejs/plugin-react',
+  'tailwindcss',
+  'typescript',
+  'vite',
+];
+
+const VITE_CONFIG = `import path from 'node:path';
+import { defineConfig } from 'vite';
+import react from '@vitejs/plugin-react';
+import tailwindcss from '@tailwindcss/vite';
+
+export default defineConfig({
+  plugins: [react(), tailwindcss()],
+  resolve: {
+    alias: [
+      { find: /^shadcn\\/(.*)$/, replacement: \`\${path.resolve(import.meta.dirname, 'shadcn')}/$1\` },
+      { find: /^@\\/(.*)$/, replacement: \`\${import.meta.dirname}/$1\` },
+    ],
+  },
+});
+`;
+
+const TSCONFIG = {
+  compilerOptions: {
+    target: 'ES2022',
+    lib: ['ES2022', 'DOM', 'DOM.Iterable'],
+    module: 'ESNext',
+    moduleResolution: 'bundler',
+    jsx: 'react-jsx',
+    strict: true,
+    skipLibCheck: true,
+    noEmit: true,
+    paths: {
+      'shadcn/*': ['./shadcn/*'],
+      '@/*': ['./*'],
+    },
+  },
+  exclude: ['node_modules', 'dist'],
+};
+
+/**
+ * Recursively read a directory into a map of relative paths to contents.
+ */
+async function readDir(dir: string, prefix: string, files: Record<string, string>): Promise<void> {
+  const entries = await fs.readdir(dir, { withFileTypes: true });
+  for (const entry of entries) {
+    const fullPath = path.join(dir, entry.name);
+    const relPath = `${prefix}/${entry.name}`;
+    if (entry.isDirectory()) {
+      await readDir(fullPath, relPath, files);
+    } else {
+      files[relPath] = await fs.readFile(fullPath, 'utf-8');
+    }
+  }
+}
+
+/**
+ * Pick the versions node-build itself uses for the given packages.
+ */
+function pinVersions(names: string[], available: Record<string, string>): Record<string, string> {
+  const versions: Record<string, string> = {};
+  for (const name of names) {
+    if (available[name]) {
+      versions[name] = available[name];
+    }
+  }
+  return versions;
+}
+
+/**
+ * Generate the files the hosted build supplies implicitly (package.json, Vite and TypeScript config,
+ * entry point, index.html and the shadcn components) so generated source builds standalone with
+ * `npm install && npm run dev`.
+ */
+export async function scaffoldProject(name: string): Promise<Record<string, string>> {
+  const ownPackage = JSON.parse(await fs.readFile(path.join(SERVER_ROOT, 'package.json'), 'utf-8'));
+  const available = { ...ownPackage.dependencies, ...ownPackage.devDependencies };
+
+  const packageJson = {
+    name: name.toLowerCase().replace(/[^a-z0-9-]+/g, '-').replace(/^-+|-+$/g, '') || 'app',
+    private: true,
+    version: '0.0.0',
+    type: 'module',
+    scripts: {
+      dev: 'vite',
+      build: 'vite build',
+      preview: 'vite preview',
+    },
+    dependencies: pinVersions(APP_DEPENDENCIES, available),
+    devDependencies: pinVersions(APP_DEV_DEPENDENCIES, available),
+  };
+
+  const files: Record<string, string> = {
+    'package.json': `${JSON.stringify(packageJson, null, 2)}\n`,
+    'vite.config.ts': VITE_CONFIG,
+    'tsconfig.json': `${JSON.stringify(TSCONFIG, null, 2)}\n`,
+    'index.html': indexHtml(name),
+    'main.tsx': MAIN_TSX,
+    '.gitignore': 'node_modules\ndist\n',
+  };
+  await readDir(SHADCN_DIR, 'shadcn', files);
+  return files;
+}
@@ -9,6 +9,10 @@ export const BuildRequestSchema = z.object({
     .refine((files) => Object.keys(files).length > 0, {
       message: 'At least one file is required',
     }),
+  // development builds skip minification so output stays readable
+  profile: z.enum(['production', 'development']).default('production'),
+  // recompress images and add WebP/AVIF and resized variants of them
+  optimize_images: z.boolean().default(false),
 });
 
 export type BuildRequest = z.infer<typeof BuildRequestSchema>;
@@ -17,9 +21,44 @@ export const BuildOutputSchema = z.record(z.string(), z.string());
 
 export type BuildOutput = z.infer<typeof BuildOutputSchema>;
 
+export const DependencySchema = z.object({
+  name: z.string(),
+  version: z.string(),
+  // SPDX expression from package.json, or "UNKNOWN" when it declares none
+  license: z.string(),
+})
+
+export type Dependency = z.infer<typeof DependencySchema>
+
 export const BuildResponseSchema = z.object({
   compiled: z.record(z.string(), z.string()),
   source: z.record(z.string(), z.string()),
+  warnings: z.array(z.string()),
+  versions: z.record(z.string(), z.string()),
+  sources: z.record(z.string(), z.array(z.string())),
+  dependencies: z.array(DependencySchema),
 })
 
 export type BuildResponse = z.infer<typeof BuildResponseSchema>
+
+export const ScaffoldRequestSchema = z.object({
+  name: z.string().min(1),
+});
+
+export type ScaffoldRequest = z.infer<typeof ScaffoldRequestSchema>;
+
+export const LighthouseRequestSchema = z.object({
+  url: z.url({ protocol: /^https?$/ }),
+});
+
+export type LighthouseRequest = z.infer<typeof LighthouseRequestSchema>;
+
+export const LighthouseResponseSchema = z.object({
+  // category scores out of 100: performance, accessibility, best_practices, seo
+  scores: z.record(z.string(), z.number()),
+  // timings in milliseconds, layout shift unitless, byte weight in bytes
+  metrics: z.record(z.string(), z.number()),
+  lighthouse_version: z.string(),
+});
+
+export type LighthouseResponse = z.infer<typeof LighthouseResponseSchema>;
@@ -1,7 +1,10 @@
 import express, { Express, NextFunction, Request, Response } from 'express';
 import * as logfire from '@pydantic/logfire-node';
-import { BuildRequestSchema } from './schema.js';
+import { BuildRequestSchema, LighthouseRequestSchema, ScaffoldRequestSchema } from './schema.js';
 import { buildProject } from './build.js';
+import { scaffoldProject } from './scaffold.js';
+import { runLighthouse } from './lighthouse.js';
+import { redact } from './redaction.js';
 
 const app: Express = express();
 
@@ -20,7 +23,7 @@ app.post('/build', async (req: Request, res: Response) => {
   const parsed = BuildRequestSchema.safeParse(req.body);
 
   if (!parsed.success) {
-    logfire.warning('Invalid build request', { error: parsed.error.message });
+    logfire.warning('Invalid build request', { error: redact(parsed.error.message) });
     res.status(400).send(parsed.error.message);
     return;
   }
@@ -35,11 +38,50 @@ app.post('/build', async (req: Request, res: Response) => {
     res.status(200).json(output);
   } catch (err) {
     const message = err instanceof Error ? err.message : String(err);
-    logfire.error('Build failed: {message}', { message });
+    logfire.error('Build failed: {message}', { message: redact(message) });
     res.status(400).send(message);
   }
 });
 
+app.post('/scaffold', async (req: Request, res: Response) => {
+  const parsed = ScaffoldRequestSchema.safeParse(req.body);
+
+  if (!parsed.success) {
+    logfire.warning('Invalid scaffold request', { error: redact(parsed.error.message) });
+    res.status(400).send(parsed.error.message);
+    return;
+  }
+
+  try {
+    const files = await scaffoldProject(parsed.data.name);
+    res.status(200).json(files);
+  } catch (err) {
+    const message = err instanceof Error ? err.message : String(err);
+    logfire.error('Scaffold failed: {message}', { message: redact(message) });
+    res.status(500).send(message);
+  }
+});
+
+app.post('/lighthouse', async (req: Request, res: Response) => {
+  const parsed = LighthouseRequestSchema.safeParse(req.body);
+
+  if (!parsed.success) {
+    logfire.warning('Invalid lighthouse request', { error: redact(parsed.error.message) });
+    res.status(400).send(parsed.error.message);
+    return;
+  }
+
+  try {
+    const result = await runLighthouse(parsed.data);
+    logfire.info('Lighthouse audit finished with performance {performance}', { performance: result.scores.performance });
+    res.status(200).json(result);
+  } catch (err) {
+    const message = err instanceof Error ? err.message : String(err);
+    logfire.error('Lighthouse audit failed: {message}', { message: redact(message) });
+    res.status(500).send(message);
+  }
+});
+
 app.get('/health', (_req: Request, res: Response) => {
   res.send('OK');
 });
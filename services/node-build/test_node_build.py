@@ -1,6 +1,11 @@
 """Tests for the node-build server."""
 
+import base64
+import json
 import os
+import random
+import struct
+import zlib
 
 import pytest
 import requests
@@ -8,6 +13,8 @@ import requests
 BASE_URL = os.getenv('BASE_URL', 'http://localhost:3002')
 BUILD_URL = f'{BASE_URL}/build'
 HEALTH_URL = f'{BASE_URL}/health'
+SCAFFOLD_URL = f'{BASE_URL}/scaffold'
+LIGHTHOUSE_URL = f'{BASE_URL}/lighthouse'
 
 
 @pytest.fixture
@@ -70,6 +77,11 @@ def test_rejects_invalid_json() -> None:
     assert 'invalid json' in response.text.lower()
 
 
+def test_lighthouse_rejects_non_http_url() -> None:
+    response = requests.post(LIGHTHOUSE_URL, json={'url': 'file:///etc/passwd'})
+    assert response.status_code == 400
+
+
 # Build success tests
 
 
@@ -84,6 +96,93 @@ def test_builds_simple_react_app(simple_react_app: dict[str, str]) -> None:
     assert len(output['compiled']) > 0
 
 
+def test_build_reports_tool_versions(simple_react_app: dict[str, str]) -> None:
+    payload = {'files': simple_react_app}
+    response = requests.post(BUILD_URL, json=payload, timeout=60)
+    assert response.status_code == 200
+
+    output = response.json()
+    assert isinstance(output['warnings'], list)
+    assert 'vite' in output['versions']
+    assert output['versions']['node'].startswith('v')
+
+
+def test_build_maps_artifacts_to_sources(simple_react_app: dict[str, str]) -> None:
+    payload = {'files': simple_react_app}
+    response = requests.post(BUILD_URL, json=payload, timeout=60)
+    assert response.status_code == 200
+
+    sources = response.json()['sources']
+    js_files = [k for k in sources if k.endswith('.js')]
+    assert len(js_files) > 0
+    assert any('app.tsx' in sources[k] for k in js_files)
+
+
+def test_build_reports_bundled_dependencies(simple_react_app: dict[str, str]) -> None:
+    payload = {'files': simple_react_app}
+    response = requests.post(BUILD_URL, json=payload, timeout=60)
+    assert response.status_code == 200
+
+    dependencies = {d['name']: d for d in response.json()['dependencies']}
+    assert dependencies['react']['license'] == 'MIT'
+    assert dependencies['react']['version']
+    assert 'vite' not in dependencies
+
+
+def test_development_profile_skips_minification(simple_react_app: dict[str, str]) -> None:
+    sizes: dict[str, int] = {}
+    for profile in ('production', 'development'):
+        payload = {'files': simple_react_app, 'profile': profile}
+        response = requests.post(BUILD_URL, json=payload, timeout=60)
+        assert response.status_code == 200
+        compiled = response.json()['compiled']
+        sizes[profile] = sum(len(v) for k, v in compiled.items() if k.endswith('.js'))
+    assert sizes['development'] > sizes['production']
+
+
+def test_rejects_unknown_profile(simple_react_app: dict[str, str]) -> None:
+    payload = {'files': simple_react_app, 'profile': 'debug'}
+    response = requests.post(BUILD_URL, json=payload)
+    assert response.status_code == 400
+
+
+def noise_png(width: int, height: int) -> bytes:
+    """An RGB PNG of random pixels, too large for Vite to inline."""
+    rng = random.Random(0)
+    rows = b''.join(b'\x00' + rng.randbytes(width * 3) for _ in range(height))
+
+    def chunk(kind: bytes, data: bytes) -> bytes:
+        return struct.pack('>I', len(data)) + kind + data + struct.pack('>I', zlib.crc32(kind + data))
+
+    header = struct.pack('>IIBBBBB', width, height, 8, 2, 0, 0, 0)
+    return b'\x89PNG\r\n\x1a\n' + chunk(b'IHDR', header) + chunk(b'IDAT', zlib.compress(rows)) + chunk(b'IEND', b'')
+
+
+def test_optimize_images_adds_variants() -> None:
+    image = noise_png(1000, 40)
+    files = {
+        'app.tsx': """
+import photo from "./photo.png";
+
+export default function App() {
+  return <img src={photo} alt="" />;
+}
+""",
+        'photo.png': base64.b64encode(image).decode(),
+    }
+    response = requests.post(BUILD_URL, json={'files': files, 'optimize_images': True}, timeout=60)
+    assert response.status_code == 200
+    data = response.json()
+    assert 'photo.png' not in data['source']
+
+    compiled = data['compiled']
+    (png,) = [k for k in compiled if k.endswith('.png') and not k.endswith(('.480w.png', '.960w.png'))]
+    stem = png.removesuffix('.png')
+    for variant in ('.webp', '.avif', '.480w.webp', '.960w.avif', '.480w.png'):
+        assert stem + variant in compiled
+    assert stem + '.1600w.webp' not in compiled
+
+
 def test_output_contains_js_file(simple_react_app: dict[str, str]) -> None:
     payload = {'files': simple_react_app}
     response = requests.post(BUILD_URL, json=payload, timeout=60)
@@ -468,3 +567,21 @@ export default function App() {
     }
     response = requests.post(BUILD_URL, json=payload, timeout=60)
     assert response.status_code == 200
+
+
+def test_scaffold_returns_project_files() -> None:
+    response = requests.post(SCAFFOLD_URL, json={'name': 'My App'})
+    assert response.status_code == 200
+    files = response.json()
+    for path in ('package.json', 'vite.config.ts', 'tsconfig.json', 'index.html', 'main.tsx', 'shadcn/lib/utils.ts'):
+        assert path in files
+    package = json.loads(files['package.json'])
+    assert package['name'] == 'my-app'
+    assert package['scripts']['dev'] == 'vite'
+    assert 'react' in package['dependencies']
+    assert 'vite' in package['devDependencies']
+
+
+def test_scaffold_rejects_missing_name() -> None:
+    response = requests.post(SCAFFOLD_URL, json={})
+    assert response.status_code == 400
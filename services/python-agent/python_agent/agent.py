@@ -1,14 +1,16 @@
 """React builder agent using pydantic-ai."""
 
 import os
+from typing import Any
 
 import httpx
 import logfire
 from pydantic_ai import Agent, ModelRetry, RunContext, TextOutput
 from pydantic_ai.models.anthropic import AnthropicModel
 from pydantic_ai.providers.gateway import gateway_provider
+from pydantic_ai.tools import ToolDefinition
 
-from .models import AppDependencies
+from .models import AgentSettings, AppDependencies, BrandKit, EditRecord
 
 BUILD_ENDPOINT = os.environ.get('BUILD_ENDPOINT', 'http://localhost:3002/build')
 
@@ -27,6 +29,14 @@ You are a React application builder. Create client-side React applications follo
      Tooltip, Dialog, Select, ScrollArea, AlertDialog, DropdownMenu, Avatar, Accordion, Popover, Table
    - Use lucide-react for icons: import { Icon } from "lucide-react"
    - Example: import { Button } from "shadcn/components/ui/button"
+8. URLs like library://logo.png refer to the user's shared asset library: use them verbatim as string
+   URLs (img src, CSS url()), never import them or replace them with placeholders
+9. Forms that collect data (contact, signup, feedback) submit it with
+   fetch('./view/_forms/{name}', {method: 'POST', headers: {'Content-Type': 'application/json'}, body})
+   using a short form name like "contact"; show a confirmation on success and an error otherwise
+10. State that should outlive the page (todo lists, counters, saved settings) is kept as JSON values with
+   fetch('./view/_data/{key}') to read (404 until set) and {method: 'PUT', body: JSON.stringify(value)} to write,
+   rather than only in localStorage; keys are short slash-separated names like "todos/list"
 
 When creating files, use appropriate file paths like:
 - app.tsx for the main app component (required, default export)
@@ -59,7 +69,11 @@ async def submit_files(ctx: RunContext[AppDependencies], text: str) -> str:
         logfire.instrument_httpx(client)
         response = await client.post(
             BUILD_ENDPOINT,
-            json={'files': ctx.deps.files},
+            json={
+                'files': ctx.deps.files,
+                'profile': ctx.deps.settings.build_profile,
+                'optimize_images': ctx.deps.settings.optimize_images,
+            },
             timeout=60.0,
         )
         if response.status_code == 200:
@@ -85,7 +99,74 @@ agent: Agent[AppDependencies, str] = Agent(
 )
 
 
-@agent.tool
+@agent.instructions
+def brand_instructions(ctx: RunContext[AppDependencies]) -> str | None:
+    """Tell the model about the brand kit the app should follow.
+
+    The kit's colors and fonts are defined as CSS variables on :root of every build.
+
+    Args:
+        ctx: The run context containing app dependencies.
+
+    Returns:
+        The brand instructions, or None without a brand kit.
+    """
+    brand = ctx.deps.brand
+    if brand is None or not (brand.colors or brand.fonts or brand.logo):
+        return None
+    lines = [
+        "The app must follow the user's brand kit. Refer to it through these CSS variables, e.g. in Tailwind",
+        'arbitrary values like bg-[var(--brand-color-primary)], rather than copying the values:',
+    ]
+    lines += [f'- color {name} ({value}): var(--brand-color-{name})' for name, value in sorted(brand.colors.items())]
+    lines += [f'- font {name} ({value}): var(--brand-font-{name})' for name, value in sorted(brand.fonts.items())]
+    if brand.logo:
+        lines.append(f'Use the logo library://{brand.logo} wherever the app shows its brand, e.g. the header.')
+    return '\n'.join(lines)
+
+
+COMPACT_INSTRUCTIONS = """\
+You summarize the earlier part of a conversation between a user and a React application builder,
+so the conversation can continue without the full history.
+
+Keep every requirement, preference and decision the user has expressed, what has been built or changed,
+and anything left unresolved. Leave out pleasantries and file contents. Write plain prose, without emojis.
+"""
+
+
+
+compact_agent: Agent[None, str] = Agent(model, instructions=COMPACT_INSTRUCTIONS)
+
+
+def settings_model(settings: AgentSettings) -> AnthropicModel | None:
+    """Return the model a project's settings ask for, or None to use the agent's default.
+
+    Args:
+        settings: The project's agent settings.
+
+    Returns:
+        The overriding model, if any.
+    """
+    if settings.model is None:
+        return None
+    return AnthropicModel(settings.model, provider=provider)
+
+
+async def allowed_tool(ctx: RunContext[AppDependencies], tool_def: ToolDefinition) -> ToolDefinition | None:
+    """Hide tools the project's settings don't allow from the model.
+
+    Args:
+        ctx: The run context containing app dependencies.
+        tool_def: The tool being prepared for this step.
+
+    Returns:
+        The tool definition if the tool is allowed, otherwise None.
+    """
+    tools = ctx.deps.settings.tools
+    return tool_def if tools is None or tool_def.name in tools else None
+
+
+@agent.tool(prepare=allowed_tool)
 def create_file(ctx: RunContext[AppDependencies], file_path: str, content: str) -> str:
     """Create a new file with the given content.
 
@@ -101,7 +182,30 @@ def create_file(ctx: RunContext[AppDependencies], file_path: str, content: str)
     return f'Created file: {file_path}'
 
 
-@agent.tool
+def fuzzy_find(content: str, old_str: str) -> tuple[int, int] | None:
+    """Find old_str in content, ignoring leading and trailing whitespace on each line.
+
+    Args:
+        content: The file content to search.
+        old_str: The text to find.
+
+    Returns:
+        The (start, end) offsets of the whole lines that match, or None if there is no match.
+    """
+    needle = [line.strip() for line in old_str.strip('\n').splitlines()]
+    if not needle:
+        return None
+
+    lines = content.splitlines(keepends=True)
+    for i in range(len(lines) - len(needle) + 1):
+        if all(lines[i + j].strip() == needle[j] for j in range(len(needle))):
+            start = sum(len(line) for line in lines[:i])
+            end = start + sum(len(line) for line in lines[i : i + len(needle)])
+            return start, end
+    return None
+
+
+@agent.tool(prepare=allowed_tool)
 def edit_file(
     ctx: RunContext[AppDependencies],
     path: str,
@@ -122,12 +226,24 @@ def edit_file(
         Summary of the changes made.
     """
     if path not in ctx.deps.files:
-        return f'Error: File {path} does not exist'
+        error = f'File {path} does not exist'
+        ctx.deps.edits.append(EditRecord(path=path, old_str=old_str, status='failed', error=error))
+        return f'Error: {error}'
 
     content = ctx.deps.files[path]
 
     if old_str not in content:
-        return f'Error: Could not find "{old_str[:50]}..." in {path}'
+        # Models often get indentation slightly wrong, fall back to matching whole lines
+        span = fuzzy_find(content, old_str)
+        if span is None:
+            error = f'Could not find "{old_str[:50]}..." in {path}'
+            ctx.deps.edits.append(EditRecord(path=path, old_str=old_str, status='failed', error=error))
+            return f'Error: {error}'
+        start, end = span
+        replacement = new_str if new_str.endswith('\n') or not content[start:end].endswith('\n') else new_str + '\n'
+        ctx.deps.files[path] = content[:start] + replacement + content[end:]
+        ctx.deps.edits.append(EditRecord(path=path, old_str=old_str, status='fuzzy'))
+        return f'Edited {path}: Replaced 1 occurrence (matched ignoring whitespace, check indentation)'
 
     if replace_all:
         count = content.count(old_str)
@@ -138,10 +254,11 @@ def edit_file(
         summary = 'Replaced 1 occurrence'
 
     ctx.deps.files[path] = content
+    ctx.deps.edits.append(EditRecord(path=path, old_str=old_str, status='applied'))
     return f'Edited {path}: {summary}'
 
 
-@agent.tool
+@agent.tool(prepare=allowed_tool)
 def delete_file(ctx: RunContext[AppDependencies], file_path: str) -> str:
     """Delete a file.
 
@@ -159,22 +276,128 @@ def delete_file(ctx: RunContext[AppDependencies], file_path: str) -> str:
     return f'Deleted file: {file_path}'
 
 
+async def workspace_tool(ctx: RunContext[AppDependencies], tool_def: ToolDefinition) -> ToolDefinition | None:
+    """Only offer the workspace tools when the app is in a workspace.
+
+    Args:
+        ctx: The run context containing app dependencies.
+        tool_def: The tool being prepared for this step.
+
+    Returns:
+        The tool definition if there are workspace files, otherwise None.
+    """
+    return tool_def if ctx.deps.workspace_files else None
+
+
+@agent.tool(prepare=workspace_tool)
+def list_workspace_files(ctx: RunContext[AppDependencies]) -> str:
+    """List the source files of the other apps in this app's workspace.
+
+    Args:
+        ctx: The run context containing app dependencies.
+
+    Returns:
+        Each project ID followed by its file paths.
+    """
+    lines: list[str] = []
+    for project_id, files in sorted(ctx.deps.workspace_files.items()):
+        lines.append(f'{project_id}:')
+        lines.extend(f'  {path}' for path in sorted(files))
+    return '\n'.join(lines)
+
+
+@agent.tool(prepare=workspace_tool)
+def read_workspace_file(ctx: RunContext[AppDependencies], project_id: str, path: str) -> str:
+    """Read a source file of another app in this app's workspace. These files are read-only.
+
+    Args:
+        ctx: The run context containing app dependencies.
+        project_id: The ID of the project the file belongs to, as listed by list_workspace_files.
+        path: The file path within that project.
+
+    Returns:
+        The file content.
+    """
+    files = ctx.deps.workspace_files.get(project_id)
+    if files is None:
+        return f'Error: Project {project_id} is not in this workspace'
+    if path not in files:
+        return f'Error: File {path} does not exist in project {project_id}'
+    return files[path]
+
+
 async def run_agent(
     prompt: str,
     existing_files: dict[str, str] | None = None,
-) -> tuple[dict[str, str], dict[str, str], str]:
+    settings: AgentSettings | None = None,
+    workspace_files: dict[str, dict[str, str]] | None = None,
+    brand: BrandKit | None = None,
+) -> tuple[dict[str, str], dict[str, str], str, list[EditRecord], int]:
     """Run the React builder agent.
 
     Args:
         prompt: The user's prompt describing what to build or modify.
         existing_files: Optional dict of existing files when editing an app.
+        settings: Optional project settings choosing the model, tools and build profile.
+        workspace_files: Optional read-only source files of the other apps in the app's workspace.
+        brand: Optional brand kit the app should follow.
 
     Returns:
-        A tuple of (files, compiled_files, summary) where:
+        A tuple of (files, compiled_files, summary, edits, tokens) where:
         - files: The final state of all source files
         - compiled_files: The compiled js/css/sourcemap files from the build
         - summary: The summary string from the model
+        - edits: The outcome of every edit_file call, in order
+        - tokens: The total tokens used across every model request in the run
+    """
+    settings = settings or AgentSettings()
+    deps = AppDependencies(
+        files=existing_files.copy() if existing_files else {},
+        settings=settings,
+        workspace_files=workspace_files or {},
+        brand=brand,
+    )
+    result = await agent.run(prompt, deps=deps, model=settings_model(settings))
+    return deps.files, deps.compiled_files, result.output, deps.edits, result.usage().total_tokens
+
+
+def format_transcript(messages: list[dict[str, Any]]) -> str:
+    """Render chat UI messages as a plain text transcript.
+
+    Text parts are kept verbatim; tool calls are reduced to their names so file contents don't
+    dominate the transcript.
+
+    Args:
+        messages: Messages in the Vercel AI SDK UI format, each with a role and a list of parts.
+
+    Returns:
+        One paragraph per message, prefixed with its role.
+    """
+    lines: list[str] = []
+    for message in messages:
+        texts: list[str] = []
+        for part in message.get('parts', []):
+            part_type = part.get('type', '')
+            if part_type == 'text':
+                texts.append(part.get('text', ''))
+            elif part_type.startswith('tool-'):
+                texts.append(f'[used tool {part_type.removeprefix("tool-")}]')
+        if texts:
+            lines.append(f'{message.get("role", "user")}: {" ".join(texts)}')
+    return '\n\n'.join(lines)
+
+
+async def summarize_conversation(
+    messages: list[dict[str, Any]], settings: AgentSettings | None = None
+) -> tuple[str, int]:
+    """Summarize earlier conversation turns so they can replace the originals.
+
+    Args:
+        messages: The messages to summarize, oldest first, in the Vercel AI SDK UI format.
+        settings: Optional project settings choosing the model.
+
+    Returns:
+        A tuple of (summary, tokens) where tokens is the total used by the summarization.
     """
-    deps = AppDependencies(files=existing_files.copy() if existing_files else {})
-    result = await agent.run(prompt, deps=deps)
-    return deps.files, deps.compiled_files, result.output
+    result = await compact_agent.run(format_transcript(messages), model=settings_model(settings or AgentSettings()))
+    return result.output, result.usage().total_tokens
@@ -1,14 +1,30 @@
 """Shared Pydantic models and dataclasses for the React builder agent."""
 
 from dataclasses import dataclass, field
+from typing import Any, Literal
 
 from pydantic import BaseModel
 
 
+class BrandKit(BaseModel):
+    """An owner's branding, which generated apps follow."""
+
+    colors: dict[str, str] = {}
+    """CSS colors by name, available to apps as var(--brand-color-{name})."""
+    fonts: dict[str, str] = {}
+    """CSS font families by role, available to apps as var(--brand-font-{name})."""
+    logo: str = ''
+    """Name of the logo in the owner's asset library, if any."""
+
+
 class CreateAppRequest(BaseModel):
     """Request to create a new React app."""
 
     prompt: str
+    workspace_files: dict[str, dict[str, str]] = {}
+    """Source files of the other projects in the app's workspace, by project ID."""
+    brand: BrandKit | None = None
+    """The brand kit the app should follow, if any."""
 
 
 class CreateAppResponse(BaseModel):
@@ -17,6 +33,7 @@ class CreateAppResponse(BaseModel):
     files: dict[str, str]
     compiled_files: dict[str, str]
     summary: str
+    tokens: int = 0
 
 
 class EditAppRequest(BaseModel):
@@ -24,6 +41,19 @@ class EditAppRequest(BaseModel):
 
     prompt: str
     files: dict[str, str]
+    workspace_files: dict[str, dict[str, str]] = {}
+    """Source files of the other projects in the app's workspace, by project ID."""
+    brand: BrandKit | None = None
+    """The brand kit the app should follow, if any."""
+
+
+class EditRecord(BaseModel):
+    """Outcome of a single edit_file call, i.e. one search/replace hunk."""
+
+    path: str
+    old_str: str
+    status: Literal['applied', 'fuzzy', 'failed']
+    error: str | None = None
 
 
 class EditAppResponse(BaseModel):
@@ -32,6 +62,34 @@ class EditAppResponse(BaseModel):
     files: dict[str, str]
     compiled_files: dict[str, str]
     summary: str
+    edits: list[EditRecord] = []
+    tokens: int = 0
+
+
+class CompactRequest(BaseModel):
+    """Request to summarize earlier conversation turns."""
+
+    messages: list[dict[str, Any]]
+
+
+class CompactResponse(BaseModel):
+    """Summary to stand in for the summarized turns."""
+
+    summary: str
+    tokens: int = 0
+
+
+@dataclass
+class AgentSettings:
+    """Per-project options, sent by go-main as request headers."""
+
+    model: str | None = None
+    """Model name overriding the default, if any."""
+    tools: frozenset[str] | None = None
+    """The tools the agent may use, or None for all of them."""
+    build_profile: Literal['production', 'development'] = 'production'
+    optimize_images: bool = False
+    """Whether builds add WebP, AVIF and resized variants of images."""
 
 
 @dataclass
@@ -40,3 +98,9 @@ class AppDependencies:
 
     files: dict[str, str] = field(default_factory=dict)
     compiled_files: dict[str, str] = field(default_factory=dict)
+    edits: list[EditRecord] = field(default_factory=list)
+    settings: AgentSettings = field(default_factory=AgentSettings)
+    workspace_files: dict[str, dict[str, str]] = field(default_factory=dict)
+    """Read-only source files of the other projects in the app's workspace, by project ID."""
+    brand: BrandKit | None = None
+    """The brand kit the app should follow, if any."""
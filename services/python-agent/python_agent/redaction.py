@@ -0,0 +1,55 @@
+"""Redaction of prompts, file contents and conversations before they reach traces."""
+
+import hashlib
+import os
+from typing import Any
+
+from starlette.requests import Request
+
+REDACTION_MODE = os.environ.get('REDACTION_MODE', 'off')
+REDACTION_MAX_LENGTH = int(os.environ.get('REDACTION_MAX_LENGTH', '64'))
+
+if REDACTION_MODE not in ('off', 'truncate', 'hash'):
+    raise ValueError(f'Unknown REDACTION_MODE {REDACTION_MODE!r}')
+
+
+def redact(value: Any) -> Any:
+    """Truncate or hash every string in a value, according to REDACTION_MODE.
+
+    Hashes are short SHA-256 prefixes, so equal payloads can still be correlated without being readable.
+
+    Args:
+        value: A string, or a dict/list/tuple containing strings at any depth.
+
+    Returns:
+        The value with the same shape and each string redacted.
+    """
+    if isinstance(value, str):
+        if REDACTION_MODE == 'truncate' and len(value) > REDACTION_MAX_LENGTH:
+            return f'{value[:REDACTION_MAX_LENGTH]}... ({len(value.encode())} bytes)'
+        if REDACTION_MODE == 'hash':
+            digest = hashlib.sha256(value.encode()).hexdigest()[:16]
+            return f'sha256:{digest} ({len(value.encode())} bytes)'
+        return value
+    if isinstance(value, dict):
+        return {k: redact(v) for k, v in value.items()}
+    if isinstance(value, list | tuple):
+        return [redact(v) for v in value]
+    if hasattr(value, 'model_dump'):
+        return redact(value.model_dump())
+    return value
+
+
+def redact_request_attributes(_request: Request, attributes: dict[str, Any]) -> dict[str, Any] | None:
+    """Redact endpoint arguments recorded on FastAPI spans.
+
+    Args:
+        _request: The incoming request.
+        attributes: The attributes logfire would record, with parsed arguments under 'values'.
+
+    Returns:
+        The attributes with argument values redacted.
+    """
+    if REDACTION_MODE == 'off':
+        return attributes
+    return {**attributes, 'values': redact(attributes.get('values', {}))}
@@ -1,54 +1,128 @@
 """FastAPI server for the React builder agent."""
 
+from typing import Annotated
+
 import logfire
-from fastapi import FastAPI
+from fastapi import Depends, FastAPI, HTTPException
 from pydantic_ai.ui.vercel_ai import VercelAIAdapter
 from starlette.requests import Request
 from starlette.responses import Response
 
-from .agent import agent, run_agent
-from .models import AppDependencies, CreateAppRequest, CreateAppResponse, EditAppRequest, EditAppResponse
+from .agent import agent, run_agent, settings_model, summarize_conversation
+from .models import (
+    AgentSettings,
+    AppDependencies,
+    BrandKit,
+    CompactRequest,
+    CompactResponse,
+    CreateAppRequest,
+    CreateAppResponse,
+    EditAppRequest,
+    EditAppResponse,
+)
+from .redaction import REDACTION_MODE, redact_request_attributes
 
 logfire.configure(service_name='agent', distributed_tracing=True)
-logfire.instrument_pydantic_ai()
+# With redaction on, prompts and model responses are left off agent spans entirely
+logfire.instrument_pydantic_ai(include_content=REDACTION_MODE == 'off')
 
 app = FastAPI(
     title='React Builder Agent',
     description='A pydantic-ai powered agent that builds React applications',
 )
-logfire.instrument_fastapi(app)
+logfire.instrument_fastapi(app, request_attributes_mapper=redact_request_attributes)
+
+
+def agent_settings(request: Request) -> AgentSettings:
+    """Read per-project settings from the headers go-main sends.
+
+    Args:
+        request: The incoming request.
+
+    Returns:
+        The settings, with defaults for any header not sent.
+
+    Raises:
+        HTTPException: If the build profile header isn't a known profile.
+    """
+    settings = AgentSettings()
+    if model := request.headers.get('X-Agent-Model'):
+        settings.model = model
+    if tools := request.headers.get('X-Agent-Tools'):
+        settings.tools = frozenset(tool.strip() for tool in tools.split(','))
+    profile = request.headers.get('X-Build-Profile')
+    if profile == 'production' or profile == 'development':
+        settings.build_profile = profile
+    elif profile:
+        raise HTTPException(status_code=400, detail=f'Unknown build profile {profile!r}')
+    settings.optimize_images = request.headers.get('X-Optimize-Images') == 'true'
+    return settings
+
+
+Settings = Annotated[AgentSettings, Depends(agent_settings)]
 
 
 @app.post('/apps')
-async def create_app(request: CreateAppRequest) -> CreateAppResponse:
+async def create_app(request: CreateAppRequest, settings: Settings) -> CreateAppResponse:
     """Create a new React application based on the given prompt.
 
     Args:
         request: The request containing the prompt for the app to build.
+        settings: The project's model, tool and build profile settings.
 
     Returns:
         The generated files and a summary of the application.
     """
-    files, compiled_files, summary = await run_agent(request.prompt)
-    return CreateAppResponse(files=files, compiled_files=compiled_files, summary=summary)
+    files, compiled_files, summary, _, tokens = await run_agent(
+        request.prompt, settings=settings, workspace_files=request.workspace_files, brand=request.brand
+    )
+    return CreateAppResponse(files=files, compiled_files=compiled_files, summary=summary, tokens=tokens)
 
 
 @app.post('/apps/edit')
-async def edit_app(request: EditAppRequest) -> EditAppResponse:
+async def edit_app(request: EditAppRequest, settings: Settings) -> EditAppResponse:
     """Edit an existing React application based on the given prompt.
 
     Args:
         request: The request containing the prompt and existing files.
+        settings: The project's model, tool and build profile settings.
+
+    Returns:
+        The final files, a summary of the changes and the outcome of each edit.
+    """
+    files, compiled_files, summary, edits, tokens = await run_agent(
+        request.prompt, request.files, settings, request.workspace_files, request.brand
+    )
+    return EditAppResponse(files=files, compiled_files=compiled_files, summary=summary, edits=edits, tokens=tokens)
+
+
+@app.post('/compact')
+async def compact(request: CompactRequest, settings: Settings) -> CompactResponse:
+    """Summarize earlier chat turns so callers can replace them and stay within context limits.
+
+    Args:
+        request: The request containing the messages to summarize, oldest first.
+        settings: The project's settings, for the model to summarize with.
+
+    Returns:
+        The summary and the tokens used to produce it.
+    """
+    summary, tokens = await summarize_conversation(request.messages, settings)
+    return CompactResponse(summary=summary, tokens=tokens)
+
+
+@app.get('/health')
+async def health() -> dict[str, str]:
+    """Report that the agent is up, for go-main's readiness checks.
 
     Returns:
-        The final files and a summary of the changes.
+        A fixed OK status.
     """
-    files, compiled_files, summary = await run_agent(request.prompt, request.files)
-    return EditAppResponse(files=files, compiled_files=compiled_files, summary=summary)
+    return {'status': 'ok'}
 
 
 @app.post('/chat')
-async def chat(request: Request) -> Response:
+async def chat(request: Request, settings: Settings) -> Response:
     """Handle streaming chat via Vercel AI SDK protocol.
 
     This endpoint implements the Vercel AI SDK protocol for real-time streaming
@@ -57,6 +131,7 @@ async def chat(request: Request) -> Response:
 
     Args:
         request: The Starlette request containing the chat message.
+        settings: The project's model, tool and build profile settings.
 
     Returns:
         A streaming response with Server-Sent Events containing the agent's response.
@@ -64,8 +139,10 @@ async def chat(request: Request) -> Response:
     # Parse the request body to extract any existing files
     body = await request.json()
     files = body.get('files', {})
+    workspace_files = body.get('workspace_files', {})
+    brand = BrandKit.model_validate(body['brand']) if body.get('brand') else None
 
     # Create dependencies with existing files
-    deps = AppDependencies(files=files)
+    deps = AppDependencies(files=files, settings=settings, workspace_files=workspace_files, brand=brand)
 
-    return await VercelAIAdapter.dispatch_request(request, agent=agent, deps=deps)
+    return await VercelAIAdapter.dispatch_request(request, agent=agent, deps=deps, model=settings_model(settings))
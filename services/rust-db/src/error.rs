@@ -12,6 +12,9 @@ pub enum AppError {
 
     #[error("Key not found: {0}")]
     KeyNotFound(String),
+
+    #[error("Precondition failed: {0}")]
+    PreconditionFailed(String),
 }
 
 impl IntoResponse for AppError {
@@ -19,6 +22,7 @@ impl IntoResponse for AppError {
         let (status, message) = match &self {
             Self::Database(_) => (StatusCode::INTERNAL_SERVER_ERROR, self.to_string()),
             Self::KeyNotFound(_) => (StatusCode::NOT_FOUND, self.to_string()),
+            Self::PreconditionFailed(_) => (StatusCode::PRECONDITION_FAILED, self.to_string()),
         };
 
         (status, Json(serde_json::json!({ "error": message }))).into_response()
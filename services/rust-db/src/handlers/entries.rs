@@ -1,7 +1,7 @@
 use axum::{
     Json,
     body::Bytes,
-    extract::{Path, State},
+    extract::{Path, Query, State},
     http::{HeaderMap, StatusCode, header},
     response::{IntoResponse, Response},
 };
@@ -9,7 +9,7 @@ use uuid::Uuid;
 
 use crate::{
     error::{AppError, Result},
-    models::{Entry, KeyInfo},
+    models::{EncodedEntry, Entry, GetManyRequest, KeyDetails, KeyInfo, ListParams},
 };
 
 type Pool = std::sync::Arc<sqlx_tracing::Pool<sqlx::Postgres>>;
@@ -48,7 +48,15 @@ pub async fn get_entry(State(pool): State<Pool>, Path((project, key)): Path<(Uui
     }
 }
 
-pub async fn list_entries_all(State(pool): State<Pool>, Path(project): Path<Uuid>) -> Result<Json<Vec<KeyInfo>>> {
+pub async fn list_entries_all(
+    State(pool): State<Pool>,
+    Path(project): Path<Uuid>,
+    Query(params): Query<ListParams>,
+) -> Result<Response> {
+    if params.details {
+        return list_details(&pool, project, "%".to_string()).await;
+    }
+
     let entries: Vec<KeyInfo> = sqlx::query_as!(
         KeyInfo,
         r#"
@@ -62,19 +70,24 @@ pub async fn list_entries_all(State(pool): State<Pool>, Path(project): Path<Uuid
     .fetch_all(&*pool)
     .await?;
 
-    Ok(Json(entries))
+    Ok(Json(entries).into_response())
 }
 
 pub async fn list_entries(
     State(pool): State<Pool>,
     Path((project, prefix)): Path<(Uuid, String)>,
-) -> Result<Json<Vec<KeyInfo>>> {
+    Query(params): Query<ListParams>,
+) -> Result<Response> {
     // Escape SQL LIKE wildcards
     let pattern = format!(
         "{}%",
         prefix.replace('\\', "\\\\").replace('%', "\\%").replace('_', "\\_")
     );
 
+    if params.details {
+        return list_details(&pool, project, pattern).await;
+    }
+
     let entries: Vec<KeyInfo> = sqlx::query_as!(
         KeyInfo,
         r#"
@@ -89,9 +102,69 @@ pub async fn list_entries(
     .fetch_all(&*pool)
     .await?;
 
+    Ok(Json(entries).into_response())
+}
+
+/// Lists entries matching a LIKE pattern along with their sizes and ETags.
+async fn list_details(pool: &Pool, project: Uuid, pattern: String) -> Result<Response> {
+    let entries: Vec<KeyDetails> = sqlx::query_as!(
+        KeyDetails,
+        r#"
+        SELECT key, mime_type, octet_length(content)::BIGINT AS "size!", md5(content) AS "etag!"
+        FROM entries
+        WHERE project_id = $1 AND key LIKE $2
+        ORDER BY key
+        "#,
+        project,
+        pattern
+    )
+    .fetch_all(&**pool)
+    .await?;
+
+    Ok(Json(entries).into_response())
+}
+
+pub async fn get_many_entries(
+    State(pool): State<Pool>,
+    Path(project): Path<Uuid>,
+    Json(request): Json<GetManyRequest>,
+) -> Result<Json<Vec<EncodedEntry>>> {
+    // Missing keys are simply absent from the response
+    let entries: Vec<EncodedEntry> = sqlx::query_as!(
+        EncodedEntry,
+        r#"
+        SELECT key, mime_type, translate(encode(content, 'base64'), E'\n', '') AS "content!"
+        FROM entries
+        WHERE project_id = $1 AND key = ANY($2)
+        ORDER BY key
+        "#,
+        project,
+        &request.keys[..]
+    )
+    .fetch_all(&*pool)
+    .await?;
+
+    logfire::info!(
+        "retrieved values project={project} requested={requested} found={found}",
+        project = project.to_string(),
+        requested = request.keys.len(),
+        found = entries.len()
+    );
+
     Ok(Json(entries))
 }
 
+/// Reads a conditional request header, stripping quotes from ETags.
+fn precondition(headers: &HeaderMap, name: header::HeaderName) -> Option<String> {
+    headers
+        .get(name)
+        .and_then(|v| v.to_str().ok())
+        .map(|v| v.trim().trim_matches('"').to_string())
+}
+
+/// Stores an entry. `If-None-Match: *` only creates it if the key is absent, and `If-Match` only replaces it if
+/// the current content's md5 (as listed in details) matches; otherwise 412 is returned. Together they let callers
+/// build leases and other compare-and-swap updates.
 pub async fn store_entry(
     State(pool): State<Pool>,
     Path((project, key)): Path<(Uuid, String)>,
@@ -111,6 +184,47 @@ pub async fn store_entry(
         .execute(&*pool)
         .await?;
 
+    if precondition(&headers, header::IF_NONE_MATCH).as_deref() == Some("*") {
+        let result = sqlx::query(
+            r#"
+            INSERT INTO entries (project_id, key, mime_type, content, updated_at)
+            VALUES ($1, $2, $3, $4, NOW())
+            ON CONFLICT (project_id, key) DO NOTHING
+            "#,
+        )
+        .bind(project)
+        .bind(&key)
+        .bind(&mime_type)
+        .bind(body.as_ref())
+        .execute(&*pool)
+        .await?;
+        if result.rows_affected() == 0 {
+            return Err(AppError::PreconditionFailed(key));
+        }
+        return Ok(StatusCode::CREATED);
+    }
+
+    if let Some(etag) = precondition(&headers, header::IF_MATCH) {
+        let result = sqlx::query(
+            r#"
+            UPDATE entries
+            SET mime_type = $3, content = $4, updated_at = NOW()
+            WHERE project_id = $1 AND key = $2 AND md5(content) = $5
+            "#,
+        )
+        .bind(project)
+        .bind(&key)
+        .bind(&mime_type)
+        .bind(body.as_ref())
+        .bind(&etag)
+        .execute(&*pool)
+        .await?;
+        if result.rows_affected() == 0 {
+            return Err(AppError::PreconditionFailed(key));
+        }
+        return Ok(StatusCode::CREATED);
+    }
+
     // Upsert entry
     sqlx::query(
         r#"
@@ -133,7 +247,25 @@ pub async fn store_entry(
     Ok(StatusCode::CREATED)
 }
 
-pub async fn delete_entry(State(pool): State<Pool>, Path((project, key)): Path<(Uuid, String)>) -> Result<StatusCode> {
+/// Deletes an entry. With `If-Match`, it is only deleted if the content's md5 matches, and 412 is returned otherwise.
+pub async fn delete_entry(
+    State(pool): State<Pool>,
+    Path((project, key)): Path<(Uuid, String)>,
+    headers: HeaderMap,
+) -> Result<StatusCode> {
+    if let Some(etag) = precondition(&headers, header::IF_MATCH) {
+        let result = sqlx::query("DELETE FROM entries WHERE project_id = $1 AND key = $2 AND md5(content) = $3")
+            .bind(project)
+            .bind(&key)
+            .bind(&etag)
+            .execute(&*pool)
+            .await?;
+        if result.rows_affected() == 0 {
+            return Err(AppError::PreconditionFailed(key));
+        }
+        return Ok(StatusCode::NO_CONTENT);
+    }
+
     let result = sqlx::query("DELETE FROM entries WHERE project_id = $1 AND key = $2")
         .bind(project)
         .bind(&key)
@@ -1,4 +1,4 @@
-use serde::Serialize;
+use serde::{Deserialize, Serialize};
 
 #[derive(Debug, Serialize)]
 pub struct KeyInfo {
@@ -6,8 +6,36 @@ pub struct KeyInfo {
     pub mime_type: String,
 }
 
+/// A list entry with the content's size in bytes and an ETag (MD5 of the content).
+#[derive(Debug, Serialize)]
+pub struct KeyDetails {
+    pub key: String,
+    pub mime_type: String,
+    pub size: i64,
+    pub etag: String,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct ListParams {
+    #[serde(default)]
+    pub details: bool,
+}
+
 #[derive(Debug)]
 pub struct Entry {
     pub mime_type: String,
     pub content: Vec<u8>,
 }
+
+#[derive(Debug, Deserialize)]
+pub struct GetManyRequest {
+    pub keys: Vec<String>,
+}
+
+/// An entry returned by get-many, with its content base64 encoded.
+#[derive(Debug, Serialize)]
+pub struct EncodedEntry {
+    pub key: String,
+    pub mime_type: String,
+    pub content: String,
+}
@@ -12,6 +12,8 @@ pub fn create_router(pool: std::sync::Arc<sqlx_tracing::Pool<sqlx::Postgres>>) -
         .route("/project/{project}/get/{*key}", get(entries::get_entry))
         .route("/project/{project}/list/", get(entries::list_entries_all))
         .route("/project/{project}/list/{*prefix}", get(entries::list_entries))
+        // Outside /project/ so it can't shadow a stored key
+        .route("/get-many/{project}", post(entries::get_many_entries))
         // Catch-all routes for store and delete
         .route("/project/{project}/{*key}", post(entries::store_entry))
         .route("/project/{project}/{*key}", delete(entries::delete_entry))
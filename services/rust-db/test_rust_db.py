@@ -1,5 +1,7 @@
 """Integration tests for the KV database service."""
 
+import base64
+import hashlib
 import uuid
 
 import requests
@@ -410,3 +412,96 @@ def test_project_isolation() -> None:
 
     assert response1.content == b'project1 content'
     assert response2.content == b'project2 content'
+
+
+def test_list_with_details() -> None:
+    """Test that details=true adds sizes and ETags to list entries."""
+    project_id = new_project_id()
+    content = b'Hello, World!'
+
+    requests.post(
+        f'{BASE_URL}/project/{project_id}/docs/hello.txt',
+        data=content,
+        headers={'Content-Type': 'text/plain'},
+        timeout=10,
+    )
+
+    response = requests.get(
+        f'{BASE_URL}/project/{project_id}/list/docs/',
+        params={'details': 'true'},
+        timeout=10,
+    )
+    assert response.status_code == 200
+    assert response.json() == [
+        {
+            'key': 'docs/hello.txt',
+            'mime_type': 'text/plain',
+            'size': len(content),
+            'etag': hashlib.md5(content).hexdigest(),
+        }
+    ]
+
+
+def test_get_many() -> None:
+    """Test fetching several keys in one request, skipping missing ones."""
+    project_id = new_project_id()
+    entries = {'a.txt': b'first', 'b.bin': b'\x00\x01\x02\xff' * 100}
+
+    for key, content in entries.items():
+        requests.post(
+            f'{BASE_URL}/project/{project_id}/{key}',
+            data=content,
+            headers={'Content-Type': 'application/octet-stream'},
+            timeout=10,
+        )
+
+    response = requests.post(
+        f'{BASE_URL}/get-many/{project_id}',
+        json={'keys': ['a.txt', 'b.bin', 'missing.txt']},
+        timeout=10,
+    )
+    assert response.status_code == 200
+    result = {entry['key']: base64.b64decode(entry['content']) for entry in response.json()}
+    assert result == entries
+
+
+def test_key_named_get_many_is_stored() -> None:
+    """Test that a key called get-many is stored like any other, not taken for a get-many request."""
+    project_id = new_project_id()
+    response = requests.post(
+        f'{BASE_URL}/project/{project_id}/get-many',
+        data=b'just a key',
+        headers={'Content-Type': 'text/plain'},
+        timeout=10,
+    )
+    assert response.status_code == 201
+
+    response = requests.get(f'{BASE_URL}/project/{project_id}/get/get-many', timeout=10)
+    assert response.status_code == 200
+    assert response.content == b'just a key'
+
+
+def test_conditional_store_and_delete() -> None:
+    """Test If-None-Match and If-Match preconditions on store and delete."""
+    project_id = new_project_id()
+    url = f'{BASE_URL}/project/{project_id}/lock.json'
+    get_url = f'{BASE_URL}/project/{project_id}/get/lock.json'
+
+    response = requests.post(url, data=b'first', headers={'If-None-Match': '*'}, timeout=10)
+    assert response.status_code == 201
+    response = requests.post(url, data=b'second', headers={'If-None-Match': '*'}, timeout=10)
+    assert response.status_code == 412
+
+    stale = hashlib.md5(b'other').hexdigest()
+    response = requests.post(url, data=b'second', headers={'If-Match': f'"{stale}"'}, timeout=10)
+    assert response.status_code == 412
+    current = hashlib.md5(b'first').hexdigest()
+    response = requests.post(url, data=b'second', headers={'If-Match': f'"{current}"'}, timeout=10)
+    assert response.status_code == 201
+    assert requests.get(get_url, timeout=10).content == b'second'
+
+    response = requests.delete(url, headers={'If-Match': f'"{current}"'}, timeout=10)
+    assert response.status_code == 412
+    response = requests.delete(url, headers={'If-Match': hashlib.md5(b'second').hexdigest()}, timeout=10)
+    assert response.status_code == 204
+    assert requests.get(get_url, timeout=10).status_code == 404